@@ -3,6 +3,7 @@ package aliyunblob
 import (
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"io"
@@ -210,22 +211,74 @@ type bucket struct {
 // ErrorCode should return a code that describes the error, which was returned by
 // one of the other methods in this interface.
 func (b *bucket) ErrorCode(err error) gdkerr.ErrorCode {
-	e, ok := err.(*oss.ServiceError)
+	if err == errPreconditionFailed {
+		return gdkerr.FailedPrecondition
+	}
+	e, ok := serviceError(err)
 	if !ok {
 		return gdkerr.Unknown
 	}
 	switch e.Code {
-	case "NoSuchBucket", "NoSuchKey":
+	case "NoSuchBucket", "NoSuchKey", "NoSuchUpload":
 		return gdkerr.NotFound
 	case "AccessDenied":
 		return gdkerr.PermissionDenied
 	case "InvalidArgument":
 		return gdkerr.InvalidArgument
+	case "PreconditionFailed", "NotModified", "FileAlreadyExists":
+		return gdkerr.FailedPrecondition
+	}
+	switch e.StatusCode {
+	case http.StatusNotModified, http.StatusPreconditionFailed:
+		return gdkerr.FailedPrecondition
 	default:
 		return gdkerr.Unknown
 	}
 }
 
+// serviceError extracts the oss.ServiceError from err. The SDK returns it by
+// value, but accept a pointer too.
+func serviceError(err error) (oss.ServiceError, bool) {
+	switch e := err.(type) {
+	case oss.ServiceError:
+		return e, true
+	case *oss.ServiceError:
+		return *e, true
+	}
+	return oss.ServiceError{}, false
+}
+
+// errPreconditionFailed is returned when a precondition checked by the driver
+// does not hold.
+var errPreconditionFailed = errors.New("aliyunblob: precondition failed")
+
+// checkPreconditions fetches the current ETag of the (already escaped) key and
+// returns errPreconditionFailed unless it satisfies ifMatch and ifNoneMatch.
+//
+// OSS doesn't support these conditions on writes and deletes, so the check
+// and the operation that follows it are not atomic.
+func (b *bucket) checkPreconditions(key, ifMatch, ifNoneMatch string) error {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	exists := true
+	resp, err := b.ob.GetObjectMeta(key)
+	if err != nil {
+		if se, ok := serviceError(err); !ok || se.StatusCode != http.StatusNotFound {
+			return err
+		}
+		exists = false
+	}
+	var eTag string
+	if exists {
+		eTag = resp.Get("ETag")
+	}
+	if !driver.MatchesPreconditions(exists, eTag, ifMatch, ifNoneMatch) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
 // As converts i to driver-specific types.
 // See https://gocloud.dev/concepts/as/ for background information.
 func (b *bucket) As(i interface{}) bool {
@@ -280,6 +333,27 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	eTag := resp.Get("ETag")
 	md5 := eTagToMD5(&eTag)
 
+	// OSS computes the CRC-64 of every object.
+	var checksums map[driver.ChecksumAlgorithm][]byte
+	if crc, err := strconv.ParseUint(resp.Get(oss.HTTPHeaderOssCRC64), 10, 64); err == nil {
+		sum := make([]byte, 8)
+		binary.BigEndian.PutUint64(sum, crc)
+		checksums = map[driver.ChecksumAlgorithm][]byte{driver.ChecksumCRC64: sum}
+	}
+
+	// Only ask for the tags of objects that have some.
+	var tags map[string]string
+	if n, err := strconv.Atoi(resp.Get("X-Oss-Tagging-Count")); err == nil && n > 0 {
+		out, err := b.ob.GetObjectTagging(key)
+		if err != nil {
+			return nil, err
+		}
+		tags = make(map[string]string, len(out.Tags))
+		for _, t := range out.Tags {
+			tags[t.Key] = t.Value
+		}
+	}
+
 	return &driver.Attributes{
 		CacheControl:       resp.Get("Cache-Control"),
 		ContentDisposition: resp.Get("Content-Disposition"),
@@ -287,11 +361,15 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ContentLanguage:    resp.Get("Content-Language"),
 		ContentType:        resp.Get("Content-Type"),
 		Metadata:           md,
+		Tags:               tags,
+		StorageClass:       resp.Get(oss.HTTPHeaderOssStorageClass),
 		// CreateTime not supported; left as the zero time.
-		ModTime: modTime,
-		Size:    size,
-		MD5:     md5,
-		ETag:    eTag,
+		ModTime:              modTime,
+		Size:                 size,
+		MD5:                  md5,
+		Checksums:            checksums,
+		ETag:                 eTag,
+		ServerSideEncryption: encryptionFromHeaders(resp),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*http.Header)
 			if !ok {
@@ -354,6 +432,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 				ModTime: obj.LastModified,
 				Size:    obj.Size,
 				MD5:     eTagToMD5(&obj.ETag),
+				ETag:    obj.ETag,
 				AsFunc: func(i interface{}) bool {
 					p, ok := i.(*oss.ObjectProperties)
 					if !ok {
@@ -411,10 +490,22 @@ func (r *reader) Attributes() *driver.ReaderAttributes {
 // gdkerr.NotFound.
 // opts is guaranteed to be non-nil.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset int64, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.ServerSideEncryption != nil {
+		// Only set for customer keys.
+		return nil, errCustomerKey
+	}
 	key = escapeKey(key)
 
 	in := []oss.Option{}
 
+	if opts.IfMatch != "" {
+		in = append(in, oss.IfMatch(opts.IfMatch))
+	}
+
+	if opts.IfNoneMatch != "" {
+		in = append(in, oss.IfNoneMatch(opts.IfNoneMatch))
+	}
+
 	attrs, err := b.Attributes(ctx, key)
 	if err != nil {
 		return nil, err
@@ -430,6 +521,7 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset int64, l
 		ContentType: attrs.ContentType,
 		ModTime:     attrs.ModTime,
 		Size:        attrs.Size,
+		ETag:        attrs.ETag,
 	}}, nil
 
 }
@@ -447,6 +539,9 @@ type writer struct {
 	ob  *oss.Bucket
 	key string
 	in  []oss.Option
+
+	// check is called before the object is uploaded; may be nil.
+	check func() error
 }
 
 // Write appends p to w. User must call Close to close the w after done writing.
@@ -485,7 +580,12 @@ func (w *writer) open(pr *io.PipeReader) error {
 		}
 		var err error
 
-		err = w.ob.PutObject(w.key, body, w.in...)
+		if w.check != nil {
+			err = w.check()
+		}
+		if err == nil {
+			err = w.ob.PutObject(w.key, body, w.in...)
+		}
 
 		if err != nil {
 			w.err = err
@@ -527,8 +627,47 @@ func (w *writer) Close() error {
 // Implementations should abort an ongoing write if ctx is later canceled,
 // and do any necessary cleanup in Close. Close should then return ctx.Err().
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if isCustomerKey(opts.ServerSideEncryption) {
+		return nil, errCustomerKey
+	}
 	key = escapeKey(key)
 
+	in := objectOptions(contentType, opts)
+
+	if len(opts.ContentMD5) > 0 {
+		in = append(in, oss.ContentMD5(base64.StdEncoding.EncodeToString(opts.ContentMD5)))
+	}
+	// OSS can't check a CRC-64 given in opts.Checksums before storing an
+	// object; the SDK checks the one it computes against the one OSS returns,
+	// unless CRC checks are disabled.
+
+	var check func() error
+	if opts.IfMatch == "" && opts.IfNoneMatch == "*" {
+		// OSS can enforce this one natively.
+		in = append(in, oss.ForbidOverWrite(true))
+	} else if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		check = func() error { return b.checkPreconditions(key, opts.IfMatch, opts.IfNoneMatch) }
+	}
+
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+
+	return &writer{
+		ctx:   ctx,
+		ob:    b.ob,
+		key:   key,
+		in:    in,
+		check: check,
+		donec: make(chan struct{}),
+	}, nil
+}
+
+// objectOptions returns the options that set the attributes of an object
+// written with contentType and opts.
+func objectOptions(contentType string, opts *driver.WriterOptions) []oss.Option {
 	in := []oss.Option{
 		oss.ContentType(contentType),
 	}
@@ -549,23 +688,72 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		in = append(in, oss.ContentLanguage(opts.ContentLanguage))
 	}
 
-	if len(opts.ContentMD5) > 0 {
-		in = append(in, oss.ContentMD5(base64.StdEncoding.EncodeToString(opts.ContentMD5)))
+	if len(opts.Tags) > 0 {
+		in = append(in, oss.SetTagging(ossTagging(opts.Tags)))
 	}
 
-	if opts.BeforeWrite != nil {
-		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
-			return nil, err
+	if opts.StorageClass != "" {
+		in = append(in, oss.ObjectStorageClass(oss.StorageClassType(opts.StorageClass)))
+	}
+	return append(in, encryptionOptions(opts.ServerSideEncryption)...)
+}
+
+// errCustomerKey is returned for driver.EncryptionCustomerKey.
+var errCustomerKey = gdkerr.Newf(gdkerr.Unimplemented, nil, "aliyunblob: OSS doesn't support server-side encryption with customer keys")
+
+// isCustomerKey reports whether sse uses a customer key.
+func isCustomerKey(sse *driver.ServerSideEncryption) bool {
+	return sse != nil && sse.Mode == driver.EncryptionCustomerKey
+}
+
+// encryptionOptions returns the options that encrypt an object as described
+// by sse, which doesn't use a customer key.
+func encryptionOptions(sse *driver.ServerSideEncryption) []oss.Option {
+	if sse == nil {
+		return nil
+	}
+	switch sse.Mode {
+	case driver.EncryptionServiceKey:
+		return []oss.Option{oss.ServerSideEncryption("AES256")}
+	case driver.EncryptionKMS:
+		in := []oss.Option{oss.ServerSideEncryption("KMS")}
+		if sse.KeyID != "" {
+			in = append(in, oss.ServerSideEncryptionKeyID(sse.KeyID))
 		}
+		return in
 	}
+	return nil
+}
 
-	return &writer{
-		ctx:   ctx,
-		ob:    b.ob,
-		key:   key,
-		in:    in,
-		donec: make(chan struct{}),
-	}, nil
+// encryptionFromHeaders returns the encryption of an object described by the
+// headers of a response, or nil if it isn't encrypted.
+func encryptionFromHeaders(h http.Header) *driver.ServerSideEncryption {
+	switch h.Get(oss.HTTPHeaderOssServerSideEncryption) {
+	case "AES256", "SM4":
+		return &driver.ServerSideEncryption{Mode: driver.EncryptionServiceKey}
+	case "KMS":
+		return &driver.ServerSideEncryption{Mode: driver.EncryptionKMS, KeyID: h.Get(oss.HTTPHeaderOssServerSideEncryptionKeyID)}
+	}
+	return nil
+}
+
+// ossTagging returns tags as an oss.Tagging, sorted by key.
+func ossTagging(tags map[string]string) oss.Tagging {
+	var t oss.Tagging
+	for k, v := range tags {
+		t.Tags = append(t.Tags, oss.Tag{Key: k, Value: v})
+	}
+	sort.Slice(t.Tags, func(i, j int) bool { return t.Tags[i].Key < t.Tags[j].Key })
+	return t
+}
+
+// SetTags implements driver.Tagger.
+func (b *bucket) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	key = escapeKey(key)
+	if len(tags) == 0 {
+		return b.ob.DeleteObjectTagging(key)
+	}
+	return b.ob.PutObjectTagging(key, ossTagging(tags))
 }
 
 // Copy copies the object associated with srcKey to dstKey.
@@ -577,13 +765,24 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 //
 // opts is guaranteed to be non-nil.
 func (b *bucket) Copy(ctx context.Context, dstKey string, srcKey string, opts *driver.CopyOptions) error {
+	if isCustomerKey(opts.ServerSideEncryption) || opts.SourceServerSideEncryption != nil {
+		return errCustomerKey
+	}
 	srcKey = escapeKey(srcKey)
 	dstKey = escapeKey(dstKey)
 
-	in := []oss.Option{}
+	in := encryptionOptions(opts.ServerSideEncryption)
+
+	if opts.IfMatch == "" && opts.IfNoneMatch == "*" {
+		in = append(in, oss.ForbidOverWrite(true))
+	} else if err := b.checkPreconditions(dstKey, opts.IfMatch, opts.IfNoneMatch); err != nil {
+		return err
+	}
 
 	if opts.BeforeCopy != nil {
-		return opts.BeforeCopy(func(interface{}) bool { return false })
+		if err := opts.BeforeCopy(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
 	}
 
 	_, err := b.ob.CopyObject(srcKey, dstKey, in...)
@@ -594,8 +793,11 @@ func (b *bucket) Copy(ctx context.Context, dstKey string, srcKey string, opts *d
 // Delete deletes the object associated with key. If the specified object does
 // not exist, Delete must return an error for which ErrorCode returns
 // gdkerr.NotFound.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	key = escapeKey(key)
+	if err := b.checkPreconditions(key, opts.IfMatch, ""); err != nil {
+		return err
+	}
 	return b.ob.DeleteObject(key)
 }
 
@@ -0,0 +1,91 @@
+package aliyunblob
+
+import (
+	"context"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// Compose implements driver.Composer. The composed object is a multipart
+// upload whose parts are copied from the sources with UploadPartCopy, so
+// every source except the last one must be at least oss.MinPartSize (100 KiB).
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	if isCustomerKey(opts.ServerSideEncryption) || opts.SourceServerSideEncryption != nil {
+		return errCustomerKey
+	}
+	// Check the sources before starting the upload, and make sure that they
+	// don't change while they are copied.
+	attrs := make([]*driver.Attributes, len(srcKeys))
+	for i, key := range srcKeys {
+		a, err := b.Attributes(ctx, key)
+		if err != nil {
+			return err
+		}
+		if a.Size < oss.MinPartSize && i < len(srcKeys)-1 {
+			return gdkerr.Newf(gdkerr.Unimplemented, nil, "aliyunblob: Compose source %q is smaller than %d bytes", key, oss.MinPartSize)
+		}
+		attrs[i] = a
+	}
+	if len(srcKeys) == 1 && attrs[0].Size == 0 {
+		return gdkerr.Newf(gdkerr.Unimplemented, nil, "aliyunblob: Compose of a single empty object")
+	}
+
+	dstKey = escapeKey(dstKey)
+	in := objectOptions(opts.ContentType, &driver.WriterOptions{ServerSideEncryption: opts.ServerSideEncryption})
+	for k, v := range opts.Metadata {
+		in = append(in, oss.Meta(k, v))
+	}
+	imur, err := b.ob.InitiateMultipartUpload(dstKey, in...)
+	if err != nil {
+		return err
+	}
+	if err := b.composeParts(imur, srcKeys, attrs, opts); err != nil {
+		// The upload ID is the only handle on the parts; don't leave them
+		// behind.
+		b.ob.AbortMultipartUpload(imur)
+		return err
+	}
+	return nil
+}
+
+// composeParts copies the sources into the multipart upload imur, and
+// completes it.
+func (b *bucket) composeParts(imur oss.InitiateMultipartUploadResult, srcKeys []string, attrs []*driver.Attributes, opts *driver.ComposeOptions) error {
+	var parts []oss.UploadPart
+	for i, key := range srcKeys {
+		size := attrs[i].Size
+		if size == 0 {
+			// Only the last source can be empty; there is nothing to copy.
+			continue
+		}
+		var in []oss.Option
+		if attrs[i].ETag != "" {
+			in = append(in, oss.CopySourceIfMatch(attrs[i].ETag))
+		}
+		// Split large sources into equal ranges, so that none of them is
+		// too small to be a part.
+		n := (size + oss.MaxPartSize - 1) / oss.MaxPartSize
+		for start, j := int64(0), int64(0); j < n; j++ {
+			end := size * (j + 1) / n
+			part, err := b.ob.UploadPartCopy(imur, b.ob.BucketName, escapeKey(key), start, end-start, len(parts)+1, in...)
+			if err != nil {
+				return err
+			}
+			parts = append(parts, part)
+			start = end
+		}
+	}
+
+	var in []oss.Option
+	if opts.IfMatch == "" && opts.IfNoneMatch == "*" {
+		// OSS can enforce this one natively.
+		in = append(in, oss.ForbidOverWrite(true))
+	} else if err := b.checkPreconditions(imur.Key, opts.IfMatch, opts.IfNoneMatch); err != nil {
+		return err
+	}
+	_, err := b.ob.CompleteMultipartUpload(imur, parts, in...)
+	return err
+}
@@ -0,0 +1,195 @@
+package aliyunblob
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+
+	"github.com/sraphs/gdk/blob/driver"
+)
+
+// defaultPartSize is the default size of the parts of resumable uploads.
+const defaultPartSize = 5 * 1024 * 1024
+
+// NewResumableWriter implements driver.ResumableUploader. Sessions are OSS
+// multipart uploads, and tokens are their upload IDs.
+func (b *bucket) NewResumableWriter(ctx context.Context, key, contentType, token string, opts *driver.WriterOptions) (driver.ResumableWriter, error) {
+	if isCustomerKey(opts.ServerSideEncryption) {
+		return nil, errCustomerKey
+	}
+	key = escapeKey(key)
+	w := &resumableWriter{
+		ctx:         ctx,
+		b:           b,
+		partSize:    defaultPartSize,
+		ifMatch:     opts.IfMatch,
+		ifNoneMatch: opts.IfNoneMatch,
+	}
+	if opts.BufferSize > 0 {
+		w.partSize = int64(opts.BufferSize)
+	}
+	// OSS rejects parts smaller than this, except for the last one.
+	if w.partSize < oss.MinPartSize {
+		w.partSize = oss.MinPartSize
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+
+	if token == "" {
+		imur, err := b.ob.InitiateMultipartUpload(key, objectOptions(contentType, opts)...)
+		if err != nil {
+			return nil, err
+		}
+		w.imur = imur
+		return w, nil
+	}
+
+	// Resume the upload after the parts that were already uploaded.
+	w.imur = oss.InitiateMultipartUploadResult{Bucket: b.ob.BucketName, Key: key, UploadID: token}
+	var in []oss.Option
+	for {
+		out, err := b.ob.ListUploadedParts(w.imur, in...)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range out.UploadedParts {
+			w.parts = append(w.parts, oss.UploadPart{PartNumber: part.PartNumber, ETag: part.ETag})
+			w.committed += int64(part.Size)
+		}
+		if !out.IsTruncated {
+			break
+		}
+		marker, err := strconv.Atoi(out.NextPartNumberMarker)
+		if err != nil {
+			return nil, err
+		}
+		in = []oss.Option{oss.PartNumberMarker(marker)}
+	}
+	sort.Sort(oss.UploadParts(w.parts))
+	return w, nil
+}
+
+// ListUploadSessions implements driver.ResumableUploader.
+func (b *bucket) ListUploadSessions(ctx context.Context, prefix string) ([]*driver.UploadSession, error) {
+	in := []oss.Option{oss.Prefix(escapeKey(prefix))}
+	var sessions []*driver.UploadSession
+	for {
+		out, err := b.ob.ListMultipartUploads(in...)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range out.Uploads {
+			sessions = append(sessions, &driver.UploadSession{
+				Key:     unescapeKey(u.Key),
+				Token:   u.UploadID,
+				Started: u.Initiated,
+			})
+		}
+		if !out.IsTruncated {
+			return sessions, nil
+		}
+		in = []oss.Option{
+			oss.Prefix(escapeKey(prefix)),
+			oss.KeyMarker(out.NextKeyMarker),
+			oss.UploadIDMarker(out.NextUploadIDMarker),
+		}
+	}
+}
+
+// AbortUploadSession implements driver.ResumableUploader.
+func (b *bucket) AbortUploadSession(ctx context.Context, key, token string) error {
+	imur := oss.InitiateMultipartUploadResult{Bucket: b.ob.BucketName, Key: escapeKey(key), UploadID: token}
+	return b.ob.AbortMultipartUpload(imur)
+}
+
+// resumableWriter writes to an OSS multipart upload. Bytes are committed when
+// the part holding them has been uploaded.
+type resumableWriter struct {
+	ctx      context.Context
+	b        *bucket
+	imur     oss.InitiateMultipartUploadResult
+	partSize int64
+
+	buf       bytes.Buffer
+	parts     []oss.UploadPart
+	committed int64
+
+	ifMatch     string
+	ifNoneMatch string
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		m := int(w.partSize) - w.buf.Len()
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf.Write(p[:m])
+		n += m
+		p = p[m:]
+		if int64(w.buf.Len()) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// uploadPart uploads the buffered bytes as the next part.
+func (w *resumableWriter) uploadPart() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	size := int64(w.buf.Len())
+	partNumber := 1
+	if len(w.parts) > 0 {
+		partNumber = w.parts[len(w.parts)-1].PartNumber + 1
+	}
+	part, err := w.b.ob.UploadPart(w.imur, bytes.NewReader(w.buf.Bytes()), size, partNumber)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, part)
+	w.committed += size
+	w.buf.Reset()
+	return nil
+}
+
+func (w *resumableWriter) SessionToken() string {
+	return w.imur.UploadID
+}
+
+func (w *resumableWriter) Committed() int64 {
+	return w.committed
+}
+
+// Close uploads the last part and completes the multipart upload. If the
+// context was canceled, the upload is left in place so it can be resumed.
+func (w *resumableWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	// Every multipart upload needs at least one part, even an empty one.
+	if w.buf.Len() > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+	var in []oss.Option
+	if w.ifMatch == "" && w.ifNoneMatch == "*" {
+		// OSS can enforce this one natively.
+		in = append(in, oss.ForbidOverWrite(true))
+	} else if err := w.b.checkPreconditions(w.imur.Key, w.ifMatch, w.ifNoneMatch); err != nil {
+		return err
+	}
+	_, err := w.b.ob.CompleteMultipartUpload(w.imur, w.parts, in...)
+	return err
+}
@@ -0,0 +1,963 @@
+// Package azureblob provides a blob implementation that uses Azure Blob
+// Storage. Use OpenBucket to construct a *blob.Bucket.
+//
+// # URLs
+//
+// For blob.OpenBucket, azureblob registers for the scheme "azblob".
+// The default URL opener will use the account name and credentials from the
+// environment variables AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY, and
+// AZURE_STORAGE_SAS_TOKEN; see NewDefaultServiceURLOptions for the full list.
+// To customize the URL opener, or for more details on the URL format,
+// see URLOpener.
+// See https://sraphs.github.io/gdk/concepts/urls/ for background information.
+//
+// # Escaping
+//
+// Go CDK supports all UTF-8 strings; to make this work with services lacking
+// full UTF-8 support, strings must be escaped (during writes) and unescaped
+// (during reads). The following escapes are performed for azureblob:
+//   - Blob keys: ASCII characters 0-31, 34 ("\""), 35 ("#"), 37 ("%"), 63 ("?"),
+//     92 ("\"), and 127 are escaped to "__0x<hex>__". A trailing "/" in a blob
+//     key is escaped in the same way. Additionally, the "/" in "../" is escaped
+//     in the same way.
+//   - Metadata keys: Per https://docs.microsoft.com/en-us/azure/storage/blobs/storage-properties-metadata,
+//     Azure only allows C# identifiers as metadata keys. Therefore, characters
+//     other than "[a-z][A-z][0-9]_" are escaped using "__0x<hex>__". In addition,
+//     characters "[0-9]" are escaped when they start the string.
+//   - Metadata values: Escaped using URL encoding.
+//
+// # As
+//
+// azureblob exposes the following types for As:
+//   - Bucket: *container.Client
+//   - Error: *azcore.ResponseError
+//   - ListObject: container.BlobItem for objects, container.BlobPrefix for "directories"
+//   - ListOptions.BeforeList: *container.ListBlobsFlatOptions, or
+//     *container.ListBlobsHierarchyOptions when ListOptions.Delimiter is set
+//   - Reader: azblobblob.DownloadStreamResponse
+//   - Reader.BeforeRead: *azblobblob.DownloadStreamOptions
+//   - Attributes: azblobblob.GetPropertiesResponse
+//   - CopyOptions.BeforeCopy: *azblobblob.StartCopyFromURLOptions
+//   - WriterOptions.BeforeWrite: *blockblob.UploadStreamOptions
+//   - SignedURLOptions.BeforeSign: *sas.BlobSignatureValues
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/google/wire"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/internal/escape"
+)
+
+const (
+	defaultPageSize = 1000
+
+	// defaultUploadBuffSize is the block size used for uploads when
+	// WriterOptions.BufferSize is not set.
+	defaultUploadBuffSize = 5 * 1024 * 1024
+	// defaultUploadConcurrency is the number of blocks uploaded concurrently
+	// when WriterOptions.MaxConcurrency is not set.
+	defaultUploadConcurrency = 5
+
+	// copyPollInterval is how often Copy checks whether an asynchronous
+	// server-side copy has finished.
+	copyPollInterval = 500 * time.Millisecond
+)
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, new(lazyOpener))
+}
+
+// Set holds Wire providers for this package.
+var Set = wire.NewSet(
+	NewDefaultServiceURLOptions,
+	wire.Struct(new(URLOpener), "ServiceURLOptions"),
+)
+
+// lazyOpener obtains the service URL options from the environment on the
+// first call to OpenBucketURL.
+type lazyOpener struct{}
+
+func (o *lazyOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	opener := &URLOpener{ServiceURLOptions: *NewDefaultServiceURLOptions()}
+	return opener.OpenBucketURL(ctx, u)
+}
+
+// Scheme is the URL scheme azureblob registers its URLOpener under on
+// blob.DefaultMux.
+const Scheme = "azblob"
+
+// ServiceURLOptions sets options for constructing the URL of an Azure Blob
+// Storage service endpoint.
+type ServiceURLOptions struct {
+	// AccountName is the storage account name. Required.
+	AccountName string
+
+	// AccountKey is the storage account key. If set, requests are authorized
+	// with a shared key and SignedURL is supported.
+	AccountKey string
+
+	// SASToken is a shared access signature to append to requests. It is used
+	// only when AccountKey is empty.
+	SASToken string
+
+	// StorageDomain is the domain of the service endpoint. Defaults to
+	// "blob.core.windows.net".
+	StorageDomain string
+
+	// Protocol is the protocol to use; "https" (the default) or "http".
+	Protocol string
+
+	// IsLocalEmulator should be set when connecting to a local emulator like
+	// Azurite. The service URL then has the form
+	// <Protocol>://<StorageDomain>/<AccountName>.
+	IsLocalEmulator bool
+}
+
+// NewDefaultServiceURLOptions returns a ServiceURLOptions populated from the
+// environment:
+//   - AZURE_STORAGE_ACCOUNT: AccountName
+//   - AZURE_STORAGE_KEY: AccountKey
+//   - AZURE_STORAGE_SAS_TOKEN: SASToken
+//   - AZURE_STORAGE_DOMAIN: StorageDomain
+//   - AZURE_STORAGE_PROTOCOL: Protocol
+//   - AZURE_STORAGE_IS_LOCAL_EMULATOR: IsLocalEmulator (parsed with strconv.ParseBool)
+func NewDefaultServiceURLOptions() *ServiceURLOptions {
+	isLocalEmulator, _ := strconv.ParseBool(os.Getenv("AZURE_STORAGE_IS_LOCAL_EMULATOR"))
+	return &ServiceURLOptions{
+		AccountName:     os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		AccountKey:      os.Getenv("AZURE_STORAGE_KEY"),
+		SASToken:        os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+		StorageDomain:   os.Getenv("AZURE_STORAGE_DOMAIN"),
+		Protocol:        os.Getenv("AZURE_STORAGE_PROTOCOL"),
+		IsLocalEmulator: isLocalEmulator,
+	}
+}
+
+// NewServiceURL returns the URL of the service endpoint described by opts.
+func NewServiceURL(opts *ServiceURLOptions) (string, error) {
+	if opts == nil || opts.AccountName == "" {
+		return "", errors.New("azureblob: AccountName is required")
+	}
+	domain := opts.StorageDomain
+	if domain == "" {
+		domain = "blob.core.windows.net"
+	}
+	protocol := opts.Protocol
+	if protocol == "" {
+		protocol = "https"
+	} else if protocol != "http" && protocol != "https" {
+		return "", fmt.Errorf("azureblob: invalid Protocol %q", protocol)
+	}
+	if opts.IsLocalEmulator {
+		return fmt.Sprintf("%s://%s/%s", protocol, domain, opts.AccountName), nil
+	}
+	return fmt.Sprintf("%s://%s.%s", protocol, opts.AccountName, domain), nil
+}
+
+// NewContainerClient returns a *container.Client for containerName, using
+// the endpoint and credentials described by opts. Either AccountKey or
+// SASToken must be set.
+func NewContainerClient(opts *ServiceURLOptions, containerName string) (*container.Client, error) {
+	serviceURL, err := NewServiceURL(opts)
+	if err != nil {
+		return nil, err
+	}
+	containerURL := serviceURL + "/" + containerName
+	switch {
+	case opts.AccountKey != "":
+		cred, err := azblob.NewSharedKeyCredential(opts.AccountName, opts.AccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	case opts.SASToken != "":
+		return container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(opts.SASToken, "?"), nil)
+	}
+	return nil, errors.New("azureblob: one of AccountKey or SASToken is required")
+}
+
+// URLOpener opens Azure URLs like "azblob://mycontainer".
+//
+// The URL host is used as the container name.
+//
+// The following query parameters are supported, and override the
+// corresponding fields of ServiceURLOptions:
+//
+//   - domain: StorageDomain
+//   - protocol: Protocol
+//   - localemulator: IsLocalEmulator
+type URLOpener struct {
+	// ServiceURLOptions describes the service endpoint and credentials.
+	ServiceURLOptions ServiceURLOptions
+
+	// Options specifies the options to pass to OpenBucket.
+	Options Options
+}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (o *URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	svcOpts, err := o.forParams(u.Query())
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %v", u, err)
+	}
+	client, err := NewContainerClient(svcOpts, u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %v", u, err)
+	}
+	opts := o.Options
+	if opts.Credential == nil && svcOpts.AccountKey != "" {
+		opts.Credential, err = azblob.NewSharedKeyCredential(svcOpts.AccountName, svcOpts.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("open bucket %v: %v", u, err)
+		}
+	}
+	return OpenBucket(ctx, client, &opts)
+}
+
+func (o *URLOpener) forParams(q url.Values) (*ServiceURLOptions, error) {
+	opts := new(ServiceURLOptions)
+	*opts = o.ServiceURLOptions
+	for param, values := range q {
+		value := values[0]
+		switch param {
+		case "domain":
+			opts.StorageDomain = value
+		case "protocol":
+			opts.Protocol = value
+		case "localemulator":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for query parameter %q: %v", value, param, err)
+			}
+			opts.IsLocalEmulator = b
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q", param)
+		}
+	}
+	return opts, nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by Azure Blob
+// Storage.
+type Options struct {
+	// Credential is the shared key credential used to sign SAS tokens for
+	// SignedURL. If nil, SignedURL returns an Unimplemented error.
+	Credential *azblob.SharedKeyCredential
+}
+
+// openBucket returns an Azure Blob Storage bucket for the container behind
+// client.
+func openBucket(ctx context.Context, client *container.Client, opts *Options) (*bucket, error) {
+	if client == nil {
+		return nil, errors.New("azureblob.OpenBucket: client is required")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	u, err := url.Parse(client.URL())
+	if err != nil {
+		return nil, fmt.Errorf("azureblob.OpenBucket: invalid container URL: %v", err)
+	}
+	name := path.Base(u.Path)
+	if name == "" || name == "/" || name == "." {
+		return nil, errors.New("azureblob.OpenBucket: container URL must include a container name")
+	}
+	return &bucket{name: name, client: client, opts: opts}, nil
+}
+
+// OpenBucket returns a *blob.Bucket backed by the Azure Blob Storage
+// container behind client. See the package documentation for an example.
+func OpenBucket(ctx context.Context, client *container.Client, opts *Options) (*blob.Bucket, error) {
+	drv, err := openBucket(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+// bucket represents an Azure Blob Storage container, which handles read,
+// write and delete operations on blobs within it.
+type bucket struct {
+	name   string // the container name
+	client *container.Client
+	opts   *Options
+}
+
+// reader reads an Azure blob. It implements driver.Reader.
+type reader struct {
+	body  io.ReadCloser
+	attrs driver.ReaderAttributes
+	raw   *azblobblob.DownloadStreamResponse
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.body.Read(p)
+}
+
+// Close closes the reader itself. It must be called when done reading.
+func (r *reader) Close() error {
+	return r.body.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	p, ok := i.(*azblobblob.DownloadStreamResponse)
+	if !ok || r.raw == nil {
+		return false
+	}
+	*p = *r.raw
+	return true
+}
+
+func (b *bucket) ErrorCode(err error) gdkerr.ErrorCode {
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound, bloberror.ResourceNotFound) {
+		return gdkerr.NotFound
+	}
+	if bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists) {
+		return gdkerr.FailedPrecondition
+	}
+	var rerr *azcore.ResponseError
+	if errors.As(err, &rerr) {
+		switch rerr.StatusCode {
+		case http.StatusForbidden:
+			return gdkerr.PermissionDenied
+		case http.StatusNotFound:
+			return gdkerr.NotFound
+		case http.StatusNotModified, http.StatusPreconditionFailed:
+			return gdkerr.FailedPrecondition
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return gdkerr.ResourceExhausted
+		}
+	}
+	return gdkerr.Unknown
+}
+
+func (b *bucket) Close() error {
+	return nil
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	pageSize := int32(opts.PageSize)
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	var marker *string
+	if len(opts.PageToken) > 0 {
+		marker = to.Ptr(string(opts.PageToken))
+	}
+	var prefix *string
+	if opts.Prefix != "" {
+		prefix = to.Ptr(escapeKey(opts.Prefix, true))
+	}
+
+	var (
+		items      []*container.BlobItem
+		prefixes   []*container.BlobPrefix
+		nextMarker *string
+	)
+	if opts.Delimiter == "" {
+		listOpts := &container.ListBlobsFlatOptions{
+			Include:    container.ListBlobsInclude{Metadata: true},
+			Marker:     marker,
+			MaxResults: to.Ptr(pageSize),
+			Prefix:     prefix,
+		}
+		if opts.BeforeList != nil {
+			asFunc := func(i interface{}) bool {
+				p, ok := i.(**container.ListBlobsFlatOptions)
+				if !ok {
+					return false
+				}
+				*p = listOpts
+				return true
+			}
+			if err := opts.BeforeList(asFunc); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := b.client.NewListBlobsFlatPager(listOpts).NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Segment != nil {
+			items = resp.Segment.BlobItems
+		}
+		nextMarker = resp.NextMarker
+	} else {
+		listOpts := &container.ListBlobsHierarchyOptions{
+			Include:    container.ListBlobsInclude{Metadata: true},
+			Marker:     marker,
+			MaxResults: to.Ptr(pageSize),
+			Prefix:     prefix,
+		}
+		if opts.BeforeList != nil {
+			asFunc := func(i interface{}) bool {
+				p, ok := i.(**container.ListBlobsHierarchyOptions)
+				if !ok {
+					return false
+				}
+				*p = listOpts
+				return true
+			}
+			if err := opts.BeforeList(asFunc); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := b.client.NewListBlobsHierarchyPager(escapeKey(opts.Delimiter, true), listOpts).NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Segment != nil {
+			items = resp.Segment.BlobItems
+			prefixes = resp.Segment.BlobPrefixes
+		}
+		nextMarker = resp.NextMarker
+	}
+
+	page := &driver.ListPage{}
+	if nextMarker != nil && *nextMarker != "" {
+		page.NextPageToken = []byte(*nextMarker)
+	}
+	if n := len(items) + len(prefixes); n > 0 {
+		page.Objects = make([]*driver.ListObject, 0, n)
+		for _, item := range items {
+			item := item
+			obj := &driver.ListObject{
+				Key: unescapeKey(deref(item.Name)),
+				AsFunc: func(i interface{}) bool {
+					p, ok := i.(*container.BlobItem)
+					if !ok {
+						return false
+					}
+					*p = *item
+					return true
+				},
+			}
+			if props := item.Properties; props != nil {
+				if props.LastModified != nil {
+					obj.ModTime = *props.LastModified
+				}
+				if props.ContentLength != nil {
+					obj.Size = *props.ContentLength
+				}
+				obj.MD5 = props.ContentMD5
+				if props.ETag != nil {
+					obj.ETag = string(*props.ETag)
+				}
+			}
+			page.Objects = append(page.Objects, obj)
+		}
+		for _, prefix := range prefixes {
+			prefix := prefix
+			page.Objects = append(page.Objects, &driver.ListObject{
+				Key:   unescapeKey(deref(prefix.Name)),
+				IsDir: true,
+				AsFunc: func(i interface{}) bool {
+					p, ok := i.(*container.BlobPrefix)
+					if !ok {
+						return false
+					}
+					*p = *prefix
+					return true
+				},
+			})
+		}
+		if len(items) > 0 && len(prefixes) > 0 {
+			// Azure gives us blobs and "directories" in separate lists; sort them.
+			sort.Slice(page.Objects, func(i, j int) bool {
+				return page.Objects[i].Key < page.Objects[j].Key
+			})
+		}
+	}
+	return page, nil
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(**container.Client)
+	if !ok {
+		return false
+	}
+	*p = b.client
+	return true
+}
+
+// As implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	return errors.As(err, i)
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	key = escapeKey(key, false)
+	resp, err := b.client.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	md := make(map[string]string, len(resp.Metadata))
+	for k, v := range resp.Metadata {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		md[escape.HexUnescape(k)] = escape.URLUnescape(deref(v))
+	}
+	attrs := &driver.Attributes{
+		CacheControl:       deref(resp.CacheControl),
+		ContentDisposition: deref(resp.ContentDisposition),
+		ContentEncoding:    deref(resp.ContentEncoding),
+		ContentLanguage:    deref(resp.ContentLanguage),
+		ContentType:        deref(resp.ContentType),
+		Metadata:           md,
+		MD5:                resp.ContentMD5,
+		AsFunc: func(i interface{}) bool {
+			p, ok := i.(*azblobblob.GetPropertiesResponse)
+			if !ok {
+				return false
+			}
+			*p = resp
+			return true
+		},
+	}
+	if resp.CreationTime != nil {
+		attrs.CreateTime = *resp.CreationTime
+	}
+	if resp.LastModified != nil {
+		attrs.ModTime = *resp.LastModified
+	}
+	if resp.ContentLength != nil {
+		attrs.Size = *resp.ContentLength
+	}
+	if resp.ETag != nil {
+		attrs.ETag = string(*resp.ETag)
+	}
+	return attrs, nil
+}
+
+var errServerSideEncryption = gdkerr.Newf(gdkerr.Unimplemented, nil, "azureblob: server-side encryption settings are not supported")
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.ServerSideEncryption != nil {
+		return nil, errServerSideEncryption
+	}
+	key = escapeKey(key, false)
+	blobClient := b.client.NewBlobClient(key)
+
+	// A zero Count means "to the end of the blob" to Azure, so a zero-length
+	// read is served from the blob's properties instead.
+	if length == 0 {
+		resp, err := blobClient.GetProperties(ctx, &azblobblob.GetPropertiesOptions{
+			AccessConditions: accessConditions(opts.IfMatch, opts.IfNoneMatch),
+		})
+		if err != nil {
+			return nil, err
+		}
+		r := &reader{body: io.NopCloser(strings.NewReader(""))}
+		r.attrs.ContentType = deref(resp.ContentType)
+		if resp.LastModified != nil {
+			r.attrs.ModTime = *resp.LastModified
+		}
+		if resp.ContentLength != nil {
+			r.attrs.Size = *resp.ContentLength
+		}
+		if resp.ETag != nil {
+			r.attrs.ETag = string(*resp.ETag)
+		}
+		return r, nil
+	}
+
+	dlOpts := &azblobblob.DownloadStreamOptions{
+		Range:            azblobblob.HTTPRange{Offset: offset},
+		AccessConditions: accessConditions(opts.IfMatch, opts.IfNoneMatch),
+	}
+	if length > 0 {
+		dlOpts.Range.Count = length
+	}
+	if opts.BeforeRead != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**azblobblob.DownloadStreamOptions)
+			if !ok {
+				return false
+			}
+			*p = dlOpts
+			return true
+		}
+		if err := opts.BeforeRead(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := blobClient.DownloadStream(ctx, dlOpts)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{body: resp.Body, raw: &resp}
+	r.attrs.ContentType = deref(resp.ContentType)
+	if resp.LastModified != nil {
+		r.attrs.ModTime = *resp.LastModified
+	}
+	r.attrs.Size = sizeFromContentRange(deref(resp.ContentRange))
+	if r.attrs.Size < 0 && resp.ContentLength != nil {
+		r.attrs.Size = *resp.ContentLength
+	}
+	if resp.ETag != nil {
+		r.attrs.ETag = string(*resp.ETag)
+	}
+	return r, nil
+}
+
+// sizeFromContentRange returns the total size from a Content-Range header of
+// the form "bytes 0-9/100", or -1 if it can't be determined.
+func sizeFromContentRange(cr string) int64 {
+	i := strings.LastIndex(cr, "/")
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(cr[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// escapeKey does all required escaping for UTF-8 strings to work with Azure.
+// isPrefix is true when escaping a prefix or delimiter rather than a full key.
+func escapeKey(key string, isPrefix bool) string {
+	return escape.HexEscape(key, func(r []rune, i int) bool {
+		c := r[i]
+		switch {
+		// Azure does not work well with backslashes in blob names.
+		case c == '\\':
+			return true
+		// Azure doesn't handle these characters (determined via experimentation).
+		case c < 32 || c == 34 || c == 35 || c == 37 || c == 63 || c == 127:
+			return true
+		// Escape trailing "/" for full keys, otherwise Azure can't address them
+		// consistently.
+		case !isPrefix && i == len(r)-1 && c == '/':
+			return true
+		// For "../", escape the trailing slash.
+		case i > 1 && c == '/' && r[i-1] == '.' && r[i-2] == '.':
+			return true
+		}
+		return false
+	})
+}
+
+// unescapeKey reverses escapeKey.
+func unescapeKey(key string) string {
+	return escape.HexUnescape(key)
+}
+
+// writer writes an Azure block blob. It implements driver.Writer.
+type writer struct {
+	ctx        context.Context
+	client     *blockblob.Client
+	uploadOpts *blockblob.UploadStreamOptions
+
+	w     *io.PipeWriter // created when the first byte is written
+	donec chan struct{}  // closed when done writing
+	// The following fields will be written before donec closes:
+	err error
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.ServerSideEncryption != nil {
+		return nil, errServerSideEncryption
+	}
+	if len(opts.Tags) > 0 || opts.StorageClass != "" {
+		return nil, gdkerr.New(gdkerr.Unimplemented, nil, 1, "azureblob: tags and storage classes are not supported")
+	}
+	key = escapeKey(key, false)
+	md := make(map[string]*string, len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		e := escape.HexEscape(k, func(runes []rune, i int) bool {
+			c := runes[i]
+			switch {
+			case i == 0 && c >= '0' && c <= '9':
+				return true
+			case escape.IsASCIIAlphanumeric(c):
+				return false
+			case c == '_':
+				return false
+			}
+			return true
+		})
+		if _, ok := md[e]; ok {
+			return nil, fmt.Errorf("duplicate keys after escaping: %q => %q", k, e)
+		}
+		md[e] = to.Ptr(escape.URLEscape(v))
+	}
+	uploadOpts := &blockblob.UploadStreamOptions{
+		BlockSize:   bufferSize(opts.BufferSize),
+		Concurrency: concurrency(opts.MaxConcurrency),
+		HTTPHeaders: &azblobblob.HTTPHeaders{
+			BlobCacheControl:       nilIfEmpty(opts.CacheControl),
+			BlobContentDisposition: nilIfEmpty(opts.ContentDisposition),
+			BlobContentEncoding:    nilIfEmpty(opts.ContentEncoding),
+			BlobContentLanguage:    nilIfEmpty(opts.ContentLanguage),
+			BlobContentMD5:         opts.ContentMD5,
+			BlobContentType:        nilIfEmpty(contentType),
+		},
+		Metadata:         md,
+		AccessConditions: accessConditions(opts.IfMatch, opts.IfNoneMatch),
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**blockblob.UploadStreamOptions)
+			if !ok {
+				return false
+			}
+			*p = uploadOpts
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	return &writer{
+		ctx:        ctx,
+		client:     b.client.NewBlockBlobClient(key),
+		uploadOpts: uploadOpts,
+		donec:      make(chan struct{}),
+	}, nil
+}
+
+// bufferSize returns the block size to use for uploads given
+// WriterOptions.BufferSize.
+func bufferSize(size int) int64 {
+	if size <= 0 {
+		return defaultUploadBuffSize
+	}
+	return int64(size)
+}
+
+// concurrency returns the number of concurrent block uploads given
+// WriterOptions.MaxConcurrency.
+func concurrency(n int) int {
+	if n <= 0 {
+		return defaultUploadConcurrency
+	}
+	return n
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Write appends p to w. User must call Close to close the w after done writing.
+func (w *writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.w == nil {
+		pr, pw := io.Pipe()
+		w.w = pw
+		w.open(pr)
+	}
+	select {
+	case <-w.donec:
+		return 0, w.err
+	default:
+	}
+	return w.w.Write(p)
+}
+
+// open starts the upload in the background, reading from pr. pr may be nil
+// if we're Closing and no data was written.
+func (w *writer) open(pr *io.PipeReader) {
+	go func() {
+		defer close(w.donec)
+
+		var body io.Reader = strings.NewReader("")
+		if pr != nil {
+			body = pr
+		}
+		_, w.err = w.client.UploadStream(w.ctx, body, w.uploadOpts)
+		if w.err != nil && pr != nil {
+			pr.CloseWithError(w.err)
+		}
+	}()
+}
+
+// Close completes the writer and closes it. Any error occurring during write
+// will be returned. If a writer is closed before any Write is called, Close
+// will create an empty blob at the given key.
+func (w *writer) Close() error {
+	if w.w == nil {
+		w.open(nil)
+	} else if err := w.w.Close(); err != nil {
+		return err
+	}
+	<-w.donec
+	return w.err
+}
+
+// Copy implements driver.Copy.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.ServerSideEncryption != nil || opts.SourceServerSideEncryption != nil {
+		return errServerSideEncryption
+	}
+	dstKey = escapeKey(dstKey, false)
+	srcKey = escapeKey(srcKey, false)
+	dstClient := b.client.NewBlobClient(dstKey)
+	srcURL := b.client.NewBlobClient(srcKey).URL()
+
+	copyOpts := &azblobblob.StartCopyFromURLOptions{
+		AccessConditions: accessConditions(opts.IfMatch, opts.IfNoneMatch),
+	}
+	if opts.BeforeCopy != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**azblobblob.StartCopyFromURLOptions)
+			if !ok {
+				return false
+			}
+			*p = copyOpts
+			return true
+		}
+		if err := opts.BeforeCopy(asFunc); err != nil {
+			return err
+		}
+	}
+	resp, err := dstClient.StartCopyFromURL(ctx, srcURL, copyOpts)
+	if err != nil {
+		return err
+	}
+	// The copy may complete asynchronously; wait for it.
+	status := resp.CopyStatus
+	for status != nil && *status == azblobblob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(copyPollInterval):
+		}
+		props, err := dstClient.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != azblobblob.CopyStatusTypeSuccess {
+		return fmt.Errorf("azureblob: copy of %q to %q finished with status %q", srcKey, dstKey, *status)
+	}
+	return nil
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	key = escapeKey(key, false)
+	_, err := b.client.NewBlobClient(key).Delete(ctx, &azblobblob.DeleteOptions{
+		AccessConditions: accessConditions(opts.IfMatch, ""),
+	})
+	return err
+}
+
+// accessConditions returns the Azure access conditions for the given ETag
+// preconditions, or nil if there are none.
+func accessConditions(ifMatch, ifNoneMatch string) *azblobblob.AccessConditions {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	mac := &azblobblob.ModifiedAccessConditions{}
+	if ifMatch != "" {
+		mac.IfMatch = to.Ptr(azcore.ETag(ifMatch))
+	}
+	if ifNoneMatch != "" {
+		mac.IfNoneMatch = to.Ptr(azcore.ETag(ifNoneMatch))
+	}
+	return &azblobblob.AccessConditions{ModifiedAccessConditions: mac}
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.SignedURLOptions) (string, error) {
+	if b.opts.Credential == nil {
+		return "", gdkerr.New(gdkerr.Unimplemented, nil, 1, "azureblob: to use SignedURL, you must call OpenBucket with a non-nil Options.Credential")
+	}
+	if dopts.ContentType != "" || dopts.EnforceAbsentContentType {
+		return "", gdkerr.New(gdkerr.Unimplemented, nil, 1, "azureblob: does not enforce Content-Type on PUT")
+	}
+
+	key = escapeKey(key, false)
+	blobClient := b.client.NewBlobClient(key)
+	u, err := url.Parse(blobClient.URL())
+	if err != nil {
+		return "", err
+	}
+	perms := sas.BlobPermissions{}
+	switch dopts.Method {
+	case http.MethodGet:
+		perms.Read = true
+	case http.MethodPut:
+		perms.Create = true
+		perms.Write = true
+	case http.MethodDelete:
+		perms.Delete = true
+	default:
+		return "", fmt.Errorf("unsupported Method %s", dopts.Method)
+	}
+	values := &sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPSandHTTP,
+		ExpiryTime:    time.Now().UTC().Add(dopts.Expiry),
+		Permissions:   perms.String(),
+		ContainerName: b.name,
+		BlobName:      key,
+	}
+	if dopts.BeforeSign != nil {
+		asFunc := func(i interface{}) bool {
+			v, ok := i.(**sas.BlobSignatureValues)
+			if ok {
+				*v = values
+			}
+			return ok
+		}
+		if err := dopts.BeforeSign(asFunc); err != nil {
+			return "", err
+		}
+	}
+	qp, err := values.SignWithSharedKey(b.opts.Credential)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = qp.Encode()
+	return u.String(), nil
+}
+
+// deref returns the string s points to, or "" if s is nil.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,493 @@
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/blob/drivertest"
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/internal/testing/setup"
+)
+
+// To run these tests against a local Azurite emulator, first run
+// ./localazurite.sh. Then wait a few seconds for the emulator to be ready.
+
+const (
+	// emulatorAddr is the address of the Azurite blob service.
+	emulatorAddr = "127.0.0.1:10000"
+	// The well-known development account that Azurite accepts; see
+	// https://learn.microsoft.com/en-us/azure/storage/common/storage-use-azurite.
+	emulatorAccountName = "devstoreaccount1"
+	emulatorAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+	containerName = "gdk-blob-test"
+)
+
+var emulatorOpts = &ServiceURLOptions{
+	AccountName:     emulatorAccountName,
+	AccountKey:      emulatorAccountKey,
+	StorageDomain:   emulatorAddr,
+	Protocol:        "http",
+	IsLocalEmulator: true,
+}
+
+type harness struct {
+	cred *azblob.SharedKeyCredential
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	if !setup.HasDockerTestEnvironment() {
+		t.Skip("Skipping Azure tests since the Azurite emulator is not available")
+	}
+	conn, err := net.DialTimeout("tcp", emulatorAddr, time.Second)
+	if err != nil {
+		t.Skipf("No local Azurite emulator running: %v; see blob/azureblob/localazurite.sh", err)
+	}
+	conn.Close()
+
+	cred, err := azblob.NewSharedKeyCredential(emulatorAccountName, emulatorAccountKey)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewContainerClient(emulatorOpts, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.Create(ctx, nil); err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return nil, err
+	}
+	return &harness{cred: cred}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return http.DefaultClient
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	client, err := NewContainerClient(emulatorOpts, containerName)
+	if err != nil {
+		return nil, err
+	}
+	return openBucket(ctx, client, &Options{Credential: h.cred})
+}
+
+func (h *harness) MakeDriverForNonexistentBucket(ctx context.Context) (driver.Bucket, error) {
+	client, err := NewContainerClient(emulatorOpts, "bucket-does-not-exist")
+	if err != nil {
+		return nil, err
+	}
+	return openBucket(ctx, client, &Options{Credential: h.cred})
+}
+
+func (h *harness) Close() {}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyContentLanguage{}})
+}
+
+const language = "nl"
+
+// verifyContentLanguage uses As to access the underlying Azure types and
+// read/write the ContentLanguage field.
+type verifyContentLanguage struct{}
+
+func (verifyContentLanguage) Name() string {
+	return "verify ContentLanguage can be written and read through As"
+}
+
+func (verifyContentLanguage) BucketCheck(b *blob.Bucket) error {
+	var client *container.Client
+	if !b.As(&client) {
+		return errors.New("Bucket.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ErrorCheck(b *blob.Bucket, err error) error {
+	var rerr *azcore.ResponseError
+	if !b.ErrorAs(err, &rerr) {
+		return errors.New("Bucket.ErrorAs failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeRead(as func(interface{}) bool) error {
+	var opts *azblobblob.DownloadStreamOptions
+	if !as(&opts) {
+		return errors.New("BeforeRead.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeWrite(as func(interface{}) bool) error {
+	var opts *blockblob.UploadStreamOptions
+	if !as(&opts) {
+		return errors.New("Writer.As failed")
+	}
+	// Nothing else sets the language, so the checks below see this value.
+	if opts.HTTPHeaders == nil {
+		return errors.New("Writer.As returned options without HTTPHeaders")
+	}
+	if got := deref(opts.HTTPHeaders.BlobContentLanguage); got != "" {
+		return fmt.Errorf("Writer already has ContentLanguage %q", got)
+	}
+	opts.HTTPHeaders.BlobContentLanguage = to.Ptr(language)
+	return nil
+}
+
+func (verifyContentLanguage) BeforeCopy(as func(interface{}) bool) error {
+	var opts *azblobblob.StartCopyFromURLOptions
+	if !as(&opts) {
+		return errors.New("BeforeCopy.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeList(as func(interface{}) bool) error {
+	var flat *container.ListBlobsFlatOptions
+	var hier *container.ListBlobsHierarchyOptions
+	if !as(&flat) && !as(&hier) {
+		return errors.New("List.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeSign(as func(interface{}) bool) error {
+	var values *sas.BlobSignatureValues
+	if !as(&values) {
+		return errors.New("BeforeSign.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) AttributesCheck(attrs *blob.Attributes) error {
+	var resp azblobblob.GetPropertiesResponse
+	if !attrs.As(&resp) {
+		return errors.New("Attributes.As returned false")
+	}
+	if got := deref(resp.ContentLanguage); got != language {
+		return fmt.Errorf("got native ContentLanguage %q want %q", got, language)
+	}
+	// The value set through BeforeWrite is also the portable one.
+	if got := attrs.ContentLanguage; got != language {
+		return fmt.Errorf("got ContentLanguage %q want %q", got, language)
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ReaderCheck(r *blob.Reader) error {
+	var resp azblobblob.DownloadStreamResponse
+	if !r.As(&resp) {
+		return errors.New("Reader.As returned false")
+	}
+	if got := deref(resp.ContentLanguage); got != language {
+		return fmt.Errorf("got %q want %q", got, language)
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
+	if o.IsDir {
+		var prefix container.BlobPrefix
+		if !o.As(&prefix) {
+			return errors.New("ListObject.As for directory returned false")
+		}
+		return nil
+	}
+	var item container.BlobItem
+	if !o.As(&item) {
+		return errors.New("ListObject.As for object returned false")
+	}
+	if item.Properties == nil {
+		return errors.New("ListObject.As for object returned nil Properties")
+	}
+	if got := deref(item.Properties.ContentLanguage); got != language {
+		return fmt.Errorf("got %q want %q", got, language)
+	}
+	return nil
+}
+
+func TestEscapeKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		isPrefix bool
+		want     string
+	}{
+		{"foo/bar", false, "foo/bar"},
+		{"foo\\bar", false, "foo__0x5c__bar"},
+		{"foo?bar#baz", false, "foo__0x3f__bar__0x23__baz"},
+		{"foo%bar", false, "foo__0x25__bar"},
+		{"foo/", false, "foo__0x2f__"},
+		{"foo/", true, "foo/"},
+		{"../foo", false, "..__0x2f__foo"},
+	}
+	for _, test := range tests {
+		got := escapeKey(test.key, test.isPrefix)
+		if got != test.want {
+			t.Errorf("escapeKey(%q, %v) got %q want %q", test.key, test.isPrefix, got, test.want)
+		}
+		if back := unescapeKey(got); back != test.key {
+			t.Errorf("unescapeKey(%q) got %q want %q", got, back, test.key)
+		}
+	}
+}
+
+func TestNewServiceURL(t *testing.T) {
+	tests := []struct {
+		opts    *ServiceURLOptions
+		want    string
+		wantErr bool
+	}{
+		{opts: nil, wantErr: true},
+		{opts: &ServiceURLOptions{}, wantErr: true},
+		{opts: &ServiceURLOptions{AccountName: "acct"}, want: "https://acct.blob.core.windows.net"},
+		{opts: &ServiceURLOptions{AccountName: "acct", StorageDomain: "blob.core.chinacloudapi.cn"}, want: "https://acct.blob.core.chinacloudapi.cn"},
+		{opts: &ServiceURLOptions{AccountName: "acct", Protocol: "ftp"}, wantErr: true},
+		{opts: emulatorOpts, want: "http://127.0.0.1:10000/devstoreaccount1"},
+	}
+	for _, test := range tests {
+		got, err := NewServiceURL(test.opts)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%+v: got err %v want error %v", test.opts, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("%+v: got %q want %q", test.opts, got, test.want)
+		}
+	}
+}
+
+func TestOpenBucket(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		description string
+		url         string
+		nilClient   bool
+		want        string
+		wantErr     bool
+	}{
+		{
+			description: "nil client results in error",
+			nilClient:   true,
+			wantErr:     true,
+		},
+		{
+			description: "missing container name results in error",
+			url:         "https://acct.blob.core.windows.net/",
+			wantErr:     true,
+		},
+		{
+			description: "success",
+			url:         "https://acct.blob.core.windows.net/foo",
+			want:        "foo",
+		},
+		{
+			description: "success with emulator URL",
+			url:         "http://127.0.0.1:10000/devstoreaccount1/foo",
+			want:        "foo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var client *container.Client
+			if !test.nilClient {
+				var err error
+				client, err = container.NewClientWithNoCredential(test.url, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Create driver impl.
+			drv, err := openBucket(ctx, client, nil)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got err %v want error %v", err, test.wantErr)
+			}
+			if err == nil && drv != nil && drv.name != test.want {
+				t.Errorf("got %q want %q", drv.name, test.want)
+			}
+
+			// Create portable type.
+			b, err := OpenBucket(ctx, client, nil)
+			if b != nil {
+				defer b.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("got err %v want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestUploadOptions(t *testing.T) {
+	ctx := context.Background()
+	client, err := container.NewClientWithNoCredential("https://acct.blob.core.windows.net/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, err := openBucket(ctx, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		bufferSize, maxConcurrency int
+		wantBlockSize              int64
+		wantConcurrency            int
+	}{
+		{0, 0, defaultUploadBuffSize, defaultUploadConcurrency},
+		{-1, -1, defaultUploadBuffSize, defaultUploadConcurrency},
+		{1024 * 1024, 3, 1024 * 1024, 3},
+	}
+	for _, test := range tests {
+		var got *blockblob.UploadStreamOptions
+		opts := &driver.WriterOptions{
+			BufferSize:     test.bufferSize,
+			MaxConcurrency: test.maxConcurrency,
+			BeforeWrite: func(as func(interface{}) bool) error {
+				if !as(&got) {
+					return errors.New("Writer.As failed")
+				}
+				return nil
+			},
+		}
+		if _, err := drv.NewTypedWriter(ctx, "key", "text/plain", opts); err != nil {
+			t.Fatal(err)
+		}
+		if got.BlockSize != test.wantBlockSize {
+			t.Errorf("BufferSize %d: got BlockSize %d want %d", test.bufferSize, got.BlockSize, test.wantBlockSize)
+		}
+		if got.Concurrency != test.wantConcurrency {
+			t.Errorf("MaxConcurrency %d: got Concurrency %d want %d", test.maxConcurrency, got.Concurrency, test.wantConcurrency)
+		}
+		if ct := deref(got.HTTPHeaders.BlobContentType); ct != "text/plain" {
+			t.Errorf("got ContentType %q want %q", ct, "text/plain")
+		}
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewContainerClient(emulatorOpts, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := azblob.NewSharedKeyCredential(emulatorAccountName, emulatorAccountKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a credential, SignedURL is unimplemented.
+	b, err := OpenBucket(ctx, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := b.SignedURL(ctx, "my-key", nil); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("got error %v want Unimplemented", err)
+	}
+
+	b, err = OpenBucket(ctx, client, &Options{Credential: cred})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	tests := []struct {
+		method   string
+		wantPerm string
+	}{
+		{http.MethodGet, "r"},
+		{http.MethodPut, "cw"},
+		{http.MethodDelete, "d"},
+	}
+	for _, test := range tests {
+		s, err := b.SignedURL(ctx, "my-key", &blob.SignedURLOptions{Method: test.method})
+		if err != nil {
+			t.Fatalf("%s: %v", test.method, err)
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := u.Path, "/devstoreaccount1/foo/my-key"; got != want {
+			t.Errorf("%s: got path %q want %q", test.method, got, want)
+		}
+		q := u.Query()
+		if got := q.Get("sp"); got != test.wantPerm {
+			t.Errorf("%s: got permissions %q want %q", test.method, got, test.wantPerm)
+		}
+		if q.Get("sig") == "" {
+			t.Errorf("%s: got no signature", test.method)
+		}
+	}
+
+	// Content-Type can't be enforced on PUT.
+	_, err = b.SignedURL(ctx, "my-key", &blob.SignedURLOptions{Method: http.MethodPut, ContentType: "text/plain"})
+	if gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("got error %v want Unimplemented", err)
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		// OK.
+		{"azblob://mycontainer", false},
+		// OK, setting domain.
+		{"azblob://mycontainer?domain=blob.core.usgovcloudapi.net", false},
+		// OK, setting protocol.
+		{"azblob://mycontainer?protocol=http", false},
+		// OK, setting localemulator.
+		{"azblob://mycontainer?localemulator=true&domain=localhost:10000", false},
+		// Invalid protocol.
+		{"azblob://mycontainer?protocol=ftp", true},
+		// Invalid localemulator.
+		{"azblob://mycontainer?localemulator=notabool", true},
+		// Invalid parameter.
+		{"azblob://mycontainer?param=value", true},
+	}
+
+	mux := new(blob.URLMux)
+	mux.RegisterBucket(Scheme, &URLOpener{ServiceURLOptions: ServiceURLOptions{
+		AccountName: emulatorAccountName,
+		AccountKey:  emulatorAccountKey,
+	}})
+	for _, test := range tests {
+		b, err := mux.OpenBucket(ctx, test.URL)
+		if b != nil {
+			defer b.Close()
+		}
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+	}
+
+	// Without credentials, opening fails.
+	mux = new(blob.URLMux)
+	mux.RegisterBucket(Scheme, &URLOpener{ServiceURLOptions: ServiceURLOptions{AccountName: "acct"}})
+	if _, err := mux.OpenBucket(ctx, "azblob://mycontainer"); err == nil || !strings.Contains(err.Error(), "AccountKey or SASToken") {
+		t.Errorf("got error %v, want missing credentials error", err)
+	}
+}
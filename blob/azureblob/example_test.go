@@ -0,0 +1,55 @@
+package azureblob_test
+
+import (
+	"context"
+	"log"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/azureblob"
+)
+
+func ExampleOpenBucket() {
+	// PRAGMA: This example is used on github.com/sraphs/gdk; PRAGMA comments adjust how it is shown and can be ignored.
+	// PRAGMA: On github.com/sraphs/gdk, hide lines until the next blank line.
+	ctx := context.Background()
+
+	// Describe the service endpoint and credentials, here from the
+	// AZURE_STORAGE_* environment variables.
+	opts := azureblob.NewDefaultServiceURLOptions()
+
+	// Create a *container.Client for the container "my-container".
+	client, err := azureblob.NewContainerClient(opts, "my-container")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create a *blob.Bucket.
+	bucket, err := azureblob.OpenBucket(ctx, client, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
+
+func Example_openBucketFromURL() {
+	// PRAGMA: This example is used on github.com/sraphs/gdk; PRAGMA comments adjust how it is shown and can be ignored.
+	// PRAGMA: On github.com/sraphs/gdk, add a blank import: _ "github.com/sraphs/gdk/blob/azureblob"
+	// PRAGMA: On github.com/sraphs/gdk, hide lines until the next blank line.
+	ctx := context.Background()
+
+	// blob.OpenBucket creates a *blob.Bucket from a URL.
+	// This URL will open the container "my-container" using credentials found
+	// in environment variables, as documented in the package.
+	bucket, err := blob.OpenBucket(ctx, "azblob://my-container")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+
+	// Another example, against a local emulator like Azurite.
+	localbucket, err := blob.OpenBucket(ctx, "azblob://my-container?protocol=http&localemulator=true&domain=localhost:10000")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer localbucket.Close()
+}
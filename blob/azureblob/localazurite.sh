@@ -0,0 +1,13 @@
+#!/usr/bin/env bash
+
+# Starts a local Azurite (Azure Storage emulator) instance via Docker.
+
+# https://coderwall.com/p/fkfaqq/safer-bash-scripts-with-set-euxo-pipefail
+set -euo pipefail
+
+# Clean up and run Azurite.
+echo "Starting Azurite..."
+docker rm -f azurite &>/dev/null || :
+docker run -d -p 10000:10000 --name azurite mcr.microsoft.com/azure-storage/azurite:3.21.0 azurite-blob --blobHost 0.0.0.0 --loose &>/dev/null
+echo "...done. Run \"docker rm -f azurite\" to clean up the container."
+echo
@@ -48,6 +48,7 @@ package blob
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/md5"
 	"fmt"
@@ -64,14 +65,18 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/googleapis/gax-go/v2"
+	"github.com/klauspost/compress/zstd"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/sraphs/gdk/blob/driver"
 	"github.com/sraphs/gdk/gdkerr"
 	"github.com/sraphs/gdk/internal/oc"
 	"github.com/sraphs/gdk/internal/openurl"
+	"github.com/sraphs/gdk/secrets"
 )
 
 // Ensure that Reader implements io.ReadSeekCloser.
@@ -91,6 +96,13 @@ type Reader struct {
 	relativeOffset int64                 // Current offset (relative to baseOffset).
 	savedOffset    int64                 // Last relativeOffset for r, saved after relativeOffset is changed in Seek, or -1 if no Seek.
 	end            func(error)           // Called at Close to finish trace and metric collection.
+	// for retries of failed reads; see ReaderOptions.RetryPolicy.
+	retry      *ReadRetryPolicy // nil if retries are disabled
+	backoff    gax.Backoff
+	retries    int   // Consecutive retries since the last successful read.
+	pendingErr error // Error to retry at the next Read, held back because the failed read returned data.
+	// for progress reporting and bandwidth limiting; nil if neither is enabled.
+	xfer *transfer
 	// for metric collection;
 	statsTagMutators []tag.Mutator
 	bytesRead        int
@@ -116,28 +128,146 @@ func (r *Reader) Read(p []byte) (int, error) {
 			// Nope! We're at the same place we left off.
 		} else {
 			// Yep! We've changed the offset. Recreate the reader.
-			_ = r.r.Close()
-			length := r.baseLength
-			if length >= 0 {
-				length -= r.relativeOffset
-				if length < 0 {
-					// Shouldn't happen based on checks in Seek.
-					return 0, gdkerr.Newf(gdkerr.Internal, nil, "blob: invalid Seek (base length %d, relative offset %d)", r.baseLength, r.relativeOffset)
-				}
-			}
-			var err error
-			r.r, err = r.b.NewRangeReader(r.ctx, r.key, r.baseOffset+r.relativeOffset, length, r.dopts)
-			if err != nil {
+			if err := r.reopen(); err != nil {
 				return 0, wrapError(r.b, err, r.key)
 			}
+			r.pendingErr = nil
+		}
+	}
+	var n int
+	var err error
+	if r.pendingErr != nil {
+		err, r.pendingErr = r.pendingErr, nil
+	} else {
+		n, err = r.read(p)
+	}
+	for err != nil && err != io.EOF && r.retry != nil {
+		if n > 0 {
+			// Return the data we got; the next Read will deal with err.
+			r.pendingErr = err
+			return n, nil
+		}
+		if !r.shouldRetry(err) {
+			break
+		}
+		if err = gax.Sleep(r.ctx, r.backoff.Pause()); err != nil {
+			break
+		}
+		if err = r.reopen(); err == nil {
+			n, err = r.read(p)
 		}
 	}
+	return n, wrapError(r.b, err, r.key)
+}
+
+// read reads from the underlying reader, keeping track of the offset.
+func (r *Reader) read(p []byte) (int, error) {
 	n, err := r.r.Read(p)
 	r.bytesRead += n
 	r.relativeOffset += int64(n)
-	return n, wrapError(r.b, err, r.key)
+	if n > 0 && r.retries > 0 {
+		r.retries = 0
+		r.backoff = gax.Backoff{Initial: r.backoff.Initial, Max: r.backoff.Max}
+	}
+	r.xfer.add(n)
+	// The bytes were already read; pay for them before the next read.
+	if werr := r.xfer.wait(n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// reopen replaces the underlying reader with one starting at the current
+// offset.
+func (r *Reader) reopen() error {
+	_ = r.r.Close()
+	length := r.baseLength
+	if length >= 0 {
+		length -= r.relativeOffset
+		if length < 0 {
+			// Shouldn't happen based on checks in Seek.
+			return gdkerr.Newf(gdkerr.Internal, nil, "blob: invalid Seek (base length %d, relative offset %d)", r.baseLength, r.relativeOffset)
+		}
+	}
+	dr, err := r.b.NewRangeReader(r.ctx, r.key, r.baseOffset+r.relativeOffset, length, r.dopts)
+	if err != nil {
+		// Leave a reader behind so that Close and the attribute getters
+		// keep working.
+		r.r = &closedReader{attrs: *r.r.Attributes()}
+		return err
+	}
+	r.r = dr
+	return nil
+}
+
+// setRetryPolicy enables retries of failed reads according to policy, and
+// pins the reader to the ETag of the blob it is reading.
+func (r *Reader) setRetryPolicy(policy *ReadRetryPolicy) error {
+	p := *policy
+	if p.MaxRetries <= 0 {
+		p.MaxRetries = DefaultReadMaxRetries
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultReadInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultReadMaxBackoff
+	}
+	r.retry = &p
+	r.backoff = gax.Backoff{Initial: p.InitialBackoff, Max: p.MaxBackoff}
+
+	// A specific version never changes, and neither does a blob whose ETag
+	// the caller has already pinned.
+	if r.dopts.VersionID != "" || (r.dopts.IfMatch != "" && r.dopts.IfMatch != "*") {
+		return nil
+	}
+	eTag := r.r.Attributes().ETag
+	if eTag == "" {
+		// The driver doesn't report the ETag when reading; look it up.
+		attrs, err := r.b.Attributes(r.ctx, r.key)
+		if err != nil {
+			return err
+		}
+		eTag = attrs.ETag
+	}
+	dopts := *r.dopts
+	dopts.IfMatch = eTag
+	r.dopts = &dopts
+	return nil
+}
+
+// shouldRetry reports whether a read that failed with err should be retried,
+// counting the retry if so.
+func (r *Reader) shouldRetry(err error) bool {
+	if r.retries >= r.retry.MaxRetries || r.ctx.Err() != nil {
+		return false
+	}
+	err = wrapError(r.b, err, r.key)
+	if r.retry.IsRetryable != nil {
+		if !r.retry.IsRetryable(err) {
+			return false
+		}
+	} else if !isRetryableReadError(r.ctx, err) {
+		return false
+	}
+	r.retries++
+	return true
+}
+
+// closedReader is a driver.Reader standing in for one that could not be
+// reopened.
+type closedReader struct {
+	attrs driver.ReaderAttributes
+}
+
+func (r *closedReader) Read([]byte) (int, error) {
+	return 0, gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: Reader could not be reopened")
 }
 
+func (r *closedReader) Close() error                         { return nil }
+func (r *closedReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+func (r *closedReader) As(interface{}) bool                  { return false }
+
 // Seek implements io.Seeker (https://golang.org/pkg/io/#Seeker).
 func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 	if r.savedOffset == -1 {
@@ -276,6 +406,11 @@ type Attributes struct {
 	// case-insensitive keys (e.g., "foo" and "FOO"), only one value
 	// will be kept, and it is undefined which one.
 	Metadata map[string]string
+	// Tags holds the tags of the blob, or nil; see WriterOptions.Tags.
+	Tags map[string]string
+	// StorageClass is the service-specific storage class of the blob, if
+	// available; see WriterOptions.StorageClass.
+	StorageClass string
 	// CreateTime is the time the blob was created, if available. If not available,
 	// CreateTime will be the zero time.
 	CreateTime time.Time
@@ -285,8 +420,18 @@ type Attributes struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// Checksums holds the checksums of the blob contents that are available,
+	// by algorithm, or nil. Drivers may only have the ones given in
+	// WriterOptions.Checksums when the blob was written.
+	Checksums map[ChecksumAlgorithm][]byte
 	// ETag for the blob; see https://en.wikipedia.org/wiki/HTTP_ETag.
 	ETag string
+	// VersionID identifies the latest version of the blob, if the bucket keeps
+	// versions; see Bucket.ListVersions. Otherwise it is empty.
+	VersionID string
+	// ServerSideEncryption describes how the service encrypts the blob at
+	// rest, or is nil if it doesn't or the driver doesn't report it.
+	ServerSideEncryption *ServerSideEncryption
 
 	asFunc func(interface{}) bool
 }
@@ -309,11 +454,15 @@ func (a *Attributes) As(i interface{}) bool {
 type Writer struct {
 	b                driver.Bucket
 	w                driver.Writer
+	rw               driver.ResumableWriter // non-nil for resumable uploads
 	key              string
 	end              func(error) // called at Close to finish trace and metric collection
 	cancel           func()      // cancels the ctx provided to NewTypedWriter if contentMD5 verification fails
 	contentMD5       []byte
 	md5hash          hash.Hash
+	checksums        map[ChecksumAlgorithm][]byte
+	checksumHashes   map[ChecksumAlgorithm]hash.Hash
+	xfer             *transfer     // for progress reporting and bandwidth limiting; may be nil
 	statsTagMutators []tag.Mutator // for metric collection
 	bytesWritten     int
 	closed           bool
@@ -340,12 +489,21 @@ const sniffLen = 512
 // Writes may happen asynchronously, so the returned error can be nil
 // even if the actual write eventually fails. The write is only guaranteed to
 // have succeeded if Close returns no error.
-func (w *Writer) Write(p []byte) (int, error) {
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if err := w.xfer.wait(len(p)); err != nil {
+		return 0, err
+	}
+	defer func() { w.xfer.add(n) }()
 	if len(w.contentMD5) > 0 {
 		if _, err := w.md5hash.Write(p); err != nil {
 			return 0, err
 		}
 	}
+	for _, h := range w.checksumHashes {
+		if _, err := h.Write(p); err != nil {
+			return 0, err
+		}
+	}
 	if w.w != nil {
 		return w.write(p)
 	}
@@ -360,7 +518,7 @@ func (w *Writer) Write(p []byte) (int, error) {
 
 	// Store p in w.buf and detect the content-type when the size of content in
 	// w.buf is at least 512 bytes.
-	n, err := w.buf.Write(p)
+	n, err = w.buf.Write(p)
 	if err != nil {
 		return 0, err
 	}
@@ -402,6 +560,16 @@ func (w *Writer) Close() (err error) {
 			return gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: the WriterOptions.ContentMD5 you specified (%X) did not match what was written (%X)", w.contentMD5, md5sum)
 		}
 	}
+	for alg, h := range w.checksumHashes {
+		// Same as for ContentMD5.
+		if sum := h.Sum(nil); !bytes.Equal(sum, w.checksums[alg]) {
+			w.cancel()
+			if w.w != nil {
+				_ = w.w.Close()
+			}
+			return gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: the WriterOptions.Checksums[%s] you specified (%X) did not match what was written (%X)", alg, w.checksums[alg], sum)
+		}
+	}
 
 	defer w.cancel()
 	if w.w != nil {
@@ -435,6 +603,26 @@ func (w *Writer) write(p []byte) (int, error) {
 	return n, wrapError(w.b, err, w.key)
 }
 
+// SessionToken returns the token of the resumable upload session the Writer
+// is writing to, or "" if it isn't resumable; see WriterOptions.Resumable.
+func (w *Writer) SessionToken() string {
+	if w.rw == nil {
+		return ""
+	}
+	return w.rw.SessionToken()
+}
+
+// Committed returns the number of bytes of the blob that have been durably
+// stored in the resumable upload session, including those stored before the
+// session was resumed. If the session is resumed, writing continues from
+// there. Committed returns 0 if the Writer isn't resumable.
+func (w *Writer) Committed() int64 {
+	if w.rw == nil {
+		return 0
+	}
+	return w.rw.Committed()
+}
+
 // ReadFrom reads from r and writes to w until EOF or error.
 // The return value is the number of bytes read from r.
 //
@@ -471,12 +659,13 @@ type ListOptions struct {
 	BeforeList func(asFunc func(interface{}) bool) error
 }
 
-// ListIterator iterates over List results.
+// ListIterator iterates over List and ListVersions results.
 type ListIterator struct {
-	b       *Bucket
-	opts    *driver.ListOptions
-	page    *driver.ListPage
-	nextIdx int
+	b        *Bucket
+	opts     *driver.ListOptions
+	versions bool // list versions instead of blobs
+	page     *driver.ListPage
+	nextIdx  int
 }
 
 // Next returns a *ListObject for the next blob. It returns (nil, io.EOF) if
@@ -489,12 +678,16 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 			dobj := i.page.Objects[i.nextIdx]
 			i.nextIdx++
 			return &ListObject{
-				Key:     dobj.Key,
-				ModTime: dobj.ModTime,
-				Size:    dobj.Size,
-				MD5:     dobj.MD5,
-				IsDir:   dobj.IsDir,
-				asFunc:  dobj.AsFunc,
+				Key:       dobj.Key,
+				ModTime:   dobj.ModTime,
+				Size:      dobj.Size,
+				MD5:       dobj.MD5,
+				Checksums: fromDriverChecksums(dobj.Checksums),
+				ETag:      dobj.ETag,
+				IsDir:     dobj.IsDir,
+				VersionID: dobj.VersionID,
+				IsLatest:  dobj.IsLatest,
+				asFunc:    dobj.AsFunc,
 			}, nil
 		}
 		if len(i.page.NextPageToken) == 0 {
@@ -510,7 +703,17 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 		return nil, errClosed
 	}
 	// Loading a new page.
-	p, err := i.b.b.ListPaged(ctx, i.opts)
+	var p *driver.ListPage
+	var err error
+	if i.versions {
+		var v driver.Versioner
+		if v, err = i.b.versioner(); err != nil {
+			return nil, err
+		}
+		p, err = v.ListVersions(ctx, i.opts)
+	} else {
+		p, err = i.b.b.ListPaged(ctx, i.opts)
+	}
 	if err != nil {
 		return nil, wrapError(i.b.b, err, "")
 	}
@@ -529,11 +732,22 @@ type ListObject struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// Checksums holds the checksums of the blob contents that are available,
+	// by algorithm, or nil; see Attributes.Checksums.
+	Checksums map[ChecksumAlgorithm][]byte
+	// ETag for the blob, if the driver reports it when listing; see
+	// Attributes.ETag.
+	ETag string
 	// IsDir indicates that this result represents a "directory" in the
 	// hierarchical namespace, ending in ListOptions.Delimiter. Key can be
 	// passed as ListOptions.Prefix to list items in the "directory".
 	// Fields other than Key and IsDir will not be set if IsDir is true.
 	IsDir bool
+	// VersionID identifies the version of the blob this result describes, if
+	// the bucket keeps versions; see Bucket.ListVersions.
+	VersionID string
+	// IsLatest is set by ListVersions for the latest version of each blob.
+	IsLatest bool
 
 	asFunc func(interface{}) bool
 }
@@ -561,6 +775,10 @@ type Bucket struct {
 	// and thereby prevent closing until a call finishes.
 	mu     sync.RWMutex
 	closed bool
+
+	// limiter is the default for the BandwidthLimiter options; see
+	// SetBandwidthLimiter. It is protected by mu.
+	limiter *BandwidthLimiter
 }
 
 const pkgName = "github.com/sraphs/gdk/blob"
@@ -628,6 +846,17 @@ func (b *Bucket) ErrorAs(err error, i interface{}) bool {
 	return gdkerr.ErrorAs(err, i, b.b.ErrorAs)
 }
 
+// SetBandwidthLimiter sets the BandwidthLimiter used by the Readers and
+// Writers created from b afterwards, unless their options set another one;
+// see ReaderOptions.BandwidthLimiter and WriterOptions.BandwidthLimiter.
+// A nil limiter removes the limit. Transfers already in progress are not
+// affected.
+func (b *Bucket) SetBandwidthLimiter(limiter *BandwidthLimiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limiter = limiter
+}
+
 // ReadAll is a shortcut for creating a Reader via NewReader with nil
 // ReaderOptions, and reading the entire blob.
 func (b *Bucket) ReadAll(ctx context.Context, key string) (_ []byte, err error) {
@@ -664,6 +893,299 @@ func (b *Bucket) List(opts *ListOptions) *ListIterator {
 	return &ListIterator{b: b, opts: dopts}
 }
 
+// ListVersionsOptions sets options for listing blob versions via
+// Bucket.ListVersions.
+type ListVersionsOptions struct {
+	// Prefix indicates that only versions of blobs with a key starting with
+	// this prefix should be returned.
+	Prefix string
+
+	// BeforeList is a callback that will be called before each call to the
+	// the underlying service's list functionality.
+	// asFunc converts its argument to driver-specific types.
+	// See https://sraphs.github.io/gdk/concepts/as/ for background information.
+	BeforeList func(asFunc func(interface{}) bool) error
+}
+
+// ListVersions returns a ListIterator that can be used to iterate over every
+// stored version of the blobs in a bucket, including blobs that have been
+// overwritten or deleted. Results are ordered by key, and then from newest to
+// oldest version; ListObject.IsLatest is set for the latest version of a blob
+// that still exists. Pass ListObject.VersionID as ReaderOptions.VersionID to
+// read a previous version, for example to restore it.
+//
+// If versioning is not enabled for the bucket, only the latest version of
+// each blob is returned. If the bucket does not support versioning at all,
+// the iterator returns an error for which gdkerr.Code will return
+// gdkerr.Unimplemented; SupportsVersioning reports whether that is the case.
+//
+// A nil ListVersionsOptions is treated the same as the zero value.
+func (b *Bucket) ListVersions(opts *ListVersionsOptions) *ListIterator {
+	if opts == nil {
+		opts = &ListVersionsOptions{}
+	}
+	dopts := &driver.ListOptions{
+		Prefix:     opts.Prefix,
+		BeforeList: opts.BeforeList,
+	}
+	return &ListIterator{b: b, opts: dopts, versions: true}
+}
+
+// SupportsVersioning reports whether the bucket's driver supports versioning,
+// i.e., ListVersions, ReaderOptions.VersionID and DeleteOptions.VersionID.
+// It does not report whether versioning is enabled for the bucket.
+func (b *Bucket) SupportsVersioning() bool {
+	_, err := b.versioner()
+	return err == nil
+}
+
+// versioner returns the driver as a driver.Versioner, or an Unimplemented
+// error if it does not support versioning.
+func (b *Bucket) versioner() (driver.Versioner, error) {
+	if !driver.SupportsVersioning(b.b) {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: versioning is not supported by this bucket")
+	}
+	return b.b.(driver.Versioner), nil
+}
+
+// ListUploadSessions returns the resumable upload sessions that haven't been
+// completed or aborted, for keys that start with prefix. Use it to find and
+// abort sessions abandoned by lost writers.
+//
+// If the bucket doesn't support resumable uploads, ListUploadSessions returns
+// an error for which gdkerr.Code will return gdkerr.Unimplemented.
+func (b *Bucket) ListUploadSessions(ctx context.Context, prefix string) (_ []*UploadSession, err error) {
+	if !utf8.ValidString(prefix) {
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: ListUploadSessions prefix must be a valid UTF-8 string: %q", prefix)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ru, err := b.resumableUploader()
+	if err != nil {
+		return nil, err
+	}
+	ctx = b.tracer.Start(ctx, "ListUploadSessions")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	dsessions, err := ru.ListUploadSessions(ctx, prefix)
+	if err != nil {
+		return nil, wrapError(b.b, err, "")
+	}
+	sessions := make([]*UploadSession, len(dsessions))
+	for i, s := range dsessions {
+		sessions[i] = &UploadSession{Key: s.Key, Token: s.Token, Started: s.Started}
+	}
+	return sessions, nil
+}
+
+// AbortUploadSession aborts a resumable upload session for key, discarding
+// the bytes that were stored.
+//
+// If the session doesn't exist, AbortUploadSession returns an error for
+// which gdkerr.Code will return gdkerr.NotFound.
+func (b *Bucket) AbortUploadSession(ctx context.Context, key, token string) (err error) {
+	if !utf8.ValidString(key) {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: AbortUploadSession key must be a valid UTF-8 string: %q", key)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	ru, err := b.resumableUploader()
+	if err != nil {
+		return err
+	}
+	ctx = b.tracer.Start(ctx, "AbortUploadSession")
+	defer func() { b.tracer.End(ctx, err) }()
+	return wrapError(b.b, ru.AbortUploadSession(ctx, key, token), key)
+}
+
+// resumableUploader returns the driver as a driver.ResumableUploader, or an
+// Unimplemented error if it does not support resumable uploads.
+func (b *Bucket) resumableUploader() (driver.ResumableUploader, error) {
+	ru, ok := b.b.(driver.ResumableUploader)
+	if !ok {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: resumable uploads are not supported by this bucket")
+	}
+	return ru, nil
+}
+
+// ReplicationStatus returns the state of the blob for key in each replica of
+// a bucket returned by ReplicatedBucket. A blob that doesn't exist is not an
+// error.
+//
+// If the bucket is not replicated, ReplicationStatus returns an error for
+// which gdkerr.Code will return gdkerr.Unimplemented.
+func (b *Bucket) ReplicationStatus(ctx context.Context, key string) (_ *ReplicationStatus, err error) {
+	if !utf8.ValidString(key) {
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: ReplicationStatus key must be a valid UTF-8 string: %q", key)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	r, ok := b.b.(driver.Replicator)
+	if !ok {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: replication is not supported by this bucket")
+	}
+	ctx = b.tracer.Start(ctx, "ReplicationStatus")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	dstatuses, err := r.ReplicationStatus(ctx, key)
+	if err != nil {
+		return nil, wrapError(b.b, err, key)
+	}
+	status := &ReplicationStatus{InSync: true}
+	for _, s := range dstatuses {
+		rs := &ReplicaStatus{Exists: s.Exists, ETag: s.ETag, Pending: s.Pending}
+		if s.Err != nil {
+			rs.Err = wrapError(b.b, s.Err, key)
+		}
+		if s.Pending || s.Err != nil || s.Exists != dstatuses[0].Exists || s.ETag != dstatuses[0].ETag {
+			status.InSync = false
+		}
+		status.Replicas = append(status.Replicas, rs)
+	}
+	return status, nil
+}
+
+// Restore restores the blob for key that was deleted last from a bucket
+// returned by TrashBucket.
+//
+// If no deleted blob is kept for key, Restore returns an error for which
+// gdkerr.Code will return gdkerr.NotFound; if the blob exists, it returns an
+// error for which gdkerr.Code will return gdkerr.FailedPrecondition. If the
+// bucket doesn't keep deleted blobs, it returns an error for which
+// gdkerr.Code will return gdkerr.Unimplemented.
+func (b *Bucket) Restore(ctx context.Context, key string) (err error) {
+	if !utf8.ValidString(key) {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Restore key must be a valid UTF-8 string: %q", key)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	sd, err := b.softDeleter()
+	if err != nil {
+		return err
+	}
+	ctx = b.tracer.Start(ctx, "Restore")
+	defer func() { b.tracer.End(ctx, err) }()
+	return wrapError(b.b, sd.Restore(ctx, key), key)
+}
+
+// Purge permanently deletes the blobs that were deleted more than olderThan
+// ago from a bucket returned by TrashBucket. Use a zero olderThan to empty
+// the trash.
+//
+// If the bucket doesn't keep deleted blobs, Purge returns an error for which
+// gdkerr.Code will return gdkerr.Unimplemented. So does a bucket returned by
+// PrefixedBucket, since the trash is shared with the rest of the bucket;
+// purge the underlying bucket instead.
+func (b *Bucket) Purge(ctx context.Context, olderThan time.Duration) (err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	sd, err := b.softDeleter()
+	if err != nil {
+		return err
+	}
+	ctx = b.tracer.Start(ctx, "Purge")
+	defer func() { b.tracer.End(ctx, err) }()
+	return wrapError(b.b, sd.Purge(ctx, time.Now().Add(-olderThan)), "")
+}
+
+// softDeleter returns the driver as a driver.SoftDeleter, or an
+// Unimplemented error if it does not keep deleted blobs.
+func (b *Bucket) softDeleter() (driver.SoftDeleter, error) {
+	sd, ok := b.b.(driver.SoftDeleter)
+	if !ok {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: restoring deleted blobs is not supported by this bucket")
+	}
+	return sd, nil
+}
+
+// SetTags replaces the tags of the blob for key with tags; an empty tags
+// removes them all. See WriterOptions.Tags.
+//
+// If the blob doesn't exist, SetTags returns an error for which gdkerr.Code
+// will return gdkerr.NotFound. If the bucket can't change the tags of a
+// blob, it returns an error for which gdkerr.Code will return
+// gdkerr.Unimplemented.
+func (b *Bucket) SetTags(ctx context.Context, key string, tags map[string]string) (err error) {
+	if !utf8.ValidString(key) {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: SetTags key must be a valid UTF-8 string: %q", key)
+	}
+	if err := validateTags("SetTags tags", tags); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	t, ok := b.b.(driver.Tagger)
+	if !ok {
+		return gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: changing tags is not supported by this bucket")
+	}
+	ctx = b.tracer.Start(ctx, "SetTags")
+	defer func() { b.tracer.End(ctx, err) }()
+	return wrapError(b.b, t.SetTags(ctx, key, tags), key)
+}
+
+// lowercaseMetadata checks that md is valid, and returns it with lowercased
+// keys, or nil if it is empty; what describes it in errors.
+func lowercaseMetadata(what string, md map[string]string) (map[string]string, error) {
+	if len(md) == 0 {
+		return nil, nil
+	}
+	// Services are inconsistent, but at least some treat keys
+	// as case-insensitive. To make the behavior consistent, we
+	// force-lowercase them when writing and reading.
+	lower := make(map[string]string, len(md))
+	for k, v := range md {
+		if k == "" {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s keys may not be empty strings", what)
+		}
+		if !utf8.ValidString(k) {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s keys must be valid UTF-8 strings: %q", what, k)
+		}
+		if !utf8.ValidString(v) {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s values must be valid UTF-8 strings: %q", what, v)
+		}
+		lowerK := strings.ToLower(k)
+		if _, found := lower[lowerK]; found {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s has a duplicate case-insensitive metadata key: %q", what, lowerK)
+		}
+		lower[lowerK] = v
+	}
+	return lower, nil
+}
+
+// validateTags checks that tags are valid; what describes them in errors.
+func validateTags(what string, tags map[string]string) error {
+	for k, v := range tags {
+		if k == "" {
+			return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s keys may not be empty strings", what)
+		}
+		if !utf8.ValidString(k) {
+			return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s keys must be valid UTF-8 strings: %q", what, k)
+		}
+		if !utf8.ValidString(v) {
+			return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s values must be valid UTF-8 strings: %q", what, v)
+		}
+	}
+	return nil
+}
+
 // FirstPageToken is the pageToken to pass to ListPage to retrieve the first page of results.
 var FirstPageToken = []byte("first page")
 
@@ -735,12 +1257,15 @@ func (b *Bucket) ListPage(ctx context.Context, pageToken []byte, pageSize int, o
 		}
 		for _, dobj := range p.Objects {
 			retval = append(retval, &ListObject{
-				Key:     dobj.Key,
-				ModTime: dobj.ModTime,
-				Size:    dobj.Size,
-				MD5:     dobj.MD5,
-				IsDir:   dobj.IsDir,
-				asFunc:  dobj.AsFunc,
+				Key:       dobj.Key,
+				ModTime:   dobj.ModTime,
+				Size:      dobj.Size,
+				MD5:       dobj.MD5,
+				Checksums: fromDriverChecksums(dobj.Checksums),
+				ETag:      dobj.ETag,
+				IsDir:     dobj.IsDir,
+				VersionID: dobj.VersionID,
+				asFunc:    dobj.AsFunc,
 			})
 		}
 		// ListPaged may return fewer results than pageSize. If there are more results
@@ -819,18 +1344,23 @@ func (b *Bucket) Attributes(ctx context.Context, key string) (_ *Attributes, err
 		}
 	}
 	return &Attributes{
-		CacheControl:       a.CacheControl,
-		ContentDisposition: a.ContentDisposition,
-		ContentEncoding:    a.ContentEncoding,
-		ContentLanguage:    a.ContentLanguage,
-		ContentType:        a.ContentType,
-		Metadata:           md,
-		CreateTime:         a.CreateTime,
-		ModTime:            a.ModTime,
-		Size:               a.Size,
-		MD5:                a.MD5,
-		ETag:               a.ETag,
-		asFunc:             a.AsFunc,
+		CacheControl:         a.CacheControl,
+		ContentDisposition:   a.ContentDisposition,
+		ContentEncoding:      a.ContentEncoding,
+		ContentLanguage:      a.ContentLanguage,
+		ContentType:          a.ContentType,
+		Metadata:             md,
+		Tags:                 a.Tags,
+		StorageClass:         a.StorageClass,
+		CreateTime:           a.CreateTime,
+		ModTime:              a.ModTime,
+		Size:                 a.Size,
+		MD5:                  a.MD5,
+		Checksums:            fromDriverChecksums(a.Checksums),
+		ETag:                 a.ETag,
+		VersionID:            a.VersionID,
+		ServerSideEncryption: fromDriverEncryption(a.ServerSideEncryption),
+		asFunc:               a.AsFunc,
 	}, nil
 }
 
@@ -874,8 +1404,19 @@ func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length
 	if opts == nil {
 		opts = &ReaderOptions{}
 	}
+	if opts.VersionID != "" {
+		if _, err := b.versioner(); err != nil {
+			return nil, err
+		}
+	}
 	dopts := &driver.ReaderOptions{
-		BeforeRead: opts.BeforeRead,
+		BeforeRead:  opts.BeforeRead,
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+		VersionID:   opts.VersionID,
+	}
+	if dopts.ServerSideEncryption, err = opts.ServerSideEncryption.customerKeyToDriver("ReaderOptions.ServerSideEncryption"); err != nil {
+		return nil, err
 	}
 	tctx := b.tracer.Start(ctx, "NewRangeReader")
 	defer func() {
@@ -903,6 +1444,26 @@ func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length
 		end:              end,
 		statsTagMutators: []tag.Mutator{tag.Upsert(oc.ProviderKey, b.tracer.Provider)},
 	}
+	limiter := opts.BandwidthLimiter
+	if limiter == nil {
+		limiter = b.limiter
+	}
+	if limiter != nil || opts.Progress != nil {
+		total := dr.Attributes().Size - offset
+		if length >= 0 && length < total {
+			total = length
+		}
+		if total < 0 {
+			total = 0
+		}
+		r.xfer = newTransfer(ctx, limiter, opts.Progress, total)
+	}
+	if opts.RetryPolicy != nil {
+		if err = r.setRetryPolicy(opts.RetryPolicy); err != nil {
+			_ = dr.Close()
+			return nil, wrapError(b.b, err, key)
+		}
+	}
 	_, file, lineno, ok := runtime.Caller(2)
 	runtime.SetFinalizer(r, func(r *Reader) {
 		if !r.closed {
@@ -929,6 +1490,9 @@ func (b *Bucket) WriteAll(ctx context.Context, key string, p []byte, opts *Write
 		sum := md5.Sum(p)
 		realOpts.ContentMD5 = sum[:]
 	}
+	if realOpts.ProgressTotal == 0 {
+		realOpts.ProgressTotal = int64(len(p))
+	}
 	w, err := b.NewWriter(ctx, key, realOpts)
 	if err != nil {
 		return err
@@ -968,32 +1532,36 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 		ContentEncoding:    opts.ContentEncoding,
 		ContentLanguage:    opts.ContentLanguage,
 		ContentMD5:         opts.ContentMD5,
+		Checksums:          toDriverChecksums(opts.Checksums),
 		BufferSize:         opts.BufferSize,
 		MaxConcurrency:     opts.MaxConcurrency,
+		StorageClass:       opts.StorageClass,
 		BeforeWrite:        opts.BeforeWrite,
+		IfMatch:            opts.IfMatch,
+		IfNoneMatch:        opts.IfNoneMatch,
 	}
-	if len(opts.Metadata) > 0 {
-		// Services are inconsistent, but at least some treat keys
-		// as case-insensitive. To make the behavior consistent, we
-		// force-lowercase them when writing and reading.
-		md := make(map[string]string, len(opts.Metadata))
-		for k, v := range opts.Metadata {
-			if k == "" {
-				return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.Metadata keys may not be empty strings")
-			}
-			if !utf8.ValidString(k) {
-				return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.Metadata keys must be valid UTF-8 strings: %q", k)
-			}
-			if !utf8.ValidString(v) {
-				return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.Metadata values must be valid UTF-8 strings: %q", v)
-			}
-			lowerK := strings.ToLower(k)
-			if _, found := md[lowerK]; found {
-				return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.Metadata has a duplicate case-insensitive metadata key: %q", lowerK)
-			}
-			md[lowerK] = v
+	if dopts.ServerSideEncryption, err = opts.ServerSideEncryption.toDriver("WriterOptions.ServerSideEncryption"); err != nil {
+		return nil, err
+	}
+	if len(opts.Tags) > 0 {
+		if err := validateTags("WriterOptions.Tags", opts.Tags); err != nil {
+			return nil, err
 		}
-		dopts.Metadata = md
+		dopts.Tags = opts.Tags
+	}
+	if dopts.Metadata, err = lowercaseMetadata("WriterOptions.Metadata", opts.Metadata); err != nil {
+		return nil, err
+	}
+	var checksumHashes map[ChecksumAlgorithm]hash.Hash
+	for alg := range opts.Checksums {
+		h, ok := driver.NewChecksumHash(driver.ChecksumAlgorithm(alg))
+		if !ok {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.Checksums has an unsupported algorithm: %q", alg)
+		}
+		if checksumHashes == nil {
+			checksumHashes = map[ChecksumAlgorithm]hash.Hash{}
+		}
+		checksumHashes[alg] = h
 	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -1016,9 +1584,50 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 		key:              key,
 		contentMD5:       opts.ContentMD5,
 		md5hash:          md5.New(),
+		checksums:        opts.Checksums,
+		checksumHashes:   checksumHashes,
 		statsTagMutators: []tag.Mutator{tag.Upsert(oc.ProviderKey, b.tracer.Provider)},
 	}
-	if opts.ContentType != "" {
+	limiter := opts.BandwidthLimiter
+	if limiter == nil {
+		limiter = b.limiter
+	}
+	total := opts.ProgressTotal
+	if total <= 0 {
+		total = -1
+	}
+	w.xfer = newTransfer(ctx, limiter, opts.Progress, total)
+	if opts.Resumable || opts.SessionToken != "" {
+		if opts.SessionToken != "" && len(opts.ContentMD5) > 0 {
+			cancel()
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.ContentMD5 may not be set when resuming an upload session")
+		}
+		if opts.SessionToken != "" && len(opts.Checksums) > 0 {
+			cancel()
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: WriterOptions.Checksums may not be set when resuming an upload session")
+		}
+		ru, err := b.resumableUploader()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		ct := "application/octet-stream"
+		if opts.ContentType != "" {
+			t, p, err := mime.ParseMediaType(opts.ContentType)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			ct = mime.FormatMediaType(t, p)
+		}
+		rw, err := ru.NewResumableWriter(ctx, key, ct, opts.SessionToken, dopts)
+		if err != nil {
+			cancel()
+			return nil, wrapError(b.b, err, key)
+		}
+		w.w = rw
+		w.rw = rw
+	} else if opts.ContentType != "" {
 		t, p, err := mime.ParseMediaType(opts.ContentType)
 		if err != nil {
 			cancel()
@@ -1057,7 +1666,8 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 // If the source blob does not exist, Copy returns an error for which
 // gdkerr.Code will return gdkerr.NotFound.
 //
-// If the destination blob already exists, it is overwritten.
+// If the destination blob already exists, it is overwritten, unless
+// opts.IfMatch or opts.IfNoneMatch say otherwise.
 func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) (err error) {
 	if !utf8.ValidString(srcKey) {
 		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Copy srcKey must be a valid UTF-8 string: %q", srcKey)
@@ -1069,10 +1679,18 @@ func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOpti
 		opts = &CopyOptions{}
 	}
 	dopts := &driver.CopyOptions{
-		BeforeCopy: opts.BeforeCopy,
+		BeforeCopy:  opts.BeforeCopy,
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
 	}
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+	if dopts.ServerSideEncryption, err = opts.ServerSideEncryption.toDriver("CopyOptions.ServerSideEncryption"); err != nil {
+		return err
+	}
+	if dopts.SourceServerSideEncryption, err = opts.SourceServerSideEncryption.customerKeyToDriver("CopyOptions.SourceServerSideEncryption"); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	if b.closed {
 		return errClosed
 	}
@@ -1081,14 +1699,179 @@ func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOpti
 	return wrapError(b.b, b.b.Copy(ctx, dstKey, srcKey, dopts), fmt.Sprintf("%s -> %s", srcKey, dstKey))
 }
 
+// Compose writes the concatenation of the blobs stored at srcKeys, in order,
+// to dstKey. srcKeys must not be empty, and may include dstKey. A nil
+// ComposeOptions is treated the same as the zero value.
+//
+// Buckets that can concatenate blobs on the service side do so: s3blob and
+// aliyunblob assemble multipart uploads from copies of the source blobs,
+// which must be large enough to be parts (5 MiB for S3, 100 KiB for OSS)
+// except for the last one, and fileblob and memblob concatenate them
+// directly. Otherwise, the source blobs are streamed through a Writer.
+//
+// If a source blob does not exist, Compose returns an error for which
+// gdkerr.Code will return gdkerr.NotFound. If the destination blob already
+// exists, it is overwritten, unless opts.IfMatch or opts.IfNoneMatch say
+// otherwise.
+func (b *Bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *ComposeOptions) (err error) {
+	if !utf8.ValidString(dstKey) {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Compose dstKey must be a valid UTF-8 string: %q", dstKey)
+	}
+	if len(srcKeys) == 0 {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Compose needs at least one source key")
+	}
+	for _, key := range srcKeys {
+		if !utf8.ValidString(key) {
+			return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Compose srcKeys must be valid UTF-8 strings: %q", key)
+		}
+	}
+	if opts == nil {
+		opts = &ComposeOptions{}
+	}
+	dopts := &driver.ComposeOptions{
+		ContentType: opts.ContentType,
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+	}
+	if dopts.Metadata, err = lowercaseMetadata("ComposeOptions.Metadata", opts.Metadata); err != nil {
+		return err
+	}
+	if dopts.ServerSideEncryption, err = opts.ServerSideEncryption.toDriver("ComposeOptions.ServerSideEncryption"); err != nil {
+		return err
+	}
+	if dopts.SourceServerSideEncryption, err = opts.SourceServerSideEncryption.customerKeyToDriver("ComposeOptions.SourceServerSideEncryption"); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Compose")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	if dopts.ContentType == "" {
+		attrs, err := sourceAttributes(ctx, b.b, srcKeys[0], dopts.SourceServerSideEncryption)
+		if err != nil {
+			return wrapError(b.b, err, srcKeys[0])
+		}
+		dopts.ContentType = attrs.ContentType
+		if dopts.ContentType == "" {
+			dopts.ContentType = "application/octet-stream"
+		}
+	} else {
+		t, p, err := mime.ParseMediaType(dopts.ContentType)
+		if err != nil {
+			return gdkerr.Newf(gdkerr.InvalidArgument, err, "blob: ComposeOptions.ContentType is invalid: %q", dopts.ContentType)
+		}
+		dopts.ContentType = mime.FormatMediaType(t, p)
+	}
+	if c, ok := b.b.(driver.Composer); ok {
+		err := wrapError(b.b, c.Compose(ctx, dstKey, srcKeys, dopts), dstKey)
+		if gdkerr.Code(err) != gdkerr.Unimplemented {
+			return err
+		}
+	}
+	return wrapError(b.b, composeByStreaming(ctx, b.b, dstKey, srcKeys, dopts), dstKey)
+}
+
+// composeByStreaming implements Compose for buckets that can't concatenate
+// blobs themselves, by reading the source blobs into a Writer.
+func composeByStreaming(ctx context.Context, b driver.Bucket, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	// Make sure that all the source blobs exist before writing anything, and
+	// that they don't change until they are read.
+	eTags := make([]string, len(srcKeys))
+	for i, key := range srcKeys {
+		attrs, err := sourceAttributes(ctx, b, key, opts.SourceServerSideEncryption)
+		if err != nil {
+			return err
+		}
+		eTags[i] = attrs.ETag
+	}
+	// Cancel the write if reading fails, so that nothing is written.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewTypedWriter(ctx, dstKey, opts.ContentType, &driver.WriterOptions{
+		Metadata:             opts.Metadata,
+		IfMatch:              opts.IfMatch,
+		IfNoneMatch:          opts.IfNoneMatch,
+		ServerSideEncryption: opts.ServerSideEncryption,
+	})
+	if err != nil {
+		return err
+	}
+	for i, key := range srcKeys {
+		if err := copyBlob(ctx, b, w, key, eTags[i], opts.SourceServerSideEncryption); err != nil {
+			cancel()
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// sourceAttributes returns the attributes of the source blob for key of
+// Compose. Blobs encrypted with the customer key sse are read instead, since
+// drivers may need the key to get their attributes.
+func sourceAttributes(ctx context.Context, b driver.Bucket, key string, sse *driver.ServerSideEncryption) (*driver.ReaderAttributes, error) {
+	if sse == nil {
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return &driver.ReaderAttributes{ContentType: attrs.ContentType, ModTime: attrs.ModTime, Size: attrs.Size, ETag: attrs.ETag}, nil
+	}
+	r, err := b.NewRangeReader(ctx, key, 0, 0, &driver.ReaderOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.Attributes(), nil
+}
+
+// copyBlob writes the blob for key, which must have the given ETag and may
+// be encrypted with the customer key sse, to w.
+func copyBlob(ctx context.Context, b driver.Bucket, w io.Writer, key, eTag string, sse *driver.ServerSideEncryption) error {
+	ropts := driver.ReaderOptions{ServerSideEncryption: sse}
+	if eTag != "" {
+		ropts.IfMatch = eTag
+	}
+	r, err := b.NewRangeReader(ctx, key, 0, -1, &ropts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
 // Delete deletes the blob stored at key.
 //
 // If the blob does not exist, Delete returns an error for which
 // gdkerr.Code will return gdkerr.NotFound.
-func (b *Bucket) Delete(ctx context.Context, key string) (err error) {
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	return b.DeleteWithOptions(ctx, key, nil)
+}
+
+// DeleteWithOptions is like Delete, but accepts options; for example, to only
+// delete the blob if it has not changed since it was read.
+// A nil DeleteOptions is treated the same as the zero value.
+func (b *Bucket) DeleteWithOptions(ctx context.Context, key string, opts *DeleteOptions) (err error) {
 	if !utf8.ValidString(key) {
 		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Delete key must be a valid UTF-8 string: %q", key)
 	}
+	if opts == nil {
+		opts = &DeleteOptions{}
+	}
+	if opts.VersionID != "" {
+		if _, err := b.versioner(); err != nil {
+			return err
+		}
+	}
+	dopts := &driver.DeleteOptions{
+		IfMatch:   opts.IfMatch,
+		VersionID: opts.VersionID,
+	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	if b.closed {
@@ -1096,7 +1879,144 @@ func (b *Bucket) Delete(ctx context.Context, key string) (err error) {
 	}
 	ctx = b.tracer.Start(ctx, "Delete")
 	defer func() { b.tracer.End(ctx, err) }()
-	return wrapError(b.b, b.b.Delete(ctx, key), key)
+	return wrapError(b.b, b.b.Delete(ctx, key, dopts), key)
+}
+
+// deleteConcurrency is the maximum number of concurrent Delete calls made by
+// DeleteMany and DeletePrefix for drivers that don't support bulk deletes.
+const deleteConcurrency = 16
+
+// deletePrefixPageSize is the number of keys DeletePrefix lists and deletes at
+// a time.
+const deletePrefixPageSize = 1000
+
+// DeleteMany deletes the blobs stored at keys. Drivers that can delete many
+// blobs per request do so; for other drivers, DeleteMany calls Delete
+// concurrently.
+//
+// Keys that don't exist are not errors. DeleteMany returns the errors for the
+// keys whose blobs could not be deleted, indexed by key; it is empty if all
+// were deleted. The returned error is non-nil only if the operation as a
+// whole failed, for example because the Bucket is closed.
+func (b *Bucket) DeleteMany(ctx context.Context, keys []string) (_ map[string]error, err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeleteMany")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	failed := map[string]error{}
+	seen := make(map[string]bool, len(keys))
+	valid := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if !utf8.ValidString(key) {
+			failed[key] = gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: DeleteMany key must be a valid UTF-8 string: %q", key)
+			continue
+		}
+		valid = append(valid, key)
+	}
+	if err := b.deleteMany(ctx, valid, failed); err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+// DeletePrefix deletes all blobs whose keys start with prefix, in batches,
+// using DeleteMany. An empty prefix deletes every blob in the bucket.
+//
+// DeletePrefix returns the errors for the keys whose blobs could not be
+// deleted, indexed by key; it is empty if all were deleted. The returned
+// error is non-nil if listing the blobs failed, or if the operation failed as
+// a whole.
+//
+// Blobs written while DeletePrefix is running may or may not be deleted.
+func (b *Bucket) DeletePrefix(ctx context.Context, prefix string) (_ map[string]error, err error) {
+	if !utf8.ValidString(prefix) {
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: DeletePrefix prefix must be a valid UTF-8 string: %q", prefix)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeletePrefix")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	failed := map[string]error{}
+	lopts := &driver.ListOptions{
+		Prefix:   prefix,
+		PageSize: deletePrefixPageSize,
+	}
+	for {
+		p, err := b.b.ListPaged(ctx, lopts)
+		if err != nil {
+			return nil, wrapError(b.b, err, "")
+		}
+		keys := make([]string, 0, len(p.Objects))
+		for _, obj := range p.Objects {
+			keys = append(keys, obj.Key)
+		}
+		if err := b.deleteMany(ctx, keys, failed); err != nil {
+			return nil, err
+		}
+		if len(p.NextPageToken) == 0 {
+			return failed, nil
+		}
+		lopts.PageToken = p.NextPageToken
+	}
+}
+
+// deleteMany deletes keys, which are distinct, adding the errors for keys
+// that could not be deleted to failed. b.mu must be held.
+func (b *Bucket) deleteMany(ctx context.Context, keys []string, failed map[string]error) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	// record adds err, if any, to failed. Keys that don't exist are not errors.
+	var mu sync.Mutex
+	record := func(key string, err error) {
+		err = wrapError(b.b, err, key)
+		if err == nil || gdkerr.Code(err) == gdkerr.NotFound {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		failed[key] = err
+	}
+
+	if bd, ok := b.b.(driver.BulkDeleter); ok {
+		errs, err := bd.DeleteMany(ctx, keys)
+		if err == nil && len(errs) != len(keys) {
+			err = fmt.Errorf("blob: driver DeleteMany returned %d errors for %d keys", len(errs), len(keys))
+		}
+		if err == nil {
+			for i, key := range keys {
+				record(key, errs[i])
+			}
+			return nil
+		}
+		if err = wrapError(b.b, err, ""); gdkerr.Code(err) != gdkerr.Unimplemented {
+			return err
+		}
+	}
+
+	var g errgroup.Group
+	g.SetLimit(deleteConcurrency)
+	dopts := &driver.DeleteOptions{}
+	for _, key := range keys {
+		key := key
+		g.Go(func() error {
+			record(key, b.b.Delete(ctx, key, dopts))
+			return nil
+		})
+	}
+	return g.Wait()
 }
 
 // SignedURL returns a URL that can be used to GET (default), PUT or DELETE
@@ -1215,6 +2135,211 @@ type ReaderOptions struct {
 	// asFunc converts its argument to driver-specific types.
 	// See https://sraphs.github.io/gdk/concepts/as/ for background information.
 	BeforeRead func(asFunc func(interface{}) bool) error
+
+	// IfMatch, if non-empty, makes the read succeed only if the blob's ETag
+	// (see Attributes.ETag) matches it; "*" matches any existing blob.
+	// Otherwise, NewReader returns an error for which gdkerr.Code will return
+	// gdkerr.FailedPrecondition. Since Seek reopens the blob with the same
+	// options, setting IfMatch also ensures that all reads see the same
+	// content.
+	IfMatch string
+
+	// IfNoneMatch, if non-empty, makes the read succeed only if the blob's
+	// ETag does not match it; for example, to only read a blob that has
+	// changed since it was last read. "*" matches any existing blob.
+	// Otherwise, NewReader returns an error for which gdkerr.Code will return
+	// gdkerr.FailedPrecondition.
+	IfNoneMatch string
+
+	// VersionID, if non-empty, reads the given version of the blob instead of
+	// the latest one; see Bucket.ListVersions. If the bucket does not support
+	// versioning, NewReader returns an error for which gdkerr.Code will return
+	// gdkerr.Unimplemented.
+	VersionID string
+
+	// RetryPolicy, if non-nil, makes Read recover from transient failures by
+	// reopening the blob at the current offset, instead of returning the
+	// error. Resumed reads are pinned to the ETag of the blob as it was
+	// opened (or to IfMatch, if set), so if the blob is overwritten in the
+	// meantime, Read returns an error for which gdkerr.Code will return
+	// gdkerr.FailedPrecondition rather than mixing the contents of two
+	// versions.
+	//
+	// If nil, errors from the underlying reader are returned as is.
+	RetryPolicy *ReadRetryPolicy
+
+	// Progress, if non-nil, is called by Read after each read that returns
+	// data, with the number of bytes read so far; Progress.Total is the
+	// length of the range read. It is called synchronously, so it should
+	// return quickly.
+	Progress func(Progress)
+
+	// BandwidthLimiter, if non-nil, limits the rate at which Read returns
+	// data. It defaults to the one set with Bucket.SetBandwidthLimiter, if
+	// any.
+	BandwidthLimiter *BandwidthLimiter
+
+	// ServerSideEncryption holds the key of a blob written with
+	// EncryptionCustomerKey, which is needed to read it. It is ignored for
+	// other modes, for which reads need no settings. If the bucket doesn't
+	// support EncryptionCustomerKey, NewReader returns an error for which
+	// gdkerr.Code will return gdkerr.Unimplemented.
+	ServerSideEncryption *ServerSideEncryption
+}
+
+// ReadRetryPolicy controls how a Reader recovers from failed reads; see
+// ReaderOptions.RetryPolicy.
+type ReadRetryPolicy struct {
+	// MaxRetries is the maximum number of consecutive times the blob is
+	// reopened after a failed read; the count is reset whenever a read
+	// returns data. Defaults to DefaultReadMaxRetries.
+	MaxRetries int
+
+	// InitialBackoff is the pause before the first retry; it doubles for
+	// every consecutive retry, up to MaxBackoff. They default to
+	// DefaultReadInitialBackoff and DefaultReadMaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// IsRetryable, if non-nil, reports whether a read that failed with err
+	// should be retried. By default, all errors are retried except those for
+	// which gdkerr.Code returns gdkerr.NotFound, gdkerr.InvalidArgument,
+	// gdkerr.Unimplemented, gdkerr.FailedPrecondition,
+	// gdkerr.PermissionDenied or gdkerr.Canceled. Reads are never retried
+	// once the context passed to NewReader is done.
+	IsRetryable func(err error) bool
+}
+
+const (
+	// DefaultReadMaxRetries is the default for ReadRetryPolicy.MaxRetries.
+	DefaultReadMaxRetries = 3
+	// DefaultReadInitialBackoff is the default for
+	// ReadRetryPolicy.InitialBackoff.
+	DefaultReadInitialBackoff = 100 * time.Millisecond
+	// DefaultReadMaxBackoff is the default for ReadRetryPolicy.MaxBackoff.
+	DefaultReadMaxBackoff = 5 * time.Second
+)
+
+// ChecksumAlgorithm names an algorithm for WriterOptions.Checksums and
+// Attributes.Checksums. Checksums are the bytes of the digest; for CRCs, that
+// is the big-endian encoding of the CRC.
+type ChecksumAlgorithm string
+
+// Supported checksum algorithms.
+const (
+	// ChecksumCRC32 is the CRC-32 with the IEEE polynomial.
+	ChecksumCRC32 ChecksumAlgorithm = ChecksumAlgorithm(driver.ChecksumCRC32)
+	// ChecksumCRC32C is the CRC-32 with the Castagnoli polynomial.
+	ChecksumCRC32C ChecksumAlgorithm = ChecksumAlgorithm(driver.ChecksumCRC32C)
+	// ChecksumCRC64 is the CRC-64 with the ECMA polynomial, as used by
+	// Alibaba Cloud OSS.
+	ChecksumCRC64 ChecksumAlgorithm = ChecksumAlgorithm(driver.ChecksumCRC64)
+	// ChecksumSHA1 is SHA-1.
+	ChecksumSHA1 ChecksumAlgorithm = ChecksumAlgorithm(driver.ChecksumSHA1)
+	// ChecksumSHA256 is SHA-256.
+	ChecksumSHA256 ChecksumAlgorithm = ChecksumAlgorithm(driver.ChecksumSHA256)
+)
+
+func toDriverChecksums(checksums map[ChecksumAlgorithm][]byte) map[driver.ChecksumAlgorithm][]byte {
+	if len(checksums) == 0 {
+		return nil
+	}
+	dchecksums := make(map[driver.ChecksumAlgorithm][]byte, len(checksums))
+	for alg, sum := range checksums {
+		dchecksums[driver.ChecksumAlgorithm(alg)] = sum
+	}
+	return dchecksums
+}
+
+func fromDriverChecksums(dchecksums map[driver.ChecksumAlgorithm][]byte) map[ChecksumAlgorithm][]byte {
+	if len(dchecksums) == 0 {
+		return nil
+	}
+	checksums := make(map[ChecksumAlgorithm][]byte, len(dchecksums))
+	for alg, sum := range dchecksums {
+		checksums[ChecksumAlgorithm(alg)] = sum
+	}
+	return checksums
+}
+
+// EncryptionMode is how a service encrypts blobs at rest; see
+// ServerSideEncryption.
+type EncryptionMode string
+
+// Encryption modes.
+const (
+	// EncryptionServiceKey encrypts blobs with keys managed by the service,
+	// like SSE-S3 for S3 or OSS-managed keys for OSS.
+	EncryptionServiceKey = EncryptionMode(driver.EncryptionServiceKey)
+	// EncryptionKMS encrypts blobs with a key of the key management service
+	// of the provider, like SSE-KMS for S3 or KMS for OSS.
+	EncryptionKMS = EncryptionMode(driver.EncryptionKMS)
+	// EncryptionCustomerKey encrypts blobs with a key provided with every
+	// request, which the service doesn't store, like SSE-C for S3. The same
+	// key must be given in ReaderOptions to read the blob, and in
+	// CopyOptions to copy it.
+	EncryptionCustomerKey = EncryptionMode(driver.EncryptionCustomerKey)
+)
+
+// ServerSideEncryption describes how a service encrypts a blob at rest. It is
+// unrelated to EncryptedBucket, which encrypts blobs before they are sent to
+// the service.
+type ServerSideEncryption struct {
+	// Mode is how the blob is encrypted.
+	Mode EncryptionMode
+	// KeyID identifies the key of EncryptionKMS, like the ID or ARN of an
+	// AWS KMS key; if empty, the default key of the service is used. It must
+	// be empty for other modes, except in Attributes, where for
+	// EncryptionCustomerKey it is the base64-encoded MD5 hash of
+	// CustomerKey, if available.
+	KeyID string
+	// CustomerKey is the 256-bit AES key of EncryptionCustomerKey. It must be
+	// empty for other modes, and is never set in Attributes.
+	CustomerKey []byte
+}
+
+// toDriver validates e, and converts it to the driver type; what describes it
+// in errors.
+func (e *ServerSideEncryption) toDriver(what string) (*driver.ServerSideEncryption, error) {
+	if e == nil {
+		return nil, nil
+	}
+	switch e.Mode {
+	case EncryptionServiceKey, EncryptionKMS:
+		if len(e.CustomerKey) > 0 {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s.CustomerKey may only be set for EncryptionCustomerKey", what)
+		}
+		if e.Mode == EncryptionServiceKey && e.KeyID != "" {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s.KeyID may not be set for EncryptionServiceKey", what)
+		}
+	case EncryptionCustomerKey:
+		if len(e.CustomerKey) != 32 {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s.CustomerKey must be 32 bytes long, got %d", what, len(e.CustomerKey))
+		}
+		if e.KeyID != "" {
+			return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s.KeyID may not be set for EncryptionCustomerKey", what)
+		}
+	default:
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: %s.Mode is invalid: %q", what, e.Mode)
+	}
+	return &driver.ServerSideEncryption{Mode: driver.EncryptionMode(e.Mode), KeyID: e.KeyID, CustomerKey: e.CustomerKey}, nil
+}
+
+// customerKeyToDriver is like toDriver, for the options of reads, which only
+// need a key for EncryptionCustomerKey; it returns nil for other modes.
+func (e *ServerSideEncryption) customerKeyToDriver(what string) (*driver.ServerSideEncryption, error) {
+	de, err := e.toDriver(what)
+	if err != nil || de == nil || de.Mode != driver.EncryptionCustomerKey {
+		return nil, err
+	}
+	return de, nil
+}
+
+func fromDriverEncryption(de *driver.ServerSideEncryption) *ServerSideEncryption {
+	if de == nil {
+		return nil
+	}
+	return &ServerSideEncryption{Mode: EncryptionMode(de.Mode), KeyID: de.KeyID}
 }
 
 // WriterOptions sets options for NewWriter.
@@ -1268,12 +2393,43 @@ type WriterOptions struct {
 	// https://tools.ietf.org/html/rfc1864
 	ContentMD5 []byte
 
+	// Checksums holds checksums of the blob contents, by algorithm. Like
+	// ContentMD5, the checksums of the bytes written must match them, or
+	// Close will return an error without completing the write; an
+	// unsupported algorithm makes NewWriter return an error. Drivers may
+	// also pass them to the service, and store them to be returned in
+	// Attributes.Checksums.
+	Checksums map[ChecksumAlgorithm][]byte
+
 	// Metadata holds key/value strings to be associated with the blob, or nil.
 	// Keys may not be empty, and are lowercased before being written.
 	// Duplicate case-insensitive keys (e.g., "foo" and "FOO") will result in
 	// an error.
 	Metadata map[string]string
 
+	// Tags holds key/value tags to be associated with the blob, or nil. Keys
+	// may not be empty. Unlike Metadata, tags are not lowercased, and can be
+	// changed after the blob is written with Bucket.SetTags; services may use
+	// them in lifecycle rules and access policies.
+	//
+	// If the bucket can't store tags, NewWriter returns an error for which
+	// gdkerr.Code will return gdkerr.Unimplemented.
+	Tags map[string]string
+
+	// StorageClass is the service-specific storage class of the blob, like
+	// "STANDARD_IA" or "GLACIER" for S3 and "IA" or "Archive" for OSS. If
+	// empty, the bucket's default is used.
+	//
+	// If the bucket doesn't have storage classes, NewWriter returns an error
+	// for which gdkerr.Code will return gdkerr.Unimplemented.
+	StorageClass string
+
+	// ServerSideEncryption, if non-nil, sets how the service encrypts the
+	// blob at rest, instead of the bucket's default. If the bucket doesn't
+	// support its Mode, NewWriter returns an error for which gdkerr.Code will
+	// return gdkerr.Unimplemented.
+	ServerSideEncryption *ServerSideEncryption
+
 	// BeforeWrite is a callback that will be called exactly once, before
 	// any data is written (unless NewWriter returns an error, in which case
 	// it will not be called at all). Note that this is not necessarily during
@@ -1283,6 +2439,99 @@ type WriterOptions struct {
 	// asFunc converts its argument to driver-specific types.
 	// See https://sraphs.github.io/gdk/concepts/as/ for background information.
 	BeforeWrite func(asFunc func(interface{}) bool) error
+
+	// IfMatch, if non-empty, makes the write succeed only if a blob already
+	// exists at the key and its ETag (see Attributes.ETag) matches IfMatch;
+	// "*" matches any existing blob. This allows a safe read-modify-write:
+	// read the blob and its ETag, then write it back with IfMatch set.
+	//
+	// If the precondition fails, nothing is written and Close returns an
+	// error for which gdkerr.Code will return gdkerr.FailedPrecondition.
+	IfMatch string
+
+	// IfNoneMatch, if non-empty, makes the write succeed only if there is no
+	// existing blob whose ETag matches IfNoneMatch. Use "*" to write only if
+	// the blob does not exist yet; for services that use generation numbers,
+	// this corresponds to a "generation 0" precondition.
+	//
+	// If the precondition fails, nothing is written and Close returns an
+	// error for which gdkerr.Code will return gdkerr.FailedPrecondition.
+	IfNoneMatch string
+
+	// Resumable starts a resumable upload session. Writer.SessionToken returns
+	// a token for the session that can be saved, and passed as SessionToken
+	// to a later NewWriter call to resume the upload if this Writer is lost,
+	// for example because the process died.
+	//
+	// The content type of a resumable upload is not sniffed; if ContentType is
+	// empty, "application/octet-stream" is used.
+	//
+	// If the bucket doesn't support resumable uploads, NewWriter returns an
+	// error for which gdkerr.Code will return gdkerr.Unimplemented.
+	Resumable bool
+
+	// SessionToken resumes the upload session identified by the token; it
+	// implies Resumable. The returned Writer's Committed method reports how
+	// many bytes of the blob were already stored; the caller must continue
+	// writing from there. The attributes given when the session was started
+	// are used, and those in the other options are ignored; IfMatch and
+	// IfNoneMatch apply as usual. ContentMD5 may not be set.
+	//
+	// If the session doesn't exist, NewWriter returns an error for which
+	// gdkerr.Code will return gdkerr.NotFound.
+	SessionToken string
+
+	// Progress, if non-nil, is called by Write after each write, with the
+	// number of bytes written to the Writer so far. Writes may be buffered,
+	// so bytes are reported before they are sent. It is called
+	// synchronously, so it should return quickly.
+	Progress func(Progress)
+
+	// ProgressTotal is the number of bytes expected to be written, reported
+	// as Progress.Total; if it isn't positive, Progress.Total is -1.
+	// WriteAll sets it to the length of the data it writes.
+	ProgressTotal int64
+
+	// BandwidthLimiter, if non-nil, limits the rate at which Write accepts
+	// data. It defaults to the one set with Bucket.SetBandwidthLimiter, if
+	// any.
+	BandwidthLimiter *BandwidthLimiter
+}
+
+// UploadSession describes a resumable upload session that has not been
+// completed or aborted; see WriterOptions.Resumable.
+type UploadSession struct {
+	// Key is the key of the blob being uploaded.
+	Key string
+	// Token identifies the session; pass it as WriterOptions.SessionToken to
+	// resume the upload, or to Bucket.AbortUploadSession.
+	Token string
+	// Started is the time the session was started, or zero if unknown.
+	Started time.Time
+}
+
+// ReplicationStatus is the state of a blob in the replicas of a bucket; see
+// Bucket.ReplicationStatus.
+type ReplicationStatus struct {
+	// Replicas holds the state of the blob in each replica, in the order they
+	// were given to ReplicatedBucket.
+	Replicas []*ReplicaStatus
+	// InSync reports whether all the replicas have the same version of the
+	// blob, or none has it, and no repair is pending.
+	InSync bool
+}
+
+// ReplicaStatus is the state of a blob in one replica.
+type ReplicaStatus struct {
+	// Exists reports whether the replica has the blob.
+	Exists bool
+	// ETag is the ETag of the blob in the replica, if it exists.
+	ETag string
+	// Pending reports whether a repair of the blob in the replica is queued.
+	Pending bool
+	// Err is the error getting the state of the replica, or the last error
+	// repairing it, if any.
+	Err error
 }
 
 // CopyOptions sets options for Copy.
@@ -1293,6 +2542,65 @@ type CopyOptions struct {
 	// asFunc converts its argument to driver-specific types.
 	// See https://sraphs.github.io/gdk/concepts/as/ for background information.
 	BeforeCopy func(asFunc func(interface{}) bool) error
+
+	// IfMatch and IfNoneMatch are preconditions on the destination blob,
+	// with the same semantics as in WriterOptions. If a precondition fails,
+	// Copy returns an error for which gdkerr.Code will return
+	// gdkerr.FailedPrecondition.
+	IfMatch     string
+	IfNoneMatch string
+
+	// ServerSideEncryption, if non-nil, sets how the service encrypts the
+	// destination blob, as in WriterOptions.
+	ServerSideEncryption *ServerSideEncryption
+
+	// SourceServerSideEncryption holds the key of a source blob written with
+	// EncryptionCustomerKey, as in ReaderOptions.
+	SourceServerSideEncryption *ServerSideEncryption
+}
+
+// ComposeOptions sets options for Compose.
+type ComposeOptions struct {
+	// ContentType is the MIME type of the composed blob. If empty, it is the
+	// content type of the first source blob.
+	ContentType string
+
+	// Metadata holds key/value strings to be associated with the composed
+	// blob, as in WriterOptions.
+	Metadata map[string]string
+
+	// IfMatch and IfNoneMatch are preconditions on the destination blob,
+	// with the same semantics as in WriterOptions. If a precondition fails,
+	// Compose returns an error for which gdkerr.Code will return
+	// gdkerr.FailedPrecondition.
+	IfMatch     string
+	IfNoneMatch string
+
+	// ServerSideEncryption, if non-nil, sets how the service encrypts the
+	// composed blob, as in WriterOptions.
+	ServerSideEncryption *ServerSideEncryption
+
+	// SourceServerSideEncryption holds the key of source blobs written with
+	// EncryptionCustomerKey, as in ReaderOptions. All of them must have been
+	// written with the same key.
+	SourceServerSideEncryption *ServerSideEncryption
+}
+
+// DeleteOptions sets options for DeleteWithOptions.
+type DeleteOptions struct {
+	// IfMatch, if non-empty, makes the delete succeed only if the blob's ETag
+	// (see Attributes.ETag) matches it; "*" matches any existing blob.
+	// Otherwise, DeleteWithOptions returns an error for which gdkerr.Code
+	// will return gdkerr.FailedPrecondition.
+	IfMatch string
+
+	// VersionID, if non-empty, permanently deletes the given version of the
+	// blob; see Bucket.ListVersions. If it is the latest version, the next
+	// most recent version (if any) becomes the latest. Without VersionID, a
+	// versioned bucket keeps the deleted blob as a previous version.
+	// If the bucket does not support versioning, DeleteWithOptions returns an
+	// error for which gdkerr.Code will return gdkerr.Unimplemented.
+	VersionID string
 }
 
 // BucketURLOpener represents types that can open buckets based on a URL.
@@ -1440,3 +2748,326 @@ func SingleKeyBucket(bucket *Bucket, singleKey string) *Bucket {
 	bucket.closed = true
 	return NewBucket(driver.NewSingleKeyBucket(bucket.b, singleKey))
 }
+
+// EncryptedBucket returns a *Bucket based on b that encrypts blobs before
+// they are written to it, and decrypts them when they are read back.
+//
+// Blobs are encrypted with envelope encryption: each one gets a random data
+// key, which is encrypted by keeper and stored in the blob's metadata. The
+// content is encrypted with AES-256-GCM in chunks of 64 KiB, so range reads
+// only need to fetch and decrypt the chunks they cover; tampering with the
+// content is detected, and Read returns an error for which gdkerr.Code
+// will return gdkerr.Internal.
+//
+// Blobs must be written through an encrypted bucket to be readable from
+// one; reading any other blob returns an error for which gdkerr.Code will
+// return gdkerr.FailedPrecondition. Attributes and List report the sizes of
+// the plaintexts, but not their MD5 hashes or checksums. SignedURL is not
+// supported.
+//
+// bucket will be closed and no longer usable after this function returns;
+// keeper is not closed when the returned Bucket is.
+func EncryptedBucket(bucket *Bucket, keeper *secrets.Keeper) *Bucket {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.closed = true
+	return NewBucket(driver.NewEncryptedBucket(bucket.b, keeper))
+}
+
+// Compressor compresses and decompresses the blobs of a CompressedBucket.
+type Compressor interface {
+	// ContentEncoding returns the content encoding of the blobs it
+	// compresses, such as "gzip".
+	ContentEncoding() string
+	// NewWriter returns a WriteCloser compressing what is written to it into
+	// w. Close must flush everything to w, but not close it.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a ReadCloser decompressing what is read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCompressor returns a Compressor using gzip with the given compression
+// level, such as gzip.DefaultCompression.
+func GzipCompressor(level int) Compressor {
+	return gzipCompressor{level: level}
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+func (gzipCompressor) ContentEncoding() string { return "gzip" }
+
+func (c gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ZstdCompressor returns a Compressor using zstd with the given compression
+// level, such as zstd.SpeedDefault. zstd compresses faster than gzip, and
+// usually better.
+func ZstdCompressor(level zstd.EncoderLevel) Compressor {
+	return zstdCompressor{level: level}
+}
+
+type zstdCompressor struct {
+	level zstd.EncoderLevel
+}
+
+func (zstdCompressor) ContentEncoding() string { return "zstd" }
+
+func (c zstdCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(c.level))
+}
+
+func (zstdCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	// Blobs are read one at a time; don't start a goroutine per CPU.
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// CompressionOptions sets options for CompressedBucket.
+type CompressionOptions struct {
+	// Compressor returns the Compressor for the blobs with the given content
+	// type, or nil to write them uncompressed.
+	//
+	// If nil, all blobs are compressed with gzip.
+	Compressor func(contentType string) Compressor
+
+	// Decompressors are used to read the blobs with their content encoding,
+	// in addition to gzip and zstd. They must include all the other
+	// Compressors returned by Compressor.
+	Decompressors []Compressor
+}
+
+// CompressedBucket returns a *Bucket based on b that compresses blobs before
+// they are written to it, and decompresses them when they are read back.
+//
+// Compressed blobs have their ContentEncoding set to the one of their
+// Compressor, and the size and MD5 hash of their original content stored in
+// their metadata; Attributes and Reader report those instead, without the
+// ContentEncoding. Blobs written with a ContentEncoding, or for which
+// opts.Compressor returns nil, are written as they are, and like other
+// uncompressed blobs, read as they are. List reports the sizes of the stored
+// blobs, and no MD5 hashes or checksums. Attributes don't report checksums
+// of compressed blobs either.
+//
+// The compressed content of a blob is spooled to a temporary file until its
+// Writer is closed, since its metadata can only be set before it is written. Range
+// reads decompress the blob from its start, skipping up to the offset.
+// Full reads check the MD5 hash of the content; a mismatch, or content that
+// can't be decompressed, makes Read return an error for which gdkerr.Code
+// will return gdkerr.Internal.
+//
+// Signed URLs serve the blobs as they are stored, with their ContentEncoding;
+// browsers decompress gzip and zstd, but net/http clients only gzip.
+//
+// Reads must return the blobs as they are stored: drivers for services that
+// decompress blobs on the fly, such as gcsblob, need to be asked not to, with
+// ReaderOptions.BeforeRead.
+//
+// bucket will be closed and no longer usable after this function returns.
+func CompressedBucket(bucket *Bucket, opts *CompressionOptions) *Bucket {
+	if opts == nil {
+		opts = &CompressionOptions{}
+	}
+	gz := GzipCompressor(gzip.DefaultCompression)
+	choose := func(string) driver.Compressor { return gz }
+	if opts.Compressor != nil {
+		choose = func(contentType string) driver.Compressor {
+			if c := opts.Compressor(contentType); c != nil {
+				return c
+			}
+			return nil
+		}
+	}
+	decompressors := []driver.Compressor{gz, ZstdCompressor(zstd.SpeedDefault)}
+	for _, c := range opts.Decompressors {
+		decompressors = append(decompressors, c)
+	}
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.closed = true
+	return NewBucket(driver.NewCompressedBucket(bucket.b, choose, decompressors))
+}
+
+// CacheOptions sets options for CachingBucket.
+type CacheOptions struct {
+	// MaxSize is the maximum total size in bytes of the blobs in the cache.
+	// When it is exceeded, the least recently used blobs are evicted from it.
+	// Blobs larger than MaxSize are never cached. Zero means no limit.
+	MaxSize int64
+
+	// TTL is how long a cached blob is used without checking that its ETag
+	// still matches the one of the blob in the bucket. Zero means it is
+	// checked each time the blob is read, which only takes a call to
+	// Attributes on the bucket.
+	TTL time.Duration
+
+	// NegativeTTL is how long a blob that was not found in the bucket is
+	// remembered as such. Zero means it is not.
+	NegativeTTL time.Duration
+
+	// FillTimeout is how long copying a blob from the bucket to the cache
+	// may take. The copy is shared by concurrent reads of the blob, so it
+	// isn't canceled along with the context of any of them. Zero means 10
+	// minutes.
+	FillTimeout time.Duration
+}
+
+// CachingBucket returns a *Bucket reading the blobs in bucket through cache,
+// typically a fileblob or memblob bucket in front of a remote one.
+//
+// Blobs are copied to cache the first time they are read, and then read from
+// there for as long as their ETag matches the one of the blob in bucket;
+// concurrent reads of a blob that isn't cached yet copy it once. Attributes
+// are cached along with the blobs. Blobs in cache are reused across
+// instances, so a fileblob cache survives restarts; the cache should not be
+// used for anything else.
+//
+// Writes, copies and deletes go to bucket, and remove the blob from the
+// cache. Reads with ReaderOptions.BeforeRead set, List and SignedURL go to
+// bucket too.
+//
+// bucket and cache will be closed and no longer usable after this function
+// returns; they are both closed when the returned Bucket is.
+func CachingBucket(bucket, cache *Bucket, opts *CacheOptions) *Bucket {
+	var dopts *driver.CacheOptions
+	if opts != nil {
+		dopts = &driver.CacheOptions{
+			MaxSize:     opts.MaxSize,
+			TTL:         opts.TTL,
+			NegativeTTL: opts.NegativeTTL,
+			FillTimeout: opts.FillTimeout,
+		}
+	}
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.closed = true
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.closed = true
+	return NewBucket(driver.NewCachingBucket(bucket.b, cache.b, dopts))
+}
+
+// OverlayOptions sets options for OverlayBucket.
+type OverlayOptions struct {
+	// ReadOnly makes writes, copies and deletes fail with an error for which
+	// gdkerr.Code will return gdkerr.PermissionDenied.
+	ReadOnly bool
+}
+
+// OverlayBucket returns a *Bucket that overlays layers, the first one on
+// top: each blob is read from the first layer that has it, and List merges
+// the blobs of all the layers, a key only being listed once.
+//
+// Unless opts.ReadOnly is set, blobs are written to the top layer only,
+// copying them up from a lower layer if needed, so that the other layers are
+// never modified. Deleting a blob that is in a lower layer writes a whiteout
+// marker for it to the top layer, under the reserved ".gdk-whiteout/" prefix,
+// which hides it from then on; writing the blob again removes the marker.
+// Preconditions in WriterOptions apply to the blob as it is read.
+//
+// SignedURL is not supported for the DELETE method.
+//
+// The layers will be closed and no longer usable after this function
+// returns; they are all closed when the returned Bucket is.
+func OverlayBucket(layers []*Bucket, opts *OverlayOptions) *Bucket {
+	if opts == nil {
+		opts = &OverlayOptions{}
+	}
+	var drivers []driver.Bucket
+	for _, l := range layers {
+		l.mu.Lock()
+		l.closed = true
+		drivers = append(drivers, l.b)
+		l.mu.Unlock()
+	}
+	return NewBucket(driver.NewOverlayBucket(drivers, opts.ReadOnly))
+}
+
+// ReplicationOptions sets options for ReplicatedBucket.
+type ReplicationOptions struct {
+	// WriteQuorum is the number of replicas writes, copies and deletes must
+	// succeed on. Zero means all of them.
+	WriteQuorum int
+	// UnhealthyPeriod is how long a replica that failed is only read from
+	// after the others. Zero means 30 seconds.
+	UnhealthyPeriod time.Duration
+	// RepairRetryInterval is how long to wait before retrying a repair that
+	// failed. Zero means 10 seconds.
+	RepairRetryInterval time.Duration
+}
+
+// ReplicatedBucket returns a *Bucket that writes blobs to all of replicas,
+// and reads them from the first healthy one, failing over to the next ones
+// when a replica fails. A replica that fails is read from last for
+// opts.UnhealthyPeriod.
+//
+// Writes, copies and deletes succeed when they succeed on opts.WriteQuorum
+// replicas. The replicas they failed on are repaired in the background, by
+// copying the blob from one that has it; use Bucket.ReplicationStatus to
+// check on them. Blobs written through the returned Bucket have the same
+// ETag in all the replicas, and preconditions are checked against the
+// replica that reads come from.
+//
+// SignedURL is not supported, since requests to signed URLs would bypass
+// replication.
+//
+// The replicas will be closed and no longer usable after this function
+// returns; they are all closed when the returned Bucket is.
+func ReplicatedBucket(replicas []*Bucket, opts *ReplicationOptions) *Bucket {
+	if opts == nil {
+		opts = &ReplicationOptions{}
+	}
+	var drivers []driver.Bucket
+	for _, r := range replicas {
+		r.mu.Lock()
+		r.closed = true
+		drivers = append(drivers, r.b)
+		r.mu.Unlock()
+	}
+	return NewBucket(driver.NewReplicatedBucket(drivers, &driver.ReplicationOptions{
+		WriteQuorum:         opts.WriteQuorum,
+		UnhealthyPeriod:     opts.UnhealthyPeriod,
+		RepairRetryInterval: opts.RepairRetryInterval,
+	}))
+}
+
+// TrashOptions sets options for TrashBucket.
+type TrashOptions struct {
+	// Prefix is the prefix of the keys deleted blobs are moved under.
+	// It defaults to ".gdk-trash/".
+	Prefix string
+}
+
+// TrashBucket returns a *Bucket that moves blobs under opts.Prefix when they
+// are deleted, instead of deleting them, so that they can be restored with
+// Bucket.Restore until they are purged with Bucket.Purge. It only uses Copy
+// and Delete, so it works with any bucket, even without versioning.
+//
+// A blob deleted at time t is kept with the key
+// opts.Prefix+key+"@"+t, t being the number of nanoseconds since the Unix
+// epoch formatted as 20 digits. The keys under opts.Prefix are hidden from
+// List and reads, and can't be written to.
+//
+// SignedURL is not supported for the DELETE method.
+//
+// bucket will be closed and no longer usable after this function returns;
+// it is closed when the returned Bucket is.
+func TrashBucket(bucket *Bucket, opts *TrashOptions) *Bucket {
+	if opts == nil {
+		opts = &TrashOptions{}
+	}
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.closed = true
+	return NewBucket(driver.NewTrashBucket(bucket.b, opts.Prefix))
+}
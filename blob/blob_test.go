@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
@@ -239,7 +240,7 @@ func (b *erroringBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *
 	return errFake
 }
 
-func (b *erroringBucket) Delete(ctx context.Context, key string) error {
+func (b *erroringBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	return errFake
 }
 
@@ -332,6 +333,204 @@ func TestErrorsAreWrapped(t *testing.T) {
 	verifyWrap("Close", err)
 }
 
+// TestVersioningUnsupported tests that the versioning APIs fail with
+// Unimplemented for drivers that don't implement driver.Versioner.
+func TestVersioningUnsupported(t *testing.T) {
+	ctx := context.Background()
+	for _, b := range []*Bucket{
+		NewBucket(&erroringBucket{}),
+		// PrefixedBucket implements driver.Versioner regardless of its base.
+		PrefixedBucket(NewBucket(&erroringBucket{}), "p/"),
+	} {
+		defer b.Close()
+
+		if b.SupportsVersioning() {
+			t.Error("SupportsVersioning: got true want false")
+		}
+		_, err := b.ListVersions(nil).Next(ctx)
+		if gdkerr.Code(err) != gdkerr.Unimplemented {
+			t.Errorf("ListVersions: got %v want Unimplemented", err)
+		}
+		_, err = b.NewReader(ctx, "key", &ReaderOptions{VersionID: "1"})
+		if gdkerr.Code(err) != gdkerr.Unimplemented {
+			t.Errorf("NewReader: got %v want Unimplemented", err)
+		}
+		err = b.DeleteWithOptions(ctx, "key", &DeleteOptions{VersionID: "1"})
+		if gdkerr.Code(err) != gdkerr.Unimplemented {
+			t.Errorf("DeleteWithOptions: got %v want Unimplemented", err)
+		}
+	}
+}
+
+func TestResumableUploadUnsupported(t *testing.T) {
+	ctx := context.Background()
+	b := NewBucket(&erroringBucket{})
+	defer b.Close()
+
+	_, err := b.NewWriter(ctx, "key", &WriterOptions{Resumable: true})
+	if gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("NewWriter: got %v want Unimplemented", err)
+	}
+	_, err = b.ListUploadSessions(ctx, "")
+	if gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("ListUploadSessions: got %v want Unimplemented", err)
+	}
+	err = b.AbortUploadSession(ctx, "key", "token")
+	if gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("AbortUploadSession: got %v want Unimplemented", err)
+	}
+}
+
+// fakeBulkDeleter is a driver.Bucket whose blobs are the keys of blobs. It
+// implements driver.BulkDeleter unless unimplemented is set.
+type fakeBulkDeleter struct {
+	driver.Bucket
+	mu            sync.Mutex
+	blobs         map[string]bool
+	unimplemented bool
+	bulkCalls     int
+}
+
+func (b *fakeBulkDeleter) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key == "fail" {
+		return errFake
+	}
+	if !b.blobs[key] {
+		return errNotFound
+	}
+	delete(b.blobs, key)
+	return nil
+}
+
+func (b *fakeBulkDeleter) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	if b.unimplemented {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "not implemented")
+	}
+	b.bulkCalls++
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = b.Delete(ctx, key, nil)
+	}
+	return errs, nil
+}
+
+func (b *fakeBulkDeleter) Close() error {
+	return nil
+}
+
+func (b *fakeBulkDeleter) ErrorCode(err error) gdkerr.ErrorCode {
+	if err == errNotFound {
+		return gdkerr.NotFound
+	}
+	return gdkerr.Unknown
+}
+
+func TestDeleteMany(t *testing.T) {
+	ctx := context.Background()
+	for _, unimplemented := range []bool{false, true} {
+		t.Run(fmt.Sprintf("unimplemented=%v", unimplemented), func(t *testing.T) {
+			drv := &fakeBulkDeleter{
+				blobs:         map[string]bool{"a": true, "b": true, "c": true},
+				unimplemented: unimplemented,
+			}
+			b := NewBucket(drv)
+			defer b.Close()
+
+			failed, err := b.DeleteMany(ctx, []string{"a", "b", "a", "missing", "fail", "\xF4\x90\x80\x80"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(failed) != 2 {
+				t.Errorf("got %d failures %v want 2", len(failed), failed)
+			}
+			if err := failed["fail"]; gdkerr.Code(err) != gdkerr.Unknown || !errors.Is(err, errFake) {
+				t.Errorf(`failed["fail"]: got %v want errFake`, err)
+			}
+			if err := failed["\xF4\x90\x80\x80"]; gdkerr.Code(err) != gdkerr.InvalidArgument {
+				t.Errorf("invalid key: got %v want InvalidArgument", err)
+			}
+			if diff := cmp.Diff(map[string]bool{"c": true}, drv.blobs); diff != "" {
+				t.Errorf("blobs after DeleteMany: %s", diff)
+			}
+			if want := 1; !unimplemented && drv.bulkCalls != want {
+				t.Errorf("got %d calls to driver DeleteMany want %d", drv.bulkCalls, want)
+			}
+		})
+	}
+}
+
+func TestReaderRetries(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	ctx := context.Background()
+	policy := &ReadRetryPolicy{InitialBackoff: time.Millisecond}
+
+	tests := []struct {
+		name         string
+		failures     int
+		openFailures int
+		policy       *ReadRetryPolicy
+		wantReads    int
+		wantErr      bool
+	}{
+		{name: "no failures", policy: policy, wantReads: 1},
+		{name: "retries", failures: 3, policy: policy, wantReads: 4},
+		{name: "failures reset retries", failures: 3, policy: &ReadRetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond}, wantReads: 4},
+		{name: "reopen retries", failures: 1, openFailures: 2, policy: policy, wantReads: 4},
+		{name: "too many failures", failures: 1, openFailures: 2, policy: &ReadRetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond}, wantReads: 3, wantErr: true},
+		{name: "not retryable", failures: 1, policy: &ReadRetryPolicy{IsRetryable: func(error) bool { return false }}, wantReads: 1, wantErr: true},
+		{name: "retries disabled", failures: 1, wantReads: 1, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drv := &flakyBucket{data: data, failures: test.failures, openFailures: test.openFailures}
+			b := NewBucket(drv)
+			defer b.Close()
+
+			r, err := b.NewReader(ctx, "key", &ReaderOptions{RetryPolicy: test.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got err %v want error %v", err, test.wantErr)
+			}
+			if drv.reads != test.wantReads {
+				t.Errorf("got %d reads want %d", drv.reads, test.wantReads)
+			}
+			if err == nil && !bytes.Equal(got, data) {
+				t.Error("read data doesn't match")
+			}
+		})
+	}
+
+	t.Run("overwritten", func(t *testing.T) {
+		drv := &flakyBucket{data: data, failures: 1}
+		b := NewBucket(drv)
+		defer b.Close()
+
+		r, err := b.NewReader(ctx, "key", &ReaderOptions{RetryPolicy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+		drv.mu.Lock()
+		drv.eTag = "\"new\""
+		drv.mu.Unlock()
+		if _, err := io.ReadAll(r); gdkerr.Code(err) != gdkerr.FailedPrecondition {
+			t.Errorf("got %v want FailedPrecondition", err)
+		}
+	})
+}
+
 var (
 	testOpenOnce sync.Once
 	testOpenGot  *url.URL
@@ -375,6 +574,18 @@ func TestBucketIsClosed(t *testing.T) {
 	if err := bucket.Delete(ctx, ""); err != errClosed {
 		t.Error(err)
 	}
+	if _, err := bucket.DeleteMany(ctx, []string{""}); err != errClosed {
+		t.Error(err)
+	}
+	if _, err := bucket.DeletePrefix(ctx, ""); err != errClosed {
+		t.Error(err)
+	}
+	if _, err := bucket.ListUploadSessions(ctx, ""); err != errClosed {
+		t.Error(err)
+	}
+	if err := bucket.AbortUploadSession(ctx, "", ""); err != errClosed {
+		t.Error(err)
+	}
 	if _, err := bucket.SignedURL(ctx, "", nil); err != errClosed {
 		t.Error(err)
 	}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"testing"
 
@@ -12,6 +15,7 @@ import (
 
 	"github.com/sraphs/gdk/blob"
 	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
 )
 
 // TestWriteReturnValues verifies that blob.Writer returns the correct n
@@ -49,6 +53,37 @@ func TestWriteReturnValues(t *testing.T) {
 	}
 }
 
+// TestWriterChecksums verifies that blob.Writer checks
+// WriterOptions.Checksums.
+func TestWriterChecksums(t *testing.T) {
+	ctx := context.Background()
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+
+	content := []byte("hello world")
+	sha := sha256.Sum256(content)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(content))
+	checksums := map[blob.ChecksumAlgorithm][]byte{blob.ChecksumSHA256: sha[:], blob.ChecksumCRC32: crc}
+	if err := bucket.WriteAll(ctx, "good", content, &blob.WriterOptions{Checksums: checksums}); err != nil {
+		t.Fatal(err)
+	}
+
+	checksums[blob.ChecksumCRC32] = []byte{1, 2, 3, 4}
+	err := bucket.WriteAll(ctx, "bad", content, &blob.WriterOptions{Checksums: checksums})
+	if gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		t.Errorf("write with wrong checksum: got %v want FailedPrecondition", err)
+	}
+	if ok, _ := bucket.Exists(ctx, "bad"); ok {
+		t.Error("write with wrong checksum: blob was written")
+	}
+
+	_, err = bucket.NewWriter(ctx, "unsupported", &blob.WriterOptions{Checksums: map[blob.ChecksumAlgorithm][]byte{"MD4": nil}})
+	if gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("unsupported algorithm: got %v want InvalidArgument", err)
+	}
+}
+
 func randomData(nBytes int64) ([]byte, error) {
 	var buf bytes.Buffer
 	n, err := io.CopyN(&buf, rand.Reader, nBytes)
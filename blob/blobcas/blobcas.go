@@ -0,0 +1,435 @@
+// Package blobcas provides a content-addressed store on top of a
+// *blob.Bucket: blobs are stored under the SHA-256 digest of their content,
+// so that identical content is only stored once.
+//
+// Put streams content to a temporary blob while computing its digest, and
+// then commits it under the digest, unless a blob with the same digest was
+// already stored. Readers verify the digest of what they read. Blobs are
+// garbage collected once they have no references left; see Store.AddRef and
+// Store.GC.
+//
+// The blobs of a Store are kept under Options.Prefix:
+//
+//	sha256/<hex digest>          content
+//	refs/<hex digest>/<ref>      references, empty
+//	gc/<hex digest>              markers of blobs GC is deleting, empty
+//	tmp/<random>                 uploads in progress
+package blobcas
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// staleMarkerAge is the age after which GC deletes the markers of blobs being
+// deleted, assuming that the GC that wrote them failed.
+const staleMarkerAge = 10 * time.Minute
+
+// Digest is the SHA-256 digest of the content of a blob.
+type Digest [sha256.Size]byte
+
+// digestPrefix is the prefix of the string form of a Digest.
+const digestPrefix = "sha256:"
+
+// String returns the digest as "sha256:" followed by its hex encoding.
+func (d Digest) String() string {
+	return digestPrefix + hex.EncodeToString(d[:])
+}
+
+// ParseDigest parses a digest in the form returned by Digest.String.
+func ParseDigest(s string) (Digest, error) {
+	var d Digest
+	b, err := hex.DecodeString(strings.TrimPrefix(s, digestPrefix))
+	if err != nil || !strings.HasPrefix(s, digestPrefix) || len(b) != len(d) {
+		return d, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blobcas: invalid digest %q", s)
+	}
+	copy(d[:], b)
+	return d, nil
+}
+
+// Options sets options for NewStore.
+type Options struct {
+	// Prefix is prepended to the keys of all the blobs of the store. It
+	// should end with "/" to keep them apart from other blobs.
+	Prefix string
+}
+
+// Store is a content-addressed store.
+type Store struct {
+	b    *blob.Bucket
+	opts Options
+
+	beforeCollect func(Digest) // for testing
+}
+
+// NewStore returns a Store keeping its blobs in b. b is not closed by the
+// Store.
+//
+// A nil Options is treated the same as the zero value.
+func NewStore(b *blob.Bucket, opts *Options) *Store {
+	s := &Store{b: b}
+	if opts != nil {
+		s.opts = *opts
+	}
+	return s
+}
+
+func (s *Store) objectKey(d Digest) string {
+	return s.opts.Prefix + "sha256/" + hex.EncodeToString(d[:])
+}
+
+func (s *Store) refsPrefix(d Digest) string {
+	return s.opts.Prefix + "refs/" + hex.EncodeToString(d[:]) + "/"
+}
+
+func (s *Store) gcKey(d Digest) string {
+	return s.gcPrefix() + hex.EncodeToString(d[:])
+}
+
+func (s *Store) gcPrefix() string {
+	return s.opts.Prefix + "gc/"
+}
+
+func (s *Store) tempPrefix() string {
+	return s.opts.Prefix + "tmp/"
+}
+
+// PutOptions sets options for Put.
+type PutOptions struct {
+	// ContentType is the MIME type of the content. If empty, it is detected
+	// from the content; see blob.WriterOptions.ContentType.
+	ContentType string
+
+	// Digest, if non-nil, is the expected digest of the content. If a blob
+	// with that digest is already stored, Put returns without reading the
+	// content. Otherwise, the digest of what is written must match it, or Put
+	// returns an error without storing anything.
+	Digest *Digest
+}
+
+// Put stores the content read from r until EOF, and returns its digest. If a
+// blob with the same digest is already stored, it is kept, and the content
+// isn't stored again.
+//
+// If r is an io.Seeker and opts.Digest is nil, r is read twice: once to
+// compute the digest, which makes it possible to skip the upload if the
+// content is already stored, and once to upload it.
+//
+// A nil PutOptions is treated the same as the zero value.
+func (s *Store) Put(ctx context.Context, r io.Reader, opts *PutOptions) (Digest, error) {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	want := opts.Digest
+	if seeker, ok := r.(io.Seeker); ok && want == nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return Digest{}, err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return Digest{}, err
+		}
+		want = new(Digest)
+		copy(want[:], h.Sum(nil))
+	}
+	if want != nil {
+		if ok, err := s.Exists(ctx, *want); err != nil || ok {
+			return *want, err
+		}
+	}
+
+	// Upload to a temporary blob, since the digest is only known at the end.
+	tmp, err := s.tempKey()
+	if err != nil {
+		return Digest{}, err
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.b.NewWriter(wctx, tmp, &blob.WriterOptions{ContentType: opts.ContentType})
+	if err != nil {
+		return Digest{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		cancel()
+		_ = w.Close()
+		return Digest{}, err
+	}
+	var d Digest
+	copy(d[:], h.Sum(nil))
+	if want != nil && d != *want {
+		// Cancel the context before closing to abort the write.
+		cancel()
+		_ = w.Close()
+		return Digest{}, gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blobcas: the expected digest (%s) did not match what was written (%s)", *want, d)
+	}
+	if err := w.Close(); err != nil {
+		return Digest{}, err
+	}
+	defer func() { _ = s.b.Delete(ctx, tmp) }()
+
+	// Don't replace a blob stored concurrently; it has the same content.
+	err = s.b.Copy(ctx, s.objectKey(d), tmp, &blob.CopyOptions{IfNoneMatch: "*"})
+	if err != nil && gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		return Digest{}, err
+	}
+	return d, nil
+}
+
+// tempKey returns a new key for a temporary blob.
+func (s *Store) tempKey() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return s.tempPrefix() + hex.EncodeToString(id), nil
+}
+
+// Exists reports whether a blob with digest d is stored.
+func (s *Store) Exists(ctx context.Context, d Digest) (bool, error) {
+	return s.b.Exists(ctx, s.objectKey(d))
+}
+
+// Attributes returns the attributes of the blob with digest d.
+func (s *Store) Attributes(ctx context.Context, d Digest) (*blob.Attributes, error) {
+	return s.b.Attributes(ctx, s.objectKey(d))
+}
+
+// NewReader returns a Reader for the blob with digest d.
+func (s *Store) NewReader(ctx context.Context, d Digest) (*Reader, error) {
+	r, err := s.b.NewReader(ctx, s.objectKey(d), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, h: sha256.New(), d: d}, nil
+}
+
+// ReadAll reads the blob with digest d, and verifies its digest.
+func (s *Store) ReadAll(ctx context.Context, d Digest) ([]byte, error) {
+	r, err := s.NewReader(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Reader reads a blob from a Store.
+type Reader struct {
+	r *blob.Reader
+	h hash.Hash
+	d Digest
+}
+
+// Read implements io.Reader. Once the whole blob is read, Read returns an
+// error for which gdkerr.Code returns gdkerr.Internal instead of io.EOF if
+// its digest doesn't match.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.h.Sum(nil), r.d[:]) {
+		return n, gdkerr.Newf(gdkerr.Internal, nil, "blobcas: the content of %s doesn't match its digest", r.d)
+	}
+	return n, err
+}
+
+// Size returns the size of the blob in bytes.
+func (r *Reader) Size() int64 { return r.r.Size() }
+
+// ContentType returns the MIME type of the blob.
+func (r *Reader) ContentType() string { return r.r.ContentType() }
+
+// Close implements io.Closer.
+func (r *Reader) Close() error { return r.r.Close() }
+
+// AddRef adds a reference named ref to the blob with digest d, so that GC
+// keeps it. Adding a reference that exists already does nothing.
+//
+// If the blob isn't stored, or GC is deleting it, AddRef returns an error for
+// which gdkerr.Code returns gdkerr.NotFound; the content must be put again.
+func (s *Store) AddRef(ctx context.Context, d Digest, ref string) error {
+	if ref == "" {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blobcas: empty reference")
+	}
+	key := s.refsPrefix(d) + ref
+	if err := s.b.WriteAll(ctx, key, nil, &blob.WriterOptions{ContentType: "application/octet-stream"}); err != nil {
+		return err
+	}
+	// Check after adding the reference. GC checks for references after
+	// adding its marker, so either it sees the reference and keeps the blob,
+	// or the marker or the deletion is seen here.
+	collecting, err := s.b.Exists(ctx, s.gcKey(d))
+	if err == nil && collecting {
+		err = gdkerr.Newf(gdkerr.NotFound, nil, "blobcas: %s is being garbage collected", d)
+	}
+	if err == nil {
+		_, err = s.b.Attributes(ctx, s.objectKey(d))
+	}
+	if err != nil {
+		_ = s.b.Delete(ctx, key)
+		return err
+	}
+	return nil
+}
+
+// RemoveRef removes the reference named ref to the blob with digest d. If
+// there is no such reference, it returns an error for which gdkerr.Code
+// returns gdkerr.NotFound.
+func (s *Store) RemoveRef(ctx context.Context, d Digest, ref string) error {
+	if ref == "" {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blobcas: empty reference")
+	}
+	return s.b.Delete(ctx, s.refsPrefix(d)+ref)
+}
+
+// Refs returns the names of the references to the blob with digest d.
+func (s *Store) Refs(ctx context.Context, d Digest) ([]string, error) {
+	prefix := s.refsPrefix(d)
+	var refs []string
+	iter := s.b.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, strings.TrimPrefix(obj.Key, prefix))
+	}
+}
+
+// GC deletes the blobs that have no references and were stored more than
+// minAge ago, and the temporary blobs of uploads abandoned more than minAge
+// ago. It returns the digests of the blobs deleted.
+//
+// minAge protects blobs that were just put from being deleted before a
+// reference is added to them. A blob that Put found already stored keeps
+// its age, so GC may delete it before AddRef is called; AddRef then returns
+// an error for which gdkerr.Code returns gdkerr.NotFound.
+//
+// GC may run concurrently with AddRef: a blob is never deleted once AddRef
+// returned successfully for it.
+func (s *Store) GC(ctx context.Context, minAge time.Duration) ([]Digest, error) {
+	before := time.Now().Add(-minAge)
+	if err := s.deleteOlder(ctx, s.tempPrefix(), before); err != nil {
+		return nil, err
+	}
+	// Older markers were left by a GC that failed; until they are deleted,
+	// AddRef fails for their blobs.
+	if err := s.deleteOlder(ctx, s.gcPrefix(), time.Now().Add(-staleMarkerAge)); err != nil {
+		return nil, err
+	}
+
+	var deleted []Digest
+	prefix := s.opts.Prefix + "sha256/"
+	iter := s.b.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, err
+		}
+		d, err := ParseDigest(digestPrefix + strings.TrimPrefix(obj.Key, prefix))
+		if err != nil || !obj.ModTime.Before(before) {
+			continue
+		}
+		// Get the ETag before checking for references, so that the blob
+		// isn't deleted if it is replaced after that.
+		eTag := obj.ETag
+		if eTag == "" {
+			attrs, err := s.b.Attributes(ctx, obj.Key)
+			if gdkerr.Code(err) == gdkerr.NotFound {
+				continue
+			}
+			if err != nil {
+				return deleted, err
+			}
+			eTag = attrs.ETag
+		}
+		referenced, err := s.hasRefs(ctx, d)
+		if err != nil {
+			return deleted, err
+		}
+		if referenced {
+			continue
+		}
+		if s.beforeCollect != nil {
+			s.beforeCollect(d)
+		}
+		ok, err := s.collect(ctx, d, eTag)
+		if err != nil {
+			return deleted, err
+		}
+		if ok {
+			deleted = append(deleted, d)
+		}
+	}
+}
+
+// hasRefs reports whether the blob with digest d has references.
+func (s *Store) hasRefs(ctx context.Context, d Digest) (bool, error) {
+	refs, _, err := s.b.ListPage(ctx, blob.FirstPageToken, 1, &blob.ListOptions{Prefix: s.refsPrefix(d)})
+	return len(refs) > 0, err
+}
+
+// collect deletes the blob with digest d and the given ETag, unless a
+// reference to it was added since it was found to have none. It reports
+// whether the blob was deleted.
+//
+// The marker written first makes a concurrent AddRef fail; see AddRef.
+func (s *Store) collect(ctx context.Context, d Digest, eTag string) (bool, error) {
+	marker := s.gcKey(d)
+	if err := s.b.WriteAll(ctx, marker, nil, &blob.WriterOptions{ContentType: "application/octet-stream"}); err != nil {
+		return false, err
+	}
+	defer func() { _ = s.b.Delete(ctx, marker) }()
+	if ok, err := s.hasRefs(ctx, d); err != nil || ok {
+		return false, err
+	}
+	var opts *blob.DeleteOptions
+	if eTag != "" {
+		opts = &blob.DeleteOptions{IfMatch: eTag}
+	}
+	err := s.b.DeleteWithOptions(ctx, s.objectKey(d), opts)
+	switch gdkerr.Code(err) {
+	case gdkerr.OK:
+		return true, nil
+	case gdkerr.NotFound, gdkerr.FailedPrecondition:
+		// Deleted or replaced concurrently.
+		return false, nil
+	}
+	return false, err
+}
+
+// deleteOlder deletes the blobs under prefix last modified before the given
+// time.
+func (s *Store) deleteOlder(ctx context.Context, prefix string, before time.Time) error {
+	iter := s.b.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if obj.ModTime.Before(before) {
+			if err := s.b.Delete(ctx, obj.Key); err != nil && gdkerr.Code(err) != gdkerr.NotFound {
+				return err
+			}
+		}
+	}
+}
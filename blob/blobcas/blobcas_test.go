@@ -0,0 +1,200 @@
+package blobcas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// keys returns the keys of the blobs in b.
+func keys(ctx context.Context, t *testing.T, b *blob.Bucket) []string {
+	t.Helper()
+	var keys []string
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	d := Digest(sha256.Sum256([]byte("hello")))
+	got, err := ParseDigest(d.String())
+	if err != nil || got != d {
+		t.Errorf("ParseDigest(%q): got %v, %v want %v", d.String(), got, err, d)
+	}
+	for _, s := range []string{"", "sha256:", "sha256:zz", strings.TrimPrefix(d.String(), "sha256:"), d.String()[:20]} {
+		if _, err := ParseDigest(s); gdkerr.Code(err) != gdkerr.InvalidArgument {
+			t.Errorf("ParseDigest(%q): got %v want InvalidArgument", s, err)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	s := NewStore(b, &Options{Prefix: "cas/"})
+
+	content := []byte("hello, world")
+	want := Digest(sha256.Sum256(content))
+	// The reader is not an io.Seeker, so the content is uploaded.
+	d, err := s.Put(ctx, io.MultiReader(bytes.NewReader(content)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != want {
+		t.Errorf("Put: got digest %v want %v", d, want)
+	}
+	if got, err := s.ReadAll(ctx, d); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("ReadAll: got %q, %v want %q", got, err, content)
+	}
+	// Identical content is stored once, and temporary blobs are deleted.
+	if _, err := s.Put(ctx, io.MultiReader(bytes.NewReader(content)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"cas/sha256/" + strings.TrimPrefix(d.String(), "sha256:")}, keys(ctx, t, b)); diff != "" {
+		t.Errorf("blobs after Put (-want +got):\n%s", diff)
+	}
+
+	// With a known digest, the content is not read if it is stored.
+	if got, err := s.Put(ctx, iotestErrReader{}, &PutOptions{Digest: &d}); err != nil || got != d {
+		t.Errorf("Put with stored digest: got %v, %v want %v", got, err, d)
+	}
+	other := []byte("other")
+	wrong := Digest(sha256.Sum256([]byte("wrong")))
+	if _, err := s.Put(ctx, bytes.NewReader(other), &PutOptions{Digest: &wrong}); gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		t.Errorf("Put with wrong digest: got %v want FailedPrecondition", err)
+	}
+	otherDigest, err := s.Put(ctx, bytes.NewReader(other), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads verify the digest.
+	if err := b.WriteAll(ctx, "cas/sha256/"+strings.TrimPrefix(otherDigest.String(), "sha256:"), []byte("corrupted"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadAll(ctx, otherDigest); gdkerr.Code(err) != gdkerr.Internal {
+		t.Errorf("reading corrupted blob: got %v want Internal", err)
+	}
+
+	// GC deletes the blobs without references.
+	if err := s.AddRef(ctx, d, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRef(ctx, d, "b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if refs, err := s.Refs(ctx, d); err != nil || !cmp.Equal(refs, []string{"a", "b/c"}) {
+		t.Errorf("Refs: got %v, %v want [a b/c]", refs, err)
+	}
+	if deleted, err := s.GC(ctx, 0); err != nil || !cmp.Equal(deleted, []Digest{otherDigest}) {
+		t.Errorf("GC: got %v, %v want %v", deleted, err, otherDigest)
+	}
+	if err := s.AddRef(ctx, otherDigest, "a"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("AddRef to deleted blob: got %v want NotFound", err)
+	}
+	if err := s.RemoveRef(ctx, d, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveRef(ctx, d, "a"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("removing removed reference: got %v want NotFound", err)
+	}
+	if deleted, err := s.GC(ctx, 0); err != nil || len(deleted) != 0 {
+		t.Errorf("GC with a reference left: got %v, %v want none deleted", deleted, err)
+	}
+	if err := s.RemoveRef(ctx, d, "b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := s.GC(ctx, 0); err != nil || !cmp.Equal(deleted, []Digest{d}) {
+		t.Errorf("GC: got %v, %v want %v", deleted, err, d)
+	}
+	if got := keys(ctx, t, b); len(got) != 0 {
+		t.Errorf("blobs after GC: got %v want none", got)
+	}
+}
+
+// iotestErrReader fails if it is read.
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) {
+	return 0, gdkerr.Newf(gdkerr.Internal, nil, "unexpected read")
+}
+
+// TestAddRefDuringGC checks that a blob is never deleted once AddRef
+// succeeded for it, even when GC found it without references just before.
+func TestAddRefDuringGC(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	s := NewStore(b, nil)
+
+	d, err := s.Put(ctx, strings.NewReader("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addErr error
+	s.beforeCollect = func(got Digest) {
+		if got == d {
+			addErr = s.AddRef(ctx, d, "ref")
+		}
+	}
+	deleted, err := s.GC(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addErr != nil {
+		t.Fatalf("AddRef during GC: %v", addErr)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("GC: got %v deleted want none", deleted)
+	}
+	if _, err := s.ReadAll(ctx, d); err != nil {
+		t.Errorf("reading blob referenced during GC: %v", err)
+	}
+
+	// An AddRef running while GC deletes the blob fails.
+	if err := s.RemoveRef(ctx, d, "ref"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	s.beforeCollect = func(Digest) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addErr = s.AddRef(ctx, d, "ref")
+		}()
+	}
+	deleted, err = s.GC(ctx, 0)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readErr := s.ReadAll(ctx, d)
+	switch {
+	case addErr == nil && (len(deleted) != 0 || readErr != nil):
+		t.Errorf("AddRef succeeded, but GC deleted the blob: %v, %v", deleted, readErr)
+	case addErr != nil && gdkerr.Code(addErr) != gdkerr.NotFound:
+		t.Errorf("AddRef: got %v want nil or NotFound", addErr)
+	}
+	if got := keys(ctx, t, b); len(got) > 2 {
+		t.Errorf("blobs after GC: got %v want at most the blob and its reference", got)
+	}
+}
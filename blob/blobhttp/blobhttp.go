@@ -0,0 +1,291 @@
+// Package blobhttp provides an http.Handler that serves the blobs in a
+// *blob.Bucket, so that drivers without a service of their own, such as
+// fileblob and memblob, can stand in for one; for example, to test code
+// that uses signed URLs.
+//
+// GET and HEAD requests are served with http.ServeContent, which handles
+// Range, If-Match, If-None-Match, If-Modified-Since and If-Unmodified-Since
+// using the blob's ETag and ModTime. Content-Type, Cache-Control,
+// Content-Disposition, Content-Encoding and Content-Language are set from the
+// blob's Attributes. PUT and DELETE requests are only accepted if enabled in
+// Options.
+//
+// Errors are reported with the HTTP status codes matching their
+// gdkerr.ErrorCode: 404 for gdkerr.NotFound, 412 for
+// gdkerr.FailedPrecondition, and so on.
+package blobhttp
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// URLVerifier verifies signed URLs. fileblob.URLSignerHMAC implements it.
+type URLVerifier interface {
+	// KeyFromURL returns the key of the blob that surl grants access to, or
+	// an error if surl is not authentic or has expired.
+	KeyFromURL(ctx context.Context, surl *url.URL) (string, error)
+}
+
+// Options sets options for NewHandler.
+type Options struct {
+	// URLVerifier, if non-nil, makes the handler only serve signed URLs, and
+	// take the key from the URL as returned by URLVerifier.KeyFromURL;
+	// requests with URLs that don't verify are rejected with 403 Forbidden.
+	//
+	// If the URL has a "method" query parameter, as the ones signed by
+	// fileblob.URLSignerHMAC do, the request must use that method (HEAD is
+	// accepted for GET), and PUT requests must have exactly the Content-Type
+	// given by the "contentType" query parameter, or none if it is absent.
+	//
+	// If nil, the key is the path of the request URL without its leading
+	// "/"; use http.StripPrefix to serve a bucket below some path.
+	URLVerifier URLVerifier
+
+	// AllowPut makes the handler accept PUT requests, which write the request
+	// body to the blob. The Content-Type, Cache-Control, Content-Disposition,
+	// Content-Encoding, Content-Language, Content-MD5, If-Match and
+	// If-None-Match headers are passed on in the WriterOptions.
+	AllowPut bool
+
+	// AllowDelete makes the handler accept DELETE requests, which delete
+	// the blob.
+	AllowDelete bool
+}
+
+// Handler is an http.Handler serving the blobs in a bucket.
+type Handler struct {
+	b    *blob.Bucket
+	opts Options
+}
+
+// NewHandler returns a Handler serving the blobs in b.
+//
+// A nil Options is treated the same as the zero value.
+func NewHandler(b *blob.Bucket, opts *Options) *Handler {
+	h := &Handler{b: b}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := h.key(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if key == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		err = h.get(w, r, key)
+	case r.Method == http.MethodPut && h.opts.AllowPut:
+		err = h.put(w, r, key)
+	case r.Method == http.MethodDelete && h.opts.AllowDelete:
+		err = h.b.Delete(r.Context(), key)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", h.allow())
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusCode(err))
+	}
+}
+
+// key returns the key of the blob r is for, verifying its URL if needed.
+func (h *Handler) key(r *http.Request) (string, error) {
+	if h.opts.URLVerifier == nil {
+		return strings.TrimPrefix(r.URL.Path, "/"), nil
+	}
+	key, err := h.opts.URLVerifier.KeyFromURL(r.Context(), r.URL)
+	if err != nil {
+		return "", err
+	}
+	q := r.URL.Query()
+	method := q.Get("method")
+	if method == "" {
+		return key, nil
+	}
+	if method != r.Method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+		return "", errors.New("blobhttp: URL is not signed for method " + r.Method)
+	}
+	if contentType := r.Header.Get("Content-Type"); r.Method == http.MethodPut && contentType != q.Get("contentType") {
+		return "", fmt.Errorf("blobhttp: URL is not signed for Content-Type %q", contentType)
+	}
+	return key, nil
+}
+
+// allow returns the value of the Allow header.
+func (h *Handler) allow() string {
+	methods := []string{http.MethodGet, http.MethodHead}
+	if h.opts.AllowPut {
+		methods = append(methods, http.MethodPut)
+	}
+	if h.opts.AllowDelete {
+		methods = append(methods, http.MethodDelete)
+	}
+	return strings.Join(methods, ", ")
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, key string) error {
+	ctx := r.Context()
+	attrs, err := h.b.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	header := w.Header()
+	setHeader(header, "Content-Type", attrs.ContentType)
+	setHeader(header, "Cache-Control", attrs.CacheControl)
+	setHeader(header, "Content-Disposition", attrs.ContentDisposition)
+	setHeader(header, "Content-Encoding", attrs.ContentEncoding)
+	setHeader(header, "Content-Language", attrs.ContentLanguage)
+	setHeader(header, "ETag", attrs.ETag)
+
+	// Once ServeContent has started the response, errors can only cut it
+	// short.
+	content := &rangeReader{ctx: ctx, b: h.b, key: key, eTag: attrs.ETag, size: attrs.Size}
+	defer content.Close()
+	http.ServeContent(w, r, "", attrs.ModTime, content)
+	return nil
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, key string) error {
+	ctx := r.Context()
+	opts := &blob.WriterOptions{
+		CacheControl:       r.Header.Get("Cache-Control"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+		ContentType:        r.Header.Get("Content-Type"),
+		IfMatch:            r.Header.Get("If-Match"),
+		IfNoneMatch:        r.Header.Get("If-None-Match"),
+	}
+	if md5 := r.Header.Get("Content-MD5"); md5 != "" {
+		sum, err := base64.StdEncoding.DecodeString(md5)
+		if err != nil {
+			return gdkerr.Newf(gdkerr.InvalidArgument, err, "blobhttp: invalid Content-MD5 %q", md5)
+		}
+		opts.ContentMD5 = sum
+	}
+	// Cancel the write if copying the body fails, so that nothing is written.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bw, err := h.b.NewWriter(ctx, key, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, r.Body); err != nil {
+		cancel()
+		bw.Close()
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	if attrs, err := h.b.Attributes(ctx, key); err == nil {
+		setHeader(w.Header(), "ETag", attrs.ETag)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func setHeader(h http.Header, key, value string) {
+	if value != "" {
+		h.Set(key, value)
+	}
+}
+
+// statusCode returns the HTTP status code for err.
+func statusCode(err error) int {
+	switch gdkerr.Code(err) {
+	case gdkerr.NotFound:
+		return http.StatusNotFound
+	case gdkerr.AlreadyExists:
+		return http.StatusConflict
+	case gdkerr.InvalidArgument:
+		return http.StatusBadRequest
+	case gdkerr.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case gdkerr.PermissionDenied:
+		return http.StatusForbidden
+	case gdkerr.Unimplemented:
+		return http.StatusNotImplemented
+	case gdkerr.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case gdkerr.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// rangeReader is an io.ReadSeeker for http.ServeContent. The blob is only
+// opened when it is read, at the current offset, so that requests answered
+// from the headers (such as 304 Not Modified) and Range requests only read
+// what they need. Reads are pinned to eTag.
+type rangeReader struct {
+	ctx    context.Context
+	b      *blob.Bucket
+	key    string
+	eTag   string
+	size   int64
+	offset int64
+	r      *blob.Reader
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	if rr.r == nil {
+		var err error
+		rr.r, err = rr.b.NewRangeReader(rr.ctx, rr.key, rr.offset, -1, &blob.ReaderOptions{IfMatch: rr.eTag})
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := rr.r.Read(p)
+	rr.offset += int64(n)
+	return n, err
+}
+
+func (rr *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += rr.offset
+	case io.SeekEnd:
+		offset += rr.size
+	}
+	if offset < 0 {
+		return 0, errors.New("blobhttp: negative offset")
+	}
+	if offset != rr.offset {
+		rr.Close()
+		rr.offset = offset
+	}
+	return offset, nil
+}
+
+func (rr *rangeReader) Close() error {
+	if rr.r == nil {
+		return nil
+	}
+	err := rr.r.Close()
+	rr.r = nil
+	return err
+}
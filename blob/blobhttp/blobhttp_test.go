@@ -0,0 +1,163 @@
+package blobhttp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/blobhttp"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+)
+
+// do sends a request with the given headers to url, and returns the
+// response with its body.
+func do(t *testing.T, method, url, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	opts := &blob.WriterOptions{ContentType: "text/plain", CacheControl: "no-cache", ContentDisposition: "inline"}
+	if err := b.WriteAll(ctx, "dir/key", []byte("hello world"), opts); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "dir/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(blobhttp.NewHandler(b, &blobhttp.Options{AllowPut: true}))
+	defer srv.Close()
+	u := srv.URL + "/dir/key"
+
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusOK, wantBody: "hello world"},
+		{name: "head", method: http.MethodHead, wantStatus: http.StatusOK},
+		{name: "range", method: http.MethodGet, header: map[string]string{"Range": "bytes=6-"}, wantStatus: http.StatusPartialContent, wantBody: "world"},
+		{name: "if-none-match", method: http.MethodGet, header: map[string]string{"If-None-Match": attrs.ETag}, wantStatus: http.StatusNotModified},
+		{name: "if-modified-since", method: http.MethodGet, header: map[string]string{"If-Modified-Since": attrs.ModTime.Add(time.Second).UTC().Format(http.TimeFormat)}, wantStatus: http.StatusNotModified},
+		{name: "if-match", method: http.MethodGet, header: map[string]string{"If-Match": `"other"`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "delete not allowed", method: http.MethodDelete, wantStatus: http.StatusMethodNotAllowed, wantBody: "Method Not Allowed\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := do(t, test.method, u, "", test.header)
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("got status %d want %d", resp.StatusCode, test.wantStatus)
+			}
+			if body != test.wantBody {
+				t.Errorf("got body %q want %q", body, test.wantBody)
+			}
+			if test.wantStatus == http.StatusOK {
+				for k, want := range map[string]string{"Content-Type": "text/plain", "Cache-Control": "no-cache", "Content-Disposition": "inline", "ETag": attrs.ETag} {
+					if got := resp.Header.Get(k); got != want {
+						t.Errorf("got %s %q want %q", k, got, want)
+					}
+				}
+			}
+		})
+	}
+
+	if resp, _ := do(t, http.MethodGet, srv.URL+"/missing", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("get missing: got status %d want 404", resp.StatusCode)
+	}
+	resp, _ := do(t, http.MethodPut, srv.URL+"/new", "uploaded", map[string]string{"Content-Type": "text/csv"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("put: got status %d want 200", resp.StatusCode)
+	}
+	if got, err := b.ReadAll(ctx, "new"); err != nil || string(got) != "uploaded" {
+		t.Errorf("put: got %q, %v want uploaded", got, err)
+	}
+	if resp, _ := do(t, http.MethodPut, srv.URL+"/new", "again", map[string]string{"If-None-Match": "*"}); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("conditional put: got status %d want 412", resp.StatusCode)
+	}
+}
+
+func TestHandlerSignedURLs(t *testing.T) {
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	baseURL, err := url.Parse(srv.URL + "/signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := fileblob.NewURLSignerHMAC(baseURL, []byte("secret"))
+	b, err := fileblob.OpenBucket(t.TempDir(), &fileblob.Options{URLSigner: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	mux.Handle("/signed", blobhttp.NewHandler(b, &blobhttp.Options{URLVerifier: signer, AllowPut: true, AllowDelete: true}))
+
+	sign := func(method, contentType string) string {
+		t.Helper()
+		u, err := b.SignedURL(ctx, "key", &blob.SignedURLOptions{Method: method, ContentType: contentType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	putURL := sign(http.MethodPut, "text/plain")
+	if resp, _ := do(t, http.MethodPut, putURL, "hello", map[string]string{"Content-Type": "text/csv"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("put with wrong Content-Type: got status %d want 403", resp.StatusCode)
+	}
+	if resp, _ := do(t, http.MethodPut, putURL, "hello", map[string]string{"Content-Type": "text/plain"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("put: got status %d want 200", resp.StatusCode)
+	}
+
+	getURL := sign(http.MethodGet, "")
+	if resp, body := do(t, http.MethodGet, getURL, "", nil); resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Errorf("get: got status %d, body %q want 200, hello", resp.StatusCode, body)
+	}
+	if resp, _ := do(t, http.MethodDelete, getURL, "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("delete with GET URL: got status %d want 403", resp.StatusCode)
+	}
+	if resp, _ := do(t, http.MethodGet, strings.Replace(getURL, "key", "other", 1), "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("get with tampered URL: got status %d want 403", resp.StatusCode)
+	}
+	if resp, _ := do(t, http.MethodGet, srv.URL+"/signed?obj=key", "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("get unsigned: got status %d want 403", resp.StatusCode)
+	}
+
+	if resp, _ := do(t, http.MethodDelete, sign(http.MethodDelete, ""), "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete: got status %d want 204", resp.StatusCode)
+	}
+	if ok, err := b.Exists(ctx, "key"); err != nil || ok {
+		t.Errorf("after delete: got exists %v, %v want false", ok, err)
+	}
+}
@@ -0,0 +1,76 @@
+// Package blobpubsub publishes the changes to the blobs of a *blob.Bucket,
+// as returned by Bucket.Watch, to a *pubsub.Topic.
+//
+// Each blob.Event is sent as a message whose body is the event encoded as
+// JSON, and whose metadata holds the event type and the key of the blob under
+// MetadataType and MetadataKey, so that subscribers can filter the messages
+// without decoding them. EventFromMessage decodes a received message.
+package blobpubsub
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/pubsub"
+)
+
+// Metadata keys of the messages.
+const (
+	// MetadataType is the metadata key holding the blob.EventType.
+	MetadataType = "blob-event-type"
+	// MetadataKey is the metadata key holding the key of the blob.
+	MetadataKey = "blob-key"
+)
+
+// event is the JSON encoding of a blob.Event.
+type event struct {
+	Type    blob.EventType `json:"type"`
+	Key     string         `json:"key"`
+	ETag    string         `json:"etag,omitempty"`
+	ModTime time.Time      `json:"mod_time,omitempty"`
+	Size    int64          `json:"size,omitempty"`
+}
+
+// MessageFromEvent returns the message sent for e.
+func MessageFromEvent(e *blob.Event) (*pubsub.Message, error) {
+	body, err := json.Marshal(&event{Type: e.Type, Key: e.Key, ETag: e.ETag, ModTime: e.ModTime, Size: e.Size})
+	if err != nil {
+		return nil, err
+	}
+	return &pubsub.Message{
+		Body:     body,
+		Metadata: map[string]string{MetadataType: string(e.Type), MetadataKey: e.Key},
+	}, nil
+}
+
+// EventFromMessage decodes a message sent by Publish. If m wasn't, it returns
+// an error for which gdkerr.Code returns gdkerr.InvalidArgument.
+func EventFromMessage(m *pubsub.Message) (*blob.Event, error) {
+	var e event
+	if err := json.Unmarshal(m.Body, &e); err != nil || e.Type == "" || e.Key == "" {
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, err, "blobpubsub: message is not a blob event")
+	}
+	return &blob.Event{Type: e.Type, Key: e.Key, ETag: e.ETag, ModTime: e.ModTime, Size: e.Size}, nil
+}
+
+// Publish sends the events from s to topic, in order, until ctx is done or
+// receiving or sending an event fails, and returns the error that stopped it.
+// It does not close s or topic.
+func Publish(ctx context.Context, s *blob.EventStream, topic *pubsub.Topic) error {
+	for {
+		e, err := s.Next(ctx)
+		if err != nil {
+			return err
+		}
+		m, err := MessageFromEvent(e)
+		if err != nil {
+			return err
+		}
+		if err := topic.Send(ctx, m); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package blobpubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/blobpubsub"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/pubsub"
+	"github.com/sraphs/gdk/pubsub/mempubsub"
+)
+
+func TestPublish(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	topic := mempubsub.NewTopic()
+	defer topic.Shutdown(ctx)
+	sub := mempubsub.NewSubscription(topic, time.Minute)
+	defer sub.Shutdown(ctx)
+
+	s, err := b.Watch(ctx, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pctx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- blobpubsub.Publish(pctx, s, topic) }()
+
+	if err := b.WriteAll(ctx, "a", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := sub.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Ack()
+	if m.Metadata[blobpubsub.MetadataType] != string(blob.EventCreated) || m.Metadata[blobpubsub.MetadataKey] != "a" {
+		t.Errorf("got metadata %v want created a", m.Metadata)
+	}
+	e, err := blobpubsub.EventFromMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != blob.EventCreated || e.Key != "a" || e.Size != 5 || e.ETag == "" {
+		t.Errorf("got event %+v want creation of a, 5 bytes", e)
+	}
+
+	stop()
+	if err := <-done; gdkerr.Code(err) != gdkerr.Canceled {
+		t.Errorf("Publish after cancel: got %v want Canceled", err)
+	}
+	if _, err := blobpubsub.EventFromMessage(&pubsub.Message{Body: []byte("{}")}); gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("decoding other message: got %v want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,220 @@
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestCachingBucket(t *testing.T) {
+	ctx := context.Background()
+	remoteDir, cacheDir := t.TempDir(), t.TempDir()
+	open := func(dir string) *blob.Bucket {
+		t.Helper()
+		b, err := fileblob.OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// remote and cache are the same directories, without caching.
+	remote, cache := open(remoteDir), open(cacheDir)
+	defer remote.Close()
+	defer cache.Close()
+	b := blob.CachingBucket(open(remoteDir), open(cacheDir), &blob.CacheOptions{
+		MaxSize:     10,
+		TTL:         time.Hour,
+		NegativeTTL: time.Hour,
+	})
+	defer b.Close()
+
+	read := func(key, want string) {
+		t.Helper()
+		got, err := b.ReadAll(ctx, key)
+		if err != nil || string(got) != want {
+			t.Errorf("reading %s: got %q, %v want %q", key, got, err, want)
+		}
+	}
+	cached := func(key string, want bool) {
+		t.Helper()
+		if got, err := cache.Exists(ctx, key); err != nil || got != want {
+			t.Errorf("%s in cache: got %v, %v want %v", key, got, err, want)
+		}
+	}
+
+	if err := remote.WriteAll(ctx, "a", []byte("aaaa"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Concurrent misses are fine.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			read("a", "aaaa")
+		}()
+	}
+	wg.Wait()
+	cached("a", true)
+
+	// Within the TTL, the cached copy is used.
+	if err := remote.WriteAll(ctx, "a", []byte("AAAA"), nil); err != nil {
+		t.Fatal(err)
+	}
+	read("a", "aaaa")
+	// Writes go to remote and invalidate the cache.
+	if err := b.WriteAll(ctx, "a", []byte("bbbb"), nil); err != nil {
+		t.Fatal(err)
+	}
+	cached("a", false)
+	if got, err := remote.ReadAll(ctx, "a"); err != nil || string(got) != "bbbb" {
+		t.Errorf("write: got %q, %v in remote want bbbb", got, err)
+	}
+	read("a", "bbbb")
+
+	// Blobs larger than MaxSize are not cached, and the least recently used
+	// ones are evicted to make room.
+	if err := remote.WriteAll(ctx, "big", []byte("0123456789abc"), nil); err != nil {
+		t.Fatal(err)
+	}
+	read("big", "0123456789abc")
+	cached("big", false)
+	if err := remote.WriteAll(ctx, "c", []byte("cccc"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteAll(ctx, "d", []byte("dddd"), nil); err != nil {
+		t.Fatal(err)
+	}
+	read("c", "cccc")
+	read("a", "bbbb")
+	read("d", "dddd")
+	cached("a", true)
+	cached("c", false)
+	cached("d", true)
+
+	// Blobs not found are remembered within the NegativeTTL.
+	if _, err := b.ReadAll(ctx, "missing"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("reading missing blob: got %v want NotFound", err)
+	}
+	if err := remote.WriteAll(ctx, "missing", []byte("here"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Attributes(ctx, "missing"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("reading missing blob again: got %v want NotFound", err)
+	}
+	if err := b.Delete(ctx, "missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a TTL, cached blobs are checked against the remote ones, and
+	// the ones already in the cache are reused.
+	b2 := blob.CachingBucket(open(remoteDir), open(cacheDir), &blob.CacheOptions{MaxSize: 10})
+	defer b2.Close()
+	attrs, err := remote.Attributes(ctx, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b2.Attributes(ctx, "d"); err != nil || got.ETag != attrs.ETag {
+		t.Errorf("attributes: got %v, %v want ETag %q", got, err, attrs.ETag)
+	}
+	if got, err := b2.ReadAll(ctx, "d"); err != nil || string(got) != "dddd" {
+		t.Errorf("reading cached blob: got %q, %v want dddd", got, err)
+	}
+	if err := remote.WriteAll(ctx, "d", []byte("DDDD"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b2.ReadAll(ctx, "d"); err != nil || string(got) != "DDDD" {
+		t.Errorf("reading changed blob: got %q, %v want DDDD", got, err)
+	}
+}
+
+// blockingBucket is a driver.Bucket holding a single blob, whose first
+// reader blocks until release is closed, after closing started.
+type blockingBucket struct {
+	driver.Bucket
+	data    []byte
+	started chan struct{}
+	release chan struct{}
+
+	mu    sync.Mutex
+	reads int
+}
+
+func (b *blockingBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return &driver.Attributes{Size: int64(len(b.data)), ETag: "\"etag\""}, nil
+}
+
+func (b *blockingBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.mu.Lock()
+	b.reads++
+	first := b.reads == 1
+	b.mu.Unlock()
+	if first {
+		close(b.started)
+		<-b.release
+	}
+	if length < 0 {
+		length = int64(len(b.data)) - offset
+	}
+	return &blockingReader{
+		Reader: bytes.NewReader(b.data[offset : offset+length]),
+		attrs:  driver.ReaderAttributes{Size: int64(len(b.data)), ETag: "\"etag\""},
+	}, nil
+}
+
+func (b *blockingBucket) ErrorCode(error) gdkerr.ErrorCode { return gdkerr.Unknown }
+func (b *blockingBucket) Close() error                     { return nil }
+
+type blockingReader struct {
+	*bytes.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *blockingReader) Close() error                         { return nil }
+func (r *blockingReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+func (r *blockingReader) As(interface{}) bool                  { return false }
+
+func TestCachingBucketFillCanceled(t *testing.T) {
+	remote := &blockingBucket{
+		data:    []byte("abcd"),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	cache := memblob.OpenBucket(nil)
+	b := blob.CachingBucket(blob.NewBucket(remote), cache, &blob.CacheOptions{TTL: time.Hour})
+	defer b.Close()
+
+	// The read returns when its context is canceled, without waiting for the
+	// fill.
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := b.ReadAll(ctx, "a")
+		errc <- err
+	}()
+	<-remote.started
+	cancel()
+	if err := <-errc; err == nil {
+		t.Error("read with a canceled context: got nil error")
+	}
+
+	// The fill goes on, and the next reads are served from the cache.
+	close(remote.release)
+	got, err := b.ReadAll(context.Background(), "a")
+	if err != nil || string(got) != "abcd" {
+		t.Errorf("read: got %q, %v want abcd", got, err)
+	}
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	if remote.reads != 1 {
+		t.Errorf("got %d reads of the remote blob want 1", remote.reads)
+	}
+}
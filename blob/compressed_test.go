@@ -0,0 +1,133 @@
+package blob_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestCompressedBucket(t *testing.T) {
+	ctx := context.Background()
+	// Compressed content is spooled to temporary files.
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	dir := t.TempDir()
+	base, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.CompressedBucket(base, &blob.CompressionOptions{
+		Compressor: func(contentType string) blob.Compressor {
+			if strings.HasPrefix(contentType, "text/") {
+				return blob.GzipCompressor(gzip.BestCompression)
+			}
+			return nil
+		},
+	})
+	defer b.Close()
+	// raw is the same directory, uncompressed.
+	raw, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	data := bytes.Repeat([]byte("2022-01-01T00:00:00Z INFO something happened\n"), 10000)
+	opts := &blob.WriterOptions{ContentType: "text/plain", Metadata: map[string]string{"k": "v"}}
+	if err := b.WriteAll(ctx, "log", data, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "image", []byte("not compressed"), &blob.WriterOptions{ContentType: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rawAttrs, err := raw.Attributes(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawAttrs.ContentEncoding != "gzip" || rawAttrs.Size >= int64(len(data))/10 {
+		t.Errorf("stored blob: got content encoding %q, size %d", rawAttrs.ContentEncoding, rawAttrs.Size)
+	}
+	if got, err := raw.ReadAll(ctx, "image"); err != nil || string(got) != "not compressed" {
+		t.Errorf("stored image: got %q, %v want uncompressed", got, err)
+	}
+
+	attrs, err := b.Attributes(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != int64(len(data)) || attrs.ContentEncoding != "" || len(attrs.Metadata) != 1 || attrs.Metadata["k"] != "v" {
+		t.Errorf("got attributes size %d, content encoding %q, metadata %v", attrs.Size, attrs.ContentEncoding, attrs.Metadata)
+	}
+
+	for _, rng := range [][2]int64{{0, -1}, {10, 20}, {200000, 100000}, {int64(len(data)) - 5, 10}, {int64(len(data)), 1}} {
+		r, err := b.NewRangeReader(ctx, "log", rng[0], rng[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(len(data)) {
+			t.Errorf("range %v: got size %d want %d", rng, r.Size(), len(data))
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("range %v: %v", rng, err)
+		}
+		end := int64(len(data))
+		if rng[1] >= 0 && rng[0]+rng[1] < end {
+			end = rng[0] + rng[1]
+		}
+		if !bytes.Equal(got, data[rng[0]:end]) {
+			t.Errorf("range %v: got %d bytes that don't match", rng, len(got))
+		}
+	}
+
+	// Blobs written uncompressed are read as they are.
+	if err := raw.WriteAll(ctx, "plain", []byte("hello world"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, "plain"); err != nil || string(got) != "hello world" {
+		t.Errorf("reading uncompressed blob: got %q, %v want hello world", got, err)
+	}
+
+	// Content that doesn't match its checksum is detected.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("tampered"))
+	zw.Close()
+	wopts := &blob.WriterOptions{ContentEncoding: "gzip", Metadata: rawAttrs.Metadata}
+	if err := raw.WriteAll(ctx, "log", buf.Bytes(), wopts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "log"); gdkerr.Code(err) != gdkerr.Internal {
+		t.Errorf("reading tampered blob: got %v want Internal", err)
+	}
+
+	// Canceled writes leave nothing behind either.
+	wctx, cancel := context.WithCancel(ctx)
+	w, err := b.NewWriter(wctx, "canceled", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := w.Close(); err == nil {
+		t.Error("got nil error closing a canceled writer")
+	}
+	if exists, _ := raw.Exists(ctx, "canceled"); exists {
+		t.Error("canceled write was stored")
+	}
+	if files, err := os.ReadDir(tmp); err != nil || len(files) != 0 {
+		t.Errorf("got temporary files %v, %v want none", files, err)
+	}
+}
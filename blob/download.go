@@ -0,0 +1,159 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/internal/retry"
+)
+
+const (
+	// DefaultDownloadPartSize is the default for DownloadOptions.PartSize.
+	DefaultDownloadPartSize = 8 * 1024 * 1024
+	// DefaultDownloadConcurrency is the default for
+	// DownloadOptions.Concurrency.
+	DefaultDownloadConcurrency = 5
+	// DefaultDownloadMaxRetries is the default for DownloadOptions.MaxRetries.
+	DefaultDownloadMaxRetries = 3
+)
+
+// downloadBackoff controls the pause between retries of a part; each part
+// uses its own copy. It is a variable for testing.
+var downloadBackoff = gax.Backoff{}
+
+// DownloadOptions sets options for Download.
+type DownloadOptions struct {
+	// PartSize is the size of the ranges the blob is split into, in bytes.
+	// Defaults to DefaultDownloadPartSize.
+	PartSize int64
+
+	// Concurrency is the maximum number of parts downloaded at the same time.
+	// Defaults to DefaultDownloadConcurrency.
+	Concurrency int
+
+	// MaxRetries is the maximum number of times the download of a part is
+	// retried after a failure. Retries resume from the last byte written.
+	// Defaults to DefaultDownloadMaxRetries; set it to a negative value to
+	// disable retries.
+	MaxRetries int
+
+	// BeforeRead is passed through to the ReaderOptions of the reader opened
+	// for each part; see ReaderOptions.BeforeRead.
+	BeforeRead func(asFunc func(interface{}) bool) error
+}
+
+// Download reads the blob stored at key and writes it to w, starting at
+// offset 0. The blob is split into parts, which are read concurrently with
+// NewRangeReader and written with w.WriteAt as they arrive; a part that fails
+// with a retryable error is retried from where it left off, without
+// affecting the others.
+//
+// Parts are only read from the version of the blob that existed when
+// Download started; if the blob is overwritten while Download is running,
+// it returns an error for which gdkerr.Code returns gdkerr.FailedPrecondition.
+//
+// If Download returns an error, the contents of w are undefined.
+//
+// A nil DownloadOptions is treated the same as the zero value.
+func (b *Bucket) Download(ctx context.Context, key string, w io.WriterAt, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = DefaultDownloadPartSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultDownloadConcurrency
+	}
+	maxRetries := opts.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultDownloadMaxRetries
+	}
+
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	ropts := &ReaderOptions{
+		IfMatch:    attrs.ETag,
+		BeforeRead: opts.BeforeRead,
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for offset := int64(0); offset < attrs.Size; offset += partSize {
+		offset, length := offset, partSize
+		if offset+length > attrs.Size {
+			length = attrs.Size - offset
+		}
+		g.Go(func() error {
+			return b.downloadPart(ctx, key, w, offset, length, maxRetries, ropts)
+		})
+	}
+	return g.Wait()
+}
+
+// downloadPart copies length bytes of the blob stored at key, starting at
+// offset, to the same offset in w, retrying up to maxRetries times.
+func (b *Bucket) downloadPart(ctx context.Context, key string, w io.WriterAt, offset, length int64, maxRetries int, opts *ReaderOptions) error {
+	ow := &offsetWriter{w: w, offset: offset}
+	retries := 0
+	isRetryable := func(err error) bool {
+		// Errors from w are not retried.
+		if ow.err != nil || retries >= maxRetries || !isRetryableReadError(ctx, err) {
+			return false
+		}
+		retries++
+		return true
+	}
+	return retry.Call(ctx, downloadBackoff, isRetryable, func() error {
+		done := ow.offset - offset
+		r, err := b.NewRangeReader(ctx, key, offset+done, length-done, opts)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		n, err := io.Copy(ow, r)
+		if err == nil && done+n != length {
+			err = gdkerr.Newf(gdkerr.Internal, io.ErrUnexpectedEOF, "blob: Download read %d bytes of part at offset %d, want %d", done+n, offset, length)
+		}
+		return err
+	})
+}
+
+// isRetryableReadError reports whether a read that failed with err might
+// succeed if it were retried.
+func isRetryableReadError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch gdkerr.Code(err) {
+	case gdkerr.NotFound, gdkerr.InvalidArgument, gdkerr.Unimplemented, gdkerr.FailedPrecondition,
+		gdkerr.PermissionDenied, gdkerr.Canceled:
+		return false
+	}
+	return true
+}
+
+// offsetWriter is an io.Writer that writes to an io.WriterAt, starting at
+// offset. It records the first error returned by the io.WriterAt.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+	err    error
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.offset)
+	ow.offset += int64(n)
+	if err != nil && ow.err == nil {
+		ow.err = err
+	}
+	return n, err
+}
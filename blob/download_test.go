@@ -0,0 +1,156 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// flakyBucket is a driver.Bucket holding a single blob, whose readers fail
+// after reading half of their range until failures runs out. Once a reader
+// has failed, the next openFailures attempts to open one fail too.
+type flakyBucket struct {
+	driver.Bucket
+	data []byte
+	eTag string // defaults to "\"etag\""
+
+	mu           sync.Mutex
+	failures     int
+	openFailures int
+	failed       bool
+	reads        int
+}
+
+func (b *flakyBucket) currentETag() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.eTag == "" {
+		return "\"etag\""
+	}
+	return b.eTag
+}
+
+func (b *flakyBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return &driver.Attributes{Size: int64(len(b.data)), ETag: b.currentETag()}, nil
+}
+
+func (b *flakyBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.IfMatch != "" && opts.IfMatch != b.currentETag() {
+		return nil, errFake
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+	if b.failed && b.openFailures > 0 {
+		b.openFailures--
+		return nil, errors.New("unavailable")
+	}
+	if length < 0 {
+		length = int64(len(b.data)) - offset
+	}
+	data := b.data[offset : offset+length]
+	r := &flakyReader{r: bytes.NewReader(data)}
+	if b.failures > 0 {
+		b.failures--
+		b.failed = true
+		r.r = io.LimitReader(r.r, length/2)
+		r.fail = true
+	}
+	return r, nil
+}
+
+func (b *flakyBucket) Close() error {
+	return nil
+}
+
+func (b *flakyBucket) ErrorCode(err error) gdkerr.ErrorCode {
+	if err == errFake {
+		return gdkerr.FailedPrecondition
+	}
+	return gdkerr.Unknown
+}
+
+type flakyReader struct {
+	driver.Reader
+	r    io.Reader
+	fail bool
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF && r.fail {
+		err = errors.New("connection reset")
+	}
+	return n, err
+}
+
+func (r *flakyReader) Close() error {
+	return nil
+}
+
+func (r *flakyReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{}
+}
+
+// writerAt is an io.WriterAt backed by a byte slice.
+type writerAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return copy(w.buf[off:], p), nil
+}
+
+func TestDownload(t *testing.T) {
+	defer func(bo time.Duration) { downloadBackoff.Initial = bo }(downloadBackoff.Initial)
+	downloadBackoff.Initial = time.Millisecond
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		failures  int
+		opts      *DownloadOptions
+		wantReads int
+		wantErr   bool
+	}{
+		{name: "single part", opts: nil, wantReads: 1},
+		{name: "parts", opts: &DownloadOptions{PartSize: 300, Concurrency: 2}, wantReads: 4},
+		{name: "retries", failures: 3, opts: &DownloadOptions{PartSize: 300}, wantReads: 7},
+		{name: "too many failures", failures: 2, opts: &DownloadOptions{PartSize: 1000, MaxRetries: 1}, wantReads: 2, wantErr: true},
+		{name: "retries disabled", failures: 1, opts: &DownloadOptions{MaxRetries: -1}, wantReads: 1, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			drv := &flakyBucket{data: data, failures: test.failures}
+			b := NewBucket(drv)
+			defer b.Close()
+
+			w := &writerAt{buf: make([]byte, len(data))}
+			err := b.Download(ctx, "key", w, test.opts)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got err %v want error %v", err, test.wantErr)
+			}
+			if drv.reads != test.wantReads {
+				t.Errorf("got %d reads want %d", drv.reads, test.wantReads)
+			}
+			if err == nil && !bytes.Equal(w.buf, data) {
+				t.Error("downloaded data doesn't match")
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package driver
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// CacheOptions sets options for NewCachingBucket.
+type CacheOptions struct {
+	// MaxSize is the maximum total size in bytes of the cached blobs. When it
+	// is exceeded, the least recently used blobs are evicted from the cache.
+	// Blobs larger than MaxSize are never cached. Zero means no limit.
+	MaxSize int64
+	// TTL is how long a cached blob is used without checking that its ETag
+	// still matches the one of the remote blob. Zero means it is checked
+	// each time the blob is read.
+	TTL time.Duration
+	// NegativeTTL is how long a blob that was not found is remembered as
+	// such. Zero means it is not.
+	NegativeTTL time.Duration
+	// FillTimeout is how long copying a blob to the cache may take. Zero
+	// means defaultFillTimeout.
+	FillTimeout time.Duration
+}
+
+const (
+	// defaultFillTimeout is the default CacheOptions.FillTimeout.
+	defaultFillTimeout = 10 * time.Minute
+	// maxMissing is the maximum number of keys remembered as not found.
+	maxMissing = 10000
+)
+
+// cacheETagMetadata is the metadata key holding the ETag of the remote blob a
+// cached blob is a copy of.
+const cacheETagMetadata = "gdk-cache-etag"
+
+// cacheEntry is a blob held in the cache.
+type cacheEntry struct {
+	key       string
+	size      int64
+	eTag      string        // The ETag of the remote blob, or "" if not known yet.
+	cacheETag string        // The ETag of the cached copy.
+	attrs     *Attributes   // The attributes of the remote blob, if known.
+	validated time.Time     // When eTag was last checked against the remote blob.
+	elem      *list.Element // In cachingBucket.lru, if in the entries.
+}
+
+// cachingBucket implements Bucket by serving reads from blobs copied from
+// remote into cache, and passing everything else to remote.
+type cachingBucket struct {
+	remote Bucket
+	cache  Bucket
+	opts   CacheOptions
+	fills  singleflight.Group
+
+	mu      sync.Mutex
+	loaded  bool                   // Whether entries holds the blobs in cache.
+	entries map[string]*cacheEntry // By key.
+	lru     *list.List             // Of *cacheEntry, most recently used first.
+	size    int64                  // The total size of entries.
+	missing map[string]time.Time   // Keys not found, until when.
+	// gen is incremented each time a key is invalidated, so that fills
+	// overlapping with a write can tell their copy might be stale.
+	gen uint64
+}
+
+// NewCachingBucket returns a Bucket reading the blobs of b through cache:
+// blobs are copied to cache the first time they are read, and then read from
+// there as long as their ETag matches the one of the blob in b. Writes and
+// deletes go to b, and evict the blob from cache.
+//
+// Blobs already in cache are reused, if they were written by a caching
+// bucket. Reads with ReaderOptions.BeforeRead, IfNoneMatch or VersionID set,
+// and all other operations, are passed to b.
+func NewCachingBucket(b, cache Bucket, opts *CacheOptions) Bucket {
+	cb := &cachingBucket{
+		remote:  b,
+		cache:   cache,
+		entries: map[string]*cacheEntry{},
+		lru:     list.New(),
+		missing: map[string]time.Time{},
+	}
+	if opts != nil {
+		cb.opts = *opts
+	}
+	return cb
+}
+
+func (b *cachingBucket) ErrorCode(err error) gdkerr.ErrorCode  { return b.remote.ErrorCode(err) }
+func (b *cachingBucket) As(i interface{}) bool                 { return b.remote.As(i) }
+func (b *cachingBucket) ErrorAs(err error, i interface{}) bool { return b.remote.ErrorAs(err, i) }
+
+func (b *cachingBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	if err := b.load(ctx); err != nil {
+		return nil, err
+	}
+	if b.isMissing(key) {
+		return nil, errCachedNotFound
+	}
+	if e := b.lookup(key); e != nil && e.attrs != nil {
+		attrs := *e.attrs
+		return &attrs, nil
+	}
+	attrs, err := b.remote.Attributes(ctx, key)
+	if err != nil {
+		b.setMissing(key, err)
+		return nil, err
+	}
+	b.validate(key, attrs)
+	return attrs, nil
+}
+
+func (b *cachingBucket) ListPaged(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	return b.remote.ListPaged(ctx, opts)
+}
+
+func (b *cachingBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	if opts.BeforeRead != nil || opts.IfNoneMatch != "" || opts.VersionID != "" {
+		return b.remote.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	if err := b.load(ctx); err != nil {
+		return nil, err
+	}
+	if b.isMissing(key) {
+		return nil, errCachedNotFound
+	}
+	e := b.lookup(key)
+	if e == nil {
+		attrs, err := b.remote.Attributes(ctx, key)
+		if err != nil {
+			b.setMissing(key, err)
+			return nil, err
+		}
+		if e = b.validate(key, attrs); e == nil {
+			// If the blob can't be cached, read it from remote.
+			if e, _ = b.fill(ctx, key, attrs); e == nil {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return b.remote.NewRangeReader(ctx, key, offset, length, opts)
+			}
+		}
+	}
+	if opts.IfMatch != "" && opts.IfMatch != "*" && opts.IfMatch != e.eTag {
+		return b.remote.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	r, err := b.cache.NewRangeReader(ctx, key, offset, length, &ReaderOptions{IfMatch: e.cacheETag})
+	if err != nil {
+		// The cached copy is gone or was replaced.
+		b.drop(e)
+		return b.remote.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	attrs := *r.Attributes()
+	attrs.ETag = e.eTag
+	if e.attrs != nil {
+		attrs.ContentType = e.attrs.ContentType
+		attrs.ModTime = e.attrs.ModTime
+	}
+	return &cachedReader{Reader: r, cache: b.cache, attrs: attrs}, nil
+}
+
+func (b *cachingBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
+	w, err := b.remote.NewTypedWriter(ctx, key, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{Writer: w, ctx: ctx, b: b, key: key}, nil
+}
+
+func (b *cachingBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
+	defer b.invalidate(ctx, dstKey)
+	return b.remote.Copy(ctx, dstKey, srcKey, opts)
+}
+
+func (b *cachingBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	defer b.invalidate(ctx, key)
+	return b.remote.Delete(ctx, key, opts)
+}
+
+func (b *cachingBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
+	return b.remote.SignedURL(ctx, key, opts)
+}
+
+func (b *cachingBucket) Close() error {
+	err := b.remote.Close()
+	if cerr := b.cache.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+var errCachedNotFound = gdkerr.Newf(gdkerr.NotFound, nil, "blob: blob not found (cached)")
+
+// load adds the blobs already in cache to entries, if it wasn't done yet.
+func (b *cachingBucket) load(ctx context.Context) error {
+	b.mu.Lock()
+	loaded := b.loaded
+	b.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	var objs []*ListObject
+	opts := &ListOptions{}
+	for {
+		page, err := b.cache.ListPaged(ctx, opts)
+		if err != nil {
+			return gdkerr.Newf(b.cache.ErrorCode(err), err, "blob: listing the cache")
+		}
+		objs = append(objs, page.Objects...)
+		if len(page.NextPageToken) == 0 {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	// Consider the least recently modified blobs the least recently used.
+	sort.Slice(objs, func(i, j int) bool { return objs[i].ModTime.Before(objs[j].ModTime) })
+
+	b.mu.Lock()
+	if b.loaded {
+		b.mu.Unlock()
+		return nil
+	}
+	for _, obj := range objs {
+		if obj.IsDir || b.entries[obj.Key] != nil {
+			continue
+		}
+		e := &cacheEntry{key: obj.Key, size: obj.Size}
+		e.elem = b.lru.PushFront(e)
+		b.entries[e.key] = e
+		b.size += e.size
+	}
+	victims := b.evictLocked(nil)
+	b.loaded = true
+	b.mu.Unlock()
+	b.deleteCached(ctx, victims)
+	return nil
+}
+
+// lookup returns a copy of the entry for key, if it was validated within the
+// TTL, and marks it as used.
+func (b *cachingBucket) lookup(key string) *cacheEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[key]
+	if e == nil || e.eTag == "" || time.Since(e.validated) >= b.opts.TTL {
+		return nil
+	}
+	b.lru.MoveToFront(e.elem)
+	c := *e
+	return &c
+}
+
+// validate checks the entry for key against attrs, the attributes of the
+// remote blob. If they match, it returns a copy of the entry, and marks it as
+// validated and used. If the remote blob has changed, the entry is removed.
+func (b *cachingBucket) validate(key string, attrs *Attributes) *cacheEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[key]
+	if e == nil || e.eTag == "" {
+		return nil
+	}
+	if e.eTag != attrs.ETag {
+		b.removeLocked(e)
+		return nil
+	}
+	e.attrs = attrs
+	e.validated = time.Now()
+	b.lru.MoveToFront(e.elem)
+	c := *e
+	return &c
+}
+
+// fill makes sure the cache holds a copy of the remote blob for key with the
+// given attributes, and returns its entry. It returns nil if the blob can't
+// be cached. Concurrent fills of the same blob are done once, and are not
+// canceled along with ctx, since other callers may be waiting for them.
+func (b *cachingBucket) fill(ctx context.Context, key string, attrs *Attributes) (*cacheEntry, error) {
+	if attrs.ETag == "" || (b.opts.MaxSize > 0 && attrs.Size > b.opts.MaxSize) {
+		return nil, nil
+	}
+	ch := b.fills.DoChan(key+"\x00"+attrs.ETag, func() (interface{}, error) {
+		timeout := b.opts.FillTimeout
+		if timeout <= 0 {
+			timeout = defaultFillTimeout
+		}
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, timeout)
+		defer cancel()
+		return b.doFill(ctx, key, attrs)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*cacheEntry), nil
+	}
+}
+
+func (b *cachingBucket) doFill(ctx context.Context, key string, attrs *Attributes) (*cacheEntry, error) {
+	b.mu.Lock()
+	gen := b.gen
+	b.mu.Unlock()
+
+	// The blob in cache may already be a copy of the remote blob, for
+	// example if it was filled before the bucket was opened.
+	cattrs, err := b.cache.Attributes(ctx, key)
+	if err != nil || cattrs.Metadata[cacheETagMetadata] != attrs.ETag {
+		if err := b.copyToCache(ctx, key, attrs); err != nil {
+			return nil, err
+		}
+		if cattrs, err = b.cache.Attributes(ctx, key); err != nil {
+			return nil, gdkerr.Newf(b.cache.ErrorCode(err), err, "blob: filling the cache")
+		}
+	}
+	e := &cacheEntry{
+		key:       key,
+		size:      cattrs.Size,
+		eTag:      attrs.ETag,
+		cacheETag: cattrs.ETag,
+		attrs:     attrs,
+		validated: time.Now(),
+	}
+	b.mu.Lock()
+	if b.gen != gen {
+		// The blob was written to while it was copied, so the copy may be
+		// stale; use it this once, but don't keep it.
+		b.mu.Unlock()
+		return e, nil
+	}
+	if old := b.entries[key]; old != nil {
+		b.removeLocked(old)
+	}
+	c := *e
+	e.elem = b.lru.PushFront(e)
+	b.entries[key] = e
+	b.size += e.size
+	victims := b.evictLocked(e)
+	b.mu.Unlock()
+	b.deleteCached(ctx, victims)
+	return &c, nil
+}
+
+// detachedContext is a context.Context with the values of its parent, which
+// is never canceled and has no deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// copyToCache copies the remote blob for key with the given attributes to
+// the cache.
+func (b *cachingBucket) copyToCache(ctx context.Context, key string, attrs *Attributes) error {
+	r, err := b.remote.NewRangeReader(ctx, key, 0, -1, &ReaderOptions{IfMatch: attrs.ETag})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Cancel the write if the copy fails, so that nothing is written.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.cache.NewTypedWriter(ctx, key, attrs.ContentType, &WriterOptions{
+		Metadata: map[string]string{cacheETagMetadata: attrs.ETag},
+	})
+	if err != nil {
+		return gdkerr.Newf(b.cache.ErrorCode(err), err, "blob: filling the cache")
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return gdkerr.Newf(b.cache.ErrorCode(err), err, "blob: filling the cache")
+	}
+	return nil
+}
+
+// evictLocked removes the least recently used entries other than keep until
+// the total size is within MaxSize, and returns them. b.mu must be held.
+func (b *cachingBucket) evictLocked(keep *cacheEntry) []*cacheEntry {
+	var victims []*cacheEntry
+	for elem := b.lru.Back(); elem != nil && b.opts.MaxSize > 0 && b.size > b.opts.MaxSize; {
+		e := elem.Value.(*cacheEntry)
+		elem = elem.Prev()
+		if e == keep {
+			continue
+		}
+		b.removeLocked(e)
+		victims = append(victims, e)
+	}
+	return victims
+}
+
+// removeLocked removes e from the entries. b.mu must be held.
+func (b *cachingBucket) removeLocked(e *cacheEntry) {
+	b.lru.Remove(e.elem)
+	delete(b.entries, e.key)
+	b.size -= e.size
+}
+
+// deleteCached deletes the copies of entries from the cache, unless they
+// were replaced.
+func (b *cachingBucket) deleteCached(ctx context.Context, entries []*cacheEntry) {
+	for _, e := range entries {
+		_ = b.cache.Delete(ctx, e.key, &DeleteOptions{IfMatch: e.cacheETag})
+	}
+}
+
+// drop removes the entry e is a copy of, if it is still there.
+func (b *cachingBucket) drop(e *cacheEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cur := b.entries[e.key]; cur != nil && cur.cacheETag == e.cacheETag {
+		b.removeLocked(cur)
+	}
+}
+
+// invalidate removes everything cached about key, after it was written to or
+// deleted.
+func (b *cachingBucket) invalidate(ctx context.Context, key string) {
+	b.mu.Lock()
+	b.gen++
+	delete(b.missing, key)
+	e := b.entries[key]
+	if e != nil {
+		b.removeLocked(e)
+	}
+	b.mu.Unlock()
+	if e != nil {
+		b.deleteCached(ctx, []*cacheEntry{e})
+	}
+}
+
+// isMissing reports whether key was not found within the NegativeTTL.
+func (b *cachingBucket) isMissing(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.missing[key]
+	if ok && time.Now().After(until) {
+		delete(b.missing, key)
+		return false
+	}
+	return ok
+}
+
+// setMissing remembers that key was not found, if err says so. At most
+// maxMissing keys are remembered: when there are more, the expired ones are
+// forgotten, and then arbitrary ones.
+func (b *cachingBucket) setMissing(key string, err error) {
+	if b.opts.NegativeTTL <= 0 || b.remote.ErrorCode(err) != gdkerr.NotFound {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if _, ok := b.missing[key]; !ok && len(b.missing) >= maxMissing {
+		for k, until := range b.missing {
+			if now.After(until) {
+				delete(b.missing, k)
+			}
+		}
+		for k := range b.missing {
+			if len(b.missing) < maxMissing {
+				break
+			}
+			delete(b.missing, k)
+		}
+	}
+	b.missing[key] = now.Add(b.opts.NegativeTTL)
+}
+
+// cachedReader reads a cached blob, with the attributes of the remote one.
+type cachedReader struct {
+	Reader
+	cache Bucket
+	attrs ReaderAttributes
+}
+
+func (r *cachedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = gdkerr.Newf(r.cache.ErrorCode(err), err, "blob: reading from the cache")
+	}
+	return n, err
+}
+
+func (r *cachedReader) Attributes() *ReaderAttributes { return &r.attrs }
+
+// invalidatingWriter is a Writer that invalidates its key in the cache when
+// closed.
+type invalidatingWriter struct {
+	Writer
+	ctx context.Context
+	b   *cachingBucket
+	key string
+}
+
+func (w *invalidatingWriter) Close() error {
+	defer w.b.invalidate(w.ctx, w.key)
+	return w.Writer.Close()
+}
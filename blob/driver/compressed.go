@@ -0,0 +1,379 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// Compressor compresses and decompresses the blobs of a compressed bucket.
+type Compressor interface {
+	// ContentEncoding returns the content encoding of the blobs it
+	// compresses, such as "gzip".
+	ContentEncoding() string
+	// NewWriter returns a WriteCloser compressing what is written to it into
+	// w. Close must flush everything to w, but not close it.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a ReadCloser decompressing what is read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+const (
+	// Metadata keys holding the size of a compressed blob's original content,
+	// and its MD5 hash, base64-encoded.
+	uncompressedSizeMetadata = "gdk-uncompressed-size"
+	uncompressedMD5Metadata  = "gdk-uncompressed-md5"
+)
+
+var errCompressedBlobCorrupted = gdkerr.Newf(gdkerr.Internal, nil, "blob: compressed blob is corrupted")
+
+// compressedBucket implements Bucket by compressing blobs before writing them
+// to base, and decompressing them when reading.
+//
+// Compressed blobs have their ContentEncoding set to the one of their
+// Compressor, and the size and MD5 hash of their original content in their
+// metadata, which is hidden from the callers along with ContentEncoding.
+// Blobs without that metadata are read as they are.
+type compressedBucket struct {
+	base Bucket
+	// choose returns the Compressor for a content type, or nil.
+	choose func(contentType string) Compressor
+	// decompressors maps content encodings to the Compressors that can
+	// decompress them.
+	decompressors map[string]Compressor
+}
+
+// NewCompressedBucket returns a Bucket based on b that compresses the blobs
+// written to it with the Compressor returned by choose for their content
+// type, if any, and decompresses the ones it reads with the Compressor in
+// decompressors matching their content encoding.
+//
+// The compressed contents of a blob are spooled to a temporary file until its
+// Writer is closed, so that the size and MD5 hash of the original content can
+// be stored with it. Range reads decompress the blob from its start. Sizes reported by
+// ListPaged are the ones of the stored blobs, and MD5 hashes and checksums are
+// not reported.
+func NewCompressedBucket(b Bucket, choose func(contentType string) Compressor, decompressors []Compressor) Bucket {
+	cb := &compressedBucket{base: b, choose: choose, decompressors: map[string]Compressor{}}
+	for _, c := range decompressors {
+		cb.decompressors[c.ContentEncoding()] = c
+	}
+	return cb
+}
+
+func (b *compressedBucket) ErrorCode(err error) gdkerr.ErrorCode  { return b.base.ErrorCode(err) }
+func (b *compressedBucket) As(i interface{}) bool                 { return b.base.As(i) }
+func (b *compressedBucket) ErrorAs(err error, i interface{}) bool { return b.base.ErrorAs(err, i) }
+
+func (b *compressedBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	attrs, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := attrs.Metadata[uncompressedSizeMetadata]; !ok {
+		return attrs, nil
+	}
+	size, sum, err := compressionMetadata(attrs.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	plain := *attrs
+	plain.Size = size
+	plain.MD5 = sum
+	plain.Checksums = nil
+	plain.ContentEncoding = ""
+	plain.Metadata = make(map[string]string, len(attrs.Metadata))
+	for k, v := range attrs.Metadata {
+		if k != uncompressedSizeMetadata && k != uncompressedMD5Metadata {
+			plain.Metadata[k] = v
+		}
+	}
+	return &plain, nil
+}
+
+func (b *compressedBucket) ListPaged(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	page, err := b.base.ListPaged(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	// The hashes of compressed blobs are not the ones of their content.
+	for _, obj := range page.Objects {
+		obj.MD5 = nil
+		obj.Checksums = nil
+	}
+	return page, nil
+}
+
+func (b *compressedBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	attrs, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := attrs.Metadata[uncompressedSizeMetadata]; !ok {
+		return b.base.NewRangeReader(ctx, key, offset, length, opts)
+	}
+	size, sum, err := compressionMetadata(attrs.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	c := b.decompressors[attrs.ContentEncoding]
+	if c == nil {
+		return nil, gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: no decompressor for content encoding %q", attrs.ContentEncoding)
+	}
+
+	// Read the whole blob, from the version of it the metadata came from,
+	// unless there is nothing to read.
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	if offset > end {
+		offset = end
+	}
+	clength := int64(-1)
+	if end == offset {
+		// Nothing to decompress, but still check the preconditions.
+		clength = 0
+	}
+	bopts := *opts
+	if bopts.IfMatch == "" || bopts.IfMatch == "*" {
+		bopts.IfMatch = attrs.ETag
+	}
+	r, err := b.base.NewRangeReader(ctx, key, 0, clength, &bopts)
+	if err != nil {
+		return nil, err
+	}
+	battrs := r.Attributes()
+	cr := &compressedReader{
+		base:      r,
+		skip:      offset,
+		remaining: end - offset,
+		attrs: ReaderAttributes{
+			ContentType: battrs.ContentType,
+			ModTime:     battrs.ModTime,
+			Size:        size,
+			ETag:        battrs.ETag,
+		},
+	}
+	if clength == 0 {
+		return cr, nil
+	}
+	if cr.zr, err = c.NewReader(readerFunc(cr.readBase)); err != nil {
+		r.Close()
+		return nil, cr.corrupted()
+	}
+	if offset == 0 && end == size && len(sum) > 0 {
+		// Reading everything, so the content can be verified.
+		cr.sum, cr.md5hash = sum, md5.New()
+	}
+	return cr, nil
+}
+
+func (b *compressedBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
+	var c Compressor
+	// Content that is already encoded is written as it is.
+	if opts.ContentEncoding == "" && b.choose != nil {
+		c = b.choose(contentType)
+	}
+	if c == nil {
+		return b.base.NewTypedWriter(ctx, key, contentType, opts)
+	}
+	w := &compressedWriter{
+		ctx:         ctx,
+		b:           b.base,
+		key:         key,
+		contentType: contentType,
+		opts:        *opts,
+		md5hash:     md5.New(),
+	}
+	// ContentMD5 and Checksums are the hashes of the original content, which
+	// the portable type verifies.
+	w.opts.ContentMD5 = nil
+	w.opts.Checksums = nil
+	w.opts.ContentEncoding = c.ContentEncoding()
+	var err error
+	if w.spool, err = os.CreateTemp("", "gdk-compressed-"); err != nil {
+		return nil, err
+	}
+	if w.zw, err = c.NewWriter(w.spool); err != nil {
+		w.removeSpool()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (b *compressedBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
+	// The metadata is copied along with the blob, so the copy stays readable.
+	return b.base.Copy(ctx, dstKey, srcKey, opts)
+}
+
+func (b *compressedBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	return b.base.Delete(ctx, key, opts)
+}
+
+func (b *compressedBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
+	// HTTP clients decompress the blobs served with their ContentEncoding.
+	return b.base.SignedURL(ctx, key, opts)
+}
+
+func (b *compressedBucket) Close() error { return b.base.Close() }
+
+// compressionMetadata returns the size and MD5 hash of the original content
+// of a compressed blob, stored in metadata.
+func compressionMetadata(metadata map[string]string) (int64, []byte, error) {
+	size, err := strconv.ParseInt(metadata[uncompressedSizeMetadata], 10, 64)
+	if err != nil || size < 0 {
+		return 0, nil, errCompressedBlobCorrupted
+	}
+	sum, err := base64.StdEncoding.DecodeString(metadata[uncompressedMD5Metadata])
+	if err != nil {
+		return 0, nil, errCompressedBlobCorrupted
+	}
+	return size, sum, nil
+}
+
+// compressedWriter compresses what is written to it into the temporary file
+// spool, and copies it to the base bucket when closed, with the size and MD5
+// hash of what was written in its metadata.
+type compressedWriter struct {
+	ctx         context.Context
+	b           Bucket
+	key         string
+	contentType string
+	opts        WriterOptions
+	zw          io.WriteCloser
+	spool       *os.File
+	size        int64
+	md5hash     hash.Hash
+}
+
+func (w *compressedWriter) Write(p []byte) (int, error) {
+	n, err := w.zw.Write(p)
+	w.md5hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *compressedWriter) Close() error {
+	defer w.removeSpool()
+	if err := w.zw.Close(); err != nil {
+		return err
+	}
+	// The portable type cancels the context to abort the write.
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(w.opts.Metadata)+2)
+	for k, v := range w.opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[uncompressedSizeMetadata] = strconv.FormatInt(w.size, 10)
+	metadata[uncompressedMD5Metadata] = base64.StdEncoding.EncodeToString(w.md5hash.Sum(nil))
+	w.opts.Metadata = metadata
+
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// Cancel the write to the base bucket if copying to it fails.
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	bw, err := w.b.NewTypedWriter(ctx, w.key, w.contentType, &w.opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, w.spool); err != nil {
+		cancel() // cancel before Close cancels the write
+		bw.Close()
+		return err
+	}
+	return bw.Close()
+}
+
+// removeSpool closes and deletes the temporary file.
+func (w *compressedWriter) removeSpool() {
+	w.spool.Close()
+	os.Remove(w.spool.Name())
+}
+
+// compressedReader decompresses what is read from base, returning remaining
+// bytes after skipping skip bytes. If md5hash is set, it checks that the
+// content read has the MD5 hash sum.
+type compressedReader struct {
+	base      Reader
+	zr        io.ReadCloser
+	skip      int64
+	remaining int64
+	sum       []byte
+	md5hash   hash.Hash
+	baseErr   error
+	attrs     ReaderAttributes
+}
+
+func (r *compressedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if r.skip > 0 {
+		n, err := io.CopyN(io.Discard, r.zr, r.skip)
+		r.skip -= n
+		if err != nil {
+			return 0, r.corrupted()
+		}
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.zr.Read(p)
+	r.remaining -= int64(n)
+	if r.md5hash != nil {
+		r.md5hash.Write(p[:n])
+	}
+	if (err == io.EOF && r.remaining > 0) || (err != nil && err != io.EOF) {
+		return n, r.corrupted()
+	}
+	if r.remaining == 0 && r.md5hash != nil && !bytes.Equal(r.md5hash.Sum(nil), r.sum) {
+		return n, errCompressedBlobCorrupted
+	}
+	return n, nil
+}
+
+// readBase reads from base for the decompressor, remembering the errors
+// other than io.EOF.
+func (r *compressedReader) readBase(p []byte) (int, error) {
+	n, err := r.base.Read(p)
+	if err != nil && err != io.EOF {
+		r.baseErr = err
+	}
+	return n, err
+}
+
+// corrupted returns the error to report when the decompressor fails: errors
+// reading the blob are passed on, the others mean the blob is corrupted.
+func (r *compressedReader) corrupted() error {
+	if r.baseErr != nil {
+		return r.baseErr
+	}
+	return errCompressedBlobCorrupted
+}
+
+func (r *compressedReader) Close() error {
+	if r.zr != nil {
+		r.zr.Close()
+	}
+	return r.base.Close()
+}
+
+func (r *compressedReader) Attributes() *ReaderAttributes { return &r.attrs }
+func (r *compressedReader) As(i interface{}) bool         { return r.base.As(i) }
+
+// readerFunc is an io.Reader calling itself.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
@@ -5,7 +5,12 @@ package driver
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
 	"io"
 	"strings"
 	"time"
@@ -21,6 +26,29 @@ type ReaderOptions struct {
 	// asFunc allows drivers to expose driver-specific types;
 	// see Bucket.As for more details.
 	BeforeRead func(asFunc func(interface{}) bool) error
+
+	// IfMatch, if non-empty, makes the read conditional on the blob's ETag
+	// matching it; "*" matches any existing blob. If the precondition fails,
+	// NewRangeReader must return an error for which ErrorCode returns
+	// gdkerr.FailedPrecondition.
+	IfMatch string
+	// IfNoneMatch, if non-empty, makes the read conditional on the blob's ETag
+	// not matching it; "*" matches any existing blob. If the precondition
+	// fails, NewRangeReader must return an error for which ErrorCode returns
+	// gdkerr.FailedPrecondition.
+	IfNoneMatch string
+
+	// VersionID, if non-empty, selects a specific version of the blob to read
+	// instead of the latest one. If there is no such version, NewRangeReader
+	// must return an error for which ErrorCode returns gdkerr.NotFound.
+	// It is only set for drivers that implement Versioner.
+	VersionID string
+
+	// ServerSideEncryption, if non-nil, holds the key the blob was encrypted
+	// with. It is only set for EncryptionCustomerKey, and drivers that don't
+	// support it must return an error for which ErrorCode returns
+	// gdkerr.Unimplemented.
+	ServerSideEncryption *ServerSideEncryption
 }
 
 // Reader reads an object from the blob.
@@ -70,15 +98,50 @@ type WriterOptions struct {
 	// underlying network service to guarantee the integrity of the bytes in
 	// transit.
 	ContentMD5 []byte
+	// Checksums holds checksums of the blob contents, by algorithm. The
+	// portable type checks that the checksums of the bytes written match
+	// them, and that the algorithms are supported by NewChecksumHash.
+	// Driver implementations may pass them to their underlying network
+	// service to guarantee the integrity of the bytes in transit, and should
+	// store them if they can, to report them in Attributes.Checksums.
+	Checksums map[ChecksumAlgorithm][]byte
 	// Metadata holds key/value strings to be associated with the blob.
 	// Keys are guaranteed to be non-empty and lowercased.
 	Metadata map[string]string
+	// Tags holds key/value tags to be associated with the blob, if any. Keys
+	// are guaranteed to be non-empty. Unlike Metadata, tags can be changed
+	// after the blob is written; see Tagger. Drivers that can't store tags
+	// must return an error for which ErrorCode returns gdkerr.Unimplemented
+	// from NewTypedWriter if it is non-empty.
+	Tags map[string]string
+	// StorageClass is the service-specific storage class of the blob, like
+	// "STANDARD_IA" for S3 or "IA" for OSS, or empty for the bucket's default.
+	// Drivers that don't have storage classes must return an error for which
+	// ErrorCode returns gdkerr.Unimplemented from NewTypedWriter if it is
+	// non-empty.
+	StorageClass string
+	// ServerSideEncryption, if non-nil, sets how the service encrypts the
+	// blob at rest. Drivers that don't support its Mode must return an error
+	// for which ErrorCode returns gdkerr.Unimplemented from NewTypedWriter.
+	ServerSideEncryption *ServerSideEncryption
 	// BeforeWrite is a callback that must be called exactly once before
 	// any data is written, unless NewTypedWriter returns an error, in
 	// which case it should not be called.
 	// asFunc allows drivers to expose driver-specific types;
 	// see Bucket.As for more details.
 	BeforeWrite func(asFunc func(interface{}) bool) error
+
+	// IfMatch, if non-empty, makes the write conditional on an existing blob
+	// having an ETag that matches it; "*" matches any existing blob. If the
+	// precondition fails, the write must not happen, and Close must return an
+	// error for which ErrorCode returns gdkerr.FailedPrecondition.
+	IfMatch string
+	// IfNoneMatch, if non-empty, makes the write conditional on there being no
+	// existing blob with an ETag that matches it; "*" matches any existing
+	// blob, so "*" means "only if the blob does not exist". If the
+	// precondition fails, the write must not happen, and Close must return an
+	// error for which ErrorCode returns gdkerr.FailedPrecondition.
+	IfNoneMatch string
 }
 
 // CopyOptions controls options for Copy.
@@ -87,6 +150,126 @@ type CopyOptions struct {
 	// asFunc allows drivers to expose driver-specific types;
 	// see Bucket.As for more details.
 	BeforeCopy func(asFunc func(interface{}) bool) error
+
+	// IfMatch and IfNoneMatch are preconditions on the destination blob, with
+	// the same semantics as in WriterOptions. If a precondition fails, Copy
+	// must return an error for which ErrorCode returns
+	// gdkerr.FailedPrecondition.
+	IfMatch     string
+	IfNoneMatch string
+
+	// ServerSideEncryption, if non-nil, sets how the service encrypts the
+	// destination blob, as in WriterOptions.
+	ServerSideEncryption *ServerSideEncryption
+	// SourceServerSideEncryption, if non-nil, holds the key the source blob
+	// was encrypted with, as in ReaderOptions.
+	SourceServerSideEncryption *ServerSideEncryption
+}
+
+// DeleteOptions controls options for Delete.
+type DeleteOptions struct {
+	// IfMatch, if non-empty, makes the delete conditional on the blob's ETag
+	// matching it; "*" matches any existing blob. If the precondition fails,
+	// Delete must return an error for which ErrorCode returns
+	// gdkerr.FailedPrecondition.
+	IfMatch string
+
+	// VersionID, if non-empty, permanently deletes that version of the blob
+	// rather than the latest one. If it is the latest version, the next most
+	// recent version (if any) becomes the latest. If there is no such
+	// version, Delete must return an error for which ErrorCode returns
+	// gdkerr.NotFound. IfMatch applies to the selected version.
+	// It is only set for drivers that implement Versioner.
+	VersionID string
+}
+
+// ChecksumAlgorithm names an algorithm for the checksums in
+// WriterOptions.Checksums and Attributes.Checksums. Checksums are the bytes
+// returned by the Sum method of the hash.Hash returned by NewChecksumHash;
+// for CRCs, that is the big-endian encoding of the CRC.
+type ChecksumAlgorithm string
+
+// Supported checksum algorithms.
+const (
+	// ChecksumCRC32 is the CRC-32 with the IEEE polynomial.
+	ChecksumCRC32 ChecksumAlgorithm = "CRC32"
+	// ChecksumCRC32C is the CRC-32 with the Castagnoli polynomial.
+	ChecksumCRC32C ChecksumAlgorithm = "CRC32C"
+	// ChecksumCRC64 is the CRC-64 with the ECMA polynomial, as used by
+	// Alibaba Cloud OSS.
+	ChecksumCRC64 ChecksumAlgorithm = "CRC64"
+	// ChecksumSHA1 is SHA-1.
+	ChecksumSHA1 ChecksumAlgorithm = "SHA1"
+	// ChecksumSHA256 is SHA-256.
+	ChecksumSHA256 ChecksumAlgorithm = "SHA256"
+)
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+	crc64Table  = crc64.MakeTable(crc64.ECMA)
+)
+
+// NewChecksumHash returns a hash.Hash computing checksums with alg, or false
+// if alg is not supported.
+func NewChecksumHash(alg ChecksumAlgorithm) (hash.Hash, bool) {
+	switch alg {
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), true
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), true
+	case ChecksumCRC64:
+		return crc64.New(crc64Table), true
+	case ChecksumSHA1:
+		return sha1.New(), true
+	case ChecksumSHA256:
+		return sha256.New(), true
+	}
+	return nil, false
+}
+
+// MatchesPreconditions reports whether a blob satisfies the ifMatch and
+// ifNoneMatch preconditions described in WriterOptions. exists reports
+// whether the blob exists; eTag is its ETag, and is ignored if exists is
+// false. Drivers for services without native conditional requests can use
+// it to evaluate preconditions themselves.
+func MatchesPreconditions(exists bool, eTag, ifMatch, ifNoneMatch string) bool {
+	if ifMatch != "" && (!exists || (ifMatch != "*" && ifMatch != eTag)) {
+		return false
+	}
+	if ifNoneMatch != "" && exists && (ifNoneMatch == "*" || ifNoneMatch == eTag) {
+		return false
+	}
+	return true
+}
+
+// EncryptionMode is how a service encrypts blobs at rest; see
+// ServerSideEncryption.
+type EncryptionMode string
+
+// Encryption modes.
+const (
+	// EncryptionServiceKey encrypts blobs with keys managed by the service,
+	// like SSE-S3 or OSS-managed keys.
+	EncryptionServiceKey EncryptionMode = "service-key"
+	// EncryptionKMS encrypts blobs with a key of the key management service
+	// of the provider, like SSE-KMS.
+	EncryptionKMS EncryptionMode = "kms"
+	// EncryptionCustomerKey encrypts blobs with a key provided with every
+	// request, which the service doesn't store, like SSE-C.
+	EncryptionCustomerKey EncryptionMode = "customer-key"
+)
+
+// ServerSideEncryption describes how a service encrypts a blob at rest.
+type ServerSideEncryption struct {
+	// Mode is how the blob is encrypted. It is not empty.
+	Mode EncryptionMode
+	// KeyID identifies the key of EncryptionKMS, or is empty for the default
+	// key of the service. In Attributes, for EncryptionCustomerKey, it is the
+	// base64-encoded MD5 hash of CustomerKey, if available.
+	KeyID string
+	// CustomerKey is the 256-bit AES key of EncryptionCustomerKey. It is
+	// never set in Attributes.
+	CustomerKey []byte
 }
 
 // ReaderAttributes contains a subset of attributes about a blob that are
@@ -99,6 +282,8 @@ type ReaderAttributes struct {
 	ModTime time.Time
 	// Size is the size of the object in bytes.
 	Size int64
+	// ETag for the blob object, if available; see Attributes.ETag.
+	ETag string
 }
 
 // Attributes contains attributes about a blob.
@@ -126,6 +311,14 @@ type Attributes struct {
 	// "foo" and "FOO"), only one value will be kept, and it is undefined
 	// which one.
 	Metadata map[string]string
+	// Tags holds the tags of the blob, if any; see WriterOptions.Tags.
+	Tags map[string]string
+	// StorageClass is the storage class of the blob, if available; see
+	// WriterOptions.StorageClass.
+	StorageClass string
+	// ServerSideEncryption describes how the blob is encrypted at rest, or is
+	// nil if it isn't or that isn't known.
+	ServerSideEncryption *ServerSideEncryption
 	// CreateTime is the time the blob object was created. If not available,
 	// leave as the zero time.
 	CreateTime time.Time
@@ -135,8 +328,14 @@ type Attributes struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// Checksums holds the checksums of the blob contents that are available,
+	// by algorithm; see WriterOptions.Checksums. It may be nil.
+	Checksums map[ChecksumAlgorithm][]byte
 	// ETag for the blob; see https://en.wikipedia.org/wiki/HTTP_ETag.
 	ETag string
+	// VersionID identifies the latest version of the blob, for drivers that
+	// implement Versioner and have versioning enabled. Otherwise it is empty.
+	VersionID string
 	// AsFunc allows drivers to expose driver-specific types;
 	// see Bucket.As for more details.
 	// If not set, no driver-specific types are supported.
@@ -184,11 +383,22 @@ type ListObject struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// Checksums holds the checksums of the blob contents that are available,
+	// by algorithm; see Attributes.Checksums. It may be nil.
+	Checksums map[ChecksumAlgorithm][]byte
+	// ETag for the blob, if available; see Attributes.ETag.
+	ETag string
 	// IsDir indicates that this result represents a "directory" in the
 	// hierarchical namespace, ending in ListOptions.Delimiter. Key can be
 	// passed as ListOptions.Prefix to list items in the "directory".
 	// Fields other than Key and IsDir will not be set if IsDir is true.
 	IsDir bool
+	// VersionID identifies the version of the blob this result describes; see
+	// Attributes.VersionID.
+	VersionID string
+	// IsLatest is set by Versioner.ListVersions for the latest version of
+	// each blob.
+	IsLatest bool
 	// AsFunc allows drivers to expose driver-specific types;
 	// see Bucket.As for more details.
 	// If not set, no driver-specific types are supported.
@@ -280,7 +490,8 @@ type Bucket interface {
 	// Delete deletes the object associated with key. If the specified object does
 	// not exist, Delete must return an error for which ErrorCode returns
 	// gdkerr.NotFound.
-	Delete(ctx context.Context, key string) error
+	// opts is guaranteed to be non-nil.
+	Delete(ctx context.Context, key string, opts *DeleteOptions) error
 
 	// SignedURL returns a URL that can be used to GET the blob for the duration
 	// specified in opts.Expiry. opts is guaranteed to be non-nil.
@@ -295,6 +506,241 @@ type Bucket interface {
 	Close() error
 }
 
+// Versioner is an optional interface that a Bucket implements if the service
+// keeps previous versions of blobs when they are overwritten or deleted.
+// Drivers that implement it must honor ReaderOptions.VersionID and
+// DeleteOptions.VersionID, and should fill in Attributes.VersionID.
+// Wrappers may implement it regardless of their base; use SupportsVersioning
+// to check whether a Bucket supports versioning.
+type Versioner interface {
+	// ListVersions is like ListPaged, but returns every stored version of
+	// each matching blob rather than only the latest one, with VersionID set.
+	// Results are ordered by key, and then from newest to oldest version,
+	// with IsLatest set on the first. opts.Delimiter is always empty.
+	//
+	// If versioning is not enabled for the bucket, ListVersions returns the
+	// latest (and only) version of each blob.
+	// opts is guaranteed to be non-nil.
+	ListVersions(ctx context.Context, opts *ListOptions) (*ListPage, error)
+}
+
+// SupportsVersioning reports whether b supports versioning. Buckets returned
+// by NewPrefixedBucket always implement Versioner, so for them it reports
+// whether their base does.
+func SupportsVersioning(b Bucket) bool {
+	if pb, ok := b.(*prefixedBucket); ok {
+		return SupportsVersioning(pb.base)
+	}
+	_, ok := b.(Versioner)
+	return ok
+}
+
+// BulkDeleter is an optional interface that a Bucket implements if the
+// service can delete many objects in a single request. The portable type
+// falls back to calling Delete concurrently for drivers that don't.
+type BulkDeleter interface {
+	// DeleteMany deletes the objects associated with keys, which are distinct.
+	// Implementations should split keys into as many requests as the service
+	// requires.
+	//
+	// It returns one error per key, in the same order as keys; nil means the
+	// object was deleted. Errors for keys that didn't exist may be nil or an
+	// error for which ErrorCode returns gdkerr.NotFound.
+	// If the operation fails as a whole, DeleteMany returns a non-nil error
+	// instead. If that error's ErrorCode is gdkerr.Unimplemented, the portable
+	// type falls back to calling Delete for each key.
+	DeleteMany(ctx context.Context, keys []string) ([]error, error)
+}
+
+// ResumableWriter is a Writer for a resumable upload session.
+type ResumableWriter interface {
+	Writer
+
+	// SessionToken returns the token identifying the upload session; passing
+	// it to ResumableUploader.NewResumableWriter resumes the session.
+	SessionToken() string
+
+	// Committed returns the number of bytes that have been durably stored in
+	// the session, and that won't have to be written again if it is resumed.
+	// Bytes that have been written but not yet committed are lost if the
+	// session is resumed.
+	Committed() int64
+}
+
+// UploadSession describes a resumable upload session that has not been
+// completed or aborted.
+type UploadSession struct {
+	// Key is the key of the blob being uploaded.
+	Key string
+	// Token identifies the session; see ResumableWriter.SessionToken.
+	Token string
+	// Started is the time the session was started, or zero if unknown.
+	Started time.Time
+}
+
+// ResumableUploader is an optional interface that a Bucket implements if it
+// supports upload sessions that can be resumed after the writer is lost, for
+// example because the process died.
+type ResumableUploader interface {
+	// NewResumableWriter starts a resumable upload session for key if token is
+	// empty, or resumes the session identified by token otherwise.
+	//
+	// When starting a session, contentType and opts are the same as for
+	// NewTypedWriter. When resuming one, the attributes given when it was
+	// started are used, and contentType and the attributes in opts are
+	// ignored; the preconditions in opts apply. Writes continue after the
+	// bytes reported by Committed.
+	//
+	// Closing the returned writer completes the upload. If the ctx is canceled
+	// before Close, the session must be kept so that it can be resumed.
+	//
+	// If token doesn't identify a session for key, NewResumableWriter returns
+	// an error for which ErrorCode returns gdkerr.NotFound.
+	NewResumableWriter(ctx context.Context, key, contentType, token string, opts *WriterOptions) (ResumableWriter, error)
+
+	// ListUploadSessions returns the sessions that haven't been completed or
+	// aborted, for keys that start with prefix.
+	ListUploadSessions(ctx context.Context, prefix string) ([]*UploadSession, error)
+
+	// AbortUploadSession aborts an upload session, discarding the bytes that
+	// were committed. If token doesn't identify a session for key, it returns
+	// an error for which ErrorCode returns gdkerr.NotFound.
+	AbortUploadSession(ctx context.Context, key, token string) error
+}
+
+// ReplicaStatus is the state of a blob in one replica of a Replicator.
+type ReplicaStatus struct {
+	// Exists reports whether the replica has the blob.
+	Exists bool
+	// ETag is the ETag of the blob in the replica, if it exists.
+	ETag string
+	// Pending reports whether a repair of the blob in the replica is queued.
+	Pending bool
+	// Err is the error getting the state of the replica, or the last error
+	// repairing it, if any.
+	Err error
+}
+
+// Replicator is an optional interface that a Bucket implements if it keeps
+// blobs in several replicas.
+type Replicator interface {
+	// ReplicationStatus returns the state of the blob for key in each
+	// replica, in order. A blob that doesn't exist is not an error.
+	ReplicationStatus(ctx context.Context, key string) ([]*ReplicaStatus, error)
+}
+
+// SoftDeleter is an optional interface that a Bucket implements if deleted
+// blobs are kept, so that they can be restored.
+type SoftDeleter interface {
+	// Restore restores the blob for key that was deleted last. If no deleted
+	// blob is kept for key, it returns an error for which ErrorCode returns
+	// gdkerr.NotFound; if the blob exists, it returns an error for which
+	// ErrorCode returns gdkerr.FailedPrecondition.
+	Restore(ctx context.Context, key string) error
+
+	// Purge permanently deletes the blobs that were deleted before the given
+	// time.
+	Purge(ctx context.Context, before time.Time) error
+}
+
+// Tagger is an optional interface that a Bucket implements if the tags of a
+// blob can be changed after it is written; see WriterOptions.Tags.
+type Tagger interface {
+	// SetTags replaces the tags of the blob for key with tags, removing them
+	// all if tags is empty. Keys in tags are guaranteed to be non-empty. If
+	// the blob doesn't exist, it returns an error for which ErrorCode returns
+	// gdkerr.NotFound.
+	SetTags(ctx context.Context, key string, tags map[string]string) error
+}
+
+// ComposeOptions controls options for Compose.
+type ComposeOptions struct {
+	// ContentType is the MIME type of the composed blob. It is guaranteed to
+	// be non-empty.
+	ContentType string
+	// Metadata holds key/value strings to be associated with the composed
+	// blob. Keys are guaranteed to be non-empty and lowercased.
+	Metadata map[string]string
+
+	// IfMatch and IfNoneMatch are preconditions on the destination blob, with
+	// the same semantics as in WriterOptions. If a precondition fails,
+	// Compose must return an error for which ErrorCode returns
+	// gdkerr.FailedPrecondition.
+	IfMatch     string
+	IfNoneMatch string
+
+	// ServerSideEncryption, if non-nil, sets how the service encrypts the
+	// composed blob, as in WriterOptions.
+	ServerSideEncryption *ServerSideEncryption
+	// SourceServerSideEncryption, if non-nil, holds the key the source blobs
+	// were encrypted with, as in ReaderOptions.
+	SourceServerSideEncryption *ServerSideEncryption
+}
+
+// Composer is an optional interface that a Bucket implements if it can
+// concatenate blobs without them being read by the portable type, which
+// otherwise streams them to a Writer.
+type Composer interface {
+	// Compose writes the concatenation of the blobs for srcKeys, in order, as
+	// the blob for dstKey. srcKeys is guaranteed to be non-empty, and may
+	// include dstKey. If a source blob doesn't exist, Compose must return an
+	// error for which ErrorCode returns gdkerr.NotFound.
+	//
+	// If the service can't compose these blobs, for example because some are
+	// too small, Compose must return an error for which ErrorCode returns
+	// gdkerr.Unimplemented before writing anything; the portable type then
+	// streams them instead.
+	Compose(ctx context.Context, dstKey string, srcKeys []string, opts *ComposeOptions) error
+}
+
+// EventType is the kind of change to a blob reported by an Event.
+type EventType string
+
+// Event types.
+const (
+	// EventCreated reports that a blob was written where there was none.
+	EventCreated EventType = "created"
+	// EventUpdated reports that a blob was overwritten.
+	EventUpdated EventType = "updated"
+	// EventDeleted reports that a blob was deleted.
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a change to a blob.
+type Event struct {
+	// Type is the kind of change.
+	Type EventType
+	// Key is the key of the blob.
+	Key string
+	// ETag, ModTime and Size describe the blob after the change, if known.
+	// They are zero for EventDeleted.
+	ETag    string
+	ModTime time.Time
+	Size    int64
+}
+
+// EventStream is a stream of Events; see Watcher.
+type EventStream interface {
+	// Next returns the next Event, waiting until there is one or ctx is
+	// done. After Close is called, Next returns an error.
+	Next(ctx context.Context) (*Event, error)
+
+	// Close stops the stream, releasing its resources.
+	Close() error
+}
+
+// Watcher is an optional interface that a Bucket implements if it can
+// report changes to blobs as they happen. The portable type polls ListPaged
+// for buckets that don't implement it, or whose Watch returns an error for
+// which ErrorCode returns gdkerr.Unimplemented.
+type Watcher interface {
+	// Watch returns a stream of the changes to the blobs with keys starting
+	// with prefix, made after Watch returns. Changes in quick succession may
+	// be reported as one Event. ctx applies to Watch only, not to the
+	// returned stream.
+	Watch(ctx context.Context, prefix string) (EventStream, error)
+}
+
 // SignedURLOptions sets options for SignedURL.
 type SignedURLOptions struct {
 	// Expiry sets how long the returned URL is valid for. It is guaranteed to be > 0.
@@ -338,7 +784,9 @@ type prefixedBucket struct {
 }
 
 // NewPrefixedBucket returns a Bucket based on b with all keys modified to have
-// prefix.
+// prefix. The returned Bucket implements all the optional interfaces; the
+// methods of those that b doesn't implement return errors for which
+// ErrorCode returns gdkerr.Unimplemented.
 func NewPrefixedBucket(b Bucket, prefix string) Bucket {
 	return &prefixedBucket{base: b, prefix: prefix}
 }
@@ -365,6 +813,9 @@ func (b *prefixedBucket) ListPaged(ctx context.Context, opts *ListOptions) (*Lis
 	return page, nil
 }
 func (b *prefixedBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	if opts.VersionID != "" && !SupportsVersioning(b.base) {
+		return nil, errVersioningUnimplemented
+	}
 	return b.base.NewRangeReader(ctx, b.prefix+key, offset, length, opts)
 }
 func (b *prefixedBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
@@ -376,14 +827,145 @@ func (b *prefixedBucket) NewTypedWriter(ctx context.Context, key, contentType st
 func (b *prefixedBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
 	return b.base.Copy(ctx, b.prefix+dstKey, b.prefix+srcKey, opts)
 }
-func (b *prefixedBucket) Delete(ctx context.Context, key string) error {
-	return b.base.Delete(ctx, b.prefix+key)
+func (b *prefixedBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	if opts.VersionID != "" && !SupportsVersioning(b.base) {
+		return errVersioningUnimplemented
+	}
+	return b.base.Delete(ctx, b.prefix+key, opts)
 }
 func (b *prefixedBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
 	return b.base.SignedURL(ctx, b.prefix+key, opts)
 }
+func (b *prefixedBucket) ListVersions(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	v, ok := b.base.(Versioner)
+	if !ok {
+		return nil, errVersioningUnimplemented
+	}
+	myopts := *opts
+	myopts.Prefix = b.prefix + myopts.Prefix
+	page, err := v.ListVersions(ctx, &myopts)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range page.Objects {
+		p.Key = strings.TrimPrefix(p.Key, b.prefix)
+	}
+	return page, nil
+}
+func (b *prefixedBucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	bd, ok := b.base.(BulkDeleter)
+	if !ok {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: bulk deletes are not supported")
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = b.prefix + key
+	}
+	return bd.DeleteMany(ctx, prefixed)
+}
+func (b *prefixedBucket) NewResumableWriter(ctx context.Context, key, contentType, token string, opts *WriterOptions) (ResumableWriter, error) {
+	ru, ok := b.base.(ResumableUploader)
+	if !ok {
+		return nil, errResumableUnimplemented
+	}
+	return ru.NewResumableWriter(ctx, b.prefix+key, contentType, token, opts)
+}
+func (b *prefixedBucket) ListUploadSessions(ctx context.Context, prefix string) ([]*UploadSession, error) {
+	ru, ok := b.base.(ResumableUploader)
+	if !ok {
+		return nil, errResumableUnimplemented
+	}
+	sessions, err := ru.ListUploadSessions(ctx, b.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		s.Key = strings.TrimPrefix(s.Key, b.prefix)
+	}
+	return sessions, nil
+}
+func (b *prefixedBucket) AbortUploadSession(ctx context.Context, key, token string) error {
+	ru, ok := b.base.(ResumableUploader)
+	if !ok {
+		return errResumableUnimplemented
+	}
+	return ru.AbortUploadSession(ctx, b.prefix+key, token)
+}
+func (b *prefixedBucket) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	t, ok := b.base.(Tagger)
+	if !ok {
+		return gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: changing tags is not supported")
+	}
+	return t.SetTags(ctx, b.prefix+key, tags)
+}
+func (b *prefixedBucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *ComposeOptions) error {
+	c, ok := b.base.(Composer)
+	if !ok {
+		return gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: composing blobs is not supported")
+	}
+	prefixed := make([]string, len(srcKeys))
+	for i, key := range srcKeys {
+		prefixed[i] = b.prefix + key
+	}
+	return c.Compose(ctx, b.prefix+dstKey, prefixed, opts)
+}
+func (b *prefixedBucket) Watch(ctx context.Context, prefix string) (EventStream, error) {
+	w, ok := b.base.(Watcher)
+	if !ok {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: watching blobs is not supported")
+	}
+	s, err := w.Watch(ctx, b.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedEventStream{EventStream: s, prefix: b.prefix}, nil
+}
+func (b *prefixedBucket) ReplicationStatus(ctx context.Context, key string) ([]*ReplicaStatus, error) {
+	r, ok := b.base.(Replicator)
+	if !ok {
+		return nil, gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: replication is not supported")
+	}
+	return r.ReplicationStatus(ctx, b.prefix+key)
+}
+func (b *prefixedBucket) Restore(ctx context.Context, key string) error {
+	sd, ok := b.base.(SoftDeleter)
+	if !ok {
+		return errSoftDeleteUnimplemented
+	}
+	return sd.Restore(ctx, b.prefix+key)
+}
+
+// Purge is not supported: the base bucket's Purge would also purge the
+// blobs deleted outside of the prefix.
+func (b *prefixedBucket) Purge(ctx context.Context, before time.Time) error {
+	return errSoftDeleteUnimplemented
+}
+
 func (b *prefixedBucket) Close() error { return b.base.Close() }
 
+var (
+	errVersioningUnimplemented = gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: versioning is not supported")
+	errResumableUnimplemented  = gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: resumable uploads are not supported")
+	errSoftDeleteUnimplemented = gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: restoring deleted blobs is not supported")
+)
+
+// prefixedEventStream is an EventStream of a prefixedBucket, removing the
+// prefix from the keys of the Events of the base bucket.
+type prefixedEventStream struct {
+	EventStream
+	prefix string
+}
+
+func (s *prefixedEventStream) Next(ctx context.Context) (*Event, error) {
+	e, err := s.EventStream.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pe := *e
+	pe.Key = strings.TrimPrefix(e.Key, s.prefix)
+	return &pe, nil
+}
+
 // singleKeyBucket implements Bucket by hardwiring a specific key.
 type singleKeyBucket struct {
 	base Bucket
@@ -413,8 +995,8 @@ func (b *singleKeyBucket) NewTypedWriter(ctx context.Context, _, contentType str
 func (b *singleKeyBucket) Copy(ctx context.Context, dstKey, _ string, opts *CopyOptions) error {
 	return b.base.Copy(ctx, dstKey, b.key, opts)
 }
-func (b *singleKeyBucket) Delete(ctx context.Context, _ string) error {
-	return b.base.Delete(ctx, b.key)
+func (b *singleKeyBucket) Delete(ctx context.Context, _ string, opts *DeleteOptions) error {
+	return b.base.Delete(ctx, b.key, opts)
 }
 func (b *singleKeyBucket) SignedURL(ctx context.Context, _ string, opts *SignedURLOptions) (string, error) {
 	return b.base.SignedURL(ctx, b.key, opts)
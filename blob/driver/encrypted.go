@@ -0,0 +1,411 @@
+package driver
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// Keeper encrypts and decrypts the data keys of an encrypted bucket.
+// *secrets.Keeper implements it.
+type Keeper interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+const (
+	// encryptedChunkSize is the size of the plaintext chunks that are
+	// encrypted separately; every chunk but the last is full.
+	encryptedChunkSize = 64 * 1024
+	// encryptedOverhead is the size of the authentication tag added to each
+	// chunk.
+	encryptedOverhead = 16
+	// encryptedNoncePrefixSize is the size of the random part of the chunk
+	// nonces. The rest holds the chunk number and a flag for the last chunk.
+	encryptedNoncePrefixSize = 7
+
+	// Metadata keys holding the encrypted data key and the nonce prefix of a
+	// blob, base64-encoded.
+	encryptionKeyMetadata   = "gdk-encryption-key"
+	encryptionNonceMetadata = "gdk-encryption-nonce"
+)
+
+var (
+	errNotEncrypted           = gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: blob is not encrypted")
+	errEncryptedSignedURL     = gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: signed URLs are not supported for encrypted buckets")
+	errEncryptedBlobCorrupted = gdkerr.Newf(gdkerr.Internal, nil, "blob: encrypted blob is corrupted")
+)
+
+// encryptedBucket implements Bucket by encrypting blobs before writing them
+// to base, and decrypting them when reading.
+//
+// Each blob is encrypted with its own random AES-256 data key, using AES-GCM
+// on chunks of encryptedChunkSize bytes, so that range reads only need to
+// decrypt the chunks they cover. The nonce of each chunk is made of a random
+// prefix, the chunk number and a flag marking the last chunk, so chunks can't
+// be reordered, dropped or truncated without failing authentication. The data
+// key, encrypted by the Keeper, and the nonce prefix are stored in the blob's
+// metadata, which is hidden from the callers.
+type encryptedBucket struct {
+	base   Bucket
+	keeper Keeper
+}
+
+// NewEncryptedBucket returns a Bucket based on b that encrypts blobs on the
+// client side, with data keys encrypted by keeper.
+//
+// Blobs must be written through the returned Bucket to be readable from it.
+// Sizes reported by ListPaged assume they were; MD5 hashes and checksums are
+// not reported, since the underlying ones are of the encrypted contents.
+// SignedURL is not supported.
+func NewEncryptedBucket(b Bucket, keeper Keeper) Bucket {
+	return &encryptedBucket{base: b, keeper: keeper}
+}
+
+func (b *encryptedBucket) ErrorCode(err error) gdkerr.ErrorCode  { return b.base.ErrorCode(err) }
+func (b *encryptedBucket) As(i interface{}) bool                 { return b.base.As(i) }
+func (b *encryptedBucket) ErrorAs(err error, i interface{}) bool { return b.base.ErrorAs(err, i) }
+
+func (b *encryptedBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	attrs, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := encryptionMetadata(attrs.Metadata); err != nil {
+		return nil, err
+	}
+	size, ok := decryptedSize(attrs.Size)
+	if !ok {
+		return nil, errEncryptedBlobCorrupted
+	}
+	plain := *attrs
+	plain.Size = size
+	plain.MD5 = nil
+	plain.Checksums = nil
+	plain.Metadata = make(map[string]string, len(attrs.Metadata))
+	for k, v := range attrs.Metadata {
+		if k != encryptionKeyMetadata && k != encryptionNonceMetadata {
+			plain.Metadata[k] = v
+		}
+	}
+	return &plain, nil
+}
+
+func (b *encryptedBucket) ListPaged(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	page, err := b.base.ListPaged(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range page.Objects {
+		if obj.IsDir {
+			continue
+		}
+		if size, ok := decryptedSize(obj.Size); ok {
+			obj.Size = size
+		}
+		obj.MD5 = nil
+		obj.Checksums = nil
+	}
+	return page, nil
+}
+
+func (b *encryptedBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	attrs, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, prefix, err := encryptionMetadata(attrs.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	size, ok := decryptedSize(attrs.Size)
+	if !ok {
+		return nil, errEncryptedBlobCorrupted
+	}
+	dataKey, err := b.keeper.Decrypt(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the chunks covering the range, from the version of the blob the
+	// metadata came from.
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	if offset > end {
+		offset = end
+	}
+	lastChunk := (size - 1) / encryptedChunkSize
+	if size == 0 {
+		lastChunk = 0
+	}
+	first := offset / encryptedChunkSize
+	last := lastChunk
+	if end > offset {
+		last = (end - 1) / encryptedChunkSize
+	}
+	const stride = encryptedChunkSize + encryptedOverhead
+	start := first * stride
+	clength := (last - first + 1) * stride
+	if start+clength > attrs.Size {
+		clength = attrs.Size - start
+	}
+	if end == offset {
+		// Nothing to decrypt, but still check the preconditions.
+		start, clength = 0, 0
+	}
+	bopts := *opts
+	if bopts.IfMatch == "" || bopts.IfMatch == "*" {
+		bopts.IfMatch = attrs.ETag
+	}
+	r, err := b.base.NewRangeReader(ctx, key, start, clength, &bopts)
+	if err != nil {
+		return nil, err
+	}
+	battrs := r.Attributes()
+	er := &encryptedReader{
+		base:      r,
+		aead:      aead,
+		prefix:    prefix,
+		chunk:     uint32(first),
+		lastChunk: uint32(lastChunk),
+		skip:      offset - first*encryptedChunkSize,
+		remaining: end - offset,
+		attrs: ReaderAttributes{
+			ContentType: battrs.ContentType,
+			ModTime:     battrs.ModTime,
+			Size:        size,
+			ETag:        battrs.ETag,
+		},
+	}
+	if clength == 0 {
+		er.remaining = 0
+	}
+	return er, nil
+}
+
+func (b *encryptedBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptedNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	wrapped, err := b.keeper.Encrypt(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	bopts := *opts
+	// ContentMD5 and Checksums are the hashes of the plaintext, which the
+	// portable type verifies.
+	bopts.ContentMD5 = nil
+	bopts.Checksums = nil
+	bopts.Metadata = make(map[string]string, len(opts.Metadata)+2)
+	for k, v := range opts.Metadata {
+		bopts.Metadata[k] = v
+	}
+	bopts.Metadata[encryptionKeyMetadata] = base64.StdEncoding.EncodeToString(wrapped)
+	bopts.Metadata[encryptionNonceMetadata] = base64.StdEncoding.EncodeToString(prefix)
+	w, err := b.base.NewTypedWriter(ctx, key, contentType, &bopts)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedWriter{base: w, aead: aead, prefix: prefix}, nil
+}
+
+func (b *encryptedBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
+	// The metadata is copied along with the blob, so the copy stays readable.
+	return b.base.Copy(ctx, dstKey, srcKey, opts)
+}
+
+func (b *encryptedBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	return b.base.Delete(ctx, key, opts)
+}
+
+func (b *encryptedBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
+	return "", errEncryptedSignedURL
+}
+
+func (b *encryptedBucket) Close() error { return b.base.Close() }
+
+// encryptionMetadata returns the encrypted data key and the nonce prefix
+// stored in metadata.
+func encryptionMetadata(metadata map[string]string) (wrapped, prefix []byte, err error) {
+	k, ok1 := metadata[encryptionKeyMetadata]
+	n, ok2 := metadata[encryptionNonceMetadata]
+	if !ok1 || !ok2 {
+		return nil, nil, errNotEncrypted
+	}
+	if wrapped, err = base64.StdEncoding.DecodeString(k); err != nil {
+		return nil, nil, errEncryptedBlobCorrupted
+	}
+	if prefix, err = base64.StdEncoding.DecodeString(n); err != nil || len(prefix) != encryptedNoncePrefixSize {
+		return nil, nil, errEncryptedBlobCorrupted
+	}
+	return wrapped, prefix, nil
+}
+
+// decryptedSize returns the size of the plaintext of an encrypted blob of the
+// given size, and whether size is valid.
+func decryptedSize(size int64) (int64, bool) {
+	const stride = encryptedChunkSize + encryptedOverhead
+	if size < encryptedOverhead {
+		return 0, false
+	}
+	// The last chunk may be full, or only hold the tag if the blob is empty.
+	full, rest := (size-encryptedOverhead)/stride, (size-encryptedOverhead)%stride
+	if rest > encryptedChunkSize {
+		return 0, false
+	}
+	return full*encryptedChunkSize + rest, true
+}
+
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for the given chunk.
+func chunkNonce(prefix []byte, chunk uint32, last bool) []byte {
+	nonce := make([]byte, encryptedNoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptedNoncePrefixSize:], chunk)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptedWriter encrypts what is written to it a chunk at a time. A chunk
+// is only encrypted once the next one has started, since the last chunk is
+// encrypted differently.
+type encryptedWriter struct {
+	base   Writer
+	aead   cipher.AEAD
+	prefix []byte
+	chunk  uint32
+	buf    []byte
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == encryptedChunkSize {
+			if err := w.flush(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		m := encryptedChunkSize - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// flush encrypts the buffered chunk and writes it.
+func (w *encryptedWriter) flush(last bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.prefix, w.chunk, last), w.buf, nil)
+	if _, err := w.base.Write(sealed); err != nil {
+		return err
+	}
+	w.chunk++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *encryptedWriter) Close() error {
+	if err := w.flush(true); err != nil {
+		w.base.Close()
+		return err
+	}
+	return w.base.Close()
+}
+
+// encryptedReader decrypts the chunks read from base, returning remaining
+// bytes after skipping skip bytes of the first one.
+type encryptedReader struct {
+	base      Reader
+	aead      cipher.AEAD
+	prefix    []byte
+	chunk     uint32 // The next chunk to read.
+	lastChunk uint32 // The last chunk of the blob.
+	skip      int64
+	remaining int64
+	buf       []byte // Decrypted bytes not returned yet.
+	sealed    []byte
+	attrs     ReaderAttributes
+}
+
+func (r *encryptedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+	}
+	r.buf = r.buf[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// next reads and decrypts the next chunk into buf.
+func (r *encryptedReader) next() error {
+	if r.sealed == nil {
+		r.sealed = make([]byte, encryptedChunkSize+encryptedOverhead)
+	}
+	n, err := io.ReadFull(r.base, r.sealed)
+	if err == io.EOF || (err == io.ErrUnexpectedEOF && r.chunk != r.lastChunk) {
+		return errEncryptedBlobCorrupted
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	plain, err := r.aead.Open(r.sealed[:0], chunkNonce(r.prefix, r.chunk, r.chunk == r.lastChunk), r.sealed[:n], nil)
+	if err != nil {
+		return errEncryptedBlobCorrupted
+	}
+	r.chunk++
+	if r.skip > 0 {
+		if r.skip > int64(len(plain)) {
+			return errEncryptedBlobCorrupted
+		}
+		plain = plain[r.skip:]
+		r.skip = 0
+	}
+	r.buf = plain
+	return nil
+}
+
+func (r *encryptedReader) Close() error                  { return r.base.Close() }
+func (r *encryptedReader) Attributes() *ReaderAttributes { return &r.attrs }
+func (r *encryptedReader) As(i interface{}) bool         { return r.base.As(i) }
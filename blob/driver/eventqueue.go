@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+var errEventStreamClosed = gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: EventStream has been closed")
+
+// EventQueue is an EventStream for drivers implementing Watcher: the driver
+// pushes Events to it as they happen, and they are kept until Next returns
+// them.
+type EventQueue struct {
+	ready   chan struct{} // has a value when events or err may be available
+	onClose func()
+
+	mu     sync.Mutex
+	events []*Event
+	err    error // returned by Next once events is empty
+	closed bool
+}
+
+// NewEventQueue returns an empty EventQueue. onClose, if non-nil, is called
+// once, when the queue is closed; drivers use it to stop pushing events.
+func NewEventQueue(onClose func()) *EventQueue {
+	return &EventQueue{ready: make(chan struct{}, 1), onClose: onClose}
+}
+
+// signal wakes up Next. q.mu must be held.
+func (q *EventQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Push adds e to the queue. It does nothing once the queue is closed or has
+// failed.
+func (q *EventQueue) Push(e *Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return
+	}
+	q.events = append(q.events, e)
+	q.signal()
+}
+
+// Fail makes Next return err once the Events already pushed have been
+// returned.
+func (q *EventQueue) Fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+		q.signal()
+	}
+}
+
+// Next implements EventStream.Next.
+func (q *EventQueue) Next(ctx context.Context) (*Event, error) {
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			e := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+			q.mu.Unlock()
+			return e, nil
+		}
+		err := q.err
+		q.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close implements EventStream.Close.
+func (q *EventQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.events = nil
+	q.err = errEventStreamClosed
+	q.signal()
+	q.mu.Unlock()
+	if q.onClose != nil {
+		q.onClose()
+	}
+	return nil
+}
@@ -0,0 +1,551 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// whiteoutPrefix is the prefix of the keys of the whiteout markers of an
+// overlay bucket: deleting a blob that is in a lower layer writes an empty
+// blob at whiteoutPrefix+key in the top layer, which hides the blob in the
+// layers below it.
+const whiteoutPrefix = ".gdk-whiteout/"
+
+var (
+	errOverlayReadOnly = gdkerr.Newf(gdkerr.PermissionDenied, nil, "blob: overlay bucket is read-only")
+	errOverlayNotFound = gdkerr.Newf(gdkerr.NotFound, nil, "blob: blob not found")
+	errOverlayToken    = gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: invalid page token")
+
+	errOverlayPreconditionFailed = gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: precondition failed")
+)
+
+// overlayBucket implements Bucket by reading from the first of layers that
+// has a blob, and writing to the first layer.
+type overlayBucket struct {
+	layers   []Bucket
+	readOnly bool
+}
+
+// NewOverlayBucket returns a Bucket that reads each blob from the first of
+// layers that has it, unless a layer above it has a whiteout marker for it,
+// and writes blobs to layers[0]. Deleting a blob that is in a lower layer
+// writes a whiteout marker for it to layers[0]. If readOnly is true, writes
+// and deletes fail instead.
+//
+// GET URLs are signed by the layer the blob is read from, and PUT URLs by
+// layers[0]. SignedURL is not supported for DELETE URLs.
+func NewOverlayBucket(layers []Bucket, readOnly bool) Bucket {
+	return &overlayBucket{layers: layers, readOnly: readOnly}
+}
+
+func (b *overlayBucket) ErrorCode(err error) gdkerr.ErrorCode {
+	// The error may come from any layer.
+	for _, l := range b.layers {
+		if code := l.ErrorCode(err); code != gdkerr.Unknown {
+			return code
+		}
+	}
+	return gdkerr.Unknown
+}
+
+func (b *overlayBucket) As(i interface{}) bool { return b.layers[0].As(i) }
+
+func (b *overlayBucket) ErrorAs(err error, i interface{}) bool {
+	for _, l := range b.layers {
+		if l.ErrorAs(err, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the index of the layer the blob for key is read from, and its
+// attributes.
+func (b *overlayBucket) find(ctx context.Context, key string) (int, *Attributes, error) {
+	if strings.HasPrefix(key, whiteoutPrefix) {
+		return 0, nil, errOverlayNotFound
+	}
+	for i, l := range b.layers {
+		attrs, err := l.Attributes(ctx, key)
+		if err == nil {
+			return i, attrs, nil
+		}
+		if l.ErrorCode(err) != gdkerr.NotFound {
+			return 0, nil, err
+		}
+		if whiteout, err := b.hasWhiteout(ctx, i, key); err != nil {
+			return 0, nil, err
+		} else if whiteout {
+			break
+		}
+	}
+	return 0, nil, errOverlayNotFound
+}
+
+// hasWhiteout reports whether layer i has a whiteout marker for key.
+func (b *overlayBucket) hasWhiteout(ctx context.Context, i int, key string) (bool, error) {
+	if i == len(b.layers)-1 {
+		// There is nothing below to hide.
+		return false, nil
+	}
+	_, err := b.layers[i].Attributes(ctx, whiteoutPrefix+key)
+	if err == nil {
+		return true, nil
+	}
+	if b.layers[i].ErrorCode(err) == gdkerr.NotFound {
+		return false, nil
+	}
+	return false, err
+}
+
+func (b *overlayBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	_, attrs, err := b.find(ctx, key)
+	return attrs, err
+}
+
+func (b *overlayBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	i, _, err := b.find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// The blob may have changed since, but only within the layer.
+	return b.layers[i].NewRangeReader(ctx, key, offset, length, opts)
+}
+
+func (b *overlayBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
+	if b.readOnly {
+		return nil, errOverlayReadOnly
+	}
+	if strings.HasPrefix(key, whiteoutPrefix) {
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: key %q is reserved for whiteout markers", key)
+	}
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		// The preconditions are on the blob as it is read, which may be
+		// in a lower layer.
+		i, err := b.checkPreconditions(ctx, key, opts.IfMatch, opts.IfNoneMatch)
+		if err != nil {
+			return nil, err
+		}
+		if i != 0 {
+			// Make sure the blob still isn't in the top layer.
+			topts := *opts
+			topts.IfMatch, topts.IfNoneMatch = "", "*"
+			opts = &topts
+		}
+	}
+	w, err := b.layers[0].NewTypedWriter(ctx, key, contentType, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &overlayWriter{Writer: w, ctx: ctx, b: b, key: key}, nil
+}
+
+// checkPreconditions checks ifMatch and ifNoneMatch against the blob for
+// key, and returns the index of the layer it is read from, or -1 if there is
+// none.
+func (b *overlayBucket) checkPreconditions(ctx context.Context, key, ifMatch, ifNoneMatch string) (int, error) {
+	i, attrs, err := b.find(ctx, key)
+	if err == errOverlayNotFound {
+		i, attrs = -1, &Attributes{}
+	} else if err != nil {
+		return 0, err
+	}
+	exists := i >= 0
+	if ifMatch != "" && (!exists || (ifMatch != "*" && ifMatch != attrs.ETag)) {
+		return 0, errOverlayPreconditionFailed
+	}
+	if ifNoneMatch != "" && exists && (ifNoneMatch == "*" || ifNoneMatch == attrs.ETag) {
+		return 0, errOverlayPreconditionFailed
+	}
+	return i, nil
+}
+
+// removeWhiteout removes the whiteout marker for key from the top layer, if
+// any.
+func (b *overlayBucket) removeWhiteout(ctx context.Context, key string) error {
+	if len(b.layers) == 1 {
+		return nil
+	}
+	err := b.layers[0].Delete(ctx, whiteoutPrefix+key, &DeleteOptions{})
+	if err != nil && b.layers[0].ErrorCode(err) != gdkerr.NotFound {
+		return err
+	}
+	return nil
+}
+
+func (b *overlayBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
+	if b.readOnly {
+		return errOverlayReadOnly
+	}
+	if strings.HasPrefix(dstKey, whiteoutPrefix) {
+		return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: key %q is reserved for whiteout markers", dstKey)
+	}
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		// The preconditions are on the destination as it is read, which may
+		// be in a lower layer.
+		j, err := b.checkPreconditions(ctx, dstKey, opts.IfMatch, opts.IfNoneMatch)
+		if err != nil {
+			return err
+		}
+		if j != 0 {
+			// Make sure the destination still isn't in the top layer.
+			topts := *opts
+			topts.IfMatch, topts.IfNoneMatch = "", "*"
+			opts = &topts
+		}
+	}
+	i, attrs, err := b.find(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	if i == 0 {
+		if err := b.layers[0].Copy(ctx, dstKey, srcKey, opts); err != nil {
+			return err
+		}
+		return b.removeWhiteout(ctx, dstKey)
+	}
+
+	// Copy the blob up to the top layer.
+	r, err := b.layers[i].NewRangeReader(ctx, srcKey, 0, -1, &ReaderOptions{
+		IfMatch:              attrs.ETag,
+		ServerSideEncryption: opts.SourceServerSideEncryption,
+	})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Cancel the write if the copy fails, so that nothing is written.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.layers[0].NewTypedWriter(ctx, dstKey, attrs.ContentType, &WriterOptions{
+		CacheControl:         attrs.CacheControl,
+		ContentDisposition:   attrs.ContentDisposition,
+		ContentEncoding:      attrs.ContentEncoding,
+		ContentLanguage:      attrs.ContentLanguage,
+		Metadata:             attrs.Metadata,
+		IfMatch:              opts.IfMatch,
+		IfNoneMatch:          opts.IfNoneMatch,
+		ServerSideEncryption: opts.ServerSideEncryption,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return b.removeWhiteout(ctx, dstKey)
+}
+
+func (b *overlayBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	if b.readOnly {
+		return errOverlayReadOnly
+	}
+	i, attrs, err := b.find(ctx, key)
+	if err != nil {
+		return err
+	}
+	if i == 0 {
+		if err := b.layers[0].Delete(ctx, key, opts); err != nil {
+			return err
+		}
+	} else if opts.IfMatch != "" && opts.IfMatch != "*" && opts.IfMatch != attrs.ETag {
+		return errOverlayPreconditionFailed
+	}
+	// Hide the blobs in the layers below, if any.
+	for _, l := range b.layers[1:] {
+		_, err := l.Attributes(ctx, key)
+		if err == nil {
+			w, err := b.layers[0].NewTypedWriter(ctx, whiteoutPrefix+key, "application/octet-stream", &WriterOptions{})
+			if err != nil {
+				return err
+			}
+			return w.Close()
+		}
+		if l.ErrorCode(err) != gdkerr.NotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *overlayBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
+	switch opts.Method {
+	case http.MethodGet:
+		i, _, err := b.find(ctx, key)
+		if err == errOverlayNotFound {
+			i, err = 0, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return b.layers[i].SignedURL(ctx, key, opts)
+	case http.MethodPut:
+		if b.readOnly {
+			return "", errOverlayReadOnly
+		}
+		// A blob in the top layer is visible whether or not it has a
+		// whiteout marker.
+		return b.layers[0].SignedURL(ctx, key, opts)
+	}
+	return "", gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: overlay buckets don't support signed URLs for %s", opts.Method)
+}
+
+func (b *overlayBucket) Close() error {
+	var err error
+	for _, l := range b.layers {
+		if lerr := l.Close(); err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
+
+// overlayWriter is a Writer that removes the whiteout marker for its key
+// once the blob is written.
+type overlayWriter struct {
+	Writer
+	ctx context.Context
+	b   *overlayBucket
+	key string
+}
+
+func (w *overlayWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	return w.b.removeWhiteout(w.ctx, w.key)
+}
+
+// ListPaged merges the listings of the blobs and whiteout markers of all the
+// layers.
+//
+// The page token records the last key returned, and for each listing, the
+// token to continue it from, which may return keys up to that one again.
+func (b *overlayBucket) ListPaged(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	state := overlayToken{}
+	if len(opts.PageToken) > 0 {
+		if err := json.Unmarshal(opts.PageToken, &state); err != nil || len(state.Streams) != 2*len(b.layers) {
+			return nil, errOverlayToken
+		}
+	}
+	m := b.newMerger(opts.Prefix, opts.Delimiter, opts.PageSize, state)
+	if opts.BeforeList != nil {
+		m.streams[0].beforeList = opts.BeforeList
+		defer func() {
+			// BeforeList must be called, even if the first layer wasn't
+			// listed.
+			if m.streams[0].beforeList != nil {
+				opts.BeforeList(func(interface{}) bool { return false })
+			}
+		}()
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = overlayPageSize
+	}
+	page := &ListPage{}
+	for len(page.Objects) < pageSize {
+		obj, err := m.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return page, nil
+		}
+		page.Objects = append(page.Objects, obj)
+	}
+	if more, err := m.more(ctx); err != nil || !more {
+		return page, err
+	}
+	token, err := json.Marshal(m.token())
+	if err != nil {
+		return nil, err
+	}
+	page.NextPageToken = token
+	return page, nil
+}
+
+// overlayPageSize is the page size used by ListPaged if none is given.
+const overlayPageSize = 1000
+
+// overlayToken is the state of an overlayMerger, in page tokens.
+type overlayToken struct {
+	After   string         `json:"after,omitempty"`
+	Streams []streamTokens `json:"streams,omitempty"`
+}
+
+type streamTokens struct {
+	Token []byte `json:"token,omitempty"`
+	Done  bool   `json:"done,omitempty"`
+}
+
+// overlayStream lists the blobs, or the whiteout markers, of a layer.
+type overlayStream struct {
+	layer      int
+	whiteout   bool
+	opts       ListOptions
+	beforeList func(func(interface{}) bool) error // Passed to the first listing.
+	cur        []byte                             // The token buf was listed with.
+	next       []byte                             // The token to list after buf.
+	done       bool                               // Whether there is nothing after buf.
+	buf        []*ListObject
+}
+
+// overlayMerger merges the streams of all the layers, in key order.
+type overlayMerger struct {
+	b       *overlayBucket
+	streams []*overlayStream // The blobs and whiteout markers of each layer, in layer order.
+	after   string           // The last key returned.
+}
+
+func (b *overlayBucket) newMerger(prefix, delimiter string, pageSize int, state overlayToken) *overlayMerger {
+	m := &overlayMerger{b: b, after: state.After}
+	for i := range b.layers {
+		for _, whiteout := range []bool{false, true} {
+			s := &overlayStream{
+				layer:    i,
+				whiteout: whiteout,
+				opts:     ListOptions{Prefix: prefix, Delimiter: delimiter, PageSize: pageSize},
+			}
+			if whiteout {
+				s.opts.Prefix = whiteoutPrefix + prefix
+			}
+			if state.Streams != nil {
+				st := state.Streams[len(m.streams)]
+				s.next, s.done = st.Token, st.Done
+			}
+			m.streams = append(m.streams, s)
+		}
+	}
+	return m
+}
+
+// fill lists the next page of s if buf is empty, skipping the keys up to
+// after.
+func (s *overlayStream) fill(ctx context.Context, l Bucket, after string) error {
+	for len(s.buf) == 0 && !s.done {
+		opts := s.opts
+		opts.PageToken = s.next
+		opts.BeforeList, s.beforeList = s.beforeList, nil
+		page, err := l.ListPaged(ctx, &opts)
+		if err != nil {
+			return err
+		}
+		s.cur, s.next, s.done = s.next, page.NextPageToken, len(page.NextPageToken) == 0
+		for _, obj := range page.Objects {
+			key := obj.Key
+			if s.whiteout {
+				key = strings.TrimPrefix(key, whiteoutPrefix)
+			} else if strings.HasPrefix(key, whiteoutPrefix) {
+				continue
+			}
+			if key <= after {
+				continue
+			}
+			o := *obj
+			o.Key = key
+			s.buf = append(s.buf, &o)
+		}
+	}
+	return nil
+}
+
+// next returns the next visible object, or nil if there is none.
+func (m *overlayMerger) next(ctx context.Context) (*ListObject, error) {
+	for {
+		var key string
+		found := false
+		for _, s := range m.streams {
+			if err := s.fill(ctx, m.b.layers[s.layer], m.after); err != nil {
+				return nil, err
+			}
+			if len(s.buf) > 0 && (!found || s.buf[0].Key < key) {
+				key, found = s.buf[0].Key, true
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+
+		// Take key out of all the streams. For each layer, the blob comes
+		// before its whiteout marker.
+		var obj *ListObject
+		hidden, dirWhiteouts := false, false
+		for _, s := range m.streams {
+			if len(s.buf) == 0 || s.buf[0].Key != key {
+				continue
+			}
+			head := s.buf[0]
+			s.buf = s.buf[1:]
+			switch {
+			case s.whiteout && head.IsDir:
+				dirWhiteouts = true
+			case obj != nil || hidden:
+			case s.whiteout:
+				hidden = true
+			default:
+				obj = head
+			}
+		}
+		m.after = key
+		if obj == nil {
+			continue
+		}
+		if obj.IsDir && dirWhiteouts {
+			// Everything in the directory may be hidden.
+			visible, err := m.b.hasVisible(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if !visible {
+				continue
+			}
+		}
+		return obj, nil
+	}
+}
+
+// more reports whether there are more visible objects to return.
+func (m *overlayMerger) more(ctx context.Context) (bool, error) {
+	for _, s := range m.streams {
+		if err := s.fill(ctx, m.b.layers[s.layer], m.after); err != nil {
+			return false, err
+		}
+		if len(s.buf) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// token returns the state of m, to continue listing after the last key
+// returned.
+func (m *overlayMerger) token() overlayToken {
+	t := overlayToken{After: m.after}
+	for _, s := range m.streams {
+		if len(s.buf) > 0 {
+			// List the page again, skipping what was already returned.
+			t.Streams = append(t.Streams, streamTokens{Token: s.cur})
+		} else {
+			t.Streams = append(t.Streams, streamTokens{Token: s.next, Done: s.done})
+		}
+	}
+	return t
+}
+
+// hasVisible reports whether any blob with the given prefix is visible.
+func (b *overlayBucket) hasVisible(ctx context.Context, prefix string) (bool, error) {
+	m := b.newMerger(prefix, "", 0, overlayToken{})
+	obj, err := m.next(ctx)
+	return obj != nil, err
+}
@@ -0,0 +1,797 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// ReplicationOptions sets options for NewReplicatedBucket.
+type ReplicationOptions struct {
+	// WriteQuorum is the number of replicas writes, copies and deletes must
+	// succeed on. Zero means all of them.
+	WriteQuorum int
+	// UnhealthyPeriod is how long a replica that failed is only read from
+	// after the others. Zero means 30 seconds.
+	UnhealthyPeriod time.Duration
+	// RepairRetryInterval is how long to wait before retrying a repair that
+	// failed. Zero means 10 seconds.
+	RepairRetryInterval time.Duration
+}
+
+const (
+	defaultUnhealthyPeriod     = 30 * time.Second
+	defaultRepairRetryInterval = 10 * time.Second
+
+	// replicaVersionMetadata is the metadata key holding a random identifier
+	// of the write that created a blob, the same in all replicas.
+	replicaVersionMetadata = "gdk-replica-version"
+)
+
+var (
+	errReplicatedPreconditionFailed = gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: precondition failed")
+	errReplicatedSignedURL          = gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: signed URLs are not supported for replicated buckets")
+	errReplicatedToken              = gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: invalid page token")
+)
+
+// replicatedBucket implements Bucket by writing to all of replicas, and
+// reading from the first healthy one.
+//
+// Blobs written through it have the same random version in their metadata
+// in all the replicas, and their ETag is derived from it, so that ETags don't
+// change when reads fail over to another replica. Preconditions are checked
+// against the replica reads come from, and passed on to it as a condition on
+// its own ETag; the other replicas follow it.
+type replicatedBucket struct {
+	replicas []Bucket
+	opts     ReplicationOptions
+
+	ctx    context.Context // Canceled when the bucket is closed.
+	cancel func()
+	wake   chan struct{}
+	done   sync.WaitGroup
+
+	mu        sync.Mutex
+	unhealthy []time.Time // Until when each replica is considered unhealthy.
+	pending   map[string]*replicaRepair
+	queue     []string // Keys in pending to repair next.
+}
+
+// replicaRepair is a queued repair of a key.
+type replicaRepair struct {
+	source  int          // The replica to copy from.
+	targets map[int]bool // The replicas to repair.
+	gen     int          // Incremented when the repair changes.
+	err     error        // The last error repairing, if any.
+}
+
+// NewReplicatedBucket returns a Bucket that writes blobs to all of replicas,
+// and reads them from the first healthy one, failing over to the next ones.
+//
+// Replicas that a write, copy or delete fails on are repaired in the
+// background, by copying the blob from one that it succeeded on. The state
+// of the replicas of a blob is reported by ReplicationStatus.
+//
+// SignedURL is not supported, since requests to signed URLs would bypass
+// replication.
+func NewReplicatedBucket(replicas []Bucket, opts *ReplicationOptions) Bucket {
+	b := &replicatedBucket{
+		replicas:  replicas,
+		wake:      make(chan struct{}, 1),
+		unhealthy: make([]time.Time, len(replicas)),
+		pending:   map[string]*replicaRepair{},
+	}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.opts.WriteQuorum <= 0 || b.opts.WriteQuorum > len(replicas) {
+		b.opts.WriteQuorum = len(replicas)
+	}
+	if b.opts.UnhealthyPeriod <= 0 {
+		b.opts.UnhealthyPeriod = defaultUnhealthyPeriod
+	}
+	if b.opts.RepairRetryInterval <= 0 {
+		b.opts.RepairRetryInterval = defaultRepairRetryInterval
+	}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.done.Add(1)
+	go b.repairLoop()
+	return b
+}
+
+func (b *replicatedBucket) ErrorCode(err error) gdkerr.ErrorCode {
+	// The error may come from any replica.
+	for _, r := range b.replicas {
+		if code := r.ErrorCode(err); code != gdkerr.Unknown {
+			return code
+		}
+	}
+	return gdkerr.Unknown
+}
+
+func (b *replicatedBucket) As(i interface{}) bool { return b.replicas[0].As(i) }
+
+func (b *replicatedBucket) ErrorAs(err error, i interface{}) bool {
+	for _, r := range b.replicas {
+		if r.ErrorAs(err, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// readOrder returns the indexes of the replicas to read key from, in order:
+// the healthy ones that have no pending repair for key first.
+func (b *replicatedBucket) readOrder(key string) []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var first, last []int
+	for i := range b.replicas {
+		if now.Before(b.unhealthy[i]) || (b.pending[key] != nil && b.pending[key].targets[i]) {
+			last = append(last, i)
+		} else {
+			first = append(first, i)
+		}
+	}
+	return append(first, last...)
+}
+
+// failed reports whether err, returned by replica i, means the replica
+// failed, rather than the request, and if so, marks the replica unhealthy.
+func (b *replicatedBucket) failed(ctx context.Context, i int, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch b.replicas[i].ErrorCode(err) {
+	case gdkerr.NotFound, gdkerr.FailedPrecondition, gdkerr.InvalidArgument, gdkerr.AlreadyExists, gdkerr.Canceled:
+		return false
+	}
+	b.mu.Lock()
+	b.unhealthy[i] = time.Now().Add(b.opts.UnhealthyPeriod)
+	b.mu.Unlock()
+	return true
+}
+
+// replicaETag returns the ETag of the blob for key with the given attributes
+// in a replica.
+func replicaETag(key string, attrs *Attributes) string {
+	version, ok := attrs.Metadata[replicaVersionMetadata]
+	if !ok {
+		return attrs.ETag
+	}
+	sum := sha256.Sum256([]byte(key + "\x00" + version))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// find returns the index of the replica to read the blob for key from, and
+// the blob's attributes in it.
+func (b *replicatedBucket) find(ctx context.Context, key string) (int, *Attributes, error) {
+	var err error
+	for _, i := range b.readOrder(key) {
+		var attrs *Attributes
+		if attrs, err = b.replicas[i].Attributes(ctx, key); err == nil {
+			return i, attrs, nil
+		}
+		if !b.failed(ctx, i, err) {
+			return i, nil, err
+		}
+	}
+	return -1, nil, err
+}
+
+func (b *replicatedBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	_, attrs, err := b.find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	plain := *attrs
+	plain.ETag = replicaETag(key, attrs)
+	plain.Metadata = make(map[string]string, len(attrs.Metadata))
+	for k, v := range attrs.Metadata {
+		if k != replicaVersionMetadata {
+			plain.Metadata[k] = v
+		}
+	}
+	return &plain, nil
+}
+
+func (b *replicatedBucket) ListPaged(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	// Page tokens start with the index of the replica listed.
+	order := b.readOrder("")
+	if len(opts.PageToken) > 0 {
+		if int(opts.PageToken[0]) >= len(b.replicas) {
+			return nil, errReplicatedToken
+		}
+		order = []int{int(opts.PageToken[0])}
+	}
+	var err error
+	for _, i := range order {
+		ropts := *opts
+		if len(opts.PageToken) > 0 {
+			ropts.PageToken = opts.PageToken[1:]
+		}
+		var page *ListPage
+		if page, err = b.replicas[i].ListPaged(ctx, &ropts); err == nil {
+			// The ETags of a replica aren't the ones Attributes reports.
+			for _, obj := range page.Objects {
+				obj.ETag = ""
+			}
+			if len(page.NextPageToken) > 0 {
+				page.NextPageToken = append([]byte{byte(i)}, page.NextPageToken...)
+			}
+			return page, nil
+		}
+		if !b.failed(ctx, i, err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (b *replicatedBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	var err error
+	for _, i := range b.readOrder(key) {
+		var attrs *Attributes
+		if attrs, err = b.replicas[i].Attributes(ctx, key); err != nil {
+			if b.failed(ctx, i, err) {
+				continue
+			}
+			return nil, err
+		}
+		eTag := replicaETag(key, attrs)
+		if !MatchesPreconditions(true, eTag, opts.IfMatch, opts.IfNoneMatch) {
+			return nil, errReplicatedPreconditionFailed
+		}
+		// Read the version of the blob the attributes came from.
+		ropts := *opts
+		ropts.IfMatch, ropts.IfNoneMatch = attrs.ETag, ""
+		var r Reader
+		if r, err = b.replicas[i].NewRangeReader(ctx, key, offset, length, &ropts); err != nil {
+			if b.failed(ctx, i, err) {
+				continue
+			}
+			return nil, err
+		}
+		rattrs := *r.Attributes()
+		rattrs.ETag = eTag
+		return &replicaReader{Reader: r, attrs: rattrs}, nil
+	}
+	return nil, err
+}
+
+// conditions checks the ifMatch and ifNoneMatch preconditions on the blob
+// for key against the replica reads come from. It returns the index of that
+// replica, and the preconditions to pass on to it to make sure the blob
+// hasn't changed since; it returns -1 if there are no preconditions.
+// If ifMatch is set and the blob doesn't exist, the error is NotFound.
+func (b *replicatedBucket) conditions(ctx context.Context, key, ifMatch, ifNoneMatch string) (int, string, string, error) {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return -1, "", "", nil
+	}
+	i, attrs, err := b.find(ctx, key)
+	if err != nil && (i < 0 || b.replicas[i].ErrorCode(err) != gdkerr.NotFound) {
+		return 0, "", "", err
+	}
+	if attrs == nil {
+		if ifMatch != "" {
+			return 0, "", "", err
+		}
+		if !MatchesPreconditions(false, "", ifMatch, ifNoneMatch) {
+			return 0, "", "", errReplicatedPreconditionFailed
+		}
+		return i, "", "*", nil
+	}
+	if !MatchesPreconditions(true, replicaETag(key, attrs), ifMatch, ifNoneMatch) {
+		return 0, "", "", errReplicatedPreconditionFailed
+	}
+	return i, attrs.ETag, "", nil
+}
+
+// writeConditions is like conditions, for writes and copies: the
+// preconditions fail if ifMatch is set and the blob doesn't exist.
+func (b *replicatedBucket) writeConditions(ctx context.Context, key, ifMatch, ifNoneMatch string) (int, string, string, error) {
+	cond, ifMatch, ifNoneMatch, err := b.conditions(ctx, key, ifMatch, ifNoneMatch)
+	if err != nil && b.ErrorCode(err) == gdkerr.NotFound {
+		return 0, "", "", errReplicatedPreconditionFailed
+	}
+	return cond, ifMatch, ifNoneMatch, err
+}
+
+// each calls f for each replica concurrently, and returns the errors.
+func (b *replicatedBucket) each(f func(i int) error) []error {
+	errs := make([]error, len(b.replicas))
+	var wg sync.WaitGroup
+	for i := range b.replicas {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// settle handles the errors of a write, copy or delete of key on each
+// replica, where cond is the replica the preconditions were checked on, or
+// -1. It queues repairs for the replicas it failed on, and returns the error
+// to report, if any. Errors for which ok returns true count as successes.
+func (b *replicatedBucket) settle(ctx context.Context, key string, cond int, errs []error, ok func(i int, err error) bool) error {
+	source := -1
+	var failed []int
+	var firstErr, requestErr error
+	for i, err := range errs {
+		if err == nil || ok(i, err) {
+			if source < 0 {
+				source = i
+			}
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if !b.failed(ctx, i, err) && requestErr == nil {
+			requestErr = err
+		}
+		failed = append(failed, i)
+	}
+	if cond >= 0 && errs[cond] != nil && !ok(cond, errs[cond]) {
+		// The preconditions failed, so the others must go back to the state
+		// of the replica they were checked on.
+		if source >= 0 {
+			b.enqueue(key, cond, nil)
+		}
+		return errs[cond]
+	}
+	if source >= 0 && len(failed) > 0 {
+		b.enqueue(key, source, failed)
+	}
+	if requestErr != nil {
+		return requestErr
+	}
+	if len(errs)-len(failed) < b.opts.WriteQuorum {
+		return firstErr
+	}
+	return nil
+}
+
+func (b *replicatedBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
+	cond, ifMatch, ifNoneMatch, err := b.writeConditions(ctx, key, opts.IfMatch, opts.IfNoneMatch)
+	if err != nil {
+		return nil, err
+	}
+	version, err := newReplicaVersion()
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[replicaVersionMetadata] = version
+
+	w := &replicatedWriter{
+		ctx:     ctx,
+		b:       b,
+		key:     key,
+		cond:    cond,
+		writers: make([]Writer, len(b.replicas)),
+		cancels: make([]func(), len(b.replicas)),
+		errs:    make([]error, len(b.replicas)),
+	}
+	for i, r := range b.replicas {
+		ropts := *opts
+		ropts.Metadata = metadata
+		ropts.IfMatch, ropts.IfNoneMatch = "", ""
+		if i == cond {
+			ropts.IfMatch, ropts.IfNoneMatch = ifMatch, ifNoneMatch
+		}
+		if i > 0 {
+			// BeforeWrite must be called once.
+			ropts.BeforeWrite = nil
+		}
+		// Each write can be aborted on its own.
+		var rctx context.Context
+		rctx, w.cancels[i] = context.WithCancel(ctx)
+		if w.writers[i], w.errs[i] = r.NewTypedWriter(rctx, key, contentType, &ropts); w.errs[i] != nil {
+			if !b.failed(ctx, i, w.errs[i]) {
+				w.abort()
+				return nil, w.errs[i]
+			}
+		}
+	}
+	if err := w.quorum(); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (b *replicatedBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
+	cond, ifMatch, ifNoneMatch, err := b.writeConditions(ctx, dstKey, opts.IfMatch, opts.IfNoneMatch)
+	if err != nil {
+		return err
+	}
+	// The version is copied along with the blob.
+	errs := b.each(func(i int) error {
+		ropts := *opts
+		ropts.IfMatch, ropts.IfNoneMatch = "", ""
+		if i == cond {
+			ropts.IfMatch, ropts.IfNoneMatch = ifMatch, ifNoneMatch
+		}
+		if i > 0 {
+			// BeforeCopy must be called once.
+			ropts.BeforeCopy = nil
+		}
+		return b.replicas[i].Copy(ctx, dstKey, srcKey, &ropts)
+	})
+	if err := b.notFound(errs); err != nil {
+		return err
+	}
+	return b.settle(ctx, dstKey, cond, errs, func(int, error) bool { return false })
+}
+
+func (b *replicatedBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	cond, ifMatch, _, err := b.conditions(ctx, key, opts.IfMatch, "")
+	if err != nil {
+		return err
+	}
+	errs := b.each(func(i int) error {
+		ropts := *opts
+		ropts.IfMatch = ""
+		if i == cond {
+			ropts.IfMatch = ifMatch
+		}
+		return b.replicas[i].Delete(ctx, key, &ropts)
+	})
+	if err := b.notFound(errs); err != nil {
+		return err
+	}
+	// Replicas that didn't have the blob are fine.
+	return b.settle(ctx, key, cond, errs, func(i int, err error) bool {
+		return b.replicas[i].ErrorCode(err) == gdkerr.NotFound
+	})
+}
+
+// notFound returns an error if all the replicas that didn't fail returned
+// NotFound, and at least one did.
+func (b *replicatedBucket) notFound(errs []error) error {
+	var notFound error
+	for i, err := range errs {
+		if err == nil {
+			return nil
+		}
+		switch b.replicas[i].ErrorCode(err) {
+		case gdkerr.NotFound:
+			notFound = err
+		case gdkerr.FailedPrecondition, gdkerr.InvalidArgument:
+			return nil
+		}
+	}
+	return notFound
+}
+
+func (b *replicatedBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
+	return "", errReplicatedSignedURL
+}
+
+func (b *replicatedBucket) Close() error {
+	b.cancel()
+	b.done.Wait()
+	var err error
+	for _, r := range b.replicas {
+		if rerr := r.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// ReplicationStatus implements Replicator.
+func (b *replicatedBucket) ReplicationStatus(ctx context.Context, key string) ([]*ReplicaStatus, error) {
+	statuses := make([]*ReplicaStatus, len(b.replicas))
+	errs := b.each(func(i int) error {
+		s := &ReplicaStatus{}
+		statuses[i] = s
+		attrs, err := b.replicas[i].Attributes(ctx, key)
+		if err == nil {
+			s.Exists, s.ETag = true, replicaETag(key, attrs)
+		} else if b.replicas[i].ErrorCode(err) != gdkerr.NotFound {
+			s.Err = err
+		}
+		return ctx.Err()
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r := b.pending[key]; r != nil {
+		for i := range r.targets {
+			statuses[i].Pending = true
+			if statuses[i].Err == nil {
+				statuses[i].Err = r.err
+			}
+		}
+	}
+	return statuses, nil
+}
+
+func newReplicaVersion() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// enqueue queues a repair of the blob for key in targets, from source; if
+// targets is nil, all the other replicas are repaired.
+func (b *replicatedBucket) enqueue(key string, source int, targets []int) {
+	if targets == nil {
+		for i := range b.replicas {
+			targets = append(targets, i)
+		}
+	}
+	b.mu.Lock()
+	r := b.pending[key]
+	if r == nil {
+		r = &replicaRepair{targets: map[int]bool{}}
+		b.pending[key] = r
+		b.queue = append(b.queue, key)
+	}
+	r.source = source
+	for _, i := range targets {
+		r.targets[i] = true
+	}
+	delete(r.targets, source)
+	r.gen++
+	b.mu.Unlock()
+	b.signal()
+}
+
+// signal wakes up the repair loop.
+func (b *replicatedBucket) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// repairLoop repairs the queued keys until the bucket is closed.
+func (b *replicatedBucket) repairLoop() {
+	defer b.done.Done()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-b.wake:
+		}
+		for {
+			b.mu.Lock()
+			if len(b.queue) == 0 {
+				b.mu.Unlock()
+				break
+			}
+			key := b.queue[0]
+			b.queue = b.queue[1:]
+			b.mu.Unlock()
+			b.repair(key)
+		}
+	}
+}
+
+// repair repairs the replicas of the blob for key that need it.
+func (b *replicatedBucket) repair(key string) {
+	b.mu.Lock()
+	r := b.pending[key]
+	if r == nil {
+		b.mu.Unlock()
+		return
+	}
+	source, gen := r.source, r.gen
+	var targets []int
+	for i := range r.targets {
+		targets = append(targets, i)
+	}
+	b.mu.Unlock()
+
+	var err error
+	var repaired []int
+	for _, i := range targets {
+		if rerr := b.repairReplica(key, source, i); rerr != nil {
+			err = rerr
+		} else {
+			repaired = append(repaired, i)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.gen == gen {
+		for _, i := range repaired {
+			delete(r.targets, i)
+		}
+	}
+	switch {
+	case len(r.targets) == 0:
+		delete(b.pending, key)
+	case r.gen != gen:
+		// The repair changed while it was done.
+		b.queue = append(b.queue, key)
+		b.signal()
+	default:
+		r.err = err
+		time.AfterFunc(b.opts.RepairRetryInterval, func() {
+			b.mu.Lock()
+			b.queue = append(b.queue, key)
+			b.mu.Unlock()
+			b.signal()
+		})
+	}
+}
+
+// repairReplica makes the blob for key in replica target the same as in
+// replica source.
+func (b *replicatedBucket) repairReplica(key string, source, target int) error {
+	ctx := b.ctx
+	src, dst := b.replicas[source], b.replicas[target]
+	sattrs, err := src.Attributes(ctx, key)
+	if err != nil && src.ErrorCode(err) != gdkerr.NotFound {
+		return err
+	}
+	dattrs, derr := dst.Attributes(ctx, key)
+	if derr != nil && dst.ErrorCode(derr) != gdkerr.NotFound {
+		return derr
+	}
+	if err != nil {
+		if derr != nil {
+			return nil
+		}
+		// Don't delete a blob that was written since.
+		err := dst.Delete(ctx, key, &DeleteOptions{IfMatch: dattrs.ETag})
+		if err != nil && dst.ErrorCode(err) != gdkerr.NotFound && dst.ErrorCode(err) != gdkerr.FailedPrecondition {
+			return err
+		}
+		return nil
+	}
+	if derr == nil && replicaETag(key, dattrs) == replicaETag(key, sattrs) {
+		return nil
+	}
+
+	r, err := src.NewRangeReader(ctx, key, 0, -1, &ReaderOptions{IfMatch: sattrs.ETag})
+	if err != nil {
+		if src.ErrorCode(err) == gdkerr.FailedPrecondition {
+			// The source changed, and will be repaired from again.
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+	// Don't overwrite a blob that was written since, and cancel the write
+	// if the copy fails, so that nothing is written.
+	wopts := &WriterOptions{
+		CacheControl:       sattrs.CacheControl,
+		ContentDisposition: sattrs.ContentDisposition,
+		ContentEncoding:    sattrs.ContentEncoding,
+		ContentLanguage:    sattrs.ContentLanguage,
+		Metadata:           sattrs.Metadata,
+		Tags:               sattrs.Tags,
+		IfNoneMatch:        "*",
+	}
+	if derr == nil {
+		wopts.IfMatch, wopts.IfNoneMatch = dattrs.ETag, ""
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := dst.NewTypedWriter(ctx, key, sattrs.ContentType, wopts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil && dst.ErrorCode(err) != gdkerr.FailedPrecondition {
+		return err
+	}
+	return nil
+}
+
+// replicatedWriter writes to the writers of all the replicas.
+type replicatedWriter struct {
+	ctx     context.Context
+	b       *replicatedBucket
+	key     string
+	cond    int
+	writers []Writer // nil for the replicas that failed.
+	cancels []func()
+	errs    []error
+}
+
+// quorum returns an error if too many replicas failed for the write to
+// succeed.
+func (w *replicatedWriter) quorum() error {
+	var n int
+	var err error
+	for i, werr := range w.errs {
+		if werr == nil {
+			n++
+		} else if err == nil || i == w.cond {
+			err = werr
+		}
+	}
+	if n < w.b.opts.WriteQuorum || (w.cond >= 0 && w.errs[w.cond] != nil) {
+		return err
+	}
+	return nil
+}
+
+// abort aborts the writes that haven't failed.
+func (w *replicatedWriter) abort() {
+	for i, rw := range w.writers {
+		w.cancels[i]()
+		if rw != nil && w.errs[i] == nil {
+			rw.Close()
+		}
+	}
+}
+
+func (w *replicatedWriter) Write(p []byte) (int, error) {
+	w.b.each(func(i int) error {
+		if w.errs[i] != nil {
+			return nil
+		}
+		if _, err := w.writers[i].Write(p); err != nil {
+			w.errs[i] = err
+			// Abort the write.
+			w.cancels[i]()
+			w.writers[i].Close()
+		}
+		return nil
+	})
+	if err := w.quorum(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *replicatedWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		// The portable type cancels the context to abort the write.
+		w.abort()
+		return err
+	}
+	// The writes that already failed were aborted; settle counts them.
+	failedEarly := make([]bool, len(w.errs))
+	for i, err := range w.errs {
+		failedEarly[i] = err != nil
+	}
+	w.b.each(func(i int) error {
+		if !failedEarly[i] {
+			w.errs[i] = w.writers[i].Close()
+		}
+		w.cancels[i]()
+		return nil
+	})
+	return w.b.settle(w.ctx, w.key, w.cond, w.errs, func(int, error) bool { return false })
+}
+
+// replicaReader is a Reader from a replica, with the ETag of the replicated
+// bucket.
+type replicaReader struct {
+	Reader
+	attrs ReaderAttributes
+}
+
+func (r *replicaReader) Attributes() *ReaderAttributes { return &r.attrs }
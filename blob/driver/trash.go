@@ -0,0 +1,234 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// DefaultTrashPrefix is the prefix of the keys of trashed blobs used when
+// none is given to NewTrashBucket.
+const DefaultTrashPrefix = ".gdk-trash/"
+
+// trashTimeLen is the length of the deletion time at the end of the keys of
+// trashed blobs, in nanoseconds since the Unix epoch, padded with zeros so
+// that the keys of a blob sort in order of deletion.
+const trashTimeLen = 20
+
+var (
+	errTrashNotFound = gdkerr.Newf(gdkerr.NotFound, nil, "blob: blob not found")
+	errTrashDelete   = gdkerr.Newf(gdkerr.Unimplemented, nil, "blob: signed URLs for DELETE are not supported for trash buckets")
+
+	errTrashPreconditionFailed = gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: precondition failed")
+)
+
+// trashBucket implements Bucket by copying blobs under prefix before
+// deleting them.
+//
+// A blob deleted at time t is kept at prefix+key+"@"+t, t being formatted
+// as trashTimeLen digits; the time is part of the key, rather than the
+// metadata, because Copy can't change metadata.
+type trashBucket struct {
+	base   Bucket
+	prefix string
+}
+
+// NewTrashBucket returns a Bucket that moves blobs under prefix when they are
+// deleted, instead of deleting them, using Copy and Delete on b. The blobs
+// under prefix are hidden, and are restored and purged with the SoftDeleter
+// methods. If prefix is empty, DefaultTrashPrefix is used.
+//
+// SignedURL is not supported for DELETE URLs, since requests to them would
+// delete blobs permanently.
+func NewTrashBucket(b Bucket, prefix string) Bucket {
+	if prefix == "" {
+		prefix = DefaultTrashPrefix
+	}
+	return &trashBucket{base: b, prefix: prefix}
+}
+
+func (b *trashBucket) ErrorCode(err error) gdkerr.ErrorCode { return b.base.ErrorCode(err) }
+
+func (b *trashBucket) As(i interface{}) bool { return b.base.As(i) }
+
+func (b *trashBucket) ErrorAs(err error, i interface{}) bool { return b.base.ErrorAs(err, i) }
+
+// trashed reports whether key is reserved for trashed blobs.
+func (b *trashBucket) trashed(key string) bool { return strings.HasPrefix(key, b.prefix) }
+
+func (b *trashBucket) reserved(key string) error {
+	return gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: key %q is reserved for trashed blobs", key)
+}
+
+// trashKey returns the key of the blob for key deleted at t.
+func (b *trashBucket) trashKey(key string, t time.Time) string {
+	return fmt.Sprintf("%s%s@%0*d", b.prefix, key, trashTimeLen, t.UnixNano())
+}
+
+// deletedAt returns the deletion time of the trashed blob at trashKey, or
+// false if it is not one.
+func (b *trashBucket) deletedAt(trashKey string) (time.Time, bool) {
+	i := len(trashKey) - trashTimeLen - 1
+	if i < len(b.prefix) || trashKey[i] != '@' {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(trashKey[i+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+func (b *trashBucket) Attributes(ctx context.Context, key string) (*Attributes, error) {
+	if b.trashed(key) {
+		return nil, errTrashNotFound
+	}
+	return b.base.Attributes(ctx, key)
+}
+
+func (b *trashBucket) ListPaged(ctx context.Context, opts *ListOptions) (*ListPage, error) {
+	// Fill pages despite the trashed blobs left out.
+	ropts := *opts
+	page := &ListPage{}
+	for {
+		if opts.PageSize > 0 {
+			ropts.PageSize = opts.PageSize - len(page.Objects)
+		}
+		rpage, err := b.base.ListPaged(ctx, &ropts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range rpage.Objects {
+			if !b.trashed(obj.Key) {
+				page.Objects = append(page.Objects, obj)
+			}
+		}
+		page.NextPageToken = rpage.NextPageToken
+		if len(page.NextPageToken) == 0 || (opts.PageSize > 0 && len(page.Objects) >= opts.PageSize) {
+			return page, nil
+		}
+		ropts.PageToken = page.NextPageToken
+		// BeforeList must be called once.
+		ropts.BeforeList = nil
+	}
+}
+
+func (b *trashBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error) {
+	if b.trashed(key) {
+		return nil, errTrashNotFound
+	}
+	return b.base.NewRangeReader(ctx, key, offset, length, opts)
+}
+
+func (b *trashBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *WriterOptions) (Writer, error) {
+	if b.trashed(key) {
+		return nil, b.reserved(key)
+	}
+	return b.base.NewTypedWriter(ctx, key, contentType, opts)
+}
+
+func (b *trashBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error {
+	if b.trashed(dstKey) {
+		return b.reserved(dstKey)
+	}
+	if b.trashed(srcKey) {
+		return errTrashNotFound
+	}
+	return b.base.Copy(ctx, dstKey, srcKey, opts)
+}
+
+func (b *trashBucket) Delete(ctx context.Context, key string, opts *DeleteOptions) error {
+	if b.trashed(key) {
+		return b.reserved(key)
+	}
+	attrs, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !MatchesPreconditions(true, attrs.ETag, opts.IfMatch, "") {
+		return errTrashPreconditionFailed
+	}
+	trashKey := b.trashKey(key, time.Now())
+	if err := b.base.Copy(ctx, trashKey, key, &CopyOptions{}); err != nil {
+		return err
+	}
+	// Only delete the blob that was copied.
+	if err := b.base.Delete(ctx, key, &DeleteOptions{IfMatch: attrs.ETag}); err != nil {
+		b.base.Delete(ctx, trashKey, &DeleteOptions{})
+		return err
+	}
+	return nil
+}
+
+func (b *trashBucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error) {
+	if b.trashed(key) {
+		return "", b.reserved(key)
+	}
+	if opts.Method == http.MethodDelete {
+		// Requests to the URL would delete the blob permanently.
+		return "", errTrashDelete
+	}
+	return b.base.SignedURL(ctx, key, opts)
+}
+
+func (b *trashBucket) Close() error { return b.base.Close() }
+
+// Restore implements SoftDeleter.
+func (b *trashBucket) Restore(ctx context.Context, key string) error {
+	if b.trashed(key) {
+		return b.reserved(key)
+	}
+	// The last trashed blob for key sorts last.
+	var last string
+	opts := &ListOptions{Prefix: b.prefix + key + "@", PageSize: 1000}
+	for {
+		page, err := b.base.ListPaged(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Objects {
+			if _, ok := b.deletedAt(obj.Key); ok && len(obj.Key) == len(opts.Prefix)+trashTimeLen {
+				last = obj.Key
+			}
+		}
+		if len(page.NextPageToken) == 0 {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	if last == "" {
+		return errTrashNotFound
+	}
+	if err := b.base.Copy(ctx, key, last, &CopyOptions{IfNoneMatch: "*"}); err != nil {
+		return err
+	}
+	return b.base.Delete(ctx, last, &DeleteOptions{})
+}
+
+// Purge implements SoftDeleter.
+func (b *trashBucket) Purge(ctx context.Context, before time.Time) error {
+	opts := &ListOptions{Prefix: b.prefix, PageSize: 1000}
+	for {
+		page, err := b.base.ListPaged(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Objects {
+			if t, ok := b.deletedAt(obj.Key); !ok || !t.Before(before) {
+				continue
+			}
+			if err := b.base.Delete(ctx, obj.Key, &DeleteOptions{}); err != nil && b.base.ErrorCode(err) != gdkerr.NotFound {
+				return err
+			}
+		}
+		if len(page.NextPageToken) == 0 {
+			return nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
@@ -236,6 +236,24 @@ func RunConformanceTests(t *testing.T, newHarness HarnessMaker, asTests []AsTest
 	t.Run("TestDelete", func(t *testing.T) {
 		testDelete(t, newHarness)
 	})
+	t.Run("TestConditional", func(t *testing.T) {
+		testConditional(t, newHarness)
+	})
+	t.Run("TestDeleteMany", func(t *testing.T) {
+		testDeleteMany(t, newHarness)
+	})
+	t.Run("TestResumableUpload", func(t *testing.T) {
+		testResumableUpload(t, newHarness)
+	})
+	t.Run("TestCompose", func(t *testing.T) {
+		testCompose(t, newHarness)
+	})
+	t.Run("TestTags", func(t *testing.T) {
+		testTags(t, newHarness)
+	})
+	t.Run("TestWatch", func(t *testing.T) {
+		testWatch(t, newHarness)
+	})
 	t.Run("TestKeys", func(t *testing.T) {
 		testKeys(t, newHarness)
 	})
@@ -255,6 +273,15 @@ func RunConformanceTests(t *testing.T, newHarness HarnessMaker, asTests []AsTest
 	})
 }
 
+// RunVersioningTests runs tests of versioning for driver implementations of
+// blob that implement driver.Versioner. newHarness must make drivers for
+// buckets with versioning enabled.
+func RunVersioningTests(t *testing.T, newHarness HarnessMaker) {
+	t.Run("TestVersioning", func(t *testing.T) {
+		testVersioning(t, newHarness)
+	})
+}
+
 // RunBenchmarks runs benchmarks for driver implementations of blob.
 func RunBenchmarks(b *testing.B, bkt *blob.Bucket) {
 	b.Run("BenchmarkRead", func(b *testing.B) {
@@ -1922,6 +1949,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			a.CreateTime = time.Time{}
 			a.ModTime = time.Time{}
 			a.ETag = ""
+			a.VersionID = ""
 		}
 		clearUncomparableFields(wantAttr)
 
@@ -2047,6 +2075,807 @@ func testDelete(t *testing.T, newHarness HarnessMaker) {
 	})
 }
 
+// testConditional tests the IfMatch and IfNoneMatch preconditions on reads,
+// writes, copies and deletes.
+func testConditional(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key    = "blob-for-conditional"
+		dstKey = "blob-for-conditional-dst"
+		other  = "\"not-the-etag\""
+	)
+	ctx := context.Background()
+
+	// init creates a bucket with key written, and returns the bucket and the
+	// ETag of key.
+	init := func(t *testing.T) (*blob.Bucket, string, func()) {
+		h, err := newHarness(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv, err := h.MakeDriver(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := blob.NewBucket(drv)
+		if err := b.WriteAll(ctx, key, []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ETag == "" {
+			t.Skip("ETags not supported")
+		}
+		done := func() {
+			_ = b.Delete(ctx, key)
+			_ = b.Delete(ctx, dstKey)
+			_ = b.Close()
+			h.Close()
+		}
+		return b, attrs.ETag, done
+	}
+
+	checkErr := func(t *testing.T, op string, err error, wantFail bool) {
+		t.Helper()
+		if wantFail {
+			if err == nil {
+				t.Errorf("%s: got nil error, want FailedPrecondition", op)
+			} else if gdkerr.Code(err) != gdkerr.FailedPrecondition {
+				t.Errorf("%s: got %v, want FailedPrecondition", op, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: got unexpected error %v", op, err)
+		}
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		b, eTag, done := init(t)
+		defer done()
+
+		tests := []struct {
+			ifMatch, ifNoneMatch string
+			wantFail             bool
+		}{
+			{ifMatch: eTag},
+			{ifMatch: "*"},
+			{ifMatch: other, wantFail: true},
+			{ifNoneMatch: other},
+			{ifNoneMatch: eTag, wantFail: true},
+			{ifNoneMatch: "*", wantFail: true},
+		}
+		for _, test := range tests {
+			op := fmt.Sprintf("read IfMatch=%q IfNoneMatch=%q", test.ifMatch, test.ifNoneMatch)
+			r, err := b.NewReader(ctx, key, &blob.ReaderOptions{IfMatch: test.ifMatch, IfNoneMatch: test.ifNoneMatch})
+			if err == nil {
+				r.Close()
+			}
+			checkErr(t, op, err, test.wantFail)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		b, eTag, done := init(t)
+		defer done()
+
+		// Writing a new blob with IfNoneMatch "*" works once.
+		opts := &blob.WriterOptions{IfNoneMatch: "*"}
+		checkErr(t, "create", b.WriteAll(ctx, dstKey, []byte("new"), opts), false)
+		checkErr(t, "create again", b.WriteAll(ctx, dstKey, []byte("newer"), opts), true)
+		if got, _ := b.ReadAll(ctx, dstKey); string(got) != "new" {
+			t.Errorf("after failed create, got %q want %q", got, "new")
+		}
+
+		// IfMatch on a blob that doesn't exist fails.
+		opts = &blob.WriterOptions{IfMatch: "*"}
+		checkErr(t, "IfMatch missing", b.WriteAll(ctx, "does-not-exist", []byte("x"), opts), true)
+		if exists, _ := b.Exists(ctx, "does-not-exist"); exists {
+			t.Errorf("IfMatch missing: blob was created")
+			_ = b.Delete(ctx, "does-not-exist")
+		}
+
+		// A stale ETag fails and leaves the blob alone.
+		opts = &blob.WriterOptions{IfMatch: other}
+		checkErr(t, "IfMatch stale", b.WriteAll(ctx, key, []byte("stale write"), opts), true)
+		if got, _ := b.ReadAll(ctx, key); string(got) != "hello" {
+			t.Errorf("after failed write, got %q want %q", got, "hello")
+		}
+
+		// The current ETag works.
+		opts = &blob.WriterOptions{IfMatch: eTag}
+		checkErr(t, "IfMatch current", b.WriteAll(ctx, key, []byte("hello world"), opts), false)
+		if got, _ := b.ReadAll(ctx, key); string(got) != "hello world" {
+			t.Errorf("after write, got %q want %q", got, "hello world")
+		}
+
+		// The old ETag is now stale.
+		opts = &blob.WriterOptions{IfMatch: eTag}
+		checkErr(t, "IfMatch old", b.WriteAll(ctx, key, []byte("again"), opts), true)
+	})
+
+	t.Run("Copy", func(t *testing.T) {
+		b, eTag, done := init(t)
+		defer done()
+
+		opts := &blob.CopyOptions{IfNoneMatch: "*"}
+		checkErr(t, "copy to new", b.Copy(ctx, dstKey, key, opts), false)
+		checkErr(t, "copy to existing", b.Copy(ctx, dstKey, key, opts), true)
+
+		// The conditions apply to the destination, not the source.
+		opts = &blob.CopyOptions{IfMatch: eTag}
+		if err := b.WriteAll(ctx, dstKey, []byte("different"), nil); err != nil {
+			t.Fatal(err)
+		}
+		checkErr(t, "copy IfMatch source ETag", b.Copy(ctx, dstKey, key, opts), true)
+		dstAttrs, err := b.Attributes(ctx, dstKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = &blob.CopyOptions{IfMatch: dstAttrs.ETag}
+		checkErr(t, "copy IfMatch dst ETag", b.Copy(ctx, dstKey, key, opts), false)
+		if got, _ := b.ReadAll(ctx, dstKey); string(got) != "hello" {
+			t.Errorf("after copy, got %q want %q", got, "hello")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		b, eTag, done := init(t)
+		defer done()
+
+		checkErr(t, "delete stale", b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{IfMatch: other}), true)
+		if exists, _ := b.Exists(ctx, key); !exists {
+			t.Errorf("blob was deleted despite failed precondition")
+		}
+		checkErr(t, "delete current", b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{IfMatch: eTag}), false)
+		if exists, _ := b.Exists(ctx, key); exists {
+			t.Errorf("blob still exists after delete")
+		}
+		// A missing blob is still NotFound.
+		err := b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{IfMatch: eTag})
+		if gdkerr.Code(err) != gdkerr.NotFound {
+			t.Errorf("delete missing: got %v want NotFound", err)
+		}
+	})
+}
+
+// testDeleteMany tests DeleteMany and DeletePrefix.
+func testDeleteMany(t *testing.T, newHarness HarnessMaker) {
+	const prefix = "blob-for-delete-many/"
+	ctx := context.Background()
+
+	// init creates a bucket with some blobs under prefix, and one outside of it.
+	init := func(t *testing.T) (*blob.Bucket, []string, func()) {
+		h, err := newHarness(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv, err := h.MakeDriver(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := blob.NewBucket(drv)
+		var keys []string
+		for i := 0; i < 5; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			if err := b.WriteAll(ctx, key, []byte("hello"), nil); err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, key)
+		}
+		if err := b.WriteAll(ctx, "blob-for-delete-many-other", []byte("hello"), nil); err != nil {
+			t.Fatal(err)
+		}
+		return b, keys, func() {
+			_ = b.Delete(ctx, "blob-for-delete-many-other")
+			_, _ = b.DeletePrefix(ctx, prefix)
+			b.Close()
+			h.Close()
+		}
+	}
+
+	t.Run("DeleteMany", func(t *testing.T) {
+		b, keys, done := init(t)
+		defer done()
+
+		// Delete all but the last blob, plus a key that doesn't exist and a
+		// duplicate.
+		del := append([]string{prefix + "does-not-exist", keys[0]}, keys[:len(keys)-1]...)
+		failed, err := b.DeleteMany(ctx, del)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failed) != 0 {
+			t.Errorf("got failures %v want none", failed)
+		}
+		for i, key := range keys {
+			exists, err := b.Exists(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := i == len(keys)-1; exists != want {
+				t.Errorf("%s: got exists %v want %v", key, exists, want)
+			}
+		}
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		b, _, done := init(t)
+		defer done()
+
+		failed, err := b.DeletePrefix(ctx, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failed) != 0 {
+			t.Errorf("got failures %v want none", failed)
+		}
+		iter := b.List(&blob.ListOptions{Prefix: prefix})
+		if obj, err := iter.Next(ctx); err != io.EOF {
+			t.Errorf("after DeletePrefix, got %v, %v want io.EOF", obj, err)
+		}
+		if exists, _ := b.Exists(ctx, "blob-for-delete-many-other"); !exists {
+			t.Errorf("DeletePrefix deleted a blob outside of the prefix")
+		}
+	})
+}
+
+// testResumableUpload tests resuming and aborting resumable uploads.
+func testResumableUpload(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key     = "blob-for-resumable-upload"
+		content = "hello resumable world"
+	)
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	// hasSession reports whether token is listed as a session for key.
+	hasSession := func(token string) bool {
+		sessions, err := b.ListUploadSessions(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range sessions {
+			if s.Token == token {
+				if s.Key != key {
+					t.Errorf("session %q: got key %q want %q", token, s.Key, key)
+				}
+				return true
+			}
+		}
+		return false
+	}
+
+	// Start a session, then lose the writer by canceling it.
+	wctx, cancel := context.WithCancel(ctx)
+	w, err := b.NewWriter(wctx, key, &blob.WriterOptions{
+		Resumable:   true,
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"foo": "bar"},
+	})
+	if gdkerr.Code(err) == gdkerr.Unimplemented {
+		t.Skip("resumable uploads not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := w.SessionToken()
+	if token == "" {
+		t.Fatal("got empty SessionToken")
+	}
+	if _, err := io.WriteString(w, content[:6]); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := w.Close(); err == nil {
+		t.Error("got nil error closing a canceled writer")
+	}
+	if !hasSession(token) {
+		t.Fatal("session was not listed")
+	}
+
+	// Resume it and finish the upload.
+	w, err = b.NewWriter(ctx, key, &blob.WriterOptions{SessionToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed := w.Committed()
+	if committed < 0 || committed > 6 {
+		t.Fatalf("got Committed %d want between 0 and 6", committed)
+	}
+	if _, err := io.WriteString(w, content[committed:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.ReadAll(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("got %q want %q", got, content)
+	}
+	if hasSession(token) {
+		t.Error("completed session is still listed")
+	}
+	_, err = b.NewWriter(ctx, key, &blob.WriterOptions{SessionToken: token})
+	if gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("resuming a completed session: got %v want NotFound", err)
+	}
+
+	// Abort a session.
+	w, err = b.NewWriter(ctx, key, &blob.WriterOptions{Resumable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = w.SessionToken()
+	if _, err := io.WriteString(w, "aborted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AbortUploadSession(ctx, key, token); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil {
+		t.Error("got nil error closing the writer of an aborted session")
+	}
+	if hasSession(token) {
+		t.Error("aborted session is still listed")
+	}
+	if err := b.AbortUploadSession(ctx, key, token); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("aborting an aborted session: got %v want NotFound", err)
+	}
+	if got, _ := b.ReadAll(ctx, key); string(got) != content {
+		t.Errorf("after abort, got %q want %q", got, content)
+	}
+
+	// A BufferSize below the smallest part the service accepts still works.
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	w, err = b.NewWriter(ctx, key, &blob.WriterOptions{Resumable: true, BufferSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(large); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing a writer with a small BufferSize: %v", err)
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, large) {
+		t.Errorf("with a small BufferSize, got %d bytes want %d", len(got), len(large))
+	}
+}
+
+// testCompose tests concatenating blobs, which the portable type does by
+// streaming them for drivers that can't compose them.
+func testCompose(t *testing.T, newHarness HarnessMaker) {
+	const prefix = "blob-for-compose/"
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+	defer func() { _, _ = b.DeletePrefix(ctx, prefix) }()
+
+	write := func(key, content string) {
+		t.Helper()
+		opts := &blob.WriterOptions{ContentType: "text/plain"}
+		if err := b.WriteAll(ctx, prefix+key, []byte(content), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(key, want string) *blob.Attributes {
+		t.Helper()
+		got, err := b.ReadAll(ctx, prefix+key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q want %q", key, got, want)
+		}
+		attrs, err := b.Attributes(ctx, prefix+key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attrs
+	}
+	keys := func(keys ...string) []string {
+		for i, key := range keys {
+			keys[i] = prefix + key
+		}
+		return keys
+	}
+	write("a", "hello ")
+	write("b", "world")
+	write("empty", "")
+
+	if err := b.Compose(ctx, prefix+"ab", keys("a", "b", "empty", "a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The content type is the one of the first source.
+	if attrs := check("ab", "hello worldhello "); !strings.HasPrefix(attrs.ContentType, "text/plain") {
+		t.Errorf("got content type %q want text/plain", attrs.ContentType)
+	}
+
+	// The destination can be one of the sources.
+	opts := &blob.ComposeOptions{
+		ContentType: "application/octet-stream",
+		Metadata:    map[string]string{"Foo": "bar"},
+	}
+	if err := b.Compose(ctx, prefix+"a", keys("a", "b"), opts); err != nil {
+		t.Fatal(err)
+	}
+	attrs := check("a", "hello world")
+	if attrs.ContentType != "application/octet-stream" {
+		t.Errorf("got content type %q want application/octet-stream", attrs.ContentType)
+	}
+	if diff := cmp.Diff(map[string]string{"foo": "bar"}, attrs.Metadata); diff != "" {
+		t.Errorf("metadata: %s", diff)
+	}
+
+	if err := b.Compose(ctx, prefix+"ab", keys("a"), &blob.ComposeOptions{IfNoneMatch: "*"}); gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		t.Errorf("Compose over an existing blob with IfNoneMatch: got %v want FailedPrecondition", err)
+	}
+	if err := b.Compose(ctx, prefix+"new", keys("a", "missing"), nil); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("Compose of a missing blob: got %v want NotFound", err)
+	}
+	if exists, err := b.Exists(ctx, prefix+"new"); err != nil || exists {
+		t.Errorf("failed Compose wrote the destination: exists %v, err %v", exists, err)
+	}
+	if err := b.Compose(ctx, prefix+"new", nil, nil); gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("Compose without sources: got %v want InvalidArgument", err)
+	}
+	if err := b.Compose(ctx, prefix+"new", keys("a"), &blob.ComposeOptions{Metadata: map[string]string{"": "x"}}); gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("Compose with an empty metadata key: got %v want InvalidArgument", err)
+	}
+}
+
+// testTags tests writing blobs with tags, and changing them with SetTags.
+func testTags(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-tags"
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+	defer func() { _ = b.Delete(ctx, key) }()
+
+	check := func(want map[string]string) {
+		t.Helper()
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, attrs.Tags); diff != "" {
+			t.Errorf("tags: %s", diff)
+		}
+	}
+	tags := map[string]string{"Team": "infra", "ttl": ""}
+	err = b.WriteAll(ctx, key, []byte("hello"), &blob.WriterOptions{Tags: tags})
+	if gdkerr.Code(err) == gdkerr.Unimplemented {
+		t.Skip("tags not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(tags)
+
+	// Changing the tags doesn't change the blob.
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.SetTags(ctx, key, map[string]string{"team": "web"})
+	if gdkerr.Code(err) == gdkerr.Unimplemented {
+		t.Skip("changing tags not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"team": "web"})
+	if got, err := b.Attributes(ctx, key); err != nil {
+		t.Fatal(err)
+	} else if got.ETag != attrs.ETag {
+		t.Errorf("SetTags changed the ETag from %q to %q", attrs.ETag, got.ETag)
+	}
+	if err := b.SetTags(ctx, key, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(nil)
+
+	if err := b.SetTags(ctx, key+"-missing", tags); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("SetTags of a missing blob: got %v want NotFound", err)
+	}
+	if err := b.SetTags(ctx, key, map[string]string{"": "x"}); gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("SetTags with an empty key: got %v want InvalidArgument", err)
+	}
+	if err := b.WriteAll(ctx, key, nil, &blob.WriterOptions{Tags: map[string]string{"": "x"}}); gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("write with an empty tag key: got %v want InvalidArgument", err)
+	}
+}
+
+// testWatch tests watching changes to blobs, which drivers that don't
+// implement driver.Watcher get by polling.
+func testWatch(t *testing.T, newHarness HarnessMaker) {
+	const (
+		prefix = "blob-for-watch/"
+		other  = "blob-for-watch-other"
+	)
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+	defer func() {
+		_, _ = b.DeletePrefix(ctx, prefix)
+		_ = b.Delete(ctx, other)
+	}()
+
+	write := func(key, content string) {
+		t.Helper()
+		if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(prefix+"existing", "x")
+	write(other, "x")
+	s, err := b.Watch(ctx, prefix, &blob.WatchOptions{PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	type change struct {
+		Type blob.EventType
+		Key  string
+	}
+	next := func() change {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		e, err := s.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (e.Type == blob.EventDeleted) != (e.ETag == "" && e.Size == 0) {
+			t.Errorf("%s %s: got ETag %q, size %d", e.Type, e.Key, e.ETag, e.Size)
+		}
+		return change{e.Type, e.Key}
+	}
+	want := []change{
+		{blob.EventCreated, prefix + "sub/a"},
+		{blob.EventUpdated, prefix + "existing"},
+		{blob.EventDeleted, prefix + "existing"},
+	}
+	// Each change is waited for, so that none are merged.
+	write(other, "y")
+	write(prefix+"sub/a", "a")
+	if got := next(); got != want[0] {
+		t.Errorf("got %v want %v", got, want[0])
+	}
+	// Don't depend on the resolution of modification times.
+	time.Sleep(10 * time.Millisecond)
+	write(prefix+"existing", "updated")
+	if got := next(); got != want[1] {
+		t.Errorf("got %v want %v", got, want[1])
+	}
+	if err := b.Delete(ctx, prefix+"existing"); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != want[2] {
+		t.Errorf("got %v want %v", got, want[2])
+	}
+
+	// Nothing else happened.
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if e, err := s.Next(tctx); err == nil {
+		t.Errorf("got unexpected event %+v", e)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Next(ctx); gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		t.Errorf("Next after Close: got %v want FailedPrecondition", err)
+	}
+}
+
+// testVersioning tests listing, reading and deleting versions of a blob, and
+// restoring a previous version.
+func testVersioning(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-versioning"
+	ctx := context.Background()
+
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+	if !b.SupportsVersioning() {
+		t.Fatal("driver does not implement driver.Versioner")
+	}
+
+	// listVersions returns the versions of key, newest first.
+	listVersions := func() []*blob.ListObject {
+		t.Helper()
+		var objs []*blob.ListObject
+		iter := b.ListVersions(&blob.ListVersionsOptions{Prefix: key})
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obj.Key == key {
+				objs = append(objs, obj)
+			}
+		}
+		return objs
+	}
+	// readVersion returns the content of a version of key.
+	readVersion := func(versionID string) (string, error) {
+		r, err := b.NewReader(ctx, key, &blob.ReaderOptions{VersionID: versionID})
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+
+	// Write three versions.
+	contents := []string{"one", "two", "three"}
+	var versionIDs []string
+	for _, c := range contents {
+		if err := b.WriteAll(ctx, key, []byte(c), nil); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.VersionID == "" {
+			t.Fatal("got empty Attributes.VersionID")
+		}
+		versionIDs = append(versionIDs, attrs.VersionID)
+	}
+	defer func() {
+		for _, v := range listVersions() {
+			_ = b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{VersionID: v.VersionID})
+		}
+	}()
+
+	// ListVersions returns them newest first, with the latest flagged.
+	got := listVersions()
+	if len(got) != len(contents) {
+		t.Fatalf("got %d versions, want %d", len(got), len(contents))
+	}
+	for i, obj := range got {
+		want := versionIDs[len(versionIDs)-1-i]
+		if obj.VersionID != want {
+			t.Errorf("version %d: got VersionID %q want %q", i, obj.VersionID, want)
+		}
+		if obj.IsLatest != (i == 0) {
+			t.Errorf("version %d: got IsLatest %v", i, obj.IsLatest)
+		}
+	}
+
+	// Each version can be read.
+	for i, id := range versionIDs {
+		if got, err := readVersion(id); err != nil || got != contents[i] {
+			t.Errorf("read version %q: got %q, %v want %q", id, got, err, contents[i])
+		}
+	}
+	if _, err := readVersion("does-not-exist"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("read missing version: got %v want NotFound", err)
+	}
+
+	// Deleting the blob keeps its versions.
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := b.Exists(ctx, key); exists {
+		t.Error("blob still exists after Delete")
+	}
+	got = listVersions()
+	if len(got) != len(contents) {
+		t.Fatalf("after delete, got %d versions, want %d", len(got), len(contents))
+	}
+	for _, obj := range got {
+		if obj.IsLatest {
+			t.Errorf("after delete, version %q has IsLatest", obj.VersionID)
+		}
+	}
+
+	// Restore the first version by writing it back.
+	data, err := readVersion(versionIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, key, []byte(data), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := b.ReadAll(ctx, key); string(got) != contents[0] {
+		t.Errorf("after restore, got %q want %q", got, contents[0])
+	}
+
+	// Permanently delete a previous version.
+	if err := b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{VersionID: versionIDs[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readVersion(versionIDs[1]); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("read deleted version: got %v want NotFound", err)
+	}
+	err = b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{VersionID: versionIDs[1]})
+	if gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("delete deleted version: got %v want NotFound", err)
+	}
+	if got := listVersions(); len(got) != len(contents) {
+		t.Errorf("after deleting a version, got %d versions, want %d", len(got), len(contents))
+	}
+
+	// Permanently deleting the latest version makes the previous one latest.
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{VersionID: attrs.VersionID}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := b.ReadAll(ctx, key); string(got) != contents[2] {
+		t.Errorf("after deleting the latest version, got %q want %q", got, contents[2])
+	}
+	got = listVersions()
+	if len(got) != 2 || !got[0].IsLatest || got[0].VersionID != versionIDs[2] {
+		t.Errorf("after deleting the latest version, got versions %v", got)
+	}
+}
+
 // testConcurrentWriteAndRead tests that concurrent writing to multiple blob
 // keys and concurrent reading from multiple blob keys works.
 func testConcurrentWriteAndRead(t *testing.T, newHarness HarnessMaker) {
@@ -0,0 +1,112 @@
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/secrets/localsecrets"
+)
+
+func TestEncryptedBucket(t *testing.T) {
+	ctx := context.Background()
+	keeper := localsecrets.NewKeeper([32]byte{7})
+	defer keeper.Close()
+	dir := t.TempDir()
+	base, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.EncryptedBucket(base, keeper)
+	defer b.Close()
+	// raw is the same directory, unencrypted.
+	raw, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	data := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	opts := &blob.WriterOptions{ContentType: "application/x-test", Metadata: map[string]string{"k": "v"}}
+	if err := b.WriteAll(ctx, "key", data, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != int64(len(data)) || attrs.ContentType != "application/x-test" || len(attrs.Metadata) != 1 || attrs.Metadata["k"] != "v" {
+		t.Errorf("got attributes size %d, content type %q, metadata %v", attrs.Size, attrs.ContentType, attrs.Metadata)
+	}
+
+	// Ranges within a chunk, across chunks, and at the end.
+	for _, rng := range [][2]int64{{0, -1}, {10, 20}, {65530, 20}, {100000, 100000}, {int64(len(data)) - 5, 10}, {int64(len(data)), 1}} {
+		r, err := b.NewRangeReader(ctx, "key", rng[0], rng[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("range %v: %v", rng, err)
+		}
+		end := int64(len(data))
+		if rng[1] >= 0 && rng[0]+rng[1] < end {
+			end = rng[0] + rng[1]
+		}
+		if !bytes.Equal(got, data[rng[0]:end]) {
+			t.Errorf("range %v: got %d bytes that don't match", rng, len(got))
+		}
+	}
+
+	iter := b.List(nil)
+	obj, err := iter.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Size != int64(len(data)) {
+		t.Errorf("List: got size %d want %d", obj.Size, len(data))
+	}
+
+	// Blobs that weren't written through an encrypted bucket can't be read.
+	if err := raw.WriteAll(ctx, "plain", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "plain"); gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		t.Errorf("reading unencrypted blob: got %v want FailedPrecondition", err)
+	}
+
+	// Tampering with the ciphertext is detected.
+	rawAttrs, err := raw.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := raw.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, data[:64]) {
+		t.Error("the stored blob contains the plaintext")
+	}
+	ciphertext[70000] ^= 1
+	if err := raw.WriteAll(ctx, "key", ciphertext, &blob.WriterOptions{Metadata: rawAttrs.Metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); gdkerr.Code(err) != gdkerr.Internal {
+		t.Errorf("reading tampered blob: got %v want Internal", err)
+	}
+	if _, err := b.NewRangeReader(ctx, "key", 0, 100, nil); err != nil {
+		t.Errorf("reading untampered chunk: %v", err)
+	}
+
+	if _, err := b.SignedURL(ctx, "key", nil); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("SignedURL: got %v want Unimplemented", err)
+	}
+}
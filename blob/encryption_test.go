@@ -0,0 +1,119 @@
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestServerSideEncryption(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	key := bytes.Repeat([]byte{1}, 32)
+	otherKey := bytes.Repeat([]byte{2}, 32)
+	sum := md5.Sum(key)
+	customer := &blob.ServerSideEncryption{Mode: blob.EncryptionCustomerKey, CustomerKey: key}
+	kms := &blob.ServerSideEncryption{Mode: blob.EncryptionKMS, KeyID: "my-key"}
+
+	checkAttrs := func(key string, want *blob.ServerSideEncryption) {
+		t.Helper()
+		attrs, err := b.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, attrs.ServerSideEncryption); diff != "" {
+			t.Errorf("%s: encryption: %s", key, diff)
+		}
+	}
+	if err := b.WriteAll(ctx, "secret", []byte("hello"), &blob.WriterOptions{ServerSideEncryption: customer}); err != nil {
+		t.Fatal(err)
+	}
+	// The key itself is never reported.
+	checkAttrs("secret", &blob.ServerSideEncryption{Mode: blob.EncryptionCustomerKey, KeyID: base64.StdEncoding.EncodeToString(sum[:])})
+
+	read := func(sse *blob.ServerSideEncryption) error {
+		r, err := b.NewReader(ctx, "secret", &blob.ReaderOptions{ServerSideEncryption: sse})
+		if err != nil {
+			return err
+		}
+		return r.Close()
+	}
+	if err := read(customer); err != nil {
+		t.Errorf("read with the customer key: %v", err)
+	}
+	for _, sse := range []*blob.ServerSideEncryption{nil, {Mode: blob.EncryptionCustomerKey, CustomerKey: otherKey}} {
+		if err := read(sse); gdkerr.Code(err) != gdkerr.PermissionDenied {
+			t.Errorf("read with %+v: got %v want PermissionDenied", sse, err)
+		}
+	}
+
+	// Copies need the key of the source, and are encrypted as asked.
+	if err := b.Copy(ctx, "copy", "secret", &blob.CopyOptions{ServerSideEncryption: kms}); gdkerr.Code(err) != gdkerr.PermissionDenied {
+		t.Errorf("copy without the source key: got %v want PermissionDenied", err)
+	}
+	if err := b.Copy(ctx, "copy", "secret", &blob.CopyOptions{ServerSideEncryption: kms, SourceServerSideEncryption: customer}); err != nil {
+		t.Fatal(err)
+	}
+	checkAttrs("copy", kms)
+	// So do composed blobs.
+	srcs := []string{"secret", "secret"}
+	if err := b.Compose(ctx, "composed", srcs, &blob.ComposeOptions{ServerSideEncryption: kms}); gdkerr.Code(err) != gdkerr.PermissionDenied {
+		t.Errorf("compose without the source key: got %v want PermissionDenied", err)
+	}
+	if err := b.Compose(ctx, "composed", srcs, &blob.ComposeOptions{ServerSideEncryption: kms, SourceServerSideEncryption: customer}); err != nil {
+		t.Fatal(err)
+	}
+	checkAttrs("composed", kms)
+	if got, err := b.ReadAll(ctx, "composed"); err != nil || string(got) != "hellohello" {
+		t.Errorf("reading composed blob: got %q, %v want hellohello", got, err)
+	}
+	// Reads ignore modes other than customer keys.
+	r, err := b.NewReader(ctx, "copy", &blob.ReaderOptions{ServerSideEncryption: kms})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	for _, sse := range []*blob.ServerSideEncryption{
+		{},
+		{Mode: "other"},
+		{Mode: blob.EncryptionServiceKey, KeyID: "my-key"},
+		{Mode: blob.EncryptionKMS, CustomerKey: key},
+		{Mode: blob.EncryptionCustomerKey, CustomerKey: key[:16]},
+		{Mode: blob.EncryptionCustomerKey, CustomerKey: key, KeyID: "my-key"},
+	} {
+		if err := b.WriteAll(ctx, "invalid", nil, &blob.WriterOptions{ServerSideEncryption: sse}); gdkerr.Code(err) != gdkerr.InvalidArgument {
+			t.Errorf("write with %+v: got %v want InvalidArgument", sse, err)
+		}
+	}
+
+	// Drivers without server-side encryption.
+	fb, err := fileblob.OpenBucket(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fb.Close()
+	if err := fb.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{ServerSideEncryption: kms}); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("fileblob write: got %v want Unimplemented", err)
+	}
+	if err := fb.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fb.NewReader(ctx, "key", &blob.ReaderOptions{ServerSideEncryption: customer}); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("fileblob read: got %v want Unimplemented", err)
+	}
+	if err := fb.Compose(ctx, "composed", []string{"key"}, &blob.ComposeOptions{ServerSideEncryption: kms}); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("fileblob compose: got %v want Unimplemented", err)
+	}
+}
@@ -3,24 +3,36 @@ package fileblob
 import (
 	"encoding/json"
 	"fmt"
+	"hash"
 	"os"
+
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/gdkerr"
 )
 
 const attrsExt = ".attrs"
 
 var errAttrsExt = fmt.Errorf("file extension %q is reserved", attrsExt)
 
+// errNoSidecar is returned for attributes that can only be stored in the
+// ".attrs" sidecar files when they are not written.
+var errNoSidecar = gdkerr.Newf(gdkerr.Unimplemented, nil, "fileblob: tags and storage classes are stored in sidecar files, which the bucket doesn't write")
+
 // xattrs stores extended attributes for an object. The format is like
 // filesystem extended attributes, see
 // https://www.freedesktop.org/wiki/CommonExtendedAttributes.
 type xattrs struct {
-	CacheControl       string            `json:"user.cache_control"`
-	ContentDisposition string            `json:"user.content_disposition"`
-	ContentEncoding    string            `json:"user.content_encoding"`
-	ContentLanguage    string            `json:"user.content_language"`
-	ContentType        string            `json:"user.content_type"`
-	Metadata           map[string]string `json:"user.metadata"`
-	MD5                []byte            `json:"md5"`
+	CacheControl       string                              `json:"user.cache_control"`
+	ContentDisposition string                              `json:"user.content_disposition"`
+	ContentEncoding    string                              `json:"user.content_encoding"`
+	ContentLanguage    string                              `json:"user.content_language"`
+	ContentType        string                              `json:"user.content_type"`
+	Metadata           map[string]string                   `json:"user.metadata"`
+	Tags               map[string]string                   `json:"tags,omitempty"`
+	StorageClass       string                              `json:"storage_class,omitempty"`
+	MD5                []byte                              `json:"md5"`
+	Checksums          map[driver.ChecksumAlgorithm][]byte `json:"checksums,omitempty"`
+	VersionID          string                              `json:"version_id,omitempty"`
 }
 
 // setAttrs creates a "path.attrs" file along with blob to store the attributes,
@@ -59,3 +71,30 @@ func getAttrs(path string) (xattrs, error) {
 	}
 	return *xa, f.Close()
 }
+
+// checksumHashes returns hashes computing the checksums with the algorithms
+// of checksums, or nil if there are none.
+func checksumHashes(checksums map[driver.ChecksumAlgorithm][]byte) map[driver.ChecksumAlgorithm]hash.Hash {
+	var hashes map[driver.ChecksumAlgorithm]hash.Hash
+	for alg := range checksums {
+		if h, ok := driver.NewChecksumHash(alg); ok {
+			if hashes == nil {
+				hashes = map[driver.ChecksumAlgorithm]hash.Hash{}
+			}
+			hashes[alg] = h
+		}
+	}
+	return hashes
+}
+
+// checksumSums returns the checksums computed by hashes.
+func checksumSums(hashes map[driver.ChecksumAlgorithm]hash.Hash) map[driver.ChecksumAlgorithm][]byte {
+	if hashes == nil {
+		return nil
+	}
+	sums := make(map[driver.ChecksumAlgorithm][]byte, len(hashes))
+	for alg, h := range hashes {
+		sums[alg] = h.Sum(nil)
+	}
+	return sums
+}
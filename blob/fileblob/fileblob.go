@@ -7,7 +7,17 @@
 // writing of those metadata files can be suppressed by setting it to
 // 'MetadataDontWrite' or its equivalent "metadata=skip" in the URL for the opener.
 // In any case, absent any stored metadata many blob.Attributes fields
-// will be set to default values.
+// will be set to default values. Tags and storage classes (see
+// blob.WriterOptions.Tags and StorageClass) are only kept in the sidecar
+// files, so they are unsupported when those are not written.
+//
+// If Options.Versioning is set, previous versions of blobs are kept under the
+// ".versions" directory at the root of the bucket; keys starting with
+// ".versions/" are then reserved.
+//
+// Resumable uploads (see blob.WriterOptions.Resumable) are written to files
+// under the ".uploads" directory at the root of the bucket, which survive
+// restarts; keys starting with ".uploads/" are reserved.
 //
 // # URLs
 //
@@ -67,6 +77,10 @@ import (
 
 const defaultPageSize = 1000
 
+var errPreconditionFailed = errors.New("precondition failed")
+
+var errServerSideEncryption = gdkerr.Newf(gdkerr.Unimplemented, nil, "fileblob: server-side encryption is not supported")
+
 func init() {
 	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
 }
@@ -92,6 +106,7 @@ const Scheme = "file"
 //     see URLSignerHMAC
 //   - metadata: if set to "skip", won't write metadata such as blob.Attributes
 //     as per the package docstring
+//   - versioning: a boolean; if true, sets Options.Versioning
 //
 // If either of base_url / secret_key_path are provided, both must be.
 //
@@ -134,6 +149,7 @@ var recognizedParams = map[string]bool{
 	"base_url":        true,
 	"secret_key_path": true,
 	"metadata":        true,
+	"versioning":      true,
 }
 
 type metadataOption string // Not exported as subject to change.
@@ -172,6 +188,13 @@ func (o *URLOpener) forParams(ctx context.Context, q url.Values) (*Options, erro
 	if q.Get("create_dir") != "" {
 		opts.CreateDir = true
 	}
+	if v := q.Get("versioning"); v != "" {
+		versioning, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for query parameter 'versioning': %v", err)
+		}
+		opts.Versioning = versioning
+	}
 	baseURL := q.Get("base_url")
 	keyPath := q.Get("secret_key_path")
 	if (baseURL == "") != (keyPath == "") {
@@ -207,6 +230,11 @@ type Options struct {
 	// For supported values please see the Metadata* constants.
 	// If left unchanged, 'MetadataInSidecar' will be used.
 	Metadata metadataOption
+
+	// If true, keep the previous versions of blobs when they are overwritten
+	// or deleted; see blob.Bucket.ListVersions. Versioning requires metadata
+	// to be written, so it can't be combined with MetadataDontWrite.
+	Versioning bool
 }
 
 type bucket struct {
@@ -220,6 +248,9 @@ func openBucket(dir string, opts *Options) (driver.Bucket, error) {
 	if opts == nil {
 		opts = &Options{}
 	}
+	if opts.Versioning && opts.Metadata == MetadataDontWrite {
+		return nil, errors.New("fileblob.OpenBucket: Versioning requires metadata to be written")
+	}
 	absdir, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert %s into an absolute path: %v", dir, err)
@@ -304,6 +335,8 @@ func (b *bucket) ErrorCode(err error) gdkerr.ErrorCode {
 	switch {
 	case os.IsNotExist(err):
 		return gdkerr.NotFound
+	case err == errPreconditionFailed:
+		return gdkerr.FailedPrecondition
 	default:
 		return gdkerr.Unknown
 	}
@@ -311,7 +344,14 @@ func (b *bucket) ErrorCode(err error) gdkerr.ErrorCode {
 
 // path returns the full path for a key
 func (b *bucket) path(key string) (string, error) {
-	path := filepath.Join(b.dir, escapeKey(key))
+	rel := escapeKey(key)
+	if b.opts.Versioning && isVersionsPath(rel) {
+		return "", errVersionsDir
+	}
+	if isUploadsPath(rel) {
+		return "", errUploadsDir
+	}
+	path := filepath.Join(b.dir, rel)
 	if strings.HasSuffix(path, attrsExt) {
 		return "", errAttrsExt
 	}
@@ -380,12 +420,21 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 		if path == b.dir {
 			return nil
 		}
+		// Skip the previous versions of blobs.
+		if b.opts.Versioning && info.IsDir() && path == filepath.Join(b.dir, versionsDir) {
+			return filepath.SkipDir
+		}
+		// Skip the resumable upload sessions.
+		if info.IsDir() && path == filepath.Join(b.dir, uploadsDir) {
+			return filepath.SkipDir
+		}
 		// Strip the <b.dir> prefix from path.
 		prefixLen := len(b.dir)
 		// Include the separator for non-root.
 		if b.dir != "/" {
 			prefixLen++
 		}
+		fullPath := path
 		path = path[prefixLen:]
 		// Unescape the path to get the key.
 		key := unescapeKey(path)
@@ -412,15 +461,21 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 		if !strings.HasPrefix(key, opts.Prefix) {
 			return nil
 		}
+		fi, err := info.Info()
+		if err != nil {
+			return err
+		}
 		var md5 []byte
-		if xa, err := getAttrs(path); err == nil {
+		var checksums map[driver.ChecksumAlgorithm][]byte
+		var versionID string
+		if xa, err := getAttrs(fullPath); err == nil {
 			// Note: we only have the MD5 hash for blobs that we wrote.
 			// For other blobs, md5 will remain nil.
 			md5 = xa.MD5
-		}
-		fi, err := info.Info()
-		if err != nil {
-			return err
+			checksums = xa.Checksums
+			if b.opts.Versioning {
+				versionID = fileVersionID(fi, &xa)
+			}
 		}
 		asFunc := func(i interface{}) bool {
 			p, ok := i.(*os.FileInfo)
@@ -431,11 +486,14 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			return true
 		}
 		obj := &driver.ListObject{
-			Key:     key,
-			ModTime: fi.ModTime(),
-			Size:    fi.Size(),
-			MD5:     md5,
-			AsFunc:  asFunc,
+			Key:       key,
+			ModTime:   fi.ModTime(),
+			Size:      fi.Size(),
+			MD5:       md5,
+			Checksums: checksums,
+			ETag:      fileETag(fi),
+			VersionID: versionID,
+			AsFunc:    asFunc,
 		}
 		// If using Delimiter, collapse "directories".
 		if opts.Delimiter != "" {
@@ -529,6 +587,10 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	if err != nil {
 		return nil, err
 	}
+	var versionID string
+	if b.opts.Versioning {
+		versionID = fileVersionID(info, xa)
+	}
 	return &driver.Attributes{
 		CacheControl:       xa.CacheControl,
 		ContentDisposition: xa.ContentDisposition,
@@ -536,11 +598,15 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ContentLanguage:    xa.ContentLanguage,
 		ContentType:        xa.ContentType,
 		Metadata:           xa.Metadata,
+		Tags:               xa.Tags,
+		StorageClass:       xa.StorageClass,
 		// CreateTime left as the zero time.
-		ModTime: info.ModTime(),
-		Size:    info.Size(),
-		MD5:     xa.MD5,
-		ETag:    fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size()),
+		ModTime:   info.ModTime(),
+		Size:      info.Size(),
+		MD5:       xa.MD5,
+		Checksums: xa.Checksums,
+		ETag:      fileETag(info),
+		VersionID: versionID,
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*os.FileInfo)
 			if !ok {
@@ -552,12 +618,64 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	}, nil
 }
 
+// SetTags implements driver.Tagger by rewriting the sidecar file; the blob
+// itself, and so its ETag, is unchanged.
+func (b *bucket) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	if b.opts.Metadata == MetadataDontWrite {
+		return errNoSidecar
+	}
+	path, _, xa, err := b.forKey(key)
+	if err != nil {
+		return err
+	}
+	xa.Tags = nil
+	if len(tags) > 0 {
+		xa.Tags = tags
+	}
+	return setAttrs(path, *xa)
+}
+
+// fileETag returns the ETag for the file described by info.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// checkPreconditions returns errPreconditionFailed unless the file at path
+// satisfies ifMatch and ifNoneMatch.
+//
+// The check and the operation that follows it are not atomic; a concurrent
+// writer may change the file in between.
+func checkPreconditions(path, ifMatch, ifNoneMatch string) error {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil && !info.IsDir()
+	var eTag string
+	if exists {
+		eTag = fileETag(info)
+	}
+	if !driver.MatchesPreconditions(exists, eTag, ifMatch, ifNoneMatch) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
-	path, info, xa, err := b.forKey(key)
+	if opts.ServerSideEncryption != nil {
+		return nil, errServerSideEncryption
+	}
+	path, info, xa, err := b.forVersion(key, opts.VersionID)
 	if err != nil {
 		return nil, err
 	}
+	if !driver.MatchesPreconditions(true, fileETag(info), opts.IfMatch, opts.IfNoneMatch) {
+		return nil, errPreconditionFailed
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -590,6 +708,7 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: xa.ContentType,
 			ModTime:     info.ModTime(),
 			Size:        info.Size(),
+			ETag:        fileETag(info),
 		},
 	}, nil
 }
@@ -629,10 +748,16 @@ func (r *reader) As(i interface{}) bool {
 
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.ServerSideEncryption != nil {
+		return nil, errServerSideEncryption
+	}
 	path, err := b.path(key)
 	if err != nil {
 		return nil, err
 	}
+	if b.opts.Metadata == MetadataDontWrite && (len(opts.Tags) > 0 || opts.StorageClass != "") {
+		return nil, errNoSidecar
+	}
 	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0777)); err != nil {
 		return nil, err
 	}
@@ -655,9 +780,11 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 
 	if b.opts.Metadata == MetadataDontWrite {
 		w := &writer{
-			ctx:  ctx,
-			File: f,
-			path: path,
+			ctx:         ctx,
+			File:        f,
+			path:        path,
+			ifMatch:     opts.IfMatch,
+			ifNoneMatch: opts.IfNoneMatch,
 		}
 		return w, nil
 	}
@@ -673,14 +800,21 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		ContentLanguage:    opts.ContentLanguage,
 		ContentType:        contentType,
 		Metadata:           metadata,
+		Tags:               opts.Tags,
+		StorageClass:       opts.StorageClass,
 	}
 	w := &writerWithSidecar{
-		ctx:        ctx,
-		f:          f,
-		path:       path,
-		attrs:      attrs,
-		contentMD5: opts.ContentMD5,
-		md5hash:    md5.New(),
+		ctx:         ctx,
+		b:           b,
+		key:         key,
+		f:           f,
+		path:        path,
+		attrs:       attrs,
+		contentMD5:  opts.ContentMD5,
+		md5hash:     md5.New(),
+		checksums:   checksumHashes(opts.Checksums),
+		ifMatch:     opts.IfMatch,
+		ifNoneMatch: opts.IfNoneMatch,
 	}
 	return w, nil
 }
@@ -688,19 +822,27 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 // writerWithSidecar implements the strategy of storing metadata in a distinct file.
 type writerWithSidecar struct {
 	ctx        context.Context
+	b          *bucket
+	key        string
 	f          *os.File
 	path       string
 	attrs      xattrs
 	contentMD5 []byte
 	// We compute the MD5 hash so that we can store it with the file attributes,
 	// not for verification.
-	md5hash hash.Hash
+	md5hash     hash.Hash
+	checksums   map[driver.ChecksumAlgorithm]hash.Hash
+	ifMatch     string
+	ifNoneMatch string
 }
 
 func (w *writerWithSidecar) Write(p []byte) (n int, err error) {
 	n, err = w.f.Write(p)
+	// Don't hash the unwritten tail twice when writing is resumed.
+	for _, h := range w.checksums {
+		h.Write(p[:n])
+	}
 	if err != nil {
-		// Don't hash the unwritten tail twice when writing is resumed.
 		w.md5hash.Write(p[:n])
 		return n, err
 	}
@@ -726,16 +868,38 @@ func (w *writerWithSidecar) Close() error {
 		return err
 	}
 
+	if err := checkPreconditions(w.path, w.ifMatch, w.ifNoneMatch); err != nil {
+		return err
+	}
+
 	md5sum := w.md5hash.Sum(nil)
 	w.attrs.MD5 = md5sum
+	w.attrs.Checksums = checksumSums(w.checksums)
+	return w.b.commit(w.key, w.path, w.f.Name(), w.attrs)
+}
+
+// commit moves the file at tmp, which holds the contents of the blob for key,
+// to path, writing attrs to its attributes file.
+func (b *bucket) commit(key, path, tmp string, attrs xattrs) error {
+	if b.opts.Versioning {
+		// Keep the blob being replaced as a previous version.
+		id, err := b.newVersionID(key, path)
+		if err != nil {
+			return err
+		}
+		attrs.VersionID = id
+		if err := b.archive(key, path); err != nil {
+			return err
+		}
+	}
 
 	// Write the attributes file.
-	if err := setAttrs(w.path, w.attrs); err != nil {
+	if err := setAttrs(path, attrs); err != nil {
 		return err
 	}
 	// Rename the temp file to path.
-	if err := os.Rename(w.f.Name(), w.path); err != nil {
-		_ = os.Remove(w.path + attrsExt)
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(path + attrsExt)
 		return err
 	}
 	return nil
@@ -747,8 +911,10 @@ func (w *writerWithSidecar) Close() error {
 // which is why it is not folded into writerWithSidecar.
 type writer struct {
 	*os.File
-	ctx  context.Context
-	path string
+	ctx         context.Context
+	path        string
+	ifMatch     string
+	ifNoneMatch string
 }
 
 func (w *writer) Close() error {
@@ -766,6 +932,10 @@ func (w *writer) Close() error {
 		return err
 	}
 
+	if err := checkPreconditions(w.path, w.ifMatch, w.ifNoneMatch); err != nil {
+		return err
+	}
+
 	// Rename the temp file to path.
 	if err := os.Rename(tempname, w.path); err != nil {
 		return err
@@ -775,6 +945,9 @@ func (w *writer) Close() error {
 
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.ServerSideEncryption != nil || opts.SourceServerSideEncryption != nil {
+		return errServerSideEncryption
+	}
 	// Note: we could use NewRangeReader here, but since we need to copy all of
 	// the metadata (from xa), it's more efficient to do it directly.
 	srcPath, _, xa, err := b.forKey(srcKey)
@@ -796,6 +969,8 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 		ContentLanguage:    xa.ContentLanguage,
 		Metadata:           xa.Metadata,
 		BeforeWrite:        opts.BeforeCopy,
+		IfMatch:            opts.IfMatch,
+		IfNoneMatch:        opts.IfNoneMatch,
 	}
 	// Create a cancelable context so we can cancel the write if there are
 	// problems.
@@ -814,12 +989,76 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	return w.Close()
 }
 
+// Compose implements driver.Composer.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	if opts.ServerSideEncryption != nil || opts.SourceServerSideEncryption != nil {
+		return errServerSideEncryption
+	}
+	// Open all the sources first, so that a missing one doesn't leave a
+	// partial write behind, and so that dstKey can be one of them.
+	files := make([]*os.File, 0, len(srcKeys))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, key := range srcKeys {
+		path, _, _, err := b.forKey(key)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+
+	wopts := driver.WriterOptions{
+		Metadata:    opts.Metadata,
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+	}
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewTypedWriter(writeCtx, dstKey, opts.ContentType, &wopts)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, err := io.Copy(w, f); err != nil {
+			cancel() // cancel before Close cancels the write
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	path, err := b.path(key)
 	if err != nil {
 		return err
 	}
+	if opts.VersionID != "" {
+		return b.deleteVersion(key, path, opts)
+	}
+	if opts.IfMatch != "" {
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		if err := checkPreconditions(path, opts.IfMatch, ""); err != nil {
+			return err
+		}
+	}
+	if b.opts.Versioning {
+		// Keep the deleted blob as a previous version.
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+		return b.archive(key, path)
+	}
 	err = os.Remove(path)
 	if err != nil {
 		return err
@@ -852,6 +1091,9 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 // access to an otherwise-protected resource without requiring further
 // authentication, and callers should take care to restrict the creation of
 // signed URLs as is appropriate for their application.
+//
+// blobhttp.Handler can serve the blobs of the bucket at signed URLs, verifying
+// them with KeyFromURL.
 type URLSigner interface {
 	// URLFromKey defines how the bucket's object key will be turned
 	// into a signed URL. URLFromKey must be safe to call from multiple goroutines.
@@ -0,0 +1,291 @@
+package fileblob
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sraphs/gdk/blob/driver"
+)
+
+// uploadsDir is the directory under the bucket root that holds resumable
+// upload sessions. The bytes written to a session are kept in the file
+// uploadsDir/<token>, and the session itself in uploadsDir/<token>.json.
+const uploadsDir = ".uploads"
+
+// sessionExt is the extension of the files describing upload sessions.
+const sessionExt = ".json"
+
+var errUploadsDir = fmt.Errorf("key prefix %q is reserved", uploadsDir+"/")
+
+// isUploadsPath reports whether the escaped key rel is inside uploadsDir.
+func isUploadsPath(rel string) bool {
+	return rel == uploadsDir || strings.HasPrefix(rel, uploadsDir+string(os.PathSeparator))
+}
+
+// uploadSession is the JSON-encoded description of an upload session.
+type uploadSession struct {
+	Key     string    `json:"key"`
+	Attrs   xattrs    `json:"attrs"`
+	Started time.Time `json:"started"`
+}
+
+// validToken reports whether token could be a session token, which is 16
+// random bytes in hex. It is used to keep tokens from escaping uploadsDir.
+func validToken(token string) bool {
+	if len(token) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// sessionPath returns the path of the file holding the bytes written to the
+// session identified by token.
+func (b *bucket) sessionPath(token string) string {
+	return filepath.Join(b.dir, uploadsDir, token)
+}
+
+// session returns the upload session identified by token, which must be for
+// key.
+func (b *bucket) session(key, token string) (*uploadSession, error) {
+	if !validToken(token) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(b.sessionPath(token) + sessionExt)
+	if err != nil {
+		return nil, err
+	}
+	var s uploadSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Key != key {
+		return nil, os.ErrNotExist
+	}
+	return &s, nil
+}
+
+// NewResumableWriter implements driver.ResumableUploader.
+func (b *bucket) NewResumableWriter(ctx context.Context, key, contentType, token string, opts *driver.WriterOptions) (driver.ResumableWriter, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if b.opts.Metadata == MetadataDontWrite && (len(opts.Tags) > 0 || opts.StorageClass != "") {
+		return nil, errNoSidecar
+	}
+	if opts.ServerSideEncryption != nil {
+		return nil, errServerSideEncryption
+	}
+	var s *uploadSession
+	if token != "" {
+		if s, err = b.session(key, token); err != nil {
+			return nil, err
+		}
+	} else {
+		var buf [16]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(buf[:])
+		var metadata map[string]string
+		if len(opts.Metadata) > 0 {
+			metadata = opts.Metadata
+		}
+		s = &uploadSession{
+			Key: key,
+			Attrs: xattrs{
+				CacheControl:       opts.CacheControl,
+				ContentDisposition: opts.ContentDisposition,
+				ContentEncoding:    opts.ContentEncoding,
+				ContentLanguage:    opts.ContentLanguage,
+				ContentType:        contentType,
+				Metadata:           metadata,
+				Tags:               opts.Tags,
+				StorageClass:       opts.StorageClass,
+				// The checksums are computed when the upload completes.
+				Checksums: opts.Checksums,
+			},
+			Started: time.Now(),
+		}
+		if err := os.MkdirAll(filepath.Join(b.dir, uploadsDir), os.FileMode(0777)); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(b.sessionPath(token)+sessionExt, data, os.FileMode(0666)); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(b.sessionPath(token), os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(0666))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(i interface{}) bool {
+			p, ok := i.(**os.File)
+			if !ok {
+				return false
+			}
+			*p = f
+			return true
+		}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &resumableWriter{
+		ctx:         ctx,
+		b:           b,
+		path:        path,
+		token:       token,
+		s:           s,
+		f:           f,
+		committed:   info.Size(),
+		ifMatch:     opts.IfMatch,
+		ifNoneMatch: opts.IfNoneMatch,
+	}, nil
+}
+
+// ListUploadSessions implements driver.ResumableUploader.
+func (b *bucket) ListUploadSessions(ctx context.Context, prefix string) ([]*driver.UploadSession, error) {
+	entries, err := os.ReadDir(filepath.Join(b.dir, uploadsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*driver.UploadSession
+	for _, e := range entries {
+		token := strings.TrimSuffix(e.Name(), sessionExt)
+		if e.IsDir() || token == e.Name() || !validToken(token) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(b.dir, uploadsDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var s uploadSession
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(s.Key, prefix) {
+			sessions = append(sessions, &driver.UploadSession{Key: s.Key, Token: token, Started: s.Started})
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Key < sessions[j].Key })
+	return sessions, nil
+}
+
+// AbortUploadSession implements driver.ResumableUploader.
+func (b *bucket) AbortUploadSession(ctx context.Context, key, token string) error {
+	if _, err := b.session(key, token); err != nil {
+		return err
+	}
+	return b.removeSession(token)
+}
+
+// removeSession removes the files of the session identified by token.
+func (b *bucket) removeSession(token string) error {
+	if err := os.Remove(b.sessionPath(token) + sessionExt); err != nil {
+		return err
+	}
+	if err := os.Remove(b.sessionPath(token)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// resumableWriter appends to the file of an upload session. Bytes are
+// committed as soon as they are written to the file.
+type resumableWriter struct {
+	ctx       context.Context
+	b         *bucket
+	path      string
+	token     string
+	s         *uploadSession
+	f         *os.File
+	committed int64
+	// The preconditions are those of the current writer, not the ones given
+	// when the session was started.
+	ifMatch     string
+	ifNoneMatch string
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.committed += int64(n)
+	return n, err
+}
+
+func (w *resumableWriter) SessionToken() string {
+	return w.token
+}
+
+func (w *resumableWriter) Committed() int64 {
+	return w.committed
+}
+
+func (w *resumableWriter) Close() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	// If the write was cancelled, keep the session so it can be resumed.
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkPreconditions(w.path, w.ifMatch, w.ifNoneMatch); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), os.FileMode(0777)); err != nil {
+		return err
+	}
+	tmp := w.b.sessionPath(w.token)
+	if w.b.opts.Metadata == MetadataDontWrite {
+		if err := os.Rename(tmp, w.path); err != nil {
+			return err
+		}
+		return w.b.removeSession(w.token)
+	}
+
+	// The session may have been resumed, so hash the whole file.
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	checksums := checksumHashes(w.s.Attrs.Checksums)
+	ws := []io.Writer{h}
+	for _, ch := range checksums {
+		ws = append(ws, ch)
+	}
+	_, err = io.Copy(io.MultiWriter(ws...), f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	w.s.Attrs.MD5 = h.Sum(nil)
+	w.s.Attrs.Checksums = checksumSums(checksums)
+	if err := w.b.commit(w.s.Key, w.path, tmp, w.s.Attrs); err != nil {
+		return err
+	}
+	return w.b.removeSession(w.token)
+}
@@ -0,0 +1,311 @@
+package fileblob
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sraphs/gdk/blob/driver"
+)
+
+// versionsDir is the directory under the bucket root that holds previous
+// versions of blobs when Options.Versioning is set. The previous versions of
+// a blob are kept in the file versionsDir/<escaped key>.v/<version ID>, with
+// their attributes in a sidecar file next to it.
+const versionsDir = ".versions"
+
+// versionDirExt is appended to escaped keys to name the directory holding
+// their versions, so that the versions of "a" don't collide with the
+// versions of "a/b".
+const versionDirExt = ".v"
+
+var errVersionsDir = fmt.Errorf("key prefix %q is reserved when versioning is enabled", versionsDir+"/")
+
+// isVersionsPath reports whether the escaped key rel is inside versionsDir.
+func isVersionsPath(rel string) bool {
+	return rel == versionsDir || strings.HasPrefix(rel, versionsDir+string(os.PathSeparator))
+}
+
+// versionDir returns the directory holding the previous versions of key.
+func (b *bucket) versionDir(key string) string {
+	return filepath.Join(b.dir, versionsDir, escapeKey(key)+versionDirExt)
+}
+
+// fileVersionID returns the version ID of the blob described by info and xa.
+func fileVersionID(info os.FileInfo, xa *xattrs) string {
+	if xa.VersionID != "" {
+		return xa.VersionID
+	}
+	// Blobs written before versioning was enabled don't have a version ID;
+	// derive one from the modification time, which os.Rename preserves.
+	return fmt.Sprintf("%016x", info.ModTime().UnixNano())
+}
+
+// validVersionID reports whether id could have been returned by
+// fileVersionID. It is used to keep version IDs from escaping versionDir.
+func validVersionID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	_, err := strconv.ParseUint(id, 16, 64)
+	return err == nil
+}
+
+// versionIDs returns the IDs of the previous versions of key, newest first.
+func (b *bucket) versionIDs(key string) ([]string, error) {
+	entries, err := os.ReadDir(b.versionDir(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && validVersionID(e.Name()) {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
+
+// newVersionID returns a version ID for a new version of key, stored at path,
+// that sorts after all of its existing versions.
+func (b *bucket) newVersionID(key, path string) (string, error) {
+	ids, err := b.versionIDs(key)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err == nil {
+		xa, err := getAttrs(path)
+		if err != nil {
+			return "", err
+		}
+		ids = append(ids, fileVersionID(info, &xa))
+	}
+	next := uint64(time.Now().UnixNano())
+	for _, id := range ids {
+		if n, err := strconv.ParseUint(id, 16, 64); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return fmt.Sprintf("%016x", next), nil
+}
+
+// archive moves the blob at path, if there is one, to the previous versions
+// of key.
+func (b *bucket) archive(key, path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		return err
+	}
+	vpath := filepath.Join(b.versionDir(key), fileVersionID(info, &xa))
+	return moveBlob(path, vpath)
+}
+
+// moveBlob renames the blob at from, and its attributes file if any, to to.
+func moveBlob(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), os.FileMode(0777)); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if err := os.Rename(from+attrsExt, to+attrsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// forVersion is like forKey, but returns the given version of key, or the
+// latest one if versionID is empty.
+func (b *bucket) forVersion(key, versionID string) (string, os.FileInfo, *xattrs, error) {
+	path, info, xa, err := b.forKey(key)
+	if versionID == "" {
+		return path, info, xa, err
+	}
+	if err == nil && fileVersionID(info, xa) == versionID {
+		return path, info, xa, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, nil, err
+	}
+	if !b.opts.Versioning || !validVersionID(versionID) {
+		return "", nil, nil, os.ErrNotExist
+	}
+	vpath := filepath.Join(b.versionDir(key), versionID)
+	info, err = os.Stat(vpath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	vxa, err := getAttrs(vpath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return vpath, info, &vxa, nil
+}
+
+// deleteVersion permanently deletes a version of key, which is stored at
+// path. If it is the latest version, the newest previous version replaces it.
+func (b *bucket) deleteVersion(key, path string, opts *driver.DeleteOptions) error {
+	vpath, info, _, err := b.forVersion(key, opts.VersionID)
+	if err != nil {
+		return err
+	}
+	if !driver.MatchesPreconditions(true, fileETag(info), opts.IfMatch, "") {
+		return errPreconditionFailed
+	}
+	if err := os.Remove(vpath); err != nil {
+		return err
+	}
+	if err := os.Remove(vpath + attrsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if vpath == path {
+		ids, err := b.versionIDs(key)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			if err := moveBlob(filepath.Join(b.versionDir(key), ids[0]), path); err != nil {
+				return err
+			}
+		}
+	}
+	// Clean up the versions directory if it is now empty; this fails harmlessly
+	// otherwise.
+	_ = os.Remove(b.versionDir(key))
+	return nil
+}
+
+// ListVersions implements driver.Versioner.
+func (b *bucket) ListVersions(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if !b.opts.Versioning {
+		page, err := b.ListPaged(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Objects {
+			obj.IsLatest = true
+		}
+		return page, nil
+	}
+
+	// Collect the latest versions.
+	var objs []*driver.ListObject
+	lopts := &driver.ListOptions{Prefix: opts.Prefix}
+	for {
+		page, err := b.ListPaged(ctx, lopts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Objects {
+			obj.IsLatest = true
+		}
+		objs = append(objs, page.Objects...)
+		if len(page.NextPageToken) == 0 {
+			break
+		}
+		lopts.PageToken = page.NextPageToken
+	}
+
+	// Collect the previous versions.
+	root := filepath.Join(b.dir, versionsDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Couldn't read this file/directory for some reason; just skip it.
+			return nil
+		}
+		if d.IsDir() || !validVersionID(d.Name()) {
+			return nil
+		}
+		dir, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil || !strings.HasSuffix(dir, versionDirExt) {
+			return nil
+		}
+		key := unescapeKey(strings.TrimSuffix(dir, versionDirExt))
+		if !strings.HasPrefix(key, opts.Prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var md5 []byte
+		var checksums map[driver.ChecksumAlgorithm][]byte
+		if xa, err := getAttrs(path); err == nil {
+			md5, checksums = xa.MD5, xa.Checksums
+		}
+		objs = append(objs, &driver.ListObject{
+			Key:       key,
+			ModTime:   fi.ModTime(),
+			Size:      fi.Size(),
+			MD5:       md5,
+			Checksums: checksums,
+			ETag:      fileETag(fi),
+			VersionID: d.Name(),
+			AsFunc: func(i interface{}) bool {
+				p, ok := i.(*os.FileInfo)
+				if !ok {
+					return false
+				}
+				*p = fi
+				return true
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Order by key, then newest version first. Version IDs increase over
+	// time, so newer versions sort higher.
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].Key != objs[j].Key {
+			return objs[i].Key < objs[j].Key
+		}
+		return objs[i].VersionID > objs[j].VersionID
+	})
+
+	// pageToken is a returned NextPageToken, set below; it's the key and
+	// version ID of the last result of the previous page, separated by "\x00".
+	var tokenKey, tokenVersion string
+	hasToken := len(opts.PageToken) > 0
+	if hasToken {
+		tokenKey, tokenVersion, _ = strings.Cut(string(opts.PageToken), "\x00")
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	var result driver.ListPage
+	for _, obj := range objs {
+		// If there's a pageToken, skip anything up to and including it.
+		if hasToken && (obj.Key < tokenKey || (obj.Key == tokenKey && obj.VersionID >= tokenVersion)) {
+			continue
+		}
+		// If we've already got a full page of results, set NextPageToken and return.
+		if len(result.Objects) == pageSize {
+			last := result.Objects[pageSize-1]
+			result.NextPageToken = []byte(last.Key + "\x00" + last.VersionID)
+			break
+		}
+		result.Objects = append(result.Objects, obj)
+	}
+	return &result, nil
+}
@@ -0,0 +1,194 @@
+package fileblob
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/sraphs/gdk/blob/driver"
+)
+
+// watchSettle is how long the watcher waits for more changes after one is
+// notified, so that changes in quick succession, like the ones made by a
+// write, are reported once.
+const watchSettle = 20 * time.Millisecond
+
+// tempFileRE matches the names of the temporary files that blobs are written
+// to before being renamed.
+var tempFileRE = regexp.MustCompile(`^fileblob[0-9]+$`)
+
+// Watch implements driver.Watcher with fsnotify. Changes to the files are
+// reported as they are seen by the watcher, whether they are made through the
+// bucket or not. Files named like the temporary files blobs are written to,
+// "fileblob" followed by digits, are not reported.
+func (b *bucket) Watch(ctx context.Context, prefix string) (driver.EventStream, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{b: b, prefix: prefix, fw: fw, known: map[string]string{}}
+	if err := w.add(b.dir, false); err != nil {
+		_ = fw.Close()
+		return nil, err
+	}
+	w.q = driver.NewEventQueue(func() { _ = fw.Close() })
+	go w.run()
+	return w.q, nil
+}
+
+// watcher turns the notifications of an fsnotify.Watcher into Events.
+type watcher struct {
+	b      *bucket
+	prefix string
+	fw     *fsnotify.Watcher
+	q      *driver.EventQueue
+	// known holds the ETags of the blobs under prefix, by key. Once Watch
+	// returns, it is only used by run.
+	known map[string]string
+}
+
+// run processes the notifications until fw is closed.
+func (w *watcher) run() {
+	for {
+		select {
+		case ev, ok := <-w.fw.Events:
+			if !ok {
+				return
+			}
+			paths := map[string]bool{ev.Name: true}
+			timer := time.NewTimer(watchSettle)
+		settle:
+			for {
+				select {
+				case ev, ok := <-w.fw.Events:
+					if !ok {
+						timer.Stop()
+						return
+					}
+					paths[ev.Name] = true
+				case <-timer.C:
+					break settle
+				}
+			}
+			sorted := make([]string, 0, len(paths))
+			for path := range paths {
+				sorted = append(sorted, path)
+			}
+			sort.Strings(sorted)
+			for _, path := range sorted {
+				w.check(path)
+			}
+		case err, ok := <-w.fw.Errors:
+			if !ok {
+				return
+			}
+			w.q.Fail(err)
+			return
+		}
+	}
+}
+
+// key returns the key for path, or false if path doesn't hold a blob or a
+// directory of blobs.
+func (w *watcher) key(path string) (string, bool) {
+	rel, err := filepath.Rel(w.b.dir, path)
+	if err != nil || rel == "." {
+		return "", false
+	}
+	if strings.HasSuffix(rel, attrsExt) || tempFileRE.MatchString(filepath.Base(rel)) {
+		return "", false
+	}
+	if (w.b.opts.Versioning && isVersionsPath(rel)) || isUploadsPath(rel) {
+		return "", false
+	}
+	return unescapeKey(rel), true
+}
+
+// skipDir reports whether the directory at path can't hold blobs under
+// w.prefix.
+func (w *watcher) skipDir(path string) bool {
+	if path == w.b.dir {
+		return false
+	}
+	key, ok := w.key(path)
+	if !ok {
+		return true
+	}
+	key += "/"
+	return !strings.HasPrefix(key, w.prefix) && !strings.HasPrefix(w.prefix, key)
+}
+
+// add watches the directory at dir and its subdirectories, and records the
+// blobs in them, reporting them if report is true.
+func (w *watcher) add(dir string, report bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// Couldn't read this file/directory for some reason; just skip it.
+			return nil
+		}
+		if d.IsDir() {
+			if w.skipDir(path) {
+				return filepath.SkipDir
+			}
+			return w.fw.Add(path)
+		}
+		if report {
+			w.check(path)
+		} else if key, ok := w.key(path); ok && strings.HasPrefix(key, w.prefix) {
+			if info, err := d.Info(); err == nil {
+				w.known[key] = fileETag(info)
+			}
+		}
+		return nil
+	})
+}
+
+// check reports the changes at path since it was last checked.
+func (w *watcher) check(path string) {
+	key, ok := w.key(path)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		// The file or directory is gone; so are the blobs it held.
+		for k := range w.known {
+			if k == key || strings.HasPrefix(k, key+"/") {
+				delete(w.known, k)
+				w.q.Push(&driver.Event{Type: driver.EventDeleted, Key: k})
+			}
+		}
+		return
+	}
+	if info.IsDir() {
+		if !w.skipDir(path) {
+			// Ignore errors; the directory may already be gone.
+			_ = w.add(path, true)
+		}
+		return
+	}
+	if !strings.HasPrefix(key, w.prefix) {
+		return
+	}
+	eTag := fileETag(info)
+	prev, exists := w.known[key]
+	if exists && prev == eTag {
+		return
+	}
+	w.known[key] = eTag
+	e := &driver.Event{Type: driver.EventCreated, Key: key, ETag: eTag, ModTime: info.ModTime(), Size: info.Size()}
+	if exists {
+		e.Type = driver.EventUpdated
+	}
+	w.q.Push(e)
+}
@@ -0,0 +1,46 @@
+package gcsblob_test
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/gcsblob"
+)
+
+func ExampleOpenBucket() {
+	// PRAGMA: This example is used on github.com/sraphs/gdk; PRAGMA comments adjust how it is shown and can be ignored.
+	// PRAGMA: On github.com/sraphs/gdk, hide lines until the next blank line.
+	ctx := context.Background()
+
+	// Create a *storage.Client using default credentials.
+	// See https://cloud.google.com/docs/authentication/production for more info.
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create a *blob.Bucket.
+	bucket, err := gcsblob.OpenBucket(ctx, client, "my-bucket", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
+
+func Example_openBucketFromURL() {
+	// PRAGMA: This example is used on github.com/sraphs/gdk; PRAGMA comments adjust how it is shown and can be ignored.
+	// PRAGMA: On github.com/sraphs/gdk, add a blank import: _ "github.com/sraphs/gdk/blob/gcsblob"
+	// PRAGMA: On github.com/sraphs/gdk, hide lines until the next blank line.
+	ctx := context.Background()
+
+	// blob.OpenBucket creates a *blob.Bucket from a URL.
+	// This URL will open the bucket "my-bucket" using default credentials.
+	bucket, err := blob.OpenBucket(ctx, "gs://my-bucket")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+}
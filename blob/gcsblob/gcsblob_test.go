@@ -0,0 +1,443 @@
+package gcsblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/blob/drivertest"
+	"github.com/sraphs/gdk/gdkerr"
+	"github.com/sraphs/gdk/internal/testing/setup"
+)
+
+// These constants record the bucket used for the last --record.
+// If you want to use --record mode,
+// 1. Create a bucket in your GCP project:
+//
+//	https://console.cloud.google.com/storage/browser, then "Create Bucket".
+//
+// 2. Update the bucketName constant to your bucket name.
+// 3. Authenticate with default credentials, e.g. via
+//
+//	gcloud auth application-default login
+//
+// Without a golden file, the tests run against a local fake-gcs-server
+// emulator if one is running; start it with ./localgcs.sh.
+//
+// The golden files in testdata were recorded against fake-gcs-server serving
+// storage.googleapis.com. Subtests that need what the emulator lacks (list
+// pagination, multi-character delimiters, some attributes) have no golden
+// file, and are skipped unless the emulator is running; recording against
+// GCS covers them.
+const (
+	bucketName = "go-cloud-blob-test-bucket"
+
+	// emulatorAddr is the address of the fake-gcs-server emulator.
+	emulatorAddr = "127.0.0.1:4443"
+)
+
+type harness struct {
+	client *storage.Client
+	rt     http.RoundTripper
+	closer func()
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	if !setup.HasReplayFile(t) {
+		return newEmulatorHarness(ctx, t)
+	}
+	hc, rt, done := setup.NewGCPClient(ctx, t)
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(hc))
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return &harness{client: client, rt: rt, closer: done}, nil
+}
+
+// newEmulatorHarness returns a harness for the fake-gcs-server emulator,
+// skipping the test if it isn't running.
+func newEmulatorHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	if !setup.HasDockerTestEnvironment() {
+		t.Skip("no golden file to replay from, and the GCS emulator is not available")
+	}
+	conn, err := net.DialTimeout("tcp", emulatorAddr, time.Second)
+	if err != nil {
+		t.Skipf("no golden file to replay from; run with --record to create one, or start a local GCS emulator with blob/gcsblob/localgcs.sh (%v)", err)
+	}
+	conn.Close()
+
+	client, err := storage.NewClient(ctx,
+		option.WithEndpoint("http://"+emulatorAddr+"/storage/v1/"),
+		option.WithHTTPClient(http.DefaultClient),
+		option.WithoutAuthentication())
+	if err != nil {
+		return nil, err
+	}
+	// The emulator starts without buckets.
+	var gerr *googleapi.Error
+	if err := client.Bucket(bucketName).Create(ctx, "test-project", nil); err != nil && !(errors.As(err, &gerr) && gerr.Code == http.StatusConflict) {
+		client.Close()
+		return nil, err
+	}
+	return &harness{client: client, rt: http.DefaultTransport, closer: func() {}}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return &http.Client{Transport: h.rt}
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(ctx, h.client, bucketName, nil)
+}
+
+func (h *harness) MakeDriverForNonexistentBucket(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(ctx, h.client, "bucket-does-not-exist", nil)
+}
+
+func (h *harness) Close() {
+	h.client.Close()
+	h.closer()
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyContentLanguage{}})
+}
+
+func BenchmarkGcsblob(b *testing.B) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bkt, err := OpenBucket(ctx, client, bucketName, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	drivertest.RunBenchmarks(b, bkt)
+}
+
+const language = "nl"
+
+// verifyContentLanguage uses As to access the underlying GCS types and
+// read/write the ContentLanguage field.
+type verifyContentLanguage struct{}
+
+func (verifyContentLanguage) Name() string {
+	return "verify ContentLanguage can be written and read through As"
+}
+
+func (verifyContentLanguage) BucketCheck(b *blob.Bucket) error {
+	var client *storage.Client
+	if !b.As(&client) {
+		return errors.New("Bucket.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ErrorCheck(b *blob.Bucket, err error) error {
+	// Can't really verify this one because the storage library returns
+	// a sentinel error, storage.ErrObjectNotExist, for "not exists"
+	// instead of the supported As type googleapi.Error.
+	// Call ErrorAs anyway, and expect it to fail.
+	var to *googleapi.Error
+	if b.ErrorAs(err, &to) {
+		return errors.New("expected ErrorAs to fail")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeRead(as func(interface{}) bool) error {
+	var objp **storage.ObjectHandle
+	if !as(&objp) {
+		return errors.New("BeforeRead.As failed to get ObjectHandle")
+	}
+	var sr *storage.Reader
+	if !as(&sr) {
+		return errors.New("BeforeRead.As failed to get Reader")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeWrite(as func(interface{}) bool) error {
+	var objp **storage.ObjectHandle
+	if !as(&objp) {
+		return errors.New("Writer.As failed to get ObjectHandle")
+	}
+	var sw *storage.Writer
+	if !as(&sw) {
+		return errors.New("Writer.As failed to get Writer")
+	}
+	// Nothing else sets the language, so the checks below see this value.
+	if sw.ContentLanguage != "" {
+		return fmt.Errorf("Writer already has ContentLanguage %q", sw.ContentLanguage)
+	}
+	sw.ContentLanguage = language
+	return nil
+}
+
+func (verifyContentLanguage) BeforeCopy(as func(interface{}) bool) error {
+	var coh *CopyObjectHandles
+	if !as(&coh) {
+		return errors.New("BeforeCopy.As failed to get CopyObjectHandles")
+	}
+	var copier *storage.Copier
+	if !as(&copier) {
+		return errors.New("BeforeCopy.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) BeforeList(as func(interface{}) bool) error {
+	var q *storage.Query
+	if !as(&q) {
+		return errors.New("List.As failed")
+	}
+	// Nothing to do.
+	return nil
+}
+
+func (verifyContentLanguage) BeforeSign(as func(interface{}) bool) error {
+	var opts *storage.SignedURLOptions
+	if !as(&opts) {
+		return errors.New("BeforeSign.As failed")
+	}
+	return nil
+}
+
+func (verifyContentLanguage) AttributesCheck(attrs *blob.Attributes) error {
+	var oa storage.ObjectAttrs
+	if !attrs.As(&oa) {
+		return errors.New("Attributes.As returned false")
+	}
+	if got := oa.ContentLanguage; got != language {
+		return fmt.Errorf("got native ContentLanguage %q want %q", got, language)
+	}
+	// The value set through BeforeWrite is also the portable one.
+	if got := attrs.ContentLanguage; got != language {
+		return fmt.Errorf("got ContentLanguage %q want %q", got, language)
+	}
+	return nil
+}
+
+func (verifyContentLanguage) ReaderCheck(r *blob.Reader) error {
+	var rr *storage.Reader
+	if !r.As(&rr) {
+		return errors.New("Reader.As returned false")
+	}
+	// GCS doesn't return Content-Language via storage.Reader.
+	return nil
+}
+
+func (verifyContentLanguage) ListObjectCheck(o *blob.ListObject) error {
+	var oa storage.ObjectAttrs
+	if !o.As(&oa) {
+		return errors.New("ListObject.As returned false")
+	}
+	if o.IsDir {
+		return nil
+	}
+	if got := oa.ContentLanguage; got != language {
+		return fmt.Errorf("got %q want %q", got, language)
+	}
+	return nil
+}
+
+func TestBufferSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want int
+	}{
+		{
+			size: 5 * 1024 * 1024,
+			want: 5 * 1024 * 1024,
+		},
+		{
+			size: 0,
+			want: googleapi.DefaultUploadChunkSize,
+		},
+		{
+			size: -1024,
+			want: 0,
+		},
+	}
+	for i, test := range tests {
+		got := bufferSize(test.size)
+		if got != test.want {
+			t.Errorf("%d) got buffer size %d, want %d", i, got, test.want)
+		}
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"foo/bar", "foo/bar"},
+		{"foo\nbar", "foo__0xa__bar"},
+		{"foo\rbar", "foo__0xd__bar"},
+		{"../foo", "..__0x2f__foo"},
+		{"foo../bar", "foo..__0x2f__bar"},
+	}
+	for _, test := range tests {
+		got := escapeKey(test.key)
+		if got != test.want {
+			t.Errorf("escapeKey(%q) got %q want %q", test.key, got, test.want)
+		}
+		if back := unescapeKey(got); back != test.key {
+			t.Errorf("unescapeKey(%q) got %q want %q", got, back, test.key)
+		}
+	}
+}
+
+func TestOpenBucket(t *testing.T) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		description string
+		bucketName  string
+		nilClient   bool
+		want        string
+		wantErr     bool
+	}{
+		{
+			description: "empty bucket name results in error",
+			wantErr:     true,
+		},
+		{
+			description: "nil client results in error",
+			bucketName:  "foo",
+			nilClient:   true,
+			wantErr:     true,
+		},
+		{
+			description: "success",
+			bucketName:  "foo",
+			want:        "foo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := client
+			if test.nilClient {
+				c = nil
+			}
+
+			// Create driver impl.
+			drv, err := openBucket(ctx, c, test.bucketName, nil)
+			if (err != nil) != test.wantErr {
+				t.Errorf("got err %v want error %v", err, test.wantErr)
+			}
+			if err == nil && drv != nil && drv.name != test.want {
+				t.Errorf("got %q want %q", drv.name, test.want)
+			}
+
+			// Create portable type.
+			b, err := OpenBucket(ctx, c, test.bucketName, nil)
+			if b != nil {
+				defer b.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("got err %v want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignedURLRequiresSigner(t *testing.T) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		description string
+		opts        *Options
+		wantErr     bool
+	}{
+		{"no options", nil, true},
+		{"no signer", &Options{GoogleAccessID: "foo@example.com"}, true},
+		{"no access id", &Options{SignBytes: fakeSignBytes}, true},
+		{"two signers", &Options{GoogleAccessID: "foo@example.com", PrivateKey: []byte("key"), SignBytes: fakeSignBytes}, true},
+		{"ok", &Options{GoogleAccessID: "foo@example.com", SignBytes: fakeSignBytes}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			b, err := OpenBucket(ctx, client, bucketName, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			url, err := b.SignedURL(ctx, "my-key", nil)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got err %v want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				if gdkerr.Code(err) != gdkerr.Unimplemented {
+					t.Errorf("got error code %v want Unimplemented", gdkerr.Code(err))
+				}
+				return
+			}
+			if url == "" {
+				t.Error("got empty URL")
+			}
+		})
+	}
+}
+
+func fakeSignBytes(b []byte) ([]byte, error) {
+	return []byte("signature"), nil
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		// OK.
+		{"gs://mybucket", false},
+		// OK, setting access_id.
+		{"gs://mybucket?access_id=foo", false},
+		// Invalid private_key_path.
+		{"gs://mybucket?private_key_path=invalid-path", true},
+		// Invalid parameter.
+		{"gs://mybucket?param=value", true},
+	}
+
+	mux := new(blob.URLMux)
+	mux.RegisterBucket(Scheme, &URLOpener{Client: client})
+	for _, test := range tests {
+		b, err := mux.OpenBucket(ctx, test.URL)
+		if b != nil {
+			defer b.Close()
+		}
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+	}
+}
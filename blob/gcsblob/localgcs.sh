@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+
+# Starts a local fake-gcs-server (Google Cloud Storage emulator) instance via
+# Docker.
+
+# https://coderwall.com/p/fkfaqq/safer-bash-scripts-with-set-euxo-pipefail
+set -euo pipefail
+
+# Clean up and run fake-gcs-server.
+echo "Starting fake-gcs-server..."
+docker rm -f fake-gcs-server &>/dev/null || :
+docker run -d -p 4443:4443 --name fake-gcs-server fsouza/fake-gcs-server:1.47.4 -scheme http -public-host 127.0.0.1:4443 &>/dev/null
+echo "...done. Run \"docker rm -f fake-gcs-server\" to clean up the container."
+echo
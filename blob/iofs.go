@@ -0,0 +1,317 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// FS is a read-only view of a Bucket as an io/fs file system, for use with
+// packages that accept an fs.FS, such as html/template, net/http (via
+// http.FS) and fs.WalkDir. Create one with Bucket.FS.
+//
+// Keys are treated as slash-separated paths: a blob is a file, and a
+// directory exists wherever a listing with the "/" delimiter reports one.
+// Files have mode 0444 and directories fs.ModeDir|0555. Sys returns the
+// *Attributes of a file opened or passed to Stat, and the *ListObject of a
+// directory entry.
+//
+// A blob whose key ends in "/", like the "directory markers" some tools
+// create, is not a file, but makes its directory exist even if it is
+// otherwise empty. If a blob has the same name as a directory, the file
+// shadows the directory in Open, Stat and ReadDir. Keys that are not valid
+// fs paths (see fs.ValidPath), such as keys with a leading slash or empty
+// path elements, cannot be opened and are skipped by ReadDir.
+type FS struct {
+	b    *Bucket
+	ctx  context.Context
+	opts *ReaderOptions
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// FS returns a read-only view of b as an fs.FS. Since the fs.FS interfaces
+// don't take a context, ctx is used for every operation on the returned FS
+// and the files opened from it, and opts, which may be nil, for every blob
+// opened.
+func (b *Bucket) FS(ctx context.Context, opts *ReaderOptions) *FS {
+	return &FS{b: b, ctx: ctx, opts: opts}
+}
+
+// Open implements fs.FS. Files are read through a Reader, and implement
+// io.Seeker; directories implement fs.ReadDirFile.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		r, err := f.b.NewReader(f.ctx, name, f.opts)
+		if err == nil {
+			return &fsFile{f: f, name: name, r: r}, nil
+		}
+		if gdkerr.Code(err) != gdkerr.NotFound {
+			return nil, fsError("open", name, err)
+		}
+	}
+	ok, err := f.isDir(name)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fsDir{f: f, name: name}, nil
+}
+
+// Stat implements fs.StatFS.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		attrs, err := f.b.Attributes(f.ctx, name)
+		if err == nil {
+			return attrsFileInfo(name, attrs), nil
+		}
+		if gdkerr.Code(err) != gdkerr.NotFound {
+			return nil, fsError("stat", name, err)
+		}
+	}
+	ok, err := f.isDir(name)
+	if err != nil {
+		return nil, fsError("stat", name, err)
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return dirFileInfo(name, nil), nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	r, err := f.b.NewReader(f.ctx, name, f.opts)
+	if err != nil {
+		if gdkerr.Code(err) == gdkerr.NotFound {
+			if ok, _ := f.isDir(name); ok {
+				return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+			}
+		}
+		return nil, fsError("read", name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fsError("read", name, err)
+	}
+	return data, nil
+}
+
+// ReadDir implements fs.ReadDirFS. The entries are sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, ok, err := f.readDir(name)
+	if err != nil {
+		return nil, fsError("readdir", name, err)
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// readDir lists the directory name, and reports whether it exists.
+func (f *FS) readDir(name string) ([]fs.DirEntry, bool, error) {
+	iter := f.b.List(&ListOptions{Prefix: dirPrefix(name), Delimiter: "/"})
+	exists := name == "."
+	var files, dirs []*ListObject
+	for {
+		obj, err := iter.Next(f.ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		exists = true
+		if obj.IsDir {
+			dirs = append(dirs, obj)
+		} else {
+			files = append(files, obj)
+		}
+	}
+	entries := make([]fs.DirEntry, 0, len(files)+len(dirs))
+	names := map[string]bool{}
+	for _, obj := range files {
+		if !fs.ValidPath(obj.Key) {
+			// Including directory markers, whose keys end in "/".
+			continue
+		}
+		info := &fileInfo{name: path.Base(obj.Key), size: obj.Size, modTime: obj.ModTime, sys: obj}
+		names[info.name] = true
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	for _, obj := range dirs {
+		key := strings.TrimSuffix(obj.Key, "/")
+		if !fs.ValidPath(key) || names[path.Base(key)] {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(dirFileInfo(key, obj)))
+	}
+	// Listings are sorted by key, which can differ from the order of names:
+	// "a-b" sorts before the directory "a/", but after "a".
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, exists, nil
+}
+
+// isDir reports whether name is a directory, meaning that it is the root or
+// that there are blobs below it.
+func (f *FS) isDir(name string) (bool, error) {
+	if name == "." {
+		return true, nil
+	}
+	iter := f.b.List(&ListOptions{Prefix: dirPrefix(name), Delimiter: "/"})
+	_, err := iter.Next(f.ctx)
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dirPrefix returns the listing prefix for the directory name.
+func dirPrefix(name string) string {
+	if name == "." {
+		return ""
+	}
+	return name + "/"
+}
+
+// errIsDir is returned when reading a directory as a file.
+var errIsDir = gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: is a directory")
+
+// fsError wraps err in an *fs.PathError, using fs.ErrNotExist for errors for
+// which gdkerr.Code returns gdkerr.NotFound so that errors.Is works as
+// callers of fs.FS expect.
+func fsError(op, name string, err error) error {
+	if gdkerr.Code(err) == gdkerr.NotFound {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// fileInfo implements fs.FileInfo.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	sys     interface{}
+}
+
+func attrsFileInfo(name string, attrs *Attributes) *fileInfo {
+	return &fileInfo{name: path.Base(name), size: attrs.Size, modTime: attrs.ModTime, sys: attrs}
+}
+
+func dirFileInfo(name string, obj *ListObject) *fileInfo {
+	fi := &fileInfo{name: path.Base(name), dir: true}
+	if obj != nil {
+		fi.sys = obj
+	}
+	return fi
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return fi.sys }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// fsFile is an fs.File for a blob.
+type fsFile struct {
+	f    *FS
+	name string
+	r    *Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.f.Stat(f.name)
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *fsFile) Close() error {
+	return f.r.Close()
+}
+
+// fsDir is an fs.ReadDirFile for a directory. It is listed on the first call
+// to ReadDir.
+type fsDir struct {
+	f       *FS
+	name    string
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return dirFileInfo(d.name, nil), nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *fsDir) Close() error { return nil }
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, _, err := d.f.readDir(d.name)
+		if err != nil {
+			return nil, fsError("readdir", d.name, err)
+		}
+		d.entries, d.listed = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
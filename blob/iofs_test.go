@@ -0,0 +1,50 @@
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/memblob"
+)
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	for _, key := range []string{"a", "a-b", "a/b.txt", "a/c/d.txt", "e/f.txt", "marker/", "//skipped"} {
+		if err := b.WriteAll(ctx, key, []byte("content of "+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := b.FS(ctx, nil)
+	// The file "a" shadows the directory "a/".
+	if err := fstest.TestFS(fsys, "a", "a-b", "e/f.txt", "marker"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsys.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open missing: got %v want fs.ErrNotExist", err)
+	}
+	if _, err := fsys.Stat("/a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Stat invalid: got %v want fs.ErrInvalid", err)
+	}
+	info, err := fsys.Stat("e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Name() != "e" {
+		t.Errorf("Stat e: got %s, dir %v want a directory named e", info.Name(), info.IsDir())
+	}
+	info, err = fsys.Stat("a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs, ok := info.Sys().(*blob.Attributes); !ok || attrs.Size != int64(len("content of a/b.txt")) {
+		t.Errorf("Stat a/b.txt: got Sys %v want *blob.Attributes", info.Sys())
+	}
+}
@@ -1,5 +1,10 @@
 // Package memblob provides an in-memory blob implementation.
-// Use OpenBucket to construct a *blob.Bucket.
+// Use OpenBucket to construct a *blob.Bucket, or ServeSignedURLs to construct
+// one whose signed URLs are served by an in-process HTTP server.
+//
+// blob.WriterOptions.ServerSideEncryption is reported in Attributes, and
+// blobs written with a customer key can only be read or copied with that key,
+// but nothing is actually encrypted.
 //
 // # URLs
 //
@@ -17,12 +22,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -35,8 +42,10 @@ import (
 const defaultPageSize = 1000
 
 var (
-	errNotFound       = errors.New("blob not found")
-	errNotImplemented = errors.New("not implemented")
+	errNotFound           = errors.New("blob not found")
+	errNotImplemented     = errors.New("not implemented")
+	errPreconditionFailed = errors.New("precondition failed")
+	errCustomerKey        = errors.New("blob is encrypted with a different customer key, or none")
 )
 
 func init() {
@@ -49,34 +58,83 @@ const Scheme = "mem"
 
 // URLOpener opens URLs like "mem://".
 //
-// No query parameters are supported.
+// The following query parameters are supported:
+//
+//   - versioning: a boolean; if true, sets Options.Versioning.
 type URLOpener struct{}
 
 // OpenBucketURL opens a blob.Bucket based on u.
 func (*URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
-	for param := range u.Query() {
-		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	opts := &Options{}
+	for param, values := range u.Query() {
+		if param != "versioning" {
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+		v, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("open bucket %v: invalid value for query parameter %q: %v", u, param, err)
+		}
+		opts.Versioning = v
 	}
-	return OpenBucket(nil), nil
+	return OpenBucket(opts), nil
 }
 
 // Options sets options for constructing a *blob.Bucket backed by memory.
-type Options struct{}
+type Options struct {
+	// Versioning, if true, keeps the previous versions of blobs when they are
+	// overwritten or deleted; see blob.Bucket.ListVersions.
+	Versioning bool
+
+	// URLSigner implements signing URLs (to allow access to a resource without
+	// further authorization) and verifying that a given URL is unexpired and
+	// contains a signature produced by the URLSigner.
+	// URLSigner is only required for utilizing the SignedURL API; see
+	// ServeSignedURLs for a bucket whose signed URLs can actually be used.
+	URLSigner URLSigner
+}
 
 type blobEntry struct {
 	Content    []byte
 	Attributes *driver.Attributes
+	// CustomerKey is the key of driver.EncryptionCustomerKey, which must be
+	// given to read or copy the blob, if any.
+	CustomerKey []byte
 }
 
 type bucket struct {
 	mu    sync.Mutex
 	blobs map[string]*blobEntry
+
+	// The fields below are only used if versioning is enabled.
+	versioning bool
+	// versions holds the previous versions of each key, oldest first.
+	versions map[string][]*blobEntry
+	// lastVersion is the most recently assigned version number.
+	lastVersion uint64
+
+	// sessions holds the resumable upload sessions, by token.
+	sessions map[string]*uploadSession
+	// lastSession is the most recently assigned session number.
+	lastSession uint64
+
+	// watchers holds the prefix watched by each EventQueue returned by Watch.
+	watchers map[*driver.EventQueue]string
+
+	urlSigner URLSigner
 }
 
 // openBucket creates a driver.Bucket backed by memory.
-func openBucket(_ *Options) driver.Bucket {
+func openBucket(opts *Options) driver.Bucket {
+	if opts == nil {
+		opts = &Options{}
+	}
 	return &bucket{
-		blobs: map[string]*blobEntry{},
+		blobs:      map[string]*blobEntry{},
+		versioning: opts.Versioning,
+		versions:   map[string][]*blobEntry{},
+		sessions:   map[string]*uploadSession{},
+		watchers:   map[*driver.EventQueue]string{},
+		urlSigner:  opts.URLSigner,
 	}
 }
 
@@ -95,6 +153,10 @@ func (b *bucket) ErrorCode(err error) gdkerr.ErrorCode {
 		return gdkerr.NotFound
 	case errNotImplemented:
 		return gdkerr.Unimplemented
+	case errPreconditionFailed:
+		return gdkerr.FailedPrecondition
+	case errCustomerKey:
+		return gdkerr.PermissionDenied
 	default:
 		return gdkerr.Unknown
 	}
@@ -136,10 +198,12 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 
 		entry := b.blobs[key]
 		obj := &driver.ListObject{
-			Key:     key,
-			ModTime: entry.Attributes.ModTime,
-			Size:    entry.Attributes.Size,
-			MD5:     entry.Attributes.MD5,
+			Key:       key,
+			ModTime:   entry.Attributes.ModTime,
+			Size:      entry.Attributes.Size,
+			MD5:       entry.Attributes.MD5,
+			ETag:      entry.Attributes.ETag,
+			VersionID: entry.Attributes.VersionID,
 		}
 
 		// If using Delimiter, collapse "directories".
@@ -203,10 +267,16 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	entry, found := b.blobs[key]
-	if !found {
+	entry := b.entry(key, opts.VersionID)
+	if entry == nil {
 		return nil, errNotFound
 	}
+	if !matchesPreconditions(entry, opts.IfMatch, opts.IfNoneMatch) {
+		return nil, errPreconditionFailed
+	}
+	if !entry.hasCustomerKey(opts.ServerSideEncryption) {
+		return nil, errCustomerKey
+	}
 
 	if opts.BeforeRead != nil {
 		if err := opts.BeforeRead(func(interface{}) bool { return false }); err != nil {
@@ -229,6 +299,7 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: entry.Attributes.ContentType,
 			ModTime:     entry.Attributes.ModTime,
 			Size:        entry.Attributes.Size,
+			ETag:        entry.Attributes.ETag,
 		},
 	}, nil
 }
@@ -306,62 +377,519 @@ func (w *writer) Close() error {
 		return err
 	}
 
-	md5sum := w.md5hash.Sum(nil)
-	content := w.buf.Bytes()
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	return w.b.write(w.key, w.contentType, w.metadata, w.opts, w.buf.Bytes(), w.md5hash.Sum(nil))
+}
+
+// write stores content as the blob for key. b.mu must be held.
+func (b *bucket) write(key, contentType string, metadata map[string]string, opts *driver.WriterOptions, content, md5sum []byte) error {
 	now := time.Now()
 	entry := &blobEntry{
 		Content: content,
 		Attributes: &driver.Attributes{
-			CacheControl:       w.opts.CacheControl,
-			ContentDisposition: w.opts.ContentDisposition,
-			ContentEncoding:    w.opts.ContentEncoding,
-			ContentLanguage:    w.opts.ContentLanguage,
-			ContentType:        w.contentType,
-			Metadata:           w.metadata,
-			Size:               int64(len(content)),
-			CreateTime:         now,
-			ModTime:            now,
-			MD5:                md5sum,
-			ETag:               fmt.Sprintf("\"%x-%x\"", now.UnixNano(), len(content)),
+			CacheControl:         opts.CacheControl,
+			ContentDisposition:   opts.ContentDisposition,
+			ContentEncoding:      opts.ContentEncoding,
+			ContentLanguage:      opts.ContentLanguage,
+			ContentType:          contentType,
+			Metadata:             metadata,
+			Tags:                 copyTags(opts.Tags),
+			StorageClass:         opts.StorageClass,
+			ServerSideEncryption: encryptionAttributes(opts.ServerSideEncryption),
+			Size:                 int64(len(content)),
+			CreateTime:           now,
+			ModTime:              now,
+			MD5:                  md5sum,
+			ETag:                 fmt.Sprintf("\"%x-%x\"", now.UnixNano(), len(content)),
 		},
+		CustomerKey: customerKey(opts.ServerSideEncryption),
+	}
+	prev := b.blobs[key]
+	if !matchesPreconditions(prev, opts.IfMatch, opts.IfNoneMatch) {
+		return errPreconditionFailed
+	}
+	if prev != nil {
+		entry.Attributes.CreateTime = prev.Attributes.CreateTime
+	}
+	b.put(key, entry)
+	return nil
+}
+
+// SetTags implements driver.Tagger.
+func (b *bucket) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := b.blobs[key]
+	if entry == nil {
+		return errNotFound
+	}
+	// Entries may be shared by copies; don't modify them.
+	attrs := *entry.Attributes
+	attrs.Tags = copyTags(tags)
+	e := *entry
+	e.Attributes = &attrs
+	b.blobs[key] = &e
+	return nil
+}
+
+// customerKey returns the customer key of sse, or nil if it doesn't have one.
+func customerKey(sse *driver.ServerSideEncryption) []byte {
+	if sse == nil || sse.Mode != driver.EncryptionCustomerKey {
+		return nil
+	}
+	return sse.CustomerKey
+}
+
+// hasCustomerKey reports whether sse holds the customer key of e, if any.
+func (e *blobEntry) hasCustomerKey(sse *driver.ServerSideEncryption) bool {
+	return bytes.Equal(e.CustomerKey, customerKey(sse))
+}
+
+// encryptionAttributes returns the encryption reported in the attributes of
+// blobs written with sse. Nothing is actually encrypted.
+func encryptionAttributes(sse *driver.ServerSideEncryption) *driver.ServerSideEncryption {
+	if sse == nil {
+		return nil
 	}
+	attrs := &driver.ServerSideEncryption{Mode: sse.Mode, KeyID: sse.KeyID}
+	if sse.Mode == driver.EncryptionCustomerKey {
+		sum := md5.Sum(sse.CustomerKey)
+		attrs.KeyID = base64.StdEncoding.EncodeToString(sum[:])
+	}
+	return attrs
+}
+
+// copyTags returns a copy of tags, or nil if it is empty.
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+// uploadSession is a resumable upload session.
+type uploadSession struct {
+	key         string
+	contentType string
+	metadata    map[string]string
+	opts        *driver.WriterOptions
+	started     time.Time
+	// content holds the bytes written so far. They are committed as soon as
+	// they are written.
+	content []byte
+}
+
+// NewResumableWriter implements driver.ResumableUploader.
+func (b *bucket) NewResumableWriter(ctx context.Context, key, contentType, token string, opts *driver.WriterOptions) (driver.ResumableWriter, error) {
+	if key == "" {
+		return nil, errors.New("invalid key (empty string)")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.sessions[token]
+	if token != "" && (s == nil || s.key != key) {
+		return nil, errNotFound
+	}
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	if s == nil {
+		md := map[string]string{}
+		for k, v := range opts.Metadata {
+			md[k] = v
+		}
+		b.lastSession++
+		token = fmt.Sprintf("%016x", b.lastSession)
+		s = &uploadSession{
+			key:         key,
+			contentType: contentType,
+			metadata:    md,
+			opts:        opts,
+			started:     time.Now(),
+		}
+		b.sessions[token] = s
+	}
+	return &resumableWriter{
+		ctx:         ctx,
+		b:           b,
+		token:       token,
+		s:           s,
+		ifMatch:     opts.IfMatch,
+		ifNoneMatch: opts.IfNoneMatch,
+	}, nil
+}
+
+// ListUploadSessions implements driver.ResumableUploader.
+func (b *bucket) ListUploadSessions(ctx context.Context, prefix string) ([]*driver.UploadSession, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var sessions []*driver.UploadSession
+	for token, s := range b.sessions {
+		if strings.HasPrefix(s.key, prefix) {
+			sessions = append(sessions, &driver.UploadSession{Key: s.key, Token: token, Started: s.started})
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Token < sessions[j].Token })
+	return sessions, nil
+}
+
+// AbortUploadSession implements driver.ResumableUploader.
+func (b *bucket) AbortUploadSession(ctx context.Context, key, token string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s := b.sessions[token]; s == nil || s.key != key {
+		return errNotFound
+	}
+	delete(b.sessions, token)
+	return nil
+}
+
+type resumableWriter struct {
+	ctx   context.Context
+	b     *bucket
+	token string
+	s     *uploadSession
+	// The preconditions are those of the current writer, not the ones given
+	// when the session was started.
+	ifMatch     string
+	ifNoneMatch string
+}
+
+// session returns w's session, or errNotFound if it was completed or
+// aborted. w.b.mu must be held.
+func (w *resumableWriter) session() (*uploadSession, error) {
+	if w.b.sessions[w.token] != w.s {
+		return nil, errNotFound
+	}
+	return w.s, nil
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
 	w.b.mu.Lock()
 	defer w.b.mu.Unlock()
-	if prev := w.b.blobs[w.key]; prev != nil {
-		entry.Attributes.CreateTime = prev.Attributes.CreateTime
+	s, err := w.session()
+	if err != nil {
+		return 0, err
 	}
-	w.b.blobs[w.key] = entry
+	s.content = append(s.content, p...)
+	return len(p), nil
+}
+
+func (w *resumableWriter) SessionToken() string {
+	return w.token
+}
+
+func (w *resumableWriter) Committed() int64 {
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	return int64(len(w.s.content))
+}
+
+func (w *resumableWriter) Close() error {
+	// If the write was cancelled, keep the session so it can be resumed.
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	s, err := w.session()
+	if err != nil {
+		return err
+	}
+	opts := *s.opts
+	opts.IfMatch, opts.IfNoneMatch = w.ifMatch, w.ifNoneMatch
+	md5sum := md5.Sum(s.content)
+	if err := w.b.write(s.key, s.contentType, s.metadata, &opts, s.content, md5sum[:]); err != nil {
+		return err
+	}
+	delete(w.b.sessions, w.token)
 	return nil
 }
 
+// matchesPreconditions reports whether entry, which is nil if there is no
+// blob, satisfies ifMatch and ifNoneMatch.
+func matchesPreconditions(entry *blobEntry, ifMatch, ifNoneMatch string) bool {
+	if entry == nil {
+		return driver.MatchesPreconditions(false, "", ifMatch, ifNoneMatch)
+	}
+	return driver.MatchesPreconditions(true, entry.Attributes.ETag, ifMatch, ifNoneMatch)
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	if opts.BeforeCopy != nil {
-		return opts.BeforeCopy(func(interface{}) bool { return false })
+		if err := opts.BeforeCopy(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
 	}
 	v := b.blobs[srcKey]
 	if v == nil {
 		return errNotFound
 	}
-	b.blobs[dstKey] = v
+	if !matchesPreconditions(b.blobs[dstKey], opts.IfMatch, opts.IfNoneMatch) {
+		return errPreconditionFailed
+	}
+	if !v.hasCustomerKey(opts.SourceServerSideEncryption) {
+		return errCustomerKey
+	}
+	if b.versioning || v.Attributes.ServerSideEncryption != nil || opts.ServerSideEncryption != nil {
+		// The copy is a new version of dstKey, or is encrypted differently;
+		// don't share attributes.
+		attrs := *v.Attributes
+		attrs.ServerSideEncryption = encryptionAttributes(opts.ServerSideEncryption)
+		v = &blobEntry{Content: v.Content, Attributes: &attrs, CustomerKey: customerKey(opts.ServerSideEncryption)}
+	}
+	b.put(dstKey, v)
 	return nil
 }
 
+// Compose implements driver.Composer.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var content []byte
+	for _, key := range srcKeys {
+		v := b.blobs[key]
+		if v == nil {
+			return errNotFound
+		}
+		if !v.hasCustomerKey(opts.SourceServerSideEncryption) {
+			return errCustomerKey
+		}
+		content = append(content, v.Content...)
+	}
+	md := map[string]string{}
+	for k, v := range opts.Metadata {
+		md[k] = v
+	}
+	md5sum := md5.Sum(content)
+	wopts := &driver.WriterOptions{
+		IfMatch:              opts.IfMatch,
+		IfNoneMatch:          opts.IfNoneMatch,
+		ServerSideEncryption: opts.ServerSideEncryption,
+	}
+	return b.write(dstKey, opts.ContentType, md, wopts, content, md5sum[:])
+}
+
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.blobs[key] == nil {
+	entry := b.entry(key, opts.VersionID)
+	if entry == nil {
 		return errNotFound
 	}
-	delete(b.blobs, key)
+	if !matchesPreconditions(entry, opts.IfMatch, "") {
+		return errPreconditionFailed
+	}
+	cur := b.blobs[key]
+	defer func() { b.notify(key, cur, b.blobs[key]) }()
+	switch {
+	case opts.VersionID == "":
+		// Keep the deleted blob as a previous version.
+		if b.versioning {
+			b.versions[key] = append(b.versions[key], cur)
+		}
+		delete(b.blobs, key)
+	case entry == cur:
+		// Permanently delete the latest version; the previous one, if any,
+		// becomes the latest.
+		delete(b.blobs, key)
+		if prev := b.versions[key]; len(prev) > 0 {
+			b.blobs[key] = prev[len(prev)-1]
+			b.setVersions(key, prev[:len(prev)-1])
+		}
+	default:
+		var keep []*blobEntry
+		for _, e := range b.versions[key] {
+			if e != entry {
+				keep = append(keep, e)
+			}
+		}
+		b.setVersions(key, keep)
+	}
+	return nil
+}
+
+// put makes entry the latest version of key, keeping the current one as a
+// previous version if versioning is enabled. b.mu must be held.
+func (b *bucket) put(key string, entry *blobEntry) {
+	prev := b.blobs[key]
+	if b.versioning {
+		b.lastVersion++
+		entry.Attributes.VersionID = fmt.Sprintf("%016x", b.lastVersion)
+		if prev != nil {
+			b.versions[key] = append(b.versions[key], prev)
+		}
+	}
+	b.blobs[key] = entry
+	b.notify(key, prev, entry)
+}
+
+// Watch implements driver.Watcher.
+func (b *bucket) Watch(ctx context.Context, prefix string) (driver.EventStream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var q *driver.EventQueue
+	q = driver.NewEventQueue(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.watchers, q)
+	})
+	b.watchers[q] = prefix
+	return q, nil
+}
+
+// notify reports the change of key from prev to cur, either of which is nil
+// if there is no blob, to the watchers. b.mu must be held.
+func (b *bucket) notify(key string, prev, cur *blobEntry) {
+	if prev == cur {
+		return
+	}
+	e := driver.Event{Type: driver.EventDeleted, Key: key}
+	if cur != nil {
+		e.Type = driver.EventUpdated
+		if prev == nil {
+			e.Type = driver.EventCreated
+		}
+		e.ETag = cur.Attributes.ETag
+		e.ModTime = cur.Attributes.ModTime
+		e.Size = cur.Attributes.Size
+	}
+	for q, prefix := range b.watchers {
+		if strings.HasPrefix(key, prefix) {
+			e := e
+			q.Push(&e)
+		}
+	}
+}
+
+// setVersions replaces the previous versions of key. b.mu must be held.
+func (b *bucket) setVersions(key string, versions []*blobEntry) {
+	if len(versions) == 0 {
+		delete(b.versions, key)
+		return
+	}
+	b.versions[key] = versions
+}
+
+// entry returns the given version of key, or the latest version if versionID
+// is empty. It returns nil if there is no such version. b.mu must be held.
+func (b *bucket) entry(key, versionID string) *blobEntry {
+	cur := b.blobs[key]
+	if versionID == "" || (cur != nil && cur.Attributes.VersionID == versionID) {
+		return cur
+	}
+	for _, e := range b.versions[key] {
+		if e.Attributes.VersionID == versionID {
+			return e
+		}
+	}
 	return nil
 }
 
+// ListVersions implements driver.Versioner.
+func (b *bucket) ListVersions(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// pageToken is a returned NextPageToken, set below; it's the key and
+	// version ID of the last result of the previous page, separated by "\x00".
+	var tokenKey, tokenVersion string
+	hasToken := len(opts.PageToken) > 0
+	if hasToken {
+		tokenKey, tokenVersion, _ = strings.Cut(string(opts.PageToken), "\x00")
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	keySet := map[string]bool{}
+	for key := range b.blobs {
+		keySet[key] = true
+	}
+	for key := range b.versions {
+		keySet[key] = true
+	}
+	var keys []string
+	for key := range keySet {
+		if strings.HasPrefix(key, opts.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result driver.ListPage
+	for _, key := range keys {
+		// Newest first: the latest version, then previous versions in
+		// reverse order.
+		var entries []*blobEntry
+		if cur := b.blobs[key]; cur != nil {
+			entries = append(entries, cur)
+		}
+		prev := b.versions[key]
+		for i := len(prev) - 1; i >= 0; i-- {
+			entries = append(entries, prev[i])
+		}
+		for _, entry := range entries {
+			versionID := entry.Attributes.VersionID
+			// If there's a pageToken, skip anything up to and including it.
+			// Version IDs increase over time, so newer versions sort higher.
+			if hasToken && (key < tokenKey || (key == tokenKey && versionID >= tokenVersion)) {
+				continue
+			}
+			// If we've already got a full page of results, set NextPageToken and return.
+			if len(result.Objects) == pageSize {
+				last := result.Objects[pageSize-1]
+				result.NextPageToken = []byte(last.Key + "\x00" + last.VersionID)
+				return &result, nil
+			}
+			result.Objects = append(result.Objects, &driver.ListObject{
+				Key:       key,
+				ModTime:   entry.Attributes.ModTime,
+				Size:      entry.Attributes.Size,
+				MD5:       entry.Attributes.MD5,
+				ETag:      entry.Attributes.ETag,
+				VersionID: versionID,
+				IsLatest:  entry == b.blobs[key],
+			})
+		}
+	}
+	return &result, nil
+}
+
+// SignedURL implements driver.SignedURL.
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
-	return "", errNotImplemented
+	if b.urlSigner == nil {
+		return "", gdkerr.New(gdkerr.Unimplemented, nil, 1, "memblob.SignedURL: bucket does not have an Options.URLSigner")
+	}
+	if opts.BeforeSign != nil {
+		if err := opts.BeforeSign(func(interface{}) bool { return false }); err != nil {
+			return "", err
+		}
+	}
+	surl, err := b.urlSigner.URLFromKey(ctx, key, opts)
+	if err != nil {
+		return "", err
+	}
+	return surl.String(), nil
 }
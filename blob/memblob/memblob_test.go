@@ -1,17 +1,34 @@
 package memblob
 
 import (
+	"compress/gzip"
 	"context"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
+
 	"github.com/sraphs/gdk/blob"
 	"github.com/sraphs/gdk/blob/driver"
 	"github.com/sraphs/gdk/blob/drivertest"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/secrets/localsecrets"
 )
 
 type harness struct {
-	prefix string
+	prefix     string
+	versioning bool
+	encrypted  bool
+	compressor driver.Compressor
+	cached     bool
+	overlay    bool
+	replicated bool
+	trash      bool
+	drv        driver.Bucket
+	server     *http.Server
 }
 
 func newHarness(ctx context.Context, t *testing.T, prefix string) (drivertest.Harness, error) {
@@ -19,11 +36,74 @@ func newHarness(ctx context.Context, t *testing.T, prefix string) (drivertest.Ha
 }
 
 func (h *harness) HTTPClient() *http.Client {
-	return nil
+	if h.compressor != nil && h.compressor.ContentEncoding() != "gzip" {
+		// net/http only decodes gzip; do what browsers do for the others.
+		return &http.Client{Transport: decodingTransport{h.compressor}}
+	}
+	return &http.Client{}
+}
+
+// decodingTransport decodes the bodies of the responses with the content
+// encoding of c.
+type decodingTransport struct {
+	c driver.Compressor
+}
+
+func (t decodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || resp.Header.Get("Content-Encoding") != t.c.ContentEncoding() {
+		return resp, err
+	}
+	r, err := t.c.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	body := resp.Body
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{r, closerFunc(func() error {
+		r.Close()
+		return body.Close()
+	})}
+	resp.Header.Del("Content-Encoding")
+	resp.ContentLength = -1
+	return resp, nil
 }
 
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
 func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
-	drv := openBucket(nil)
+	// Serve signed URLs for the driver, so that TestSignedURL runs.
+	if h.drv == nil {
+		var err error
+		h.drv, h.server, err = serveSignedURLs("", &Options{Versioning: h.versioning})
+		if err != nil {
+			return nil, err
+		}
+	}
+	drv := h.drv
+	if h.encrypted {
+		drv = driver.NewEncryptedBucket(drv, localsecrets.NewKeeper([32]byte{1}))
+	}
+	if c := h.compressor; c != nil {
+		drv = driver.NewCompressedBucket(drv, func(string) driver.Compressor { return c }, []driver.Compressor{c})
+	}
+	if h.cached {
+		drv = driver.NewCachingBucket(drv, openBucket(nil), &driver.CacheOptions{MaxSize: 1 << 20})
+	}
+	if h.overlay {
+		drv = driver.NewOverlayBucket([]driver.Bucket{drv, openBucket(nil)}, false)
+	}
+	if h.replicated {
+		drv = driver.NewReplicatedBucket([]driver.Bucket{drv, openBucket(nil)}, nil)
+	}
+	if h.trash {
+		drv = driver.NewTrashBucket(drv, "")
+	}
 	if h.prefix == "" {
 		return drv, nil
 	}
@@ -35,7 +115,11 @@ func (h *harness) MakeDriverForNonexistentBucket(ctx context.Context) (driver.Bu
 	return nil, nil
 }
 
-func (h *harness) Close() {}
+func (h *harness) Close() {
+	if h.server != nil {
+		h.server.Close()
+	}
+}
 
 func TestConformance(t *testing.T) {
 	newHarnessNoPrefix := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
@@ -52,6 +136,70 @@ func TestConformanceWithPrefix(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarnessWithPrefix, nil)
 }
 
+func TestConformanceEncrypted(t *testing.T) {
+	newHarnessEncrypted := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{encrypted: true}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessEncrypted, nil)
+}
+
+func TestConformanceCompressed(t *testing.T) {
+	newHarnessCompressed := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{compressor: blob.GzipCompressor(gzip.BestSpeed)}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessCompressed, nil)
+}
+
+func TestConformanceCompressedZstd(t *testing.T) {
+	newHarnessCompressed := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{compressor: blob.ZstdCompressor(zstd.SpeedFastest)}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessCompressed, nil)
+}
+
+func TestConformanceCached(t *testing.T) {
+	newHarnessCached := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{cached: true}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessCached, nil)
+}
+
+func TestConformanceOverlay(t *testing.T) {
+	newHarnessOverlay := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{overlay: true}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessOverlay, nil)
+}
+
+func TestConformanceReplicated(t *testing.T) {
+	newHarnessReplicated := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{replicated: true}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessReplicated, nil)
+}
+
+func TestConformanceTrash(t *testing.T) {
+	newHarnessTrash := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{trash: true}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessTrash, nil)
+}
+
+func TestConformanceWithVersioning(t *testing.T) {
+	newHarnessWithVersioning := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{versioning: true}, nil
+	}
+	drivertest.RunConformanceTests(t, newHarnessWithVersioning, nil)
+	drivertest.RunVersioningTests(t, newHarnessWithVersioning)
+}
+
+func TestVersioningWithPrefix(t *testing.T) {
+	newHarnessWithVersioning := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return &harness{prefix: "some/prefix/dir/", versioning: true}, nil
+	}
+	drivertest.RunVersioningTests(t, newHarnessWithVersioning)
+}
+
 func BenchmarkMemblob(b *testing.B) {
 	drivertest.RunBenchmarks(b, OpenBucket(nil))
 }
@@ -65,6 +213,10 @@ func TestOpenBucketFromURL(t *testing.T) {
 		{"mem://", false},
 		// With prefix.
 		{"mem://?prefix=foo/bar", false},
+		// With versioning.
+		{"mem://?versioning=true", false},
+		// Invalid versioning.
+		{"mem://?versioning=maybe", true},
 		// Invalid parameter.
 		{"mem://?param=value", true},
 	}
@@ -80,3 +232,44 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestServeSignedURLsWithURLSigner(t *testing.T) {
+	ctx := context.Background()
+	// Find a free port to sign URLs for.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	signer := fileblob.NewURLSignerHMAC(&url.URL{Scheme: "http", Host: addr}, []byte("secret"))
+	b, srv, err := ServeSignedURLs(addr, &Options{URLSigner: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "a", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	surl, err := b.SignedURL(ctx, "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(surl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := signer.KeyFromURL(ctx, u); err != nil || key != "a" {
+		t.Errorf("URL not signed by the URLSigner: got %q, %v want a", key, err)
+	}
+	resp, err := http.Get(surl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, err := io.ReadAll(resp.Body); err != nil || string(got) != "hello" {
+		t.Errorf("GET %s: got %q, %v want hello", surl, got, err)
+	}
+}
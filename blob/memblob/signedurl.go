@@ -0,0 +1,81 @@
+package memblob
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/blobhttp"
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/blob/fileblob"
+)
+
+// URLSigner defines an interface for creating and verifying a signed URL for
+// objects in a memblob bucket. It has the same contract as
+// fileblob.URLSigner, so fileblob.URLSignerHMAC can be used as one.
+type URLSigner interface {
+	// URLFromKey defines how the bucket's object key will be turned
+	// into a signed URL. URLFromKey must be safe to call from multiple goroutines.
+	URLFromKey(ctx context.Context, key string, opts *driver.SignedURLOptions) (*url.URL, error)
+
+	// KeyFromURL must be able to validate a URL returned from URLFromKey.
+	// KeyFromURL must only return the object if the URL is both unexpired
+	// and authentic. KeyFromURL must be safe to call from multiple
+	// goroutines. Implementations of KeyFromURL should not modify the URL
+	// argument.
+	KeyFromURL(ctx context.Context, surl *url.URL) (string, error)
+}
+
+// ServeSignedURLs creates a *blob.Bucket backed by memory, whose signed URLs
+// are served by an HTTP server listening on addr, for testing code that uses
+// blob.Bucket.SignedURL. The server honors GET, PUT and DELETE URLs until they
+// expire, and requires PUT requests to have the Content-Type the URL was
+// signed for (or none, if it was signed without one).
+//
+// If addr is empty, the server listens on a free port of the loopback
+// interface. If opts.URLSigner is nil, URLs are signed for the server with a
+// fileblob.URLSignerHMAC and a random secret key; otherwise, opts.URLSigner
+// must sign URLs for addr.
+//
+// The caller must Close the returned server when done with it.
+func ServeSignedURLs(addr string, opts *Options) (*blob.Bucket, *http.Server, error) {
+	drv, srv, err := serveSignedURLs(addr, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blob.NewBucket(drv), srv, nil
+}
+
+// serveSignedURLs implements ServeSignedURLs, returning the driver.
+func serveSignedURLs(addr string, opts *Options) (driver.Bucket, *http.Server, error) {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.URLSigner == nil {
+		secretKey := make([]byte, 32)
+		if _, err := rand.Read(secretKey); err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		o.URLSigner = fileblob.NewURLSignerHMAC(&url.URL{Scheme: "http", Host: l.Addr().String()}, secretKey)
+	}
+	drv := openBucket(&o)
+	srv := &http.Server{Handler: blobhttp.NewHandler(blob.NewBucket(drv), &blobhttp.Options{
+		URLVerifier: o.URLSigner,
+		AllowPut:    true,
+		AllowDelete: true,
+	})}
+	go srv.Serve(l)
+	return drv, srv, nil
+}
@@ -0,0 +1,139 @@
+package blob_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestOverlayBucket(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	open := func() *blob.Bucket {
+		t.Helper()
+		b, err := fileblob.OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// lower is the same directory as the lower layer.
+	lower := open()
+	defer lower.Close()
+	top := memblob.OpenBucket(nil)
+	write := func(b *blob.Bucket, key, content string) {
+		t.Helper()
+		if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"a", "dir/b", "dir/c", "shared", "z"} {
+		write(lower, key, "lower "+key)
+	}
+	write(top, "shared", "top shared")
+	write(top, "top", "top")
+	b := blob.OverlayBucket([]*blob.Bucket{top, open()}, nil)
+	defer b.Close()
+
+	read := func(b *blob.Bucket, key, want string) {
+		t.Helper()
+		got, err := b.ReadAll(ctx, key)
+		if want == "" {
+			if gdkerr.Code(err) != gdkerr.NotFound {
+				t.Errorf("reading %s: got %q, %v want NotFound", key, got, err)
+			}
+		} else if err != nil || string(got) != want {
+			t.Errorf("reading %s: got %q, %v want %q", key, got, err, want)
+		}
+	}
+	list := func(delimiter string, pageSize int) []string {
+		t.Helper()
+		var keys []string
+		token := blob.FirstPageToken
+		for {
+			objs, next, err := b.ListPage(ctx, token, pageSize, &blob.ListOptions{Delimiter: delimiter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range objs {
+				keys = append(keys, obj.Key)
+			}
+			if len(next) == 0 {
+				return keys
+			}
+			token = next
+		}
+	}
+
+	read(b, "a", "lower a")
+	read(b, "shared", "top shared")
+	read(b, "top", "top")
+	if diff := cmp.Diff([]string{"a", "dir/b", "dir/c", "shared", "top", "z"}, list("", 2)); diff != "" {
+		t.Errorf("list (-want +got):\n%s", diff)
+	}
+
+	// Writes go to the top layer.
+	write(b, "a", "new a")
+	read(b, "a", "new a")
+	read(lower, "a", "lower a")
+	if err := b.Copy(ctx, "copy", "dir/c", nil); err != nil {
+		t.Fatal(err)
+	}
+	read(b, "copy", "lower dir/c")
+	read(lower, "copy", "")
+	// Copy preconditions apply to the destination in the lower layers too,
+	// whichever layer the source is in.
+	for _, src := range []string{"top", "dir/c"} {
+		err := b.Copy(ctx, "z", src, &blob.CopyOptions{IfNoneMatch: "*"})
+		if gdkerr.Code(err) != gdkerr.FailedPrecondition {
+			t.Errorf("copying %s over lower z with IfNoneMatch: got %v want FailedPrecondition", src, err)
+		}
+	}
+	read(b, "z", "lower z")
+
+	// Deletes hide the blobs in the lower layers.
+	if err := b.Delete(ctx, "dir/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "shared"); err != nil {
+		t.Fatal(err)
+	}
+	read(b, "dir/b", "")
+	read(b, "shared", "")
+	read(lower, "dir/b", "lower dir/b")
+	if err := b.Delete(ctx, "shared"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("deleting deleted blob: got %v want NotFound", err)
+	}
+	if diff := cmp.Diff([]string{"a", "copy", "dir/", "top", "z"}, list("/", 1)); diff != "" {
+		t.Errorf("list with delimiter (-want +got):\n%s", diff)
+	}
+	if err := b.Delete(ctx, "dir/c"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a", "copy", "top", "z"}, list("/", 10)); diff != "" {
+		t.Errorf("list with delimiter after emptying dir (-want +got):\n%s", diff)
+	}
+
+	// Writing a deleted blob makes it visible again.
+	write(b, "dir/b", "new b")
+	read(b, "dir/b", "new b")
+	if diff := cmp.Diff([]string{"a", "copy", "dir/b", "top", "z"}, list("", 3)); diff != "" {
+		t.Errorf("list after rewriting (-want +got):\n%s", diff)
+	}
+
+	ro := blob.OverlayBucket([]*blob.Bucket{open()}, &blob.OverlayOptions{ReadOnly: true})
+	defer ro.Close()
+	read(ro, "z", "lower z")
+	if err := ro.WriteAll(ctx, "z", []byte("new z"), nil); gdkerr.Code(err) != gdkerr.PermissionDenied {
+		t.Errorf("writing to read-only overlay: got %v want PermissionDenied", err)
+	}
+	if err := ro.Delete(ctx, "z"); gdkerr.Code(err) != gdkerr.PermissionDenied {
+		t.Errorf("deleting from read-only overlay: got %v want PermissionDenied", err)
+	}
+}
@@ -0,0 +1,113 @@
+package blob
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Progress reports how much of a transfer by a Reader or Writer is done; see
+// ReaderOptions.Progress and WriterOptions.Progress.
+type Progress struct {
+	// Transferred is the number of bytes read or written so far.
+	Transferred int64
+	// Total is the number of bytes to transfer, or -1 if it isn't known.
+	Total int64
+	// Rate is the average number of bytes transferred per second since the
+	// Reader or Writer was created.
+	Rate float64
+}
+
+// BandwidthLimiter limits the rate at which Readers and Writers transfer
+// data, with a token bucket. It may be shared by any number of transfers, on
+// any number of buckets, which then share the bandwidth; see
+// ReaderOptions.BandwidthLimiter, WriterOptions.BandwidthLimiter and
+// Bucket.SetBandwidthLimiter.
+//
+// A BandwidthLimiter is safe for concurrent use.
+type BandwidthLimiter struct {
+	l *rate.Limiter
+}
+
+// NewBandwidthLimiter returns a BandwidthLimiter allowing bytesPerSecond
+// bytes per second on average, in bursts of up to burst bytes. If burst is
+// not positive, it defaults to bytesPerSecond. If bytesPerSecond is not
+// positive, the rate is unlimited.
+func NewBandwidthLimiter(bytesPerSecond, burst int) *BandwidthLimiter {
+	l := &BandwidthLimiter{l: rate.NewLimiter(rate.Inf, 0)}
+	l.SetLimit(bytesPerSecond, burst)
+	return l
+}
+
+// SetLimit changes the limits of l, with the same semantics as
+// NewBandwidthLimiter. Transfers waiting for l are not affected until they
+// wait again.
+func (l *BandwidthLimiter) SetLimit(bytesPerSecond, burst int) {
+	if bytesPerSecond <= 0 {
+		l.l.SetLimit(rate.Inf)
+		return
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	l.l.SetBurst(burst)
+	l.l.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// wait blocks until n bytes may be transferred, or ctx is done.
+func (l *BandwidthLimiter) wait(ctx context.Context, n int) error {
+	for n > 0 {
+		// WaitN fails for more than a burst at once.
+		k := n
+		if burst := l.l.Burst(); burst > 0 && k > burst {
+			k = burst
+		}
+		if err := l.l.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
+// transfer tracks the bytes transferred by a Reader or a Writer, to report
+// progress and limit bandwidth. A nil *transfer does nothing.
+type transfer struct {
+	ctx      context.Context
+	limiter  *BandwidthLimiter
+	progress func(Progress)
+	start    time.Time
+	total    int64
+	done     int64
+}
+
+// newTransfer returns a transfer for a Reader or Writer, or nil if there
+// is no limiter nor progress callback.
+func newTransfer(ctx context.Context, limiter *BandwidthLimiter, progress func(Progress), total int64) *transfer {
+	if limiter == nil && progress == nil {
+		return nil
+	}
+	return &transfer{ctx: ctx, limiter: limiter, progress: progress, start: time.Now(), total: total}
+}
+
+// wait blocks until n bytes may be transferred; see BandwidthLimiter.
+func (t *transfer) wait(n int) error {
+	if t == nil || t.limiter == nil || n <= 0 {
+		return nil
+	}
+	return t.limiter.wait(t.ctx, n)
+}
+
+// add records that n more bytes were transferred, and reports progress.
+func (t *transfer) add(n int) {
+	if t == nil || t.progress == nil || n <= 0 {
+		return
+	}
+	t.done += int64(n)
+	p := Progress{Transferred: t.done, Total: t.total}
+	if secs := time.Since(t.start).Seconds(); secs > 0 {
+		p.Rate = float64(t.done) / secs
+	}
+	t.progress(p)
+}
@@ -0,0 +1,104 @@
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/memblob"
+)
+
+func TestProgress(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	content := bytes.Repeat([]byte("x"), 1000)
+	var writes []blob.Progress
+	opts := &blob.WriterOptions{Progress: func(p blob.Progress) { writes = append(writes, p) }}
+	if err := b.WriteAll(ctx, "key", content, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 1 || writes[0].Transferred != 1000 || writes[0].Total != 1000 {
+		t.Errorf("write progress: got %+v want 1000 of 1000 bytes", writes)
+	}
+
+	writes = nil
+	w, err := b.NewWriter(ctx, "key", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write(content[:100]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 4 || writes[3].Transferred != 400 || writes[3].Total != -1 {
+		t.Errorf("write progress without total: got %+v want 400 bytes of unknown total", writes)
+	}
+
+	var reads []blob.Progress
+	r, err := b.NewRangeReader(ctx, "key", 100, 250, &blob.ReaderOptions{Progress: func(p blob.Progress) { reads = append(reads, p) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	buf := make([]byte, 100)
+	for {
+		if _, err := r.Read(buf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(reads) != 3 || reads[2].Transferred != 250 || reads[2].Total != 250 || reads[2].Rate <= 0 {
+		t.Errorf("read progress: got %+v want 250 of 250 bytes in 3 reads", reads)
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	// 300 bytes at 1000 bytes/s, with a burst of 100, take at least 200ms.
+	content := bytes.Repeat([]byte("x"), 300)
+	start := time.Now()
+	if err := b.WriteAll(ctx, "key", content, &blob.WriterOptions{BandwidthLimiter: blob.NewBandwidthLimiter(1000, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("limited write took %v, want at least 200ms", d)
+	}
+
+	b.SetBandwidthLimiter(blob.NewBandwidthLimiter(1000, 100))
+	start = time.Now()
+	if _, err := b.ReadAll(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("limited read took %v, want at least 200ms", d)
+	}
+
+	// The limiter can be lifted, and a canceled transfer stops waiting.
+	b.SetBandwidthLimiter(nil)
+	if _, err := b.ReadAll(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	w, err := b.NewWriter(cctx, "key", &blob.WriterOptions{BandwidthLimiter: blob.NewBandwidthLimiter(1, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err == nil {
+		t.Error("write with canceled context: got nil want error")
+	}
+	_ = w.Close()
+}
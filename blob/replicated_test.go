@@ -0,0 +1,148 @@
+package blob_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestReplicatedBucket(t *testing.T) {
+	ctx := context.Background()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	open := func(dir string) *blob.Bucket {
+		t.Helper()
+		b, err := fileblob.OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// Replicas fail while their directory is replaced by a file.
+	breakReplica := func(i int) {
+		t.Helper()
+		if err := os.Rename(dirs[i], dirs[i]+".bak"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dirs[i], nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fixReplica := func(i int) {
+		t.Helper()
+		if err := os.Remove(dirs[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(dirs[i]+".bak", dirs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// raw are the same directories, without replication.
+	raw := []*blob.Bucket{open(dirs[0]), open(dirs[1])}
+	defer raw[0].Close()
+	defer raw[1].Close()
+	b := blob.ReplicatedBucket([]*blob.Bucket{open(dirs[0]), open(dirs[1])}, &blob.ReplicationOptions{
+		WriteQuorum:         1,
+		UnhealthyPeriod:     time.Hour,
+		RepairRetryInterval: 10 * time.Millisecond,
+	})
+	defer b.Close()
+	all := blob.ReplicatedBucket([]*blob.Bucket{open(dirs[0]), open(dirs[1])}, nil)
+	defer all.Close()
+
+	read := func(b *blob.Bucket, key, want string) {
+		t.Helper()
+		if got, err := b.ReadAll(ctx, key); err != nil || string(got) != want {
+			t.Errorf("reading %s: got %q, %v want %q", key, got, err, want)
+		}
+	}
+	status := func(key string) *blob.ReplicationStatus {
+		t.Helper()
+		s, err := b.ReplicationStatus(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// Writes go to all the replicas, with the same ETag.
+	if err := b.WriteAll(ctx, "a", []byte("aaaa"), nil); err != nil {
+		t.Fatal(err)
+	}
+	read(raw[0], "a", "aaaa")
+	read(raw[1], "a", "aaaa")
+	s := status("a")
+	if !s.InSync || len(s.Replicas) != 2 || !s.Replicas[0].Exists || s.Replicas[0].ETag != s.Replicas[1].ETag {
+		t.Errorf("status after write: got %+v want in sync", s)
+	}
+	attrs, err := b.Attributes(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ETag != s.Replicas[0].ETag || len(attrs.Metadata) != 0 {
+		t.Errorf("attributes: got ETag %q, metadata %v want ETag %q and no metadata", attrs.ETag, attrs.Metadata, s.Replicas[0].ETag)
+	}
+
+	// Writes succeed with a quorum, and the failed replicas are repaired.
+	breakReplica(1)
+	if err := b.WriteAll(ctx, "b", []byte("bbbb"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := all.WriteAll(ctx, "c", []byte("cccc"), nil); err == nil {
+		t.Error("writing without a quorum: got nil want error")
+	}
+	read(b, "b", "bbbb")
+	if s := status("b"); s.InSync || !s.Replicas[1].Pending {
+		t.Errorf("status of failed write: got %+v want pending repair", s)
+	}
+	fixReplica(1)
+	for deadline := time.Now().Add(5 * time.Second); !status("b").InSync; {
+		if time.Now().After(deadline) {
+			t.Fatal("failed replica was not repaired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	read(raw[1], "b", "bbbb")
+
+	// Reads fail over to the healthy replicas.
+	breakReplica(0)
+	read(b, "a", "aaaa")
+	if _, err := b.ReadAll(ctx, "missing"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("reading missing blob: got %v want NotFound", err)
+	}
+	fixReplica(0)
+
+	// Deletes go to all the replicas.
+	if err := b.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range raw {
+		if ok, err := r.Exists(ctx, "a"); err != nil || ok {
+			t.Errorf("replica %d after delete: got %v, %v want false", i, ok, err)
+		}
+	}
+	if s := status("a"); !s.InSync || s.Replicas[0].Exists {
+		t.Errorf("status after delete: got %+v want in sync", s)
+	}
+
+	// PrefixedBucket reports the status of the blobs with its prefix.
+	prefixed := blob.PrefixedBucket(blob.ReplicatedBucket([]*blob.Bucket{memblob.OpenBucket(nil), memblob.OpenBucket(nil)}, nil), "p/")
+	defer prefixed.Close()
+	if err := prefixed.WriteAll(ctx, "a", []byte("pa"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := prefixed.ReplicationStatus(ctx, "a"); err != nil || len(s.Replicas) != 2 || !s.Replicas[0].Exists {
+		t.Errorf("status of prefixed blob: got %+v, %v want 2 replicas holding it", s, err)
+	}
+
+	mem := blob.PrefixedBucket(memblob.OpenBucket(nil), "p/")
+	defer mem.Close()
+	if _, err := mem.ReplicationStatus(ctx, "a"); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("status of non-replicated bucket: got %v want Unimplemented", err)
+	}
+}
@@ -0,0 +1,140 @@
+package s3blob
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+const (
+	// minComposePartSize is the smallest part S3 accepts, except for the
+	// last part of a multipart upload.
+	minComposePartSize = 5 << 20
+	// maxComposePartSize is the largest range UploadPartCopy can copy.
+	maxComposePartSize = 5 << 30
+)
+
+// Compose implements driver.Composer. The composed blob is a multipart upload
+// whose parts are copied from the sources with UploadPartCopy, so every
+// source except the last one must be at least 5 MiB.
+func (b *bucket) Compose(ctx context.Context, dstKey string, srcKeys []string, opts *driver.ComposeOptions) error {
+	// Check the sources before starting the upload, and make sure that they
+	// don't change while they are copied.
+	attrs := make([]*driver.Attributes, len(srcKeys))
+	for i, key := range srcKeys {
+		a, err := b.composeSource(ctx, key, opts.SourceServerSideEncryption)
+		if err != nil {
+			return err
+		}
+		if a.Size < minComposePartSize && i < len(srcKeys)-1 {
+			return gdkerr.Newf(gdkerr.Unimplemented, nil, "s3blob: Compose source %q is smaller than %d bytes", key, minComposePartSize)
+		}
+		attrs[i] = a
+	}
+	if len(srcKeys) == 1 && attrs[0].Size == 0 {
+		return gdkerr.Newf(gdkerr.Unimplemented, nil, "s3blob: Compose of a single empty blob")
+	}
+
+	dstKey = escapeKey(dstKey)
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.name),
+		ContentType: aws.String(opts.ContentType),
+		Key:         aws.String(dstKey),
+		Metadata:    escapeMetadata(opts.Metadata),
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = serverSideEncryption(opts.ServerSideEncryption)
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
+	out, err := b.client.CreateMultipartUpload(ctx, in)
+	if err != nil {
+		return err
+	}
+	uploadID := out.UploadId
+	if err := b.composeParts(ctx, dstKey, uploadID, srcKeys, attrs, opts); err != nil {
+		// The upload ID is the only handle on the parts; don't leave them
+		// behind.
+		b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(b.name),
+			Key:      aws.String(dstKey),
+			UploadId: uploadID,
+		})
+		return err
+	}
+	return nil
+}
+
+// composeSource returns the attributes of the source blob for key, which may
+// be encrypted with the customer key sse; only Size and ETag are set. Unlike
+// Attributes, it passes the key, which S3 needs to return them.
+func (b *bucket) composeSource(ctx context.Context, key string, sse *driver.ServerSideEncryption) (*driver.Attributes, error) {
+	in := &s3.HeadObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(escapeKey(key)),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(sse)
+	resp, err := b.client.HeadObject(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{Size: resp.ContentLength, ETag: aws.ToString(resp.ETag)}, nil
+}
+
+// composeParts copies the sources into the multipart upload uploadID for
+// dstKey, which is already escaped, and completes it.
+func (b *bucket) composeParts(ctx context.Context, dstKey string, uploadID *string, srcKeys []string, attrs []*driver.Attributes, opts *driver.ComposeOptions) error {
+	var parts []types.CompletedPart
+	for i, key := range srcKeys {
+		size := attrs[i].Size
+		if size == 0 {
+			// Only the last source can be empty; there is nothing to copy.
+			continue
+		}
+		// Split large sources into equal ranges, so that none of them is
+		// too small to be a part.
+		n := (size + maxComposePartSize - 1) / maxComposePartSize
+		for start, j := int64(0), int64(0); j < n; j++ {
+			end := size * (j + 1) / n
+			partNumber := int32(len(parts) + 1)
+			in := &s3.UploadPartCopyInput{
+				Bucket:          aws.String(b.name),
+				CopySource:      aws.String(b.name + "/" + escapeKey(key)),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end-1)),
+				Key:             aws.String(dstKey),
+				PartNumber:      partNumber,
+				UploadId:        uploadID,
+			}
+			if attrs[i].ETag != "" {
+				in.CopySourceIfMatch = aws.String(attrs[i].ETag)
+			}
+			in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
+			in.CopySourceSSECustomerAlgorithm, in.CopySourceSSECustomerKey, in.CopySourceSSECustomerKeyMD5 = customerKey(opts.SourceServerSideEncryption)
+			out, err := b.client.UploadPartCopy(ctx, in)
+			if err != nil {
+				return err
+			}
+			var eTag *string
+			if out.CopyPartResult != nil {
+				eTag = out.CopyPartResult.ETag
+			}
+			parts = append(parts, types.CompletedPart{ETag: eTag, PartNumber: partNumber})
+			start = end
+		}
+	}
+	in := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.name),
+		Key:             aws.String(dstKey),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
+	if err := b.checkPreconditions(ctx, dstKey, opts.IfMatch, opts.IfNoneMatch, opts.ServerSideEncryption); err != nil {
+		return err
+	}
+	_, err := b.client.CompleteMultipartUpload(ctx, in, withConditions(opts.IfMatch, opts.IfNoneMatch, "CompleteMultipartUpload"))
+	return err
+}
@@ -24,6 +24,20 @@
 //     experimentation.
 //   - Metadata values: Escaped using URL encoding.
 //
+// # Server-side encryption
+//
+// blob.ServerSideEncryption maps to SSE-S3 (EncryptionServiceKey), SSE-KMS
+// (EncryptionKMS) and SSE-C (EncryptionCustomerKey). S3 requires the key of
+// SSE-C objects to read their attributes, which Bucket.Attributes doesn't
+// take, so Attributes and Delete fail for them; use the Reader instead.
+//
+// # Preconditions
+//
+// IfMatch and IfNoneMatch are sent as conditional headers, but not every
+// S3-compatible service honors them on writes, copies and deletes. s3blob
+// therefore also checks them against the current ETag of the blob first; the
+// check and the operation that follows it are not atomic on such services.
+//
 // # As
 //
 // s3blob exposes the following types for As:
@@ -36,7 +50,8 @@
 //   - ReaderOptions.BeforeRead: *s3.GetObjectInput
 //   - Attributes: s3.HeadObjectOutput
 //   - CopyOptions.BeforeCopy: s3.CopyObjectInput
-//   - WriterOptions.BeforeWrite: *s3.PutObjectInput, *s3manager.Uploader
+//   - WriterOptions.BeforeWrite: *s3.PutObjectInput, *s3manager.Uploader, or
+//     *s3.CreateMultipartUploadInput when starting a resumable upload
 //   - SignedURLOptions.BeforeSign:
 //     *s3.GetObjectInput, when Options.Method == http.MethodGet, or
 //     *s3.PutObjectInput, when Options.Method == http.MethodPut, or
@@ -45,6 +60,7 @@ package s3blob
 
 import (
 	"context"
+	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -57,11 +73,14 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/google/wire"
 
 	"github.com/sraphs/gdk/blob"
@@ -134,6 +153,18 @@ type Options struct {
 	// Some S3-compatible services (like CEPH) do not currently support
 	// ListObjects.
 	UseLegacyList bool
+
+	// Checksums makes Attributes ask S3 for the checksums it stores with
+	// objects, and report them in Attributes.Checksums. Some S3-compatible
+	// services don't support it. Checksums given in WriterOptions are passed
+	// to S3 regardless.
+	Checksums bool
+
+	// Tags makes Attributes ask S3 for the tags of objects, with an extra
+	// GetObjectTagging request, and report them in Attributes.Tags. Tags
+	// given in WriterOptions, and changed with Bucket.SetTags, are passed to
+	// S3 regardless.
+	Tags bool
 }
 
 // openBucket returns an S3 Bucket.
@@ -153,6 +184,8 @@ func openBucket(ctx context.Context, client *s3.Client, bucketName string, opts
 		name:          bucketName,
 		client:        client,
 		useLegacyList: opts.UseLegacyList,
+		checksums:     opts.Checksums,
+		tags:          opts.Tags,
 	}, nil
 }
 
@@ -202,6 +235,9 @@ type writer struct {
 	// v2
 	uploader *manager.Uploader
 	req      *s3.PutObjectInput
+	// check, if non-nil, checks the preconditions of the write before the
+	// upload starts.
+	check func() error
 
 	donec chan struct{} // closed when done writing
 	// The following fields will be written before donec closes:
@@ -244,8 +280,13 @@ func (w *writer) open(pr *io.PipeReader) error {
 			body = http.NoBody
 		}
 		var err error
-		w.req.Body = body
-		_, err = w.uploader.Upload(w.ctx, w.req)
+		if w.check != nil {
+			err = w.check()
+		}
+		if err == nil {
+			w.req.Body = body
+			_, err = w.uploader.Upload(w.ctx, w.req)
+		}
 		if err != nil {
 			w.err = err
 			if pr != nil {
@@ -276,6 +317,8 @@ type bucket struct {
 	name          string
 	client        *s3.Client
 	useLegacyList bool
+	checksums     bool
+	tags          bool
 }
 
 func (b *bucket) Close() error {
@@ -295,8 +338,10 @@ func (b *bucket) ErrorCode(err error) gdkerr.ErrorCode {
 		return gdkerr.Unknown
 	}
 	switch {
-	case code == "NoSuchBucket" || code == "NoSuchKey" || code == "NotFound":
+	case code == "NoSuchBucket" || code == "NoSuchKey" || code == "NoSuchUpload" || code == "NotFound":
 		return gdkerr.NotFound
+	case code == "PreconditionFailed" || code == "NotModified" || code == "ConditionalRequestConflict":
+		return gdkerr.FailedPrecondition
 	default:
 		return gdkerr.Unknown
 	}
@@ -338,6 +383,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 				ModTime: *obj.LastModified,
 				Size:    obj.Size,
 				MD5:     eTagToMD5(obj.ETag),
+				ETag:    aws.ToString(obj.ETag),
 				AsFunc: func(i interface{}) bool {
 					p, ok := i.(*types.Object)
 					if !ok {
@@ -454,6 +500,9 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		Bucket: aws.String(b.name),
 		Key:    aws.String(key),
 	}
+	if b.checksums {
+		in.ChecksumMode = types.ChecksumModeEnabled
+	}
 	resp, err := b.client.HeadObject(ctx, in)
 	if err != nil {
 		return nil, err
@@ -465,6 +514,22 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		// keys & values.
 		md[escape.HexUnescape(escape.URLUnescape(k))] = escape.URLUnescape(v)
 	}
+	var tags map[string]string
+	if b.tags {
+		out, err := b.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range out.TagSet {
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+		}
+	}
 	return &driver.Attributes{
 		CacheControl:       aws.ToString(resp.CacheControl),
 		ContentDisposition: aws.ToString(resp.ContentDisposition),
@@ -472,11 +537,20 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ContentLanguage:    aws.ToString(resp.ContentLanguage),
 		ContentType:        aws.ToString(resp.ContentType),
 		Metadata:           md,
+		Tags:               tags,
+		StorageClass:       string(resp.StorageClass),
 		// CreateTime not supported; left as the zero time.
 		ModTime: aws.ToTime(resp.LastModified),
 		Size:    resp.ContentLength,
 		MD5:     eTagToMD5(resp.ETag),
-		ETag:    aws.ToString(resp.ETag),
+		Checksums: checksumsFromS3(map[driver.ChecksumAlgorithm]*string{
+			driver.ChecksumCRC32:  resp.ChecksumCRC32,
+			driver.ChecksumCRC32C: resp.ChecksumCRC32C,
+			driver.ChecksumSHA1:   resp.ChecksumSHA1,
+			driver.ChecksumSHA256: resp.ChecksumSHA256,
+		}),
+		ETag:                 aws.ToString(resp.ETag),
+		ServerSideEncryption: encryptionFromS3(resp.ServerSideEncryption, resp.SSEKMSKeyId, resp.SSECustomerAlgorithm, resp.SSECustomerKeyMD5),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*s3.HeadObjectOutput)
 			if !ok {
@@ -506,6 +580,13 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 		Key:    aws.String(key),
 		Range:  byteRange,
 	}
+	if opts.IfMatch != "" {
+		in.IfMatch = aws.String(opts.IfMatch)
+	}
+	if opts.IfNoneMatch != "" {
+		in.IfNoneMatch = aws.String(opts.IfNoneMatch)
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
 	if opts.BeforeRead != nil {
 		asFunc := func(i interface{}) bool {
 			if p, ok := i.(**s3.GetObjectInput); ok {
@@ -532,6 +613,7 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: aws.ToString(resp.ContentType),
 			ModTime:     aws.ToTime(resp.LastModified),
 			Size:        getSize(resp.ContentLength, aws.ToString(resp.ContentRange)),
+			ETag:        aws.ToString(resp.ETag),
 		},
 		raw: resp,
 	}, nil
@@ -613,26 +695,29 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
 		if opts.BufferSize != 0 {
 			u.PartSize = int64(opts.BufferSize)
+			// The Uploader fails with smaller parts.
+			if u.PartSize < manager.MinUploadPartSize {
+				u.PartSize = manager.MinUploadPartSize
+			}
 		}
 		if opts.MaxConcurrency != 0 {
 			u.Concurrency = opts.MaxConcurrency
 		}
+		if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+			u.ClientOptions = append(u.ClientOptions, withConditions(opts.IfMatch, opts.IfNoneMatch, "PutObject", "CompleteMultipartUpload"))
+		}
+		if header, value := checksumHeader(opts.Checksums); header != "" {
+			// Checksums of the whole content only apply to single part
+			// uploads; those of multipart uploads are checksums of the
+			// checksums of the parts.
+			u.ClientOptions = append(u.ClientOptions, withHeader(header, value, "PutObject"))
+		}
 	})
-	md := make(map[string]string, len(opts.Metadata))
-	for k, v := range opts.Metadata {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		k = escape.HexEscape(url.PathEscape(k), func(runes []rune, i int) bool {
-			c := runes[i]
-			return c == '@' || c == ':' || c == '='
-		})
-		md[k] = url.PathEscape(v)
-	}
 	req := &s3.PutObjectInput{
 		Bucket:      aws.String(b.name),
 		ContentType: aws.String(contentType),
 		Key:         aws.String(key),
-		Metadata:    md,
+		Metadata:    escapeMetadata(opts.Metadata),
 	}
 	if opts.CacheControl != "" {
 		req.CacheControl = aws.String(opts.CacheControl)
@@ -649,6 +734,14 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	if len(opts.ContentMD5) > 0 {
 		req.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(opts.ContentMD5))
 	}
+	if len(opts.Tags) > 0 {
+		req.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+	if opts.StorageClass != "" {
+		req.StorageClass = types.StorageClass(opts.StorageClass)
+	}
+	req.ServerSideEncryption, req.SSEKMSKeyId = serverSideEncryption(opts.ServerSideEncryption)
+	req.SSECustomerAlgorithm, req.SSECustomerKey, req.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
 	if opts.BeforeWrite != nil {
 		asFunc := func(i interface{}) bool {
 			pu, ok := i.(**manager.Uploader)
@@ -667,12 +760,65 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 			return nil, err
 		}
 	}
-	return &writer{
+	w := &writer{
 		ctx:      ctx,
 		uploader: uploader,
 		req:      req,
 		donec:    make(chan struct{}),
-	}, nil
+	}
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		w.check = func() error {
+			return b.checkPreconditions(ctx, key, opts.IfMatch, opts.IfNoneMatch, opts.ServerSideEncryption)
+		}
+	}
+	return w, nil
+}
+
+// encodeTags encodes tags as a URL query, as expected by the Tagging field
+// of uploads.
+func encodeTags(tags map[string]string) string {
+	q := url.Values{}
+	for k, v := range tags {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+// SetTags implements driver.Tagger.
+func (b *bucket) SetTags(ctx context.Context, key string, tags map[string]string) error {
+	key = escapeKey(key)
+	if len(tags) == 0 {
+		_, err := b.client.DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		})
+		return err
+	}
+	set := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		set = append(set, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	sort.Slice(set, func(i, j int) bool { return *set[i].Key < *set[j].Key })
+	_, err := b.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(b.name),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: set},
+	})
+	return err
+}
+
+// escapeMetadata escapes the keys and values of md. See the package comments
+// for more details on escaping of metadata keys & values.
+func escapeMetadata(md map[string]string) map[string]string {
+	escaped := make(map[string]string, len(md))
+	for k, v := range md {
+		k = escape.HexEscape(url.PathEscape(k), func(runes []rune, i int) bool {
+			c := runes[i]
+			return c == '@' || c == ':' || c == '='
+		})
+		escaped[k] = url.PathEscape(v)
+	}
+	return escaped
 }
 
 // Copy implements driver.Copy.
@@ -684,6 +830,9 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 		CopySource: aws.String(b.name + "/" + srcKey),
 		Key:        aws.String(dstKey),
 	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = serverSideEncryption(opts.ServerSideEncryption)
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
+	input.CopySourceSSECustomerAlgorithm, input.CopySourceSSECustomerKey, input.CopySourceSSECustomerKeyMD5 = customerKey(opts.SourceServerSideEncryption)
 	if opts.BeforeCopy != nil {
 		asFunc := func(i interface{}) bool {
 			switch v := i.(type) {
@@ -697,24 +846,246 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 			return err
 		}
 	}
-	_, err := b.client.CopyObject(ctx, input)
+	if err := b.checkPreconditions(ctx, dstKey, opts.IfMatch, opts.IfNoneMatch, opts.ServerSideEncryption); err != nil {
+		return err
+	}
+	_, err := b.client.CopyObject(ctx, input, withConditions(opts.IfMatch, opts.IfNoneMatch, "CopyObject"))
 	return err
 }
 
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
-	if _, err := b.Attributes(ctx, key); err != nil {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
 		return err
 	}
+	// Not every S3-compatible service honors If-Match on DeleteObject, so
+	// check the ETag we just fetched as well.
+	if !driver.MatchesPreconditions(true, attrs.ETag, opts.IfMatch, "") {
+		return errPreconditionFailed
+	}
 	key = escapeKey(key)
 	input := &s3.DeleteObjectInput{
 		Bucket: aws.String(b.name),
 		Key:    aws.String(key),
 	}
-	_, err := b.client.DeleteObject(ctx, input)
+	_, err = b.client.DeleteObject(ctx, input, withConditions(opts.IfMatch, "", "DeleteObject"))
 	return err
 }
 
+// maxDeleteObjects is the maximum number of keys S3 accepts in a single
+// DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// DeleteMany implements driver.BulkDeleter.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) ([]error, error) {
+	errs := make([]error, len(keys))
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(keys) {
+			end = len(keys)
+		}
+		// index maps escaped keys back to their position in keys.
+		index := make(map[string]int, end-start)
+		objs := make([]types.ObjectIdentifier, 0, end-start)
+		for i := start; i < end; i++ {
+			key := escapeKey(keys[i])
+			index[key] = i
+			objs = append(objs, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(b.name),
+			// In quiet mode, the response only lists the keys that failed.
+			Delete: &types.Delete{Objects: objs, Quiet: true},
+		}
+		out, err := b.client.DeleteObjects(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range out.Errors {
+			i, ok := index[aws.ToString(e.Key)]
+			if !ok {
+				continue
+			}
+			errs[i] = &smithy.GenericAPIError{Code: aws.ToString(e.Code), Message: aws.ToString(e.Message)}
+		}
+	}
+	return errs, nil
+}
+
+// errPreconditionFailed is returned when a precondition is checked
+// client-side and does not hold.
+var errPreconditionFailed = &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "precondition failed"}
+
+// checkPreconditions fetches the current ETag of the (already escaped) key and
+// returns errPreconditionFailed unless it satisfies ifMatch and ifNoneMatch.
+// sse holds the key of the blob if it is encrypted with a customer key.
+func (b *bucket) checkPreconditions(ctx context.Context, key, ifMatch, ifNoneMatch string, sse *driver.ServerSideEncryption) error {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	in := &s3.HeadObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(key),
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(sse)
+	exists := true
+	var eTag string
+	resp, err := b.client.HeadObject(ctx, in)
+	if err != nil {
+		if b.ErrorCode(err) != gdkerr.NotFound {
+			return err
+		}
+		exists = false
+	} else {
+		eTag = aws.ToString(resp.ETag)
+	}
+	if !driver.MatchesPreconditions(exists, eTag, ifMatch, ifNoneMatch) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// withConditions returns an s3.Options function that sets the If-Match and
+// If-None-Match headers on requests for the named operations. The SDK doesn't
+// expose these headers as input fields for writes, copies and deletes.
+func withConditions(ifMatch, ifNoneMatch string, operations ...string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		if ifMatch == "" && ifNoneMatch == "" {
+			return
+		}
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("GDKConditionalHeaders", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				req, ok := in.Request.(*smithyhttp.Request)
+				if !ok {
+					return next.HandleBuild(ctx, in)
+				}
+				op := awsmiddleware.GetOperationName(ctx)
+				for _, name := range operations {
+					if op != name {
+						continue
+					}
+					if ifMatch != "" {
+						req.Header.Set("If-Match", ifMatch)
+					}
+					if ifNoneMatch != "" {
+						req.Header.Set("If-None-Match", ifNoneMatch)
+					}
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+		})
+	}
+}
+
+// s3ChecksumAlgorithms are the checksum algorithms S3 supports, in order of
+// preference, since a request can only have one checksum.
+var s3ChecksumAlgorithms = []driver.ChecksumAlgorithm{
+	driver.ChecksumSHA256,
+	driver.ChecksumSHA1,
+	driver.ChecksumCRC32C,
+	driver.ChecksumCRC32,
+}
+
+// checksumHeader returns the header passing the preferred checksum of
+// checksums to S3 and its value, or an empty header if there is none.
+func checksumHeader(checksums map[driver.ChecksumAlgorithm][]byte) (string, string) {
+	for _, alg := range s3ChecksumAlgorithms {
+		if sum, ok := checksums[alg]; ok {
+			return "X-Amz-Checksum-" + strings.ToLower(string(alg)), base64.StdEncoding.EncodeToString(sum)
+		}
+	}
+	return "", ""
+}
+
+// serverSideEncryption returns the ServerSideEncryption and SSEKMSKeyId
+// fields of requests writing objects encrypted as described by sse.
+func serverSideEncryption(sse *driver.ServerSideEncryption) (types.ServerSideEncryption, *string) {
+	if sse == nil {
+		return "", nil
+	}
+	switch sse.Mode {
+	case driver.EncryptionServiceKey:
+		return types.ServerSideEncryptionAes256, nil
+	case driver.EncryptionKMS:
+		var keyID *string
+		if sse.KeyID != "" {
+			keyID = aws.String(sse.KeyID)
+		}
+		return types.ServerSideEncryptionAwsKms, keyID
+	}
+	return "", nil
+}
+
+// customerKey returns the SSECustomerAlgorithm, SSECustomerKey and
+// SSECustomerKeyMD5 fields of requests for objects encrypted with the
+// customer key of sse, or nils if sse doesn't have one.
+func customerKey(sse *driver.ServerSideEncryption) (alg, key, keyMD5 *string) {
+	if sse == nil || sse.Mode != driver.EncryptionCustomerKey {
+		return nil, nil, nil
+	}
+	sum := md5.Sum(sse.CustomerKey)
+	return aws.String("AES256"), aws.String(base64.StdEncoding.EncodeToString(sse.CustomerKey)), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// encryptionFromS3 returns the encryption of an object described by the
+// fields of a response, or nil if it isn't encrypted.
+func encryptionFromS3(sse types.ServerSideEncryption, kmsKeyID, customerAlg, customerKeyMD5 *string) *driver.ServerSideEncryption {
+	switch {
+	case customerAlg != nil:
+		return &driver.ServerSideEncryption{Mode: driver.EncryptionCustomerKey, KeyID: aws.ToString(customerKeyMD5)}
+	case sse == types.ServerSideEncryptionAes256:
+		return &driver.ServerSideEncryption{Mode: driver.EncryptionServiceKey}
+	case strings.HasPrefix(string(sse), string(types.ServerSideEncryptionAwsKms)):
+		// Including "aws:kms:dsse", dual-layer encryption with KMS keys.
+		return &driver.ServerSideEncryption{Mode: driver.EncryptionKMS, KeyID: aws.ToString(kmsKeyID)}
+	}
+	return nil
+}
+
+// checksumsFromS3 decodes the base64-encoded checksums returned by S3. The
+// checksums of multipart uploads, which are checksums of the checksums of
+// the parts, are left out.
+func checksumsFromS3(encoded map[driver.ChecksumAlgorithm]*string) map[driver.ChecksumAlgorithm][]byte {
+	var checksums map[driver.ChecksumAlgorithm][]byte
+	for alg, s := range encoded {
+		if s == nil || strings.Contains(*s, "-") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(*s)
+		if err != nil {
+			continue
+		}
+		if checksums == nil {
+			checksums = map[driver.ChecksumAlgorithm][]byte{}
+		}
+		checksums[alg] = sum
+	}
+	return checksums
+}
+
+// withHeader returns an s3.Options function that sets a header on requests
+// for the named operations.
+func withHeader(header, value string, operations ...string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("GDKHeader"+header, func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				req, ok := in.Request.(*smithyhttp.Request)
+				if !ok {
+					return next.HandleBuild(ctx, in)
+				}
+				op := awsmiddleware.GetOperationName(ctx)
+				for _, name := range operations {
+					if op == name {
+						req.Header.Set(header, value)
+					}
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+		})
+	}
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	key = escapeKey(key)
 	switch opts.Method {
@@ -0,0 +1,246 @@
+package s3blob
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/sraphs/gdk/blob/driver"
+)
+
+// NewResumableWriter implements driver.ResumableUploader. Sessions are S3
+// multipart uploads, and tokens are their upload IDs.
+func (b *bucket) NewResumableWriter(ctx context.Context, key, contentType, token string, opts *driver.WriterOptions) (driver.ResumableWriter, error) {
+	key = escapeKey(key)
+	w := &resumableWriter{
+		ctx:         ctx,
+		b:           b,
+		key:         key,
+		token:       token,
+		partSize:    manager.DefaultUploadPartSize,
+		ifMatch:     opts.IfMatch,
+		ifNoneMatch: opts.IfNoneMatch,
+		sse:         opts.ServerSideEncryption,
+	}
+	if opts.BufferSize > 0 {
+		w.partSize = int64(opts.BufferSize)
+	}
+	// S3 rejects parts smaller than this, except for the last one.
+	if w.partSize < manager.MinUploadPartSize {
+		w.partSize = manager.MinUploadPartSize
+	}
+
+	if token != "" {
+		// Resume the upload after the parts that were already uploaded.
+		in := &s3.ListPartsInput{
+			Bucket:   aws.String(b.name),
+			Key:      aws.String(key),
+			UploadId: aws.String(token),
+		}
+		p := s3.NewListPartsPaginator(b.client, in)
+		for p.HasMorePages() {
+			out, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, part := range out.Parts {
+				w.parts = append(w.parts, types.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+				w.committed += part.Size
+			}
+		}
+		sort.Slice(w.parts, func(i, j int) bool { return w.parts[i].PartNumber < w.parts[j].PartNumber })
+		if opts.BeforeWrite != nil {
+			if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+				return nil, err
+			}
+		}
+		return w, nil
+	}
+
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(b.name),
+		ContentType: aws.String(contentType),
+		Key:         aws.String(key),
+		Metadata:    escapeMetadata(opts.Metadata),
+	}
+	if opts.CacheControl != "" {
+		in.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		in.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.ContentEncoding != "" {
+		in.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if opts.ContentLanguage != "" {
+		in.ContentLanguage = aws.String(opts.ContentLanguage)
+	}
+	if len(opts.Tags) > 0 {
+		in.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+	if opts.StorageClass != "" {
+		in.StorageClass = types.StorageClass(opts.StorageClass)
+	}
+	in.ServerSideEncryption, in.SSEKMSKeyId = serverSideEncryption(opts.ServerSideEncryption)
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(opts.ServerSideEncryption)
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**s3.CreateMultipartUploadInput)
+			if !ok {
+				return false
+			}
+			*p = in
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	out, err := b.client.CreateMultipartUpload(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	w.token = aws.ToString(out.UploadId)
+	return w, nil
+}
+
+// ListUploadSessions implements driver.ResumableUploader.
+func (b *bucket) ListUploadSessions(ctx context.Context, prefix string) ([]*driver.UploadSession, error) {
+	in := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(b.name),
+		Prefix: aws.String(escapeKey(prefix)),
+	}
+	var sessions []*driver.UploadSession
+	for {
+		out, err := b.client.ListMultipartUploads(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range out.Uploads {
+			sessions = append(sessions, &driver.UploadSession{
+				Key:     unescapeKey(aws.ToString(u.Key)),
+				Token:   aws.ToString(u.UploadId),
+				Started: aws.ToTime(u.Initiated),
+			})
+		}
+		if !out.IsTruncated {
+			return sessions, nil
+		}
+		in.KeyMarker = out.NextKeyMarker
+		in.UploadIdMarker = out.NextUploadIdMarker
+	}
+}
+
+// AbortUploadSession implements driver.ResumableUploader.
+func (b *bucket) AbortUploadSession(ctx context.Context, key, token string) error {
+	_, err := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.name),
+		Key:      aws.String(escapeKey(key)),
+		UploadId: aws.String(token),
+	})
+	return err
+}
+
+// resumableWriter writes to an S3 multipart upload. Bytes are committed when
+// the part holding them has been uploaded.
+type resumableWriter struct {
+	ctx      context.Context
+	b        *bucket
+	key      string
+	token    string
+	partSize int64
+
+	buf       bytes.Buffer
+	parts     []types.CompletedPart
+	committed int64
+
+	ifMatch     string
+	ifNoneMatch string
+	// sse is how the upload is encrypted. Parts need its customer key, if
+	// any, so it must be given again when resuming the upload.
+	sse *driver.ServerSideEncryption
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		m := int(w.partSize) - w.buf.Len()
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf.Write(p[:m])
+		n += m
+		p = p[m:]
+		if int64(w.buf.Len()) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// uploadPart uploads the buffered bytes as the next part.
+func (w *resumableWriter) uploadPart() error {
+	size := int64(w.buf.Len())
+	partNumber := int32(len(w.parts) + 1)
+	if len(w.parts) > 0 {
+		partNumber = w.parts[len(w.parts)-1].PartNumber + 1
+	}
+	in := &s3.UploadPartInput{
+		Bucket:        aws.String(w.b.name),
+		Key:           aws.String(w.key),
+		UploadId:      aws.String(w.token),
+		PartNumber:    partNumber,
+		Body:          bytes.NewReader(w.buf.Bytes()),
+		ContentLength: size,
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(w.sse)
+	out, err := w.b.client.UploadPart(w.ctx, in)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, types.CompletedPart{ETag: out.ETag, PartNumber: partNumber})
+	w.committed += size
+	w.buf.Reset()
+	return nil
+}
+
+func (w *resumableWriter) SessionToken() string {
+	return w.token
+}
+
+func (w *resumableWriter) Committed() int64 {
+	return w.committed
+}
+
+// Close uploads the last part and completes the multipart upload. If the
+// context was canceled, the upload is left in place so it can be resumed.
+func (w *resumableWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	// Every multipart upload needs at least one part, even an empty one.
+	if w.buf.Len() > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+	in := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.b.name),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.token),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	}
+	in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = customerKey(w.sse)
+	if err := w.b.checkPreconditions(w.ctx, w.key, w.ifMatch, w.ifNoneMatch, w.sse); err != nil {
+		return err
+	}
+	_, err := w.b.client.CompleteMultipartUpload(w.ctx, in, withConditions(w.ifMatch, w.ifNoneMatch, "CompleteMultipartUpload"))
+	return err
+}
@@ -0,0 +1,309 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultSyncConcurrency is the default for SyncOptions.Concurrency.
+const defaultSyncConcurrency = 8
+
+// SyncOptions sets options for Sync.
+type SyncOptions struct {
+	// Prefix restricts Sync to blobs whose keys start with Prefix, in both
+	// buckets. Blobs are copied to the same key in the destination.
+	Prefix string
+
+	// Delete causes Sync to delete blobs under Prefix in the destination that
+	// don't exist in the source.
+	Delete bool
+
+	// DryRun causes Sync to compare the buckets and report what it would do,
+	// without copying or deleting anything.
+	DryRun bool
+
+	// Concurrency is the maximum number of blobs compared and copied at the
+	// same time. Defaults to 8.
+	Concurrency int
+
+	// Progress, if non-nil, is called once for every blob that Sync copies or
+	// deletes (or would, if DryRun is set), after the operation completes.
+	// Calls are not concurrent.
+	Progress func(SyncEvent)
+}
+
+// SyncAction is the action Sync takes for a blob.
+type SyncAction int
+
+const (
+	// SyncCopy means the blob was copied from the source to the destination.
+	SyncCopy SyncAction = iota + 1
+	// SyncDelete means the blob was deleted from the destination.
+	SyncDelete
+)
+
+// String implements fmt.Stringer.
+func (a SyncAction) String() string {
+	switch a {
+	case SyncCopy:
+		return "copy"
+	case SyncDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// SyncEvent describes an action taken by Sync; see SyncOptions.Progress.
+type SyncEvent struct {
+	// Key is the key of the blob.
+	Key string
+	// Action is the action taken for the blob.
+	Action SyncAction
+	// Size is the size of the blob copied, or 0 for deletes.
+	Size int64
+	// Err is the error that caused the action to fail, or nil.
+	Err error
+}
+
+// SyncResult summarizes a call to Sync. If SyncOptions.DryRun is set, the
+// counts are for the blobs that would have been copied or deleted.
+type SyncResult struct {
+	// Copied is the number of blobs copied.
+	Copied int
+	// BytesCopied is the total size of the blobs copied.
+	BytesCopied int64
+	// Deleted is the number of blobs deleted from the destination.
+	Deleted int
+	// Unchanged is the number of blobs that were already up to date in the
+	// destination.
+	Unchanged int
+	// Errors holds the errors for the blobs that could not be compared,
+	// copied or deleted, indexed by key.
+	Errors map[string]error
+}
+
+// Sync makes the blobs in dst match the blobs in src, copying only the blobs
+// that are missing from dst or that differ. It can be used with any pair of
+// buckets, including ones from different drivers.
+//
+// Blobs are compared by size, then by MD5 hash. If either hash is not
+// available, Sync compares the blobs' ETags; since ETags from different
+// services are rarely comparable, blobs without hashes will usually be copied
+// again.
+//
+// Blobs are copied by reading them from src and writing them to dst, keeping
+// their content type, metadata and other attributes.
+//
+// Errors for individual blobs don't stop Sync; they are reported in the
+// result. The returned error is non-nil only if either bucket couldn't be
+// listed, in which case nothing is copied or deleted.
+func Sync(ctx context.Context, dst, src *Bucket, opts *SyncOptions) (*SyncResult, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultSyncConcurrency
+	}
+
+	srcObjs, err := listAll(ctx, src, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	dstObjs, err := listAll(ctx, dst, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	dstByKey := make(map[string]*ListObject, len(dstObjs))
+	for _, obj := range dstObjs {
+		dstByKey[obj.Key] = obj
+	}
+
+	s := &syncer{
+		dst:    dst,
+		src:    src,
+		opts:   opts,
+		result: &SyncResult{Errors: map[string]error{}},
+	}
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for _, obj := range srcObjs {
+		srcObj, dstObj := obj, dstByKey[obj.Key]
+		g.Go(func() error {
+			s.sync(ctx, srcObj, dstObj)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if opts.Delete {
+		srcKeys := make(map[string]bool, len(srcObjs))
+		for _, obj := range srcObjs {
+			srcKeys[obj.Key] = true
+		}
+		var extra []string
+		for _, obj := range dstObjs {
+			if !srcKeys[obj.Key] {
+				extra = append(extra, obj.Key)
+			}
+		}
+		if err := s.delete(ctx, extra); err != nil {
+			return s.result, err
+		}
+	}
+	return s.result, nil
+}
+
+// listAll returns all of the blobs in b whose keys start with prefix.
+func listAll(ctx context.Context, b *Bucket, prefix string) ([]*ListObject, error) {
+	var objs []*ListObject
+	iter := b.List(&ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !obj.IsDir {
+			objs = append(objs, obj)
+		}
+	}
+}
+
+// syncer holds the state of a call to Sync.
+type syncer struct {
+	dst, src *Bucket
+	opts     *SyncOptions
+
+	mu     sync.Mutex
+	result *SyncResult
+}
+
+// sync copies srcObj to dst if it differs from dstObj, which is nil if the
+// blob doesn't exist in dst.
+func (s *syncer) sync(ctx context.Context, srcObj, dstObj *ListObject) {
+	key := srcObj.Key
+	if dstObj != nil {
+		same, err := s.same(ctx, srcObj, dstObj)
+		if err != nil {
+			s.mu.Lock()
+			s.result.Errors[key] = err
+			s.mu.Unlock()
+			return
+		}
+		if same {
+			s.mu.Lock()
+			s.result.Unchanged++
+			s.mu.Unlock()
+			return
+		}
+	}
+	var err error
+	if !s.opts.DryRun {
+		err = s.copy(ctx, key)
+	}
+	s.report(SyncEvent{Key: key, Action: SyncCopy, Size: srcObj.Size, Err: err})
+}
+
+// same reports whether srcObj and dstObj have the same contents.
+func (s *syncer) same(ctx context.Context, srcObj, dstObj *ListObject) (bool, error) {
+	if srcObj.Size != dstObj.Size {
+		return false, nil
+	}
+	if srcObj.MD5 != nil && dstObj.MD5 != nil {
+		return bytes.Equal(srcObj.MD5, dstObj.MD5), nil
+	}
+	// Listings don't include ETags, and some drivers only return hashes from
+	// Attributes.
+	srcAttrs, err := s.src.Attributes(ctx, srcObj.Key)
+	if err != nil {
+		return false, err
+	}
+	dstAttrs, err := s.dst.Attributes(ctx, dstObj.Key)
+	if err != nil {
+		return false, err
+	}
+	if srcAttrs.MD5 != nil && dstAttrs.MD5 != nil {
+		return bytes.Equal(srcAttrs.MD5, dstAttrs.MD5), nil
+	}
+	return srcAttrs.ETag != "" && srcAttrs.ETag == dstAttrs.ETag, nil
+}
+
+// copy copies the blob at key from src to dst.
+func (s *syncer) copy(ctx context.Context, key string) error {
+	attrs, err := s.src.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	// Pin the read to the version we got attributes for, so the attributes
+	// and contents written match.
+	r, err := s.src.NewReader(ctx, key, &ReaderOptions{IfMatch: attrs.ETag})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.dst.NewWriter(ctx, key, &WriterOptions{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		ContentMD5:         attrs.MD5,
+		Metadata:           attrs.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		// Cancel the write so that dst is left unchanged.
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// delete deletes keys from dst.
+func (s *syncer) delete(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	failed := map[string]error{}
+	if !s.opts.DryRun {
+		var err error
+		if failed, err = s.dst.DeleteMany(ctx, keys); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		s.report(SyncEvent{Key: key, Action: SyncDelete, Err: failed[key]})
+	}
+	return nil
+}
+
+// report records ev in the result and calls the Progress callback, if any.
+func (s *syncer) report(ev SyncEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case ev.Err != nil:
+		s.result.Errors[ev.Key] = ev.Err
+	case ev.Action == SyncCopy:
+		s.result.Copied++
+		s.result.BytesCopied += ev.Size
+	case ev.Action == SyncDelete:
+		s.result.Deleted++
+	}
+	if s.opts.Progress != nil {
+		s.opts.Progress(ev)
+	}
+}
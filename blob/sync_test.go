@@ -0,0 +1,121 @@
+package blob_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/memblob"
+)
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	defer src.Close()
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+
+	write := func(b *blob.Bucket, key, content string) {
+		t.Helper()
+		opts := &blob.WriterOptions{ContentType: "text/plain", Metadata: map[string]string{"k": key}}
+		if err := b.WriteAll(ctx, key, []byte(content), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// runSync runs Sync and returns the result along with the events reported
+	// to Progress, sorted by key.
+	runSync := func(opts *blob.SyncOptions) (*blob.SyncResult, []blob.SyncEvent) {
+		t.Helper()
+		var events []blob.SyncEvent
+		opts.Progress = func(ev blob.SyncEvent) {
+			events = append(events, ev)
+		}
+		res, err := blob.Sync(ctx, dst, src, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, err := range res.Errors {
+			t.Errorf("%s: %v", key, err)
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].Key < events[j].Key })
+		return res, events
+	}
+
+	write(src, "dir/a", "aaa")
+	write(src, "dir/b", "bbbb")
+	write(src, "other", "not synced")
+	write(dst, "dir/b", "old!")
+	write(dst, "dir/extra", "extra")
+
+	// A dry run reports what would happen, without changing dst.
+	res, events := runSync(&blob.SyncOptions{Prefix: "dir/", Delete: true, DryRun: true})
+	wantEvents := []blob.SyncEvent{
+		{Key: "dir/a", Action: blob.SyncCopy, Size: 3},
+		{Key: "dir/b", Action: blob.SyncCopy, Size: 4},
+		{Key: "dir/extra", Action: blob.SyncDelete},
+	}
+	if diff := cmp.Diff(wantEvents, events); diff != "" {
+		t.Errorf("dry run events: %s", diff)
+	}
+	if res.Copied != 2 || res.BytesCopied != 7 || res.Deleted != 1 || res.Unchanged != 0 {
+		t.Errorf("dry run: got result %+v", res)
+	}
+	if exists, _ := dst.Exists(ctx, "dir/a"); exists {
+		t.Error("dry run copied dir/a")
+	}
+	if exists, _ := dst.Exists(ctx, "dir/extra"); !exists {
+		t.Error("dry run deleted dir/extra")
+	}
+
+	// Without Delete, extraneous blobs are kept.
+	_, events = runSync(&blob.SyncOptions{Prefix: "dir/"})
+	if diff := cmp.Diff(wantEvents[:2], events); diff != "" {
+		t.Errorf("sync events: %s", diff)
+	}
+	for _, key := range []string{"dir/a", "dir/b"} {
+		got, err := dst.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := src.ReadAll(ctx, key)
+		if string(got) != string(want) {
+			t.Errorf("%s: got %q want %q", key, got, want)
+		}
+		attrs, err := dst.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentType != "text/plain" || attrs.Metadata["k"] != key {
+			t.Errorf("%s: attributes were not copied: %+v", key, attrs)
+		}
+	}
+	if exists, _ := dst.Exists(ctx, "other"); exists {
+		t.Error("blob outside of Prefix was copied")
+	}
+
+	// Only changed blobs are copied again.
+	write(src, "dir/a", "AAA")
+	res, events = runSync(&blob.SyncOptions{Prefix: "dir/", Delete: true})
+	wantEvents = []blob.SyncEvent{
+		{Key: "dir/a", Action: blob.SyncCopy, Size: 3},
+		{Key: "dir/extra", Action: blob.SyncDelete},
+	}
+	if diff := cmp.Diff(wantEvents, events); diff != "" {
+		t.Errorf("sync events: %s", diff)
+	}
+	if res.Copied != 1 || res.Deleted != 1 || res.Unchanged != 1 {
+		t.Errorf("got result %+v", res)
+	}
+	if exists, _ := dst.Exists(ctx, "dir/extra"); exists {
+		t.Error("dir/extra was not deleted")
+	}
+
+	// Nothing left to do.
+	res, events = runSync(&blob.SyncOptions{Prefix: "dir/", Delete: true})
+	if len(events) != 0 || res.Unchanged != 2 {
+		t.Errorf("got result %+v, events %v want nothing to do", res, events)
+	}
+}
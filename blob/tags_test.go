@@ -0,0 +1,70 @@
+package blob_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestStorageClass(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{StorageClass: "COLD"}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.StorageClass != "COLD" {
+		t.Errorf("got storage class %q want COLD", attrs.StorageClass)
+	}
+	// Changing the tags doesn't change the storage class.
+	if err := b.SetTags(ctx, "key", map[string]string{"team": "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if attrs, err := b.Attributes(ctx, "key"); err != nil || attrs.StorageClass != "COLD" {
+		t.Errorf("after SetTags, got %v, %v want storage class COLD", attrs, err)
+	}
+}
+
+func TestTagsUnimplemented(t *testing.T) {
+	ctx := context.Background()
+
+	// fileblob can only keep tags in its sidecar files.
+	b, err := fileblob.OpenBucket(t.TempDir(), &fileblob.Options{Metadata: fileblob.MetadataDontWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for _, opts := range []*blob.WriterOptions{
+		{Tags: map[string]string{"a": "b"}},
+		{StorageClass: "COLD"},
+	} {
+		if err := b.WriteAll(ctx, "key", []byte("hello"), opts); gdkerr.Code(err) != gdkerr.Unimplemented {
+			t.Errorf("write with %+v: got %v want Unimplemented", opts, err)
+		}
+	}
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetTags(ctx, "key", map[string]string{"a": "b"}); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("fileblob SetTags: got %v want Unimplemented", err)
+	}
+
+	// Wrappers that don't implement SetTags.
+	cb := blob.CompressedBucket(memblob.OpenBucket(nil), nil)
+	defer cb.Close()
+	if err := cb.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.SetTags(ctx, "key", map[string]string{"a": "b"}); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("CompressedBucket SetTags: got %v want Unimplemented", err)
+	}
+}
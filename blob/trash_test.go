@@ -0,0 +1,152 @@
+package blob_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/sraphs/gdk/blob"
+	"github.com/sraphs/gdk/blob/fileblob"
+	"github.com/sraphs/gdk/blob/memblob"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+func TestTrashBucket(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	open := func() *blob.Bucket {
+		t.Helper()
+		b, err := fileblob.OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// raw is the same directory, without the trash.
+	raw := open()
+	defer raw.Close()
+	b := blob.TrashBucket(open(), nil)
+	defer b.Close()
+
+	write := func(key, content string) {
+		t.Helper()
+		if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(key, want string) {
+		t.Helper()
+		got, err := b.ReadAll(ctx, key)
+		if want == "" {
+			if gdkerr.Code(err) != gdkerr.NotFound {
+				t.Errorf("reading %s: got %q, %v want NotFound", key, got, err)
+			}
+		} else if err != nil || string(got) != want {
+			t.Errorf("reading %s: got %q, %v want %q", key, got, err, want)
+		}
+	}
+	list := func(b *blob.Bucket, delimiter string) []string {
+		t.Helper()
+		var keys []string
+		token := blob.FirstPageToken
+		for {
+			objs, next, err := b.ListPage(ctx, token, 1, &blob.ListOptions{Delimiter: delimiter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range objs {
+				keys = append(keys, obj.Key)
+			}
+			if len(next) == 0 {
+				return keys
+			}
+			token = next
+		}
+	}
+
+	write("a", "a1")
+	write("b", "b")
+	write("c", "c")
+	// Deleted blobs are moved to the trash, and hidden.
+	if err := b.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	write("a", "a2")
+	if err := b.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	read("a", "")
+	if diff := cmp.Diff([]string{"b"}, list(b, "")); diff != "" {
+		t.Errorf("list (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"b"}, list(b, "/")); diff != "" {
+		t.Errorf("list with delimiter (-want +got):\n%s", diff)
+	}
+	trashed := list(raw, "")
+	if len(trashed) != 4 || !strings.HasPrefix(trashed[0], ".gdk-trash/a@") {
+		t.Errorf("raw list: got %q want 3 trashed blobs and b", trashed)
+	}
+	if err := b.WriteAll(ctx, trashed[0], nil, nil); gdkerr.Code(err) != gdkerr.InvalidArgument {
+		t.Errorf("writing to the trash: got %v want InvalidArgument", err)
+	}
+
+	// Restore restores the last deleted blob, without overwriting.
+	if err := b.Restore(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	read("a", "a2")
+	if err := b.Restore(ctx, "a"); gdkerr.Code(err) != gdkerr.FailedPrecondition {
+		t.Errorf("restoring existing blob: got %v want FailedPrecondition", err)
+	}
+	if err := b.Restore(ctx, "b"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("restoring blob not deleted: got %v want NotFound", err)
+	}
+
+	// Purge only deletes the blobs deleted long enough ago.
+	if err := b.Purge(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(list(raw, "")); got != 4 {
+		t.Errorf("after purging old blobs: got %d blobs want 4", got)
+	}
+	if err := b.Purge(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, list(raw, "")); diff != "" {
+		t.Errorf("raw list after purge (-want +got):\n%s", diff)
+	}
+	if err := b.Restore(ctx, "c"); gdkerr.Code(err) != gdkerr.NotFound {
+		t.Errorf("restoring purged blob: got %v want NotFound", err)
+	}
+
+	// PrefixedBucket restores the blobs with its prefix.
+	prefixed := blob.PrefixedBucket(blob.TrashBucket(memblob.OpenBucket(nil), nil), "p/")
+	defer prefixed.Close()
+	if err := prefixed.WriteAll(ctx, "a", []byte("pa"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := prefixed.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := prefixed.Restore(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := prefixed.ReadAll(ctx, "a"); err != nil || string(got) != "pa" {
+		t.Errorf("reading restored prefixed blob: got %q, %v want pa", got, err)
+	}
+	if err := prefixed.Purge(ctx, 0); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("purging prefixed bucket: got %v want Unimplemented", err)
+	}
+
+	mem := blob.PrefixedBucket(memblob.OpenBucket(nil), "p/")
+	defer mem.Close()
+	if err := mem.Restore(ctx, "a"); gdkerr.Code(err) != gdkerr.Unimplemented {
+		t.Errorf("restoring from bucket without trash: got %v want Unimplemented", err)
+	}
+}
@@ -0,0 +1,238 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sraphs/gdk/blob/driver"
+	"github.com/sraphs/gdk/gdkerr"
+)
+
+// DefaultWatchPollInterval is the default for WatchOptions.PollInterval.
+const DefaultWatchPollInterval = 10 * time.Second
+
+// EventType is the kind of change to a blob reported by an Event.
+type EventType string
+
+// Event types.
+const (
+	// EventCreated reports that a blob was written where there was none.
+	EventCreated = EventType(driver.EventCreated)
+	// EventUpdated reports that a blob was overwritten.
+	EventUpdated = EventType(driver.EventUpdated)
+	// EventDeleted reports that a blob was deleted.
+	EventDeleted = EventType(driver.EventDeleted)
+)
+
+// Event is a change to a blob; see Bucket.Watch.
+type Event struct {
+	// Type is the kind of change.
+	Type EventType
+	// Key is the key of the blob.
+	Key string
+	// ETag, ModTime and Size describe the blob after the change, if known.
+	// They are zero for EventDeleted.
+	ETag    string
+	ModTime time.Time
+	Size    int64
+}
+
+// WatchOptions sets options for Watch.
+type WatchOptions struct {
+	// PollInterval is how often the blobs are listed to find the changes,
+	// for buckets that can't report them as they happen. Defaults to
+	// DefaultWatchPollInterval.
+	PollInterval time.Duration
+}
+
+// EventStream is a stream of changes to blobs, returned by Bucket.Watch.
+type EventStream struct {
+	b driver.Bucket
+	s driver.EventStream
+}
+
+// Next returns the next change, waiting until there is one or ctx is done.
+// After Close is called, Next returns an error for which gdkerr.Code returns
+// gdkerr.FailedPrecondition.
+//
+// Next should not be called concurrently.
+func (s *EventStream) Next(ctx context.Context) (*Event, error) {
+	e, err := s.s.Next(ctx)
+	if err != nil {
+		return nil, wrapError(s.b, err, "")
+	}
+	return &Event{
+		Type:    EventType(e.Type),
+		Key:     e.Key,
+		ETag:    e.ETag,
+		ModTime: e.ModTime,
+		Size:    e.Size,
+	}, nil
+}
+
+// Close stops the stream. It must be called once the stream is no longer
+// used.
+func (s *EventStream) Close() error {
+	return wrapError(s.b, s.s.Close(), "")
+}
+
+// Watch returns a stream of the changes to the blobs with keys starting with
+// prefix, made after Watch returns. Changes in quick succession may be
+// reported as one Event, and a blob that is created and deleted in between
+// may not be reported at all.
+//
+// Drivers that can report changes as they happen, like fileblob and memblob,
+// do so. For others, the blobs are listed every opts.PollInterval, and the
+// changes are found by comparing their ETags (or their modification times
+// and sizes, if List doesn't report ETags) with the previous listing.
+//
+// A nil WatchOptions is treated the same as the zero value.
+func (b *Bucket) Watch(ctx context.Context, prefix string, opts *WatchOptions) (_ *EventStream, err error) {
+	if !utf8.ValidString(prefix) {
+		return nil, gdkerr.Newf(gdkerr.InvalidArgument, nil, "blob: Watch prefix must be a valid UTF-8 string: %q", prefix)
+	}
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Watch")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	var s driver.EventStream
+	if w, ok := b.b.(driver.Watcher); ok {
+		s, err = w.Watch(ctx, prefix)
+	}
+	if s == nil && (err == nil || gdkerr.Code(err) == gdkerr.Unimplemented) {
+		// The driver can't watch these blobs; poll them instead.
+		interval := opts.PollInterval
+		if interval <= 0 {
+			interval = DefaultWatchPollInterval
+		}
+		s, err = newPollStream(ctx, b.b, prefix, interval)
+	}
+	if err != nil {
+		return nil, wrapError(b.b, err, "")
+	}
+	return &EventStream{b: b.b, s: s}, nil
+}
+
+var errEventStreamClosed = gdkerr.Newf(gdkerr.FailedPrecondition, nil, "blob: EventStream has been closed")
+
+// pollStream is a driver.EventStream finding the changes to the blobs by
+// listing them periodically.
+type pollStream struct {
+	b         driver.Bucket
+	prefix    string
+	interval  time.Duration
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	// The fields below are only used by Next.
+	known   map[string]string // fingerprints of the blobs, by key
+	next    time.Time         // time of the next listing
+	pending []*driver.Event   // changes found by the last listing, not returned yet
+}
+
+func newPollStream(ctx context.Context, b driver.Bucket, prefix string, interval time.Duration) (*pollStream, error) {
+	s := &pollStream{b: b, prefix: prefix, interval: interval, done: make(chan struct{})}
+	if err := s.poll(ctx); err != nil {
+		return nil, err
+	}
+	// Blobs that exist already aren't changes.
+	s.pending = nil
+	return s, nil
+}
+
+// fingerprint returns a string that changes whenever obj does.
+func fingerprint(obj *driver.ListObject) string {
+	if obj.ETag != "" {
+		return obj.ETag
+	}
+	return fmt.Sprintf("%d-%d-%x", obj.ModTime.UnixNano(), obj.Size, obj.MD5)
+}
+
+// poll lists the blobs, and queues the changes since the last listing.
+func (s *pollStream) poll(ctx context.Context) error {
+	s.next = time.Now().Add(s.interval)
+	current := map[string]string{}
+	opts := &driver.ListOptions{Prefix: s.prefix, PageSize: 1000}
+	for {
+		page, err := s.b.ListPaged(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Objects {
+			if obj.IsDir {
+				continue
+			}
+			fp := fingerprint(obj)
+			current[obj.Key] = fp
+			prev, exists := s.known[obj.Key]
+			if exists && prev == fp {
+				continue
+			}
+			e := &driver.Event{Type: driver.EventCreated, Key: obj.Key, ETag: obj.ETag, ModTime: obj.ModTime, Size: obj.Size}
+			if exists {
+				e.Type = driver.EventUpdated
+			}
+			s.pending = append(s.pending, e)
+		}
+		if len(page.NextPageToken) == 0 {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	var deleted []string
+	for key := range s.known {
+		if _, ok := current[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		s.pending = append(s.pending, &driver.Event{Type: driver.EventDeleted, Key: key})
+	}
+	s.known = current
+	return nil
+}
+
+// Next implements driver.EventStream.Next.
+func (s *pollStream) Next(ctx context.Context) (*driver.Event, error) {
+	for len(s.pending) == 0 {
+		t := time.NewTimer(time.Until(s.next))
+		select {
+		case <-s.done:
+			t.Stop()
+			return nil, errEventStreamClosed
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		if err := s.poll(ctx); err != nil {
+			return nil, err
+		}
+	}
+	select {
+	case <-s.done:
+		return nil, errEventStreamClosed
+	default:
+	}
+	e := s.pending[0]
+	s.pending = s.pending[1:]
+	return e, nil
+}
+
+// Close implements driver.EventStream.Close.
+func (s *pollStream) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
@@ -8,6 +8,8 @@
 # https://coderwall.com/p/fkfaqq/safer-bash-scripts-with-set-euxo-pipefail
 set -euo pipefail
 
+./blob/azureblob/localazurite.sh
+./blob/gcsblob/localgcs.sh
 ./pubsub/kafkapubsub/localkafka.sh
 ./pubsub/pulsarpubsub/localpulsar.sh
 ./pubsub/rabbitpubsub/localrabbit.sh